@@ -0,0 +1,160 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"sort"
+	"time"
+)
+
+// An AccessMetric identifies the statistic that redis tracks about how
+// recently or how often a key is accessed, which depends on its
+// maxmemory-policy
+type AccessMetric int
+
+const (
+	// AccessUnknown indicates that no access statistic was obtained
+	AccessUnknown AccessMetric = iota
+
+	// AccessIdleTime indicates the time since the key was last accessed, as
+	// reported by `OBJECT IDLETIME` (under any policy other than LFU)
+	AccessIdleTime
+
+	// AccessFrequency indicates the key's logarithmic access frequency
+	// counter, as reported by `OBJECT FREQ` (under an LFU policy)
+	AccessFrequency
+)
+
+// A Temperature roughly classifies how often the keys of an aggregation group
+// are accessed, e.g. to decide which groups could be moved to cheaper storage
+// (see Results.Temperature)
+type Temperature string
+
+const (
+	TemperatureHot  Temperature = "hot"
+	TemperatureWarm Temperature = "warm"
+	TemperatureCold Temperature = "cold"
+)
+
+// TemperatureThresholds determine how an aggregation group's access
+// statistics are classified as a Temperature.  A group is hot if the median
+// idle time of its keys is at most HotIdleTime (or their median access
+// frequency counter at least HotFrequency), cold if it is at least
+// ColdIdleTime (or at most ColdFrequency), and warm otherwise.  Note that
+// redis' frequency counters are logarithmic, and start at 5 for new keys.
+type TemperatureThresholds struct {
+	HotIdleTime  time.Duration
+	ColdIdleTime time.Duration
+
+	HotFrequency  int
+	ColdFrequency int
+}
+
+// DefaultTemperatureThresholds are the TemperatureThresholds used when none
+// are specified
+var DefaultTemperatureThresholds = TemperatureThresholds{
+	HotIdleTime:   10 * time.Minute,
+	ColdIdleTime:  24 * time.Hour,
+	HotFrequency:  32,
+	ColdFrequency: 5,
+}
+
+// classify returns the Temperature of keys with the specified median idle
+// time or access frequency counter (according to `metric`)
+func (t TemperatureThresholds) classify(metric AccessMetric, median int) Temperature {
+	if t == (TemperatureThresholds{}) {
+		t = DefaultTemperatureThresholds
+	}
+
+	switch {
+	case metric == AccessIdleTime && time.Duration(median)*time.Second <= t.HotIdleTime:
+		return TemperatureHot
+	case metric == AccessIdleTime && time.Duration(median)*time.Second >= t.ColdIdleTime:
+		return TemperatureCold
+	case metric == AccessFrequency && median >= t.HotFrequency:
+		return TemperatureHot
+	case metric == AccessFrequency && median <= t.ColdFrequency:
+		return TemperatureCold
+	}
+	return TemperatureWarm
+}
+
+// median returns the median of the values counted in a frequency map, which
+// must not be empty
+func median(freq map[int]int64) int {
+	values := make([]int, 0, len(freq))
+	var total int64
+	for v, n := range freq {
+		values = append(values, v)
+		total += n
+	}
+	sort.Ints(values)
+
+	rank := (total + 1) / 2
+	for _, v := range values {
+		if rank -= freq[v]; rank <= 0 {
+			return v
+		}
+	}
+	return values[len(values)-1]
+}
+
+// observeAccess records the access statistic of a sampled key
+func (r *Results) observeAccess(metric AccessMetric, idle time.Duration, freq int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch metric {
+	case AccessIdleTime:
+		r.IdleTimes[int(idle/time.Second)]++
+	case AccessFrequency:
+		r.AccessFrequencies[freq]++
+	}
+}
+
+// Temperature classifies how often the group's keys are accessed, from the
+// median of their idle times or access frequency counters (see
+// Options.CollectAccess and TemperatureThresholds).  It is heuristic: keys
+// that are read and written alike count as accessed.  It returns "" if access
+// statistics weren't collected, or if they mix idle times and frequencies.
+func (r *Results) Temperature() Temperature {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.temperature()
+}
+
+func (r *Results) temperature() Temperature {
+	switch r.accessMetric() {
+	case AccessIdleTime:
+		return r.TemperatureThresholds.classify(AccessIdleTime, median(r.IdleTimes))
+	case AccessFrequency:
+		return r.TemperatureThresholds.classify(AccessFrequency, median(r.AccessFrequencies))
+	}
+	return ""
+}
+
+// accessMetric returns the AccessMetric of the access statistics collected,
+// or AccessUnknown if there are none, or both kinds, which can't be compared
+func (r *Results) accessMetric() AccessMetric {
+	switch idle, freq := len(r.IdleTimes) > 0, len(r.AccessFrequencies) > 0; {
+	case idle && !freq:
+		return AccessIdleTime
+	case freq && !idle:
+		return AccessFrequency
+	}
+	return AccessUnknown
+}
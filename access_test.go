@@ -0,0 +1,167 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMedian(t *testing.T) {
+	assertInt(t, 2, median(map[int]int64{1: 1, 2: 1, 3: 1}))
+	assertInt(t, 1, median(map[int]int64{1: 2, 100: 1}))
+	assertInt(t, 100, median(map[int]int64{1: 1, 100: 2}))
+	assertInt(t, 7, median(map[int]int64{7: 1}))
+}
+
+func TestSampleAccessIdleTimes(t *testing.T) {
+
+	f := newFakeRedis()
+	for i, idle := range []time.Duration{30 * time.Second, time.Minute, 48 * time.Hour} {
+		f.set(fmt.Sprintf("hot:%d", i), TypeString, "value").idle = idle
+	}
+	f.set("warm:1", TypeString, "value").idle = 2 * time.Hour
+	f.set("cold:1", TypeString, "value").idle = 72 * time.Hour
+
+	stats, _, err := sample(context.Background(), f, Options{Census: true, CollectAccess: true}, PrefixAggregator(":"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for group, expected := range map[string]Temperature{"hot": TemperatureHot, "warm": TemperatureWarm, "cold": TemperatureCold} {
+		if actual := stats[group].Temperature(); actual != expected {
+			t.Errorf("%s: expected %q, actual: %q", group, expected, actual)
+		}
+	}
+	assertInt(t, 1, int(stats["hot"].IdleTimes[30]))
+	assertInt(t, 0, len(stats["hot"].AccessFrequencies))
+
+	// the idle time must be read before the key is, which would reset it
+	for i, c := range f.commands {
+		if strings.HasPrefix(c, "GET") && !strings.HasPrefix(f.commands[i-1], "OBJECT IDLETIME") {
+			t.Errorf("expected OBJECT IDLETIME to precede %s, actual: %s", c, f.commands[i-1])
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := RenderText(stats["hot"], &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "--- Access (hot) ---") {
+		t.Errorf("expected the temperature to be reported, actual: %s", buf.String())
+	}
+
+	// configurable thresholds
+	opts := Options{Census: true, CollectAccess: true, TemperatureThresholds: TemperatureThresholds{HotIdleTime: 10 * time.Second, ColdIdleTime: time.Hour}}
+	stats, _, err = sample(context.Background(), f, opts, PrefixAggregator(":"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := stats["warm"].Temperature(); actual != TemperatureCold {
+		t.Errorf("expected a lower cold threshold to apply, actual: %q", actual)
+	}
+	if actual := stats["hot"].Temperature(); actual != TemperatureWarm {
+		t.Errorf("expected a lower hot threshold to apply, actual: %q", actual)
+	}
+
+	// access statistics aren't collected unless requested
+	f.commands = nil
+	stats, _, err = sample(context.Background(), f, Options{Census: true}, PrefixAggregator(":"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := stats["hot"].Temperature(); actual != "" {
+		t.Errorf("unexpected temperature: %q", actual)
+	}
+	for _, c := range f.commands {
+		if strings.HasPrefix(c, "OBJECT") {
+			t.Errorf("unexpected command: %s", c)
+		}
+	}
+}
+
+func TestSampleAccessFrequencies(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("hot:1", TypeString, "value").freq = 100
+	f.set("cold:1", TypeString, "value").freq = 2
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		if cmd != "INFO" {
+			return nil, false
+		}
+		return bulk("# Memory\r\nmaxmemory_policy:allkeys-lfu\r\n# Keyspace\r\ndb0:keys=2,expires=0,avg_ttl=0\r\n"), true
+	}
+
+	stats, _, err := sample(context.Background(), f, Options{Census: true, CollectAccess: true}, PrefixAggregator(":"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 1, int(stats["hot"].AccessFrequencies[100]))
+	if stats["hot"].Temperature() != TemperatureHot || stats["cold"].Temperature() != TemperatureCold {
+		t.Errorf("unexpected temperatures: %q and %q", stats["hot"].Temperature(), stats["cold"].Temperature())
+	}
+	for _, c := range f.commands {
+		if strings.HasPrefix(c, "OBJECT IDLETIME") {
+			t.Errorf("unexpected command under an LFU policy: %s", c)
+		}
+	}
+
+	// servers without OBJECT FREQ yield no access statistics
+	f.commands = nil
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		if cmd != "INFO" {
+			return nil, false
+		}
+		return bulk("# Server\r\nredis_version:3.2.0\r\n# Memory\r\nmaxmemory_policy:allkeys-lfu\r\n# Keyspace\r\ndb0:keys=2,expires=0,avg_ttl=0\r\n"), true
+	}
+	stats, _, err = sample(context.Background(), f, Options{Census: true, CollectAccess: true}, PrefixAggregator(":"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := stats["hot"].Temperature(); actual != "" {
+		t.Errorf("unexpected temperature: %q", actual)
+	}
+	for _, c := range f.commands {
+		if strings.HasPrefix(c, "OBJECT") {
+			t.Errorf("unexpected command without OBJECT FREQ support: %s", c)
+		}
+	}
+}
+
+func TestMergeAccessMetrics(t *testing.T) {
+
+	lru, lfu := NewResults(), NewResults()
+	lru.observeString("a", "value")
+	lru.observeAccess(AccessIdleTime, time.Minute, 0)
+	lfu.observeString("b", "value")
+	lfu.observeAccess(AccessFrequency, 0, 40)
+
+	err := lru.Merge(lfu)
+	if !errors.Is(err, ErrIncompatibleResults) || !strings.Contains(err.Error(), "access metrics") {
+		t.Errorf("expected differing access metrics to be rejected, actual: %v", err)
+	}
+
+	// a group holding both can't be classified
+	lru.observeAccess(AccessFrequency, 0, 40)
+	if actual := lru.Temperature(); actual != "" {
+		t.Errorf("unexpected temperature: %q", actual)
+	}
+}
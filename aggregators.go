@@ -0,0 +1,357 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SampleContext describes a single sampled key, for use by a
+// ContextAggregator.
+type SampleContext struct {
+	Key  string
+	Type ValueType
+
+	// Value holds the value of a sampled string key.  It is nil for all other
+	// data types.
+	Value []byte
+
+	// DB is the index of the logical database holding the key, and Instance
+	// is the Options.Tag of the redis instance it was sampled from (if any),
+	// so that groups can be qualified by their source
+	DB       int
+	Instance string
+}
+
+// A ContextAggregator is an Aggregator that can take more than just the key
+// name and data type into account, such as the sampled value.  When the
+// Aggregator supplied to Run implements ContextAggregator, GroupsWithContext
+// is used in place of Groups.
+type ContextAggregator interface {
+	Aggregator
+	GroupsWithContext(ctx SampleContext) []string
+}
+
+// The ContextAggregatorFunc type is an adapter to allow the use of ordinary
+// functions as ContextAggregators.  Its Groups method calls the function with
+// a SampleContext holding only the key name and data type.
+type ContextAggregatorFunc func(ctx SampleContext) []string
+
+// Groups provides 0 or more groups to aggregate `key` to, when sampling redis
+// keys.
+func (f ContextAggregatorFunc) Groups(key string, valueType ValueType) []string {
+	return f(SampleContext{Key: key, Type: valueType})
+}
+
+// GroupsWithContext provides 0 or more groups to aggregate a sampled key to.
+func (f ContextAggregatorFunc) GroupsWithContext(ctx SampleContext) []string {
+	return f(ctx)
+}
+
+// groupsFor obtains the aggregation groups for a sampled key, using the
+// aggregator's GroupsWithContext method if it has one
+func groupsFor(aggregator Aggregator, ctx SampleContext) []string {
+	if ca, ok := aggregator.(ContextAggregator); ok {
+		return ca.GroupsWithContext(ctx)
+	}
+	return aggregator.Groups(ctx.Key, ctx.Type)
+}
+
+// JSONShapeAggregator is a ContextAggregator that groups string keys holding
+// JSON objects by the "shape" of the object: its sorted top-level field names,
+// e.g. "json:{email,id,name}".  This makes it easy to find out which kinds of
+// serialized documents dominate a keyspace.  Keys that are not strings, or
+// whose values are not JSON objects, are aggregated by the Fallback Aggregator
+// (if any).
+type JSONShapeAggregator struct {
+	Fallback Aggregator
+}
+
+// Groups aggregates keys using the Fallback Aggregator, since JSON shapes can
+// only be determined from a sampled value.
+func (a JSONShapeAggregator) Groups(key string, valueType ValueType) []string {
+	if a.Fallback == nil {
+		return []string{}
+	}
+	return a.Fallback.Groups(key, valueType)
+}
+
+// GroupsWithContext aggregates JSON object string values by their shape, and
+// anything else using the Fallback Aggregator.
+func (a JSONShapeAggregator) GroupsWithContext(ctx SampleContext) []string {
+	if ctx.Type == TypeString {
+		if shape, ok := jsonShape(ctx.Value); ok {
+			return []string{shape}
+		}
+	}
+	if a.Fallback == nil {
+		return []string{}
+	}
+	return groupsFor(a.Fallback, ctx)
+}
+
+// jsonShape returns a group name derived from the sorted top-level field
+// names of `value`, if it holds a JSON object
+func jsonShape(value []byte) (string, bool) {
+	trimmed := bytes.TrimSpace(value)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return "", false
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &obj); err != nil {
+		return "", false
+	}
+
+	fields := make([]string, 0, len(obj))
+	for f := range obj {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return "json:{" + strings.Join(fields, ",") + "}", true
+}
+
+// digitsExpr matches runs of decimal digits
+var digitsExpr = regexp.MustCompile("[0-9]+")
+
+// DefaultNumericPlaceholder is the placeholder used by a
+// NumericCollapseAggregator when none is specified.
+const DefaultNumericPlaceholder = "#"
+
+// NumericCollapseAggregator is an Aggregator that groups keys by their name,
+// with numbers replaced by a placeholder, so that e.g. "order:100234" and
+// "order:100235" are both aggregated as "order:#".  This is usually the
+// quickest way to find the dominant key patterns in a keyspace.
+type NumericCollapseAggregator struct {
+	// Placeholder replaces each number.  When empty,
+	// DefaultNumericPlaceholder is used.
+	Placeholder string
+
+	// Delimiter optionally separates the segments of a key name (e.g. ":").
+	// When set, only segments made up entirely of digits are replaced, so that
+	// "v2:order:100234" becomes "v2:order:#".  When empty, every run of digits
+	// is replaced, wherever it appears: "v#:order:#".
+	Delimiter string
+}
+
+// Groups aggregates `key` by its name, with numbers collapsed
+func (a NumericCollapseAggregator) Groups(key string, valueType ValueType) []string {
+	placeholder := a.Placeholder
+	if placeholder == "" {
+		placeholder = DefaultNumericPlaceholder
+	}
+
+	if a.Delimiter == "" {
+		return []string{digitsExpr.ReplaceAllLiteralString(key, placeholder)}
+	}
+
+	segments := strings.Split(key, a.Delimiter)
+	for i, seg := range segments {
+		if seg != "" && digitsExpr.FindString(seg) == seg {
+			segments[i] = placeholder
+		}
+	}
+	return []string{strings.Join(segments, a.Delimiter)}
+}
+
+// HashBucketAggregator returns an Aggregator that hashes each key name into
+// one of `n` fixed buckets, guaranteeing that sampling produces at most `n`
+// groups however varied the keyspace.  This gives a stable, memory-bounded
+// profile where no natural grouping exists, or where grouping by prefix or
+// regex would produce too many groups.  Bucket names (e.g. "bucket-3/16") are
+// opaque: the keys within a bucket have nothing in common but their hash.  A
+// non-positive `n` is treated as 1.
+func HashBucketAggregator(n int) Aggregator {
+	if n < 1 {
+		n = 1
+	}
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("bucket-%d/%d", i, n)
+	}
+
+	return AggregatorFunc(func(key string, valueType ValueType) []string {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return []string{names[h.Sum32()%uint32(n)]}
+	})
+}
+
+// PrefixAggregator returns an Aggregator that groups keys by their prefix: the
+// part of the key name before the first occurrence of `separator`, e.g. "user"
+// for "user:1234" with a separator of ":".  This is the most common key naming
+// convention, so usually yields a meaningful profile without any code.  Keys
+// that don't contain the separator (or start with it) are grouped together as
+// NoPrefix.
+func PrefixAggregator(separator string) Aggregator {
+	return AggregatorFunc(func(key string, valueType ValueType) []string {
+		i := strings.Index(key, separator)
+		if i <= 0 || separator == "" {
+			return []string{NoPrefix}
+		}
+		return []string{key[:i]}
+	})
+}
+
+// NoPrefix is the group to which PrefixAggregator aggregates keys without a
+// prefix
+const NoPrefix = "(no prefix)"
+
+// ValidatingAggregator wraps another Aggregator, enforcing constraints on the
+// group names it returns, to catch the most common mistakes made by custom
+// aggregators: empty group names, and group names of unbounded length or
+// cardinality (e.g. a regex that accidentally captures a timestamp, which can
+// exhaust memory on a large run).  Offending group names are skipped, and
+// tallied in the Diagnostics.  A ValidatingAggregator is safe for concurrent
+// use, e.g. by RunMulti.
+type ValidatingAggregator struct {
+	// Aggregator is the aggregator being validated
+	Aggregator Aggregator
+
+	// MaxNameLength, when positive, causes longer group names to be skipped
+	MaxNameLength int
+
+	// MaxGroups, when positive, caps the number of distinct groups: once it is
+	// reached, keys are no longer aggregated into any new groups
+	MaxGroups int
+
+	mu          sync.Mutex
+	groups      map[string]bool
+	diagnostics AggregatorDiagnostics
+}
+
+// AggregatorDiagnostics describes the group names returned by an Aggregator
+// wrapped by a ValidatingAggregator.
+type AggregatorDiagnostics struct {
+	// DistinctGroups is the number of distinct (valid) group names returned
+	DistinctGroups int
+
+	// EmptyNames, LongNames and OverflowNames count the group names that
+	// were skipped for being empty, longer than MaxNameLength, or new groups
+	// beyond MaxGroups, respectively
+	EmptyNames    int64
+	LongNames     int64
+	OverflowNames int64
+}
+
+// NewValidatingAggregator creates a ValidatingAggregator wrapping `a`, with
+// the specified limits (0 meaning unlimited).
+func NewValidatingAggregator(a Aggregator, maxNameLength, maxGroups int) *ValidatingAggregator {
+	return &ValidatingAggregator{Aggregator: a, MaxNameLength: maxNameLength, MaxGroups: maxGroups}
+}
+
+// Groups returns the valid groups returned by the wrapped Aggregator
+func (v *ValidatingAggregator) Groups(key string, valueType ValueType) []string {
+	return v.validate(v.Aggregator.Groups(key, valueType))
+}
+
+// GroupsWithContext returns the valid groups returned by the wrapped
+// Aggregator, passing on the SampleContext if it is a ContextAggregator
+func (v *ValidatingAggregator) GroupsWithContext(ctx SampleContext) []string {
+	return v.validate(groupsFor(v.Aggregator, ctx))
+}
+
+// Diagnostics returns the diagnostics accumulated so far
+func (v *ValidatingAggregator) Diagnostics() AggregatorDiagnostics {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	d := v.diagnostics
+	d.DistinctGroups = len(v.groups)
+	return d
+}
+
+// validate filters out any invalid group names, updating the diagnostics
+func (v *ValidatingAggregator) validate(groups []string) []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.groups == nil {
+		v.groups = make(map[string]bool)
+	}
+
+	valid := make([]string, 0, len(groups))
+	for _, g := range groups {
+		switch {
+		case g == "":
+			v.diagnostics.EmptyNames++
+		case v.MaxNameLength > 0 && len(g) > v.MaxNameLength:
+			v.diagnostics.LongNames++
+		case !v.groups[g] && v.MaxGroups > 0 && len(v.groups) >= v.MaxGroups:
+			v.diagnostics.OverflowNames++
+		default:
+			v.groups[g] = true
+			valid = append(valid, g)
+		}
+	}
+	return valid
+}
+
+// multiAggregator combines several Aggregators, so that a single sampling run
+// can feed each sampled key to all of them (see RunMultiAggregator).  Each
+// group name is suffixed with the index of the Aggregator that produced it
+// (see multiGroup), keeping the Aggregators' groups apart even where their
+// names collide, until the results are split up again (see splitGroups).
+type multiAggregator []Aggregator
+
+// Groups provides the groups of every combined Aggregator
+func (m multiAggregator) Groups(key string, valueType ValueType) []string {
+	return m.GroupsWithContext(SampleContext{Key: key, Type: valueType})
+}
+
+// GroupsWithContext provides the groups of every combined Aggregator
+func (m multiAggregator) GroupsWithContext(ctx SampleContext) []string {
+	var groups []string
+	for i, a := range m {
+		for _, g := range groupsFor(a, ctx) {
+			groups = append(groups, multiGroup(g, i))
+		}
+	}
+	return groups
+}
+
+// multiGroup qualifies `group` with the index of the Aggregator that produced
+// it.  The index is appended, rather than prefixed, so that it survives any
+// prefix added by Options.TagGroups.
+func multiGroup(group string, i int) string {
+	return group + "\x00" + strconv.Itoa(i)
+}
+
+// splitGroups splits the results aggregated with a multiAggregator of `n`
+// Aggregators into one map per Aggregator, keyed by the original group names
+func splitGroups(stats map[string]*Results, n int) []map[string]*Results {
+	split := make([]map[string]*Results, n)
+	for i := range split {
+		split[i] = make(map[string]*Results)
+	}
+	for g, r := range stats {
+		sep := strings.LastIndexByte(g, 0)
+		i, err := strconv.Atoi(g[sep+1:])
+		if sep < 0 || err != nil || i < 0 || i >= n {
+			continue
+		}
+		split[i][g[:sep]] = r
+	}
+	return split
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func assertGroups(t *testing.T, expected, actual []string) {
+	if len(expected) == 0 && len(actual) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected groups: %v, actual: %v", expected, actual)
+	}
+}
+
+func TestJSONShapeAggregator(t *testing.T) {
+
+	a := JSONShapeAggregator{Fallback: AggregatorFunc(AnyKey)}
+
+	for value, expected := range map[string]string{
+		`{"name": "x", "id": 1, "email": null}`: "json:{email,id,name}",
+		` {"id": 2, "name": {"nested": true}} `: "json:{id,name}",
+		`{}`:                                    "json:{}",
+		`[1, 2, 3]`:                             "any-key",
+		`{"truncated": `:                        "any-key",
+		`plain text`:                            "any-key",
+	} {
+		ctx := SampleContext{Key: "k", Type: TypeString, Value: []byte(value)}
+		assertGroups(t, []string{expected}, a.GroupsWithContext(ctx))
+	}
+
+	// non-strings always fall through
+	assertGroups(t, []string{"any-key"}, a.GroupsWithContext(SampleContext{Key: "k", Type: TypeHash}))
+
+	// without a fallback, non-JSON keys are not aggregated at all
+	assertGroups(t, nil, JSONShapeAggregator{}.GroupsWithContext(SampleContext{Key: "k", Type: TypeSet}))
+}
+
+func TestNumericCollapseAggregator(t *testing.T) {
+
+	for key, expected := range map[string]string{
+		"order:100234":      "order:#",
+		"order:100235":      "order:#",
+		"v2:order:17:items": "v#:order:#:items",
+		"no-numbers":        "no-numbers",
+	} {
+		assertGroups(t, []string{expected}, NumericCollapseAggregator{}.Groups(key, TypeString))
+	}
+
+	a := NumericCollapseAggregator{Placeholder: "{id}", Delimiter: ":"}
+	for key, expected := range map[string]string{
+		"order:100234":      "order:{id}",
+		"v2:order:17:items": "v2:order:{id}:items",
+		"12:ab12:":          "{id}:ab12:",
+	} {
+		assertGroups(t, []string{expected}, a.Groups(key, TypeString))
+	}
+}
+
+func TestHashBucketAggregator(t *testing.T) {
+
+	a := HashBucketAggregator(8)
+	groups := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		key := "key:" + strconv.Itoa(i)
+		g := a.Groups(key, TypeString)
+		if len(g) != 1 {
+			t.Fatalf("expected a single group for %s, actual: %v", key, g)
+		}
+		assertGroups(t, g, a.Groups(key, TypeHash))
+		groups[g[0]] = true
+	}
+	assertInt(t, 8, len(groups))
+
+	assertGroups(t, []string{"bucket-0/1"}, HashBucketAggregator(0).Groups("any", TypeString))
+}
+
+func TestValidatingAggregator(t *testing.T) {
+
+	a := NewValidatingAggregator(AggregatorFunc(func(key string, vt ValueType) []string {
+		return []string{"", key}
+	}), 8, 2)
+
+	assertGroups(t, []string{"a"}, a.Groups("a", TypeString))
+	assertGroups(t, []string{"b"}, a.Groups("b", TypeString))
+	assertGroups(t, nil, a.Groups("much-too-long", TypeString))
+	assertGroups(t, nil, a.Groups("c", TypeString))
+	assertGroups(t, []string{"a"}, a.GroupsWithContext(SampleContext{Key: "a", Type: TypeString}))
+
+	d := a.Diagnostics()
+	expected := AggregatorDiagnostics{DistinctGroups: 2, EmptyNames: 5, LongNames: 1, OverflowNames: 1}
+	if d != expected {
+		t.Errorf("expected diagnostics: %+v, actual: %+v", expected, d)
+	}
+
+	// context is passed through to a wrapped ContextAggregator
+	j := NewValidatingAggregator(JSONShapeAggregator{}, 0, 0)
+	assertGroups(t, []string{"json:{id}"}, j.GroupsWithContext(SampleContext{Key: "k", Type: TypeString, Value: []byte(`{"id": 1}`)}))
+}
+
+func TestSampleWithContextAggregator(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("user:1", TypeString, `{"id": 1, "name": "a"}`)
+	f.set("user:2", TypeString, `{"name": "b", "id": 2}`)
+	f.set("flag", TypeString, "1")
+
+	stats, _, err := sample(context.Background(), f, Options{MinSamples: 3}, JSONShapeAggregator{Fallback: AggregatorFunc(AnyKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 2, len(stats))
+	assertInt(t, 2, int(stats["json:{id,name}"].KeyCount))
+	assertInt(t, 1, int(stats["any-key"].KeyCount))
+}
+
+func TestMultiAggregator(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("user:1", TypeString, "alice")
+	f.set("user:2", TypeHash, "name", "bob")
+	f.set("session:1", TypeString, "token")
+
+	byType := AggregatorFunc(func(key string, vt ValueType) []string {
+		return []string{string(vt)}
+	})
+	byPrefix := AggregatorFunc(func(key string, vt ValueType) []string {
+		return []string{strings.SplitN(key, ":", 2)[0]}
+	})
+	// both aggregators produce a "string" group, which must be kept apart
+	clashing := AggregatorFunc(func(key string, vt ValueType) []string {
+		return []string{"string"}
+	})
+
+	stats, summary, err := sample(context.Background(), f, Options{Census: true, Tag: "a", TagGroups: true}, multiAggregator{byType, byPrefix, clashing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 3, summary.Sampled)
+
+	split := splitGroups(stats, 3)
+	assertInt(t, 3, len(split))
+	assertInt(t, 2, int(split[0]["a/string"].KeyCount))
+	assertInt(t, 1, int(split[0]["a/hash"].KeyCount))
+	assertInt(t, 2, int(split[1]["a/user"].KeyCount))
+	assertInt(t, 1, int(split[1]["a/session"].KeyCount))
+	assertInt(t, 1, len(split[2]))
+	assertInt(t, 3, int(split[2]["a/string"].KeyCount))
+}
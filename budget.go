@@ -0,0 +1,129 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// BudgetCheckInterval is the number of keys observed between checks of
+	// Options.SamplerMemoryBudget
+	BudgetCheckInterval = 1000
+
+	// resultsBytes, countEntryBytes and stringEntryBytes are rough estimates of
+	// the memory used by an empty Results, by each entry of a histogram, and by
+	// each entry of a set of strings (or similar), excluding the string itself
+	resultsBytes     = 2048
+	countEntryBytes  = 32
+	stringEntryBytes = 64
+)
+
+// footprint returns a rough estimate of the memory, in bytes, retained by the
+// Results (see Options.SamplerMemoryBudget)
+func (r *Results) footprint() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := int64(resultsBytes)
+	for _, freq := range []map[int]int64{
+		r.KeyNameSizes, r.StringSizes, r.SetSizes, r.SetElementSizes,
+		r.SortedSetSizes, r.SortedSetElementSizes, r.HashSizes,
+		r.HashElementSizes, r.HashValueSizes, r.ListSizes, r.ListElementSizes,
+		r.ListHeadElementSizes, r.ListTailElementSizes, r.ShortLivedSizes,
+		r.FetchLatencies, r.StoredValueSizes, r.LogicalValueSizes, r.IdleTimes,
+		r.AccessFrequencies, r.TTLs, r.MemoryUsages,
+	} {
+		n += int64(len(freq)) * countEntryBytes
+	}
+	for _, set := range []map[string]bool{
+		r.StringKeys, r.StringValues, r.SetKeys, r.SetElements,
+		r.SortedSetKeys, r.SortedSetElements, r.HashKeys, r.HashElements,
+		r.HashValues, r.ListKeys, r.ListElements,
+	} {
+		for s := range set {
+			n += int64(stringEntryBytes + len(s))
+		}
+	}
+	for _, encodings := range r.Encodings {
+		for e := range encodings {
+			n += int64(stringEntryBytes + len(e))
+		}
+	}
+	for _, t := range r.TopValues {
+		for v := range t.counts {
+			n += int64(stringEntryBytes + len(v))
+		}
+	}
+	for _, bk := range r.BigKeys {
+		n += int64(stringEntryBytes + len(bk.Key))
+	}
+	for instance := range r.Instances {
+		n += int64(stringEntryBytes + len(instance))
+	}
+	return n
+}
+
+// enforceBudget evicts the groups with the fewest sampled keys until the
+// estimated memory retained by all groups fits within
+// Options.SamplerMemoryBudget, always keeping at least one group.  It returns
+// the number of groups evicted.
+func (s *sampler) enforceBudget() int {
+	budget := int64(s.opts.SamplerMemoryBudget)
+	if budget <= 0 || len(s.stats) <= 1 {
+		return 0
+	}
+
+	var total int64
+	footprints := make(map[string]int64, len(s.stats))
+	groups := make([]string, 0, len(s.stats))
+	for g, r := range s.stats {
+		footprints[g] = r.footprint()
+		total += footprints[g]
+		groups = append(groups, g)
+	}
+	if total <= budget {
+		return 0
+	}
+
+	// the least important groups are those with the fewest sampled keys
+	sort.Slice(groups, func(i, j int) bool {
+		ni, nj := s.stats[groups[i]].keyCount(), s.stats[groups[j]].keyCount()
+		if ni != nj {
+			return ni < nj
+		}
+		return groups[i] < groups[j]
+	})
+
+	var evicted int
+	for _, g := range groups[:len(groups)-1] {
+		if total <= budget {
+			break
+		}
+		total -= footprints[g]
+		delete(s.stats, g)
+		evicted++
+	}
+	return evicted
+}
+
+// budgetWarning describes the eviction of `evicted` groups to keep within the
+// SamplerMemoryBudget
+func budgetWarning(evicted, budget int) string {
+	return fmt.Sprintf("%d groups were evicted to keep the sampler's memory use within %d bytes; their keys are not reported", evicted, budget)
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSamplerMemoryBudget(t *testing.T) {
+
+	f := newFakeRedis()
+	for i := 0; i < 1500; i++ {
+		f.set("big:"+strconv.Itoa(i), TypeString, "value")
+	}
+	for i := 0; i < 500; i++ {
+		f.set("tiny"+strconv.Itoa(i)+":key", TypeString, "value")
+	}
+
+	const budget = 50000
+	opts := Options{Census: true, SamplerMemoryBudget: budget}
+	stats, summary, err := sample(context.Background(), f, opts, PrefixAggregator(":"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.EvictedGroups == 0 {
+		t.Fatal("expected groups to be evicted")
+	}
+	assertInt(t, 501, len(stats)+summary.EvictedGroups)
+	assertInt(t, 1500, int(stats["big"].KeyCount))
+
+	var total int64
+	for _, r := range stats {
+		total += r.footprint()
+	}
+	if total > budget {
+		t.Errorf("expected the retained groups to fit within the budget, actual: %d bytes", total)
+	}
+	if !strings.Contains(strings.Join(summary.Warnings, "\n"), "groups were evicted") {
+		t.Errorf("expected a warning about the evicted groups, actual: %v", summary.Warnings)
+	}
+
+	// without a budget, nothing is evicted
+	stats, summary, err = sample(context.Background(), f, Options{Census: true}, PrefixAggregator(":"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 0, summary.EvictedGroups)
+	assertInt(t, 501, len(stats))
+
+	if err := validate(Options{SamplerMemoryBudget: -1}); err == nil {
+		t.Error("expected an error for a negative budget")
+	}
+}
+
+func TestSamplerMemoryBudgetUniqueGroups(t *testing.T) {
+
+	// every key forms its own group, e.g. as with a timestamp in the group
+	// name, so nearly every group is evicted
+	f := newFakeRedis()
+	for i := 0; i < 5000; i++ {
+		f.set("event:"+strconv.Itoa(i), TypeString, "value")
+	}
+	unique := AggregatorFunc(func(key string, _ ValueType) []string { return []string{key} })
+
+	const budget = 20000
+	opts := Options{Census: true, SamplerMemoryBudget: budget}
+	stats, summary, err := sample(context.Background(), f, opts, unique)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertInt(t, 5000, len(stats)+summary.EvictedGroups)
+	var total int64
+	for _, r := range stats {
+		total += r.footprint()
+	}
+	if total > budget {
+		t.Errorf("expected the retained groups to fit within the budget, actual: %d bytes", total)
+	}
+	if len(stats) > budget/resultsBytes {
+		t.Errorf("expected at most %d groups to be retained, actual: %d", budget/resultsBytes, len(stats))
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Capabilities describes the optional server features that reckon may make
+// use of, as detected from the version reported by redis' `INFO` command.
+type Capabilities struct {
+	// Version is the redis server version, or "" if it could not be determined.
+	// When the version is unknown, every feature is assumed to be available.
+	Version string
+
+	// ObjectEncoding indicates support for `OBJECT ENCODING` (redis >= 2.2.3)
+	ObjectEncoding bool
+
+	// MemoryUsage indicates support for `MEMORY USAGE` (redis >= 4.0)
+	MemoryUsage bool
+
+	// ObjectFreq indicates support for `OBJECT FREQ` (redis >= 4.0).  Note that
+	// the command also requires an LFU maxmemory-policy.
+	ObjectFreq bool
+
+	// HRandField indicates support for `HRANDFIELD` and `ZRANDMEMBER`
+	// (redis >= 6.2)
+	HRandField bool
+}
+
+// parseVersion parses a dotted redis version string into its numeric
+// components, returning nil if the version is malformed
+func parseVersion(v string) []int {
+	var parts []int
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+// atLeast indicates whether `version` is greater than or equal to `min`
+func atLeast(version []int, min ...int) bool {
+	for i, m := range min {
+		var v int
+		if i < len(version) {
+			v = version[i]
+		}
+		if v != m {
+			return v > m
+		}
+	}
+	return true
+}
+
+// probeCapabilities determines the server's capabilities from the output of
+// redis' `INFO` command
+func probeCapabilities(info map[string]string) Capabilities {
+	c := Capabilities{Version: info["redis_version"]}
+	v := parseVersion(c.Version)
+	if v == nil {
+		c.Version = ""
+		return Capabilities{
+			ObjectEncoding: true,
+			MemoryUsage:    true,
+			ObjectFreq:     true,
+			HRandField:     true,
+		}
+	}
+
+	c.ObjectEncoding = atLeast(v, 2, 2, 3)
+	c.MemoryUsage = atLeast(v, 4)
+	c.ObjectFreq = atLeast(v, 4)
+	c.HRandField = atLeast(v, 6, 2)
+	return c
+}
+
+// restrict disables any optional collectors in `opts` that depend on features
+// the server lacks, returning a warning for each one that was disabled (or,
+// for memory usage, that falls back to estimates)
+func (c Capabilities) restrict(opts *Options) []string {
+	var warnings []string
+	if opts.CollectEncodings && !c.ObjectEncoding {
+		opts.CollectEncodings = false
+		warnings = append(warnings, "OBJECT ENCODING is not supported by redis "+c.Version+"; encodings will not be collected")
+	}
+	if opts.CollectMemoryUsage && !c.MemoryUsage {
+		warnings = append(warnings, "MEMORY USAGE is not supported by redis "+c.Version+"; memory usage will be estimated from the sampled contents")
+	}
+	return warnings
+}
+
+// contentCommands holds the commands used to sample the contents of a key of
+// each data type, other than its length (see lengthCommands).  Where two
+// commands are listed, which is used depends on the server's Capabilities.
+var contentCommands = map[ValueType][]string{
+	TypeString:    {"GET"},
+	TypeList:      {"LRANGE"},
+	TypeSet:       {"SRANDMEMBER"},
+	TypeSortedSet: {"ZRANDMEMBER", "ZRANGE"},
+	TypeHash:      {"HRANDFIELD", "HSCAN"},
+}
+
+// Commands returns the (sorted) names of every redis command that sampling
+// may issue when configured with `opts`, so that they can be pre-authorized,
+// e.g. with an ACL, and reckon's footprint on the server audited.  Subcommands
+// are given in ACL form, e.g. "OBJECT|ENCODING".  Since the commands used for
+// some data types depend on the server's version (see Capabilities), and SCAN
+// is used in place of RANDOMKEY when the latter is not permitted, the set
+// includes every command that could be used, not only those that will be.
+func Commands(opts Options) []string {
+	opts = withDefaults(opts)
+
+	cmds := map[string]bool{"INFO": true, "TYPE": true, "SCAN": true}
+	if opts.Password != "" {
+		cmds["AUTH"] = true
+	}
+	if opts.DB != 0 {
+		cmds["SELECT"] = true
+	}
+	if !opts.Census && opts.Strategy == StrategyRandom && len(opts.Slots) == 0 {
+		cmds["RANDOMKEY"] = true
+	}
+	if opts.CollectEncodings {
+		cmds["OBJECT|ENCODING"] = true
+	}
+	if collectTTLs(opts) {
+		cmds["PTTL"] = true
+	}
+	if opts.CollectMemoryUsage {
+		cmds["MEMORY|USAGE"] = true
+	}
+	if opts.MaxIdleTime > 0 || opts.CollectAccess {
+		cmds["OBJECT|IDLETIME"] = true
+	}
+	if opts.CollectAccess {
+		// used instead of OBJECT IDLETIME under an LFU maxmemory-policy
+		cmds["OBJECT|FREQ"] = true
+	}
+	for _, vt := range valueTypes {
+		if filtersLengths(opts) {
+			// lengths are checked before the contents are read
+			cmds[lengthCommands[vt]] = true
+		}
+		if vt == TypeString && !opts.SizeOnlyTypes[vt] {
+			// the length of a string is that of its value
+			cmds["GET"] = true
+			continue
+		}
+		cmds[lengthCommands[vt]] = true
+		if opts.SizeOnlyTypes[vt] {
+			continue
+		}
+		if c, ok := scanCommands[vt]; ok && opts.ElementStrategy == StrategyReservoir {
+			cmds[c] = true
+			continue
+		}
+		for _, c := range contentCommands[vt] {
+			cmds[c] = true
+		}
+	}
+
+	names := make([]string, 0, len(cmds))
+	for c := range cmds {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ACLRules returns the Commands that sampling may issue when configured with
+// `opts` as redis ACL rules, e.g. "+get +info +object|encoding", ready to be
+// used with `ACL SETUSER`.
+func ACLRules(opts Options) string {
+	cmds := Commands(opts)
+	rules := make([]string, len(cmds))
+	for i, c := range cmds {
+		rules[i] = "+" + strings.ToLower(c)
+	}
+	return strings.Join(rules, " ")
+}
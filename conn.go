@@ -0,0 +1,190 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// countingConn is a redis.Conn that keeps track of the number of commands
+// issued, and the (approximate) number of bytes received in replies, so that
+// the load a sampling run imposes on a redis instance can be reported.
+type countingConn struct {
+	redis.Conn
+	commands int64
+	bytes    int64
+}
+
+// replySize estimates the size in bytes of a redis reply, excluding protocol
+// overhead
+func replySize(reply interface{}) int64 {
+	switch r := reply.(type) {
+	case []byte:
+		return int64(len(r))
+	case string:
+		return int64(len(r))
+	case redis.Error:
+		return int64(len(r))
+	case int64:
+		return int64(len(strconv.FormatInt(r, 10)))
+	case []interface{}:
+		var n int64
+		for _, e := range r {
+			n += replySize(e)
+		}
+		return n
+	}
+	return 0
+}
+
+func (c *countingConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "" {
+		c.commands++
+	}
+	reply, err := c.Conn.Do(cmd, args...)
+	c.bytes += replySize(reply)
+	if e, ok := err.(redis.Error); ok {
+		c.bytes += replySize(e)
+	}
+	return reply, err
+}
+
+func (c *countingConn) Send(cmd string, args ...interface{}) error {
+	c.commands++
+	return c.Conn.Send(cmd, args...)
+}
+
+func (c *countingConn) Receive() (interface{}, error) {
+	reply, err := c.Conn.Receive()
+	c.bytes += replySize(reply)
+	return reply, err
+}
+
+// transientErrors are the prefixes of error replies that indicate a temporary
+// condition, such as an instance that is still loading its dataset after a
+// restart, so that the command is worth retrying
+var transientErrors = []string{"LOADING", "MASTERDOWN", "TRYAGAIN", "BUSY "}
+
+// isTransient indicates whether `err` is an error reply worth retrying
+func isTransient(err error) bool {
+	e, ok := err.(redis.Error)
+	if !ok {
+		return false
+	}
+	for _, prefix := range transientErrors {
+		if strings.HasPrefix(string(e), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// pipelined is a command sent, but not yet flushed, on a retryingConn
+type pipelined struct {
+	cmd  string
+	args []interface{}
+}
+
+// retryingConn is a redis.Conn that retries commands failing with transient
+// error replies (see Options.CommandRetries), with exponential backoff.  A
+// failed pipeline is retried in its entirety, so that each retry sees the same
+// replies it would have without the failure.  Other errors, including network
+// errors (after which the connection is unusable), are not retried.  Once ctx
+// is done, a command that is backing off fails with ctx.Err().
+type retryingConn struct {
+	redis.Conn
+	ctx     context.Context
+	retries int
+	backoff time.Duration
+
+	// pending holds the commands sent since the pipeline was last flushed
+	pending []pipelined
+
+	// retried is the number of retries made
+	retried int64
+}
+
+// newRetryingConn wraps `conn` in a retryingConn, configured by `opts`, whose
+// backoff is cut short once `ctx` is done
+func newRetryingConn(ctx context.Context, conn redis.Conn, opts Options) *retryingConn {
+	c := &retryingConn{Conn: conn, ctx: ctx, retries: opts.CommandRetries, backoff: opts.CommandRetryBackoff}
+	if c.retries == 0 {
+		c.retries = DefaultCommandRetries
+	}
+	if c.backoff == 0 {
+		c.backoff = DefaultCommandRetryBackoff
+	}
+	return c
+}
+
+func (c *retryingConn) Send(cmd string, args ...interface{}) error {
+	c.pending = append(c.pending, pipelined{cmd: cmd, args: args})
+	return c.Conn.Send(cmd, args...)
+}
+
+func (c *retryingConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	pending := c.pending
+	c.pending = nil
+
+	reply, err := c.Conn.Do(cmd, args...)
+	for i := 0; i < c.retries && failedTransiently(reply, err); i++ {
+		if err := c.wait(c.backoff << uint(i)); err != nil {
+			return nil, err
+		}
+		c.retried++
+		for _, p := range pending {
+			if err := c.Conn.Send(p.cmd, p.args...); err != nil {
+				return nil, err
+			}
+		}
+		reply, err = c.Conn.Do(cmd, args...)
+	}
+	return reply, err
+}
+
+// wait waits for `d`, or until ctx is done, in which case it returns ctx.Err()
+func (c *retryingConn) wait(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// failedTransiently indicates whether a command (or, when flushing a
+// pipeline, any of its commands) failed with a transient error reply
+func failedTransiently(reply interface{}, err error) bool {
+	if isTransient(err) {
+		return true
+	}
+	if replies, ok := reply.([]interface{}); ok && err == nil {
+		for _, r := range replies {
+			if e, ok := r.(redis.Error); ok && isTransient(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
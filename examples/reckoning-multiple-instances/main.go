@@ -18,13 +18,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
-	"sync"
+	"time"
 
 	"github.com/zulily/reckon"
 )
@@ -63,17 +65,11 @@ func (a *Addresses) Set(value string) error {
 	return nil
 }
 
-// reckonResult allow us to return results OR an error on the same chan
-type reckonResult struct {
-	s        map[string]*reckon.Results
-	keyCount int64
-	err      error
-}
-
 type options struct {
 	redises    Addresses
 	minSamples int
 	sampleRate float64
+	timeout    time.Duration
 }
 
 var (
@@ -84,65 +80,55 @@ func main() {
 
 	flag.Float64Var(&opts.sampleRate, "sample-rate", 0.1, "The percentage of the keyspace to sample on each redis")
 	flag.IntVar(&opts.minSamples, "min-samples", 100, "minimum number of keys to sample on each redis")
+	flag.DurationVar(&opts.timeout, "timeout", 0, "stop sampling after this long, and report on the keys sampled so far (0 for no timeout)")
 	flag.Var(&opts.redises, "redis", "host:port address of a redis instance to sample (may be specified multiple times)")
 	flag.Parse()
 
-	// Sample 100 keys from each of three redis instances, all running on different ports on localhost
+	// Sample keys from each of the redis instances, tagging each one with its
+	// address so that the merged results can be attributed to an instance
 	var reckonOpts []reckon.Options
 
 	for _, redis := range opts.redises {
-		opt := reckon.Options{Host: redis.Host, Port: redis.Port, MinSamples: opts.minSamples, SampleRate: float32(opts.sampleRate)}
+		opt := reckon.Options{
+			Host:       redis.Host,
+			Port:       redis.Port,
+			Tag:        net.JoinHostPort(redis.Host, strconv.Itoa(redis.Port)),
+			MinSamples: opts.minSamples,
+			SampleRate: float32(opts.sampleRate),
+		}
 		reckonOpts = append(reckonOpts, opt)
 	}
 
-	aggregator := reckon.AggregatorFunc(reckon.AnyKey)
-
-	var wg sync.WaitGroup
-	results := make(chan reckonResult)
-	wg.Add(len(reckonOpts))
-
-	// Sample each redis in its own goroutine
-	for _, instanceOpts := range reckonOpts {
-		go func(opts reckon.Options) {
-			defer wg.Done()
-			log.Printf("Sampling %d keys from redis at: %s:%d...\n", opts.MinSamples, opts.Host, opts.Port)
-			s, keyCount, err := reckon.Run(opts, aggregator)
-			results <- reckonResult{s: s, keyCount: keyCount, err: err}
-		}(instanceOpts)
+	// Stop sampling on an interrupt, or once the timeout (if any) expires
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
 	}
 
-	// Collect and merge all the results
-	totals := make(map[string]*reckon.Results)
-	totalKeyCount := int64(0)
-
-	go func() {
-		for r := range results {
-			if r.err != nil {
-				panic(r.err)
-			}
-			log.Println("Got results back from a redis instance!")
-
-			totalKeyCount += r.keyCount
-			for k, v := range r.s {
-				if existing, ok := totals[k]; ok {
-					existing.Merge(v)
-					totals[k] = existing
-				} else {
-					totals[k] = v
-				}
-			}
-		}
-	}()
-
-	// render the final results to HTML when everything is complete
-	wg.Wait()
-	close(results)
+	// Sample each redis in its own goroutine, and merge all the results
+	log.Printf("Sampling %d redis instances...\n", len(reckonOpts))
+	totals, summary, err := reckon.RunMultiContext(ctx, reckonOpts, reckon.AggregatorFunc(reckon.AnyKey))
+	if ctx.Err() != nil {
+		log.Printf("Sampling stopped early (%s); reporting on the keys sampled so far\n", ctx.Err())
+	} else if err != nil {
+		panic(err)
+	}
 
-	log.Printf("total key count: %d\n", totalKeyCount)
-	for k, v := range totals {
+	// render the final results to HTML
+	log.Printf("total key count: %d\n", summary.KeyCount)
+	log.Printf("sampled %d keys in %s (%.0f keys/sec)\n", summary.Sampled, summary.Duration, summary.Throughput())
+	for _, w := range summary.Warnings {
+		log.Printf("warning: %s\n", w)
+	}
+	reportFile := func(group string) string { return fmt.Sprintf("output-%s.html", group) }
+	for _, gr := range reckon.Ordered(totals, reckon.ByKeyCount) {
+		k, v := gr.Group, gr.Results
 
 		v.Name = k
-		if f, err := os.Create(fmt.Sprintf("output-%s.html", k)); err != nil {
+		if f, err := os.Create(reportFile(k)); err != nil {
 			panic(err)
 		} else {
 			defer f.Close()
@@ -153,4 +139,26 @@ func main() {
 		}
 
 	}
+
+	// link to each group's report from an index page
+	if f, err := os.Create("index.html"); err != nil {
+		panic(err)
+	} else {
+		defer f.Close()
+		log.Printf("Rendering an index of the reports to %s\n", f.Name())
+		if err := reckon.RenderIndex(totals, f, reportFile); err != nil {
+			panic(err)
+		}
+	}
+
+	// and give an overview of which groups dominate memory use
+	if f, err := os.Create("treemap.svg"); err != nil {
+		panic(err)
+	} else {
+		defer f.Close()
+		log.Printf("Rendering a treemap of the groups to %s\n", f.Name())
+		if err := reckon.RenderTreemap(totals, f); err != nil {
+			panic(err)
+		}
+	}
 }
@@ -17,10 +17,12 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
 
 	"github.com/zulily/reckon"
@@ -45,22 +47,34 @@ func setsThatStartWithA(key string, valueType reckon.ValueType) []string {
 
 func main() {
 
-	var sampleRate float64
+	var sampleRate, maxMeanSize float64
 	opts := reckon.Options{}
 	flag.StringVar(&opts.Host, "host", "localhost", "the hostname of the redis server")
 	flag.IntVar(&opts.Port, "port", 6379, "the port of the redis server")
 	flag.IntVar(&opts.MinSamples, "min-samples", 50, "number of random samples to take (should be <= the number of keys in the redis instance")
 	flag.Float64Var(&sampleRate, "sample-rate", 0.1, "The percentage of the keyspace to sample on each redis")
+	flag.Float64Var(&maxMeanSize, "max-mean-size", 0, "if non-zero, exit with status 1 when the mean size of any type of value exceeds this many bytes")
 	flag.Parse()
 
 	opts.SampleRate = float32(sampleRate)
-	stats, keyCount, err := reckon.Run(opts, reckon.AggregatorFunc(reckon.AnyKey))
-	if err != nil {
+
+	// on Ctrl-C, stop sampling, but still render whatever was sampled so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	stats, summary, err := reckon.RunContext(ctx, opts, reckon.AggregatorFunc(reckon.AnyKey))
+	if summary.Interrupted {
+		log.Println("interrupted; rendering the partial results")
+	} else if err != nil {
 		panic(err)
 	}
 
-	log.Printf("total key count: %d\n", keyCount)
-	for k, v := range stats {
+	log.Printf("total key count: %d\n", summary.KeyCount)
+	log.Printf("sampled %d keys in %s (%.0f keys/sec)\n", summary.Sampled, summary.Duration, summary.Throughput())
+	for _, w := range summary.Warnings {
+		log.Printf("warning: %s\n", w)
+	}
+	for _, gr := range reckon.Ordered(stats, reckon.ByKeyCount) {
+		k, v := gr.Group, gr.Results
 		log.Printf("stats for: %s\n", k)
 
 		v.Name = k
@@ -74,4 +88,12 @@ func main() {
 			}
 		}
 	}
+
+	// act as a regression gate, e.g. in CI
+	if ok, violations := reckon.Check(stats, []reckon.Rule{{MaxMeanSize: maxMeanSize}}); !ok {
+		for _, v := range violations {
+			log.Println(v)
+		}
+		os.Exit(1)
+	}
 }
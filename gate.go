@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"fmt"
+	"math"
+)
+
+// A Rule is a set of thresholds that sampling results must not exceed, for
+// use with Check, e.g. to fail a CI build when a code change starts writing
+// giant values.  Thresholds of zero are not checked.
+type Rule struct {
+	// Group restricts the rule to a single aggregation group.  When empty,
+	// the rule applies to every group.
+	Group string
+
+	// Type restricts the rule to keys of a single data type.  When empty,
+	// the rule applies to the keys of each data type in turn (and MaxKeyCount
+	// to all keys).
+	Type ValueType
+
+	// MaxKeyCount is the maximum number of sampled keys
+	MaxKeyCount int64
+
+	// MaxMeanSize is the maximum mean size in bytes of the sampled values:
+	// string values, hash values, or the members of lists, sets and sorted
+	// sets (see Results.MeanValueSize)
+	MaxMeanSize float64
+
+	// MaxBigKeys is the maximum number of big keys (see
+	// Options.BigKeyThreshold)
+	MaxBigKeys int
+}
+
+// A Violation describes a Rule that the results for an aggregation group
+// broke.
+type Violation struct {
+	Group string
+	Rule  Rule
+
+	// Message describes the violation, e.g. `group "sessions": mean string
+	// size of 2048.00 bytes exceeds the maximum of 1024.00`
+	Message string
+}
+
+func (v Violation) String() string {
+	return v.Message
+}
+
+// Check evaluates the results for every aggregation group in `stats` against
+// `rules`, returning whether they all passed, along with any violations
+// (ordered by group).  Rules naming a group that is absent from `stats` pass.
+func Check(stats map[string]*Results, rules []Rule) (ok bool, violations []Violation) {
+	for _, gr := range Ordered(stats, ByGroup) {
+		for _, rule := range rules {
+			if rule.Group == "" || rule.Group == gr.Group {
+				violations = append(violations, rule.check(gr.Group, gr.Results)...)
+			}
+		}
+	}
+	return len(violations) == 0, violations
+}
+
+// check evaluates the results for a single group against the Rule
+func (rule Rule) check(group string, r *Results) []Violation {
+	var violations []Violation
+	violate := func(format string, args ...interface{}) {
+		violations = append(violations, Violation{
+			Group:   group,
+			Rule:    rule,
+			Message: fmt.Sprintf("group %q: ", group) + fmt.Sprintf(format, args...),
+		})
+	}
+
+	types := valueTypes
+	if rule.Type != "" {
+		types = []ValueType{rule.Type}
+	}
+
+	if rule.MaxKeyCount > 0 {
+		if rule.Type == "" {
+			if n := r.keyCount(); n > rule.MaxKeyCount {
+				violate("%d keys exceeds the maximum of %d", n, rule.MaxKeyCount)
+			}
+		} else if n := r.TypeCounts()[rule.Type]; n > rule.MaxKeyCount {
+			violate("%d %s keys exceeds the maximum of %d", n, rule.Type, rule.MaxKeyCount)
+		}
+	}
+
+	if rule.MaxMeanSize > 0 {
+		for _, vt := range types {
+			if mean := r.MeanValueSize(vt); !math.IsNaN(mean) && mean > rule.MaxMeanSize {
+				violate("mean %s size of %.2f bytes exceeds the maximum of %.2f", vt, mean, rule.MaxMeanSize)
+			}
+		}
+	}
+
+	if rule.MaxBigKeys > 0 {
+		if n := r.bigKeyCount(rule.Type); n > rule.MaxBigKeys {
+			violate("%d big keys exceeds the maximum of %d", n, rule.MaxBigKeys)
+		}
+	}
+	return violations
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+
+	sessions, users := NewResults(), NewResults()
+	sessions.observeString("s1", strings.Repeat("x", 2000))
+	sessions.observeString("s2", strings.Repeat("x", 1000))
+	sessions.observeBigKey(BigKey{Key: "s1", Type: TypeString, Length: 2000, Size: 2000})
+	users.observeHash("u1", 2, "name", "bob", "email", "bob@example.com")
+	stats := map[string]*Results{"sessions": sessions, "users": users}
+
+	if ok, violations := Check(stats, []Rule{{MaxKeyCount: 2, MaxMeanSize: 2000, MaxBigKeys: 1}}); !ok {
+		t.Errorf("expected the results to pass, actual: %v", violations)
+	}
+
+	ok, violations := Check(stats, []Rule{
+		{MaxMeanSize: 1024},
+		{Group: "users", Type: TypeHash, MaxMeanSize: 5},
+		{Type: TypeString, MaxKeyCount: 1},
+		{Group: "missing", MaxKeyCount: 1},
+	})
+	if ok {
+		t.Fatal("expected the results to fail")
+	}
+
+	expected := []string{
+		`group "sessions": mean string size of 1500.00 bytes exceeds the maximum of 1024.00`,
+		`group "sessions": 2 string keys exceeds the maximum of 1`,
+		`group "users": mean hash size of 9.00 bytes exceeds the maximum of 5.00`,
+	}
+	if len(violations) != len(expected) {
+		t.Fatalf("expected %d violations, actual: %v", len(expected), violations)
+	}
+	for i, v := range violations {
+		if v.String() != expected[i] {
+			t.Errorf("expected violation %q, actual: %q", expected[i], v)
+		}
+	}
+}
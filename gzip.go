@@ -0,0 +1,55 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// RenderGzip gzip-compresses the output of `render` (e.g. a closure around any
+// of the Render functions) as it is written to `out`, which is useful when
+// archiving large reports.  The gzip stream is closed once `render` returns,
+// so that `out` holds a complete gzip file, and `out` is then flushed if it
+// has a Flush method.  Render functions that flush as they go (such as
+// RenderNDJSON) don't flush the gzip stream, since doing so after every write
+// would hurt the compression ratio.  If `render` fails, the stream is still
+// closed, and its error is returned.
+func RenderGzip(out io.Writer, render func(w io.Writer) error) error {
+	zw := gzip.NewWriter(out)
+	// hide the gzip.Writer's Flush method from `render`
+	err := render(struct{ io.Writer }{zw})
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return flushWriter(out)
+}
+
+// RenderHTMLGz is like RenderHTML, but gzip-compresses the report (see
+// RenderGzip).
+func RenderHTMLGz(s *Results, out io.Writer) error {
+	return RenderGzip(out, func(w io.Writer) error { return RenderHTML(s, w) })
+}
+
+// RenderNDJSONGz is like RenderNDJSON, but gzip-compresses the output (see
+// RenderGzip).
+func RenderNDJSONGz(stats map[string]*Results, out io.Writer) error {
+	return RenderGzip(out, func(w io.Writer) error { return RenderNDJSON(stats, w) })
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestRenderGzip(t *testing.T) {
+	r := NewResults()
+	r.observeString("a1", "value")
+
+	var plain, buf bytes.Buffer
+	if err := RenderNDJSON(map[string]*Results{"a": r}, &plain); err != nil {
+		t.Fatal(err)
+	}
+	w := bufio.NewWriterSize(&buf, 16)
+	if err := RenderNDJSONGz(map[string]*Results{"a": r}, w); err != nil {
+		t.Fatal(err)
+	}
+
+	// the whole stream must have been closed and flushed through to `buf`
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != plain.String() {
+		t.Errorf("expected %q, actual: %q", plain.String(), b)
+	}
+
+	buf.Reset()
+	if err := RenderHTMLGz(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err = gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err = ioutil.ReadAll(zr); err != nil || !strings.Contains(string(b), "</html>") {
+		t.Errorf("expected a complete HTML report, actual: %q (%v)", b, err)
+	}
+
+	renderErr := errors.New("render failed")
+	if err := RenderGzip(&buf, func(w io.Writer) error { return renderErr }); err != renderErr {
+		t.Errorf("expected the render error, actual: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// JSONSchemaVersion is the version of the schema of the document written by
+// RenderJSON, following semantic versioning: the minor version is incremented
+// when fields are added (which parsers should ignore), and the major version
+// when fields are removed or renamed, or their meaning changes.
+const JSONSchemaVersion = "1.1.0"
+
+// jsonDocument is the envelope written by RenderJSON
+type jsonDocument struct {
+	SchemaVersion string       `json:"schemaVersion"`
+	GeneratedAt   time.Time    `json:"generatedAt"`
+	Instance      jsonInstance `json:"instance"`
+	Results       []jsonGroup  `json:"results"`
+}
+
+// jsonGroup is the JSON representation of a single aggregation group in the
+// document written by RenderJSON, with its Results already encoded
+type jsonGroup struct {
+	Group   string          `json:"group"`
+	Results json.RawMessage `json:"results"`
+}
+
+// jsonInstance describes the sampled redis instance(s) and the sampling run
+// in the document written by RenderJSON
+type jsonInstance struct {
+	Server       ServerInfo   `json:"server"`
+	Capabilities Capabilities `json:"capabilities"`
+	KeyCount     int64        `json:"keyCount"`
+	Sampled      int          `json:"sampled"`
+	Skipped      int          `json:"skipped"`
+	Coverage     float64      `json:"coverage"`
+	Duration     float64      `json:"durationSeconds"`
+	Exact        bool         `json:"exact"`
+	Warnings     []string     `json:"warnings"`
+}
+
+// RenderJSON writes the Results for every aggregation group in `stats`, as
+// returned by Run along with `summary`, to the supplied io.Writer as a single
+// JSON document, for consumers that need a stable schema.  The document is an
+// envelope of the form:
+//
+//	{
+//	  "schemaVersion": "1.1.0",
+//	  "generatedAt": "2006-01-02T15:04:05Z",
+//	  "instance": {"server": {...}, "capabilities": {...}, "keyCount": ...,
+//	               "sampled": ..., "skipped": ..., "coverage": ...,
+//	               "durationSeconds": ..., "exact": ..., "warnings": [...]},
+//	  "results": [{"group": ..., "results": {...}}, ...]
+//	}
+//
+// with the groups ordered by name, and each group's results encoded as by
+// RenderNDJSON.  See JSONSchemaVersion for the compatibility guarantees.
+func RenderJSON(stats map[string]*Results, summary Summary, w io.Writer) error {
+	doc := jsonDocument{
+		SchemaVersion: JSONSchemaVersion,
+		GeneratedAt:   time.Now().UTC(),
+		Instance: jsonInstance{
+			Server:       summary.Server,
+			Capabilities: summary.Capabilities,
+			KeyCount:     summary.KeyCount,
+			Sampled:      summary.Sampled,
+			Skipped:      summary.Skipped,
+			Coverage:     summary.Coverage(),
+			Duration:     summary.Duration.Seconds(),
+			Exact:        summary.Exact,
+			Warnings:     summary.Warnings,
+		},
+		Results: []jsonGroup{},
+	}
+	if doc.Instance.Warnings == nil {
+		doc.Instance.Warnings = []string{}
+	}
+
+	for _, gr := range Ordered(stats, ByGroup) {
+		gr.Results.mu.Lock()
+		b, err := json.Marshal(gr.Results)
+		gr.Results.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		doc.Results = append(doc.Results, jsonGroup{Group: gr.Group, Results: b})
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRenderJSON(t *testing.T) {
+
+	a, b := NewResults(), NewResults()
+	a.observeString("a1", "value")
+	b.observeList("b1", 3, "x", "yy")
+	summary := Summary{KeyCount: 10, Sampled: 2, Skipped: 1, Duration: 3 * time.Second, Server: ServerInfo{Version: "7.2.4"}}
+
+	var buf bytes.Buffer
+	if err := RenderJSON(map[string]*Results{"b": b, "a": a}, summary, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		SchemaVersion string
+		GeneratedAt   time.Time
+		Instance      struct {
+			Server          ServerInfo
+			KeyCount        int64
+			Sampled         int
+			Skipped         int
+			Coverage        float64
+			DurationSeconds float64
+			Warnings        []string
+		}
+		Results []struct {
+			Group   string
+			Results struct {
+				KeyCount    int64
+				StringSizes map[int]int64
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.SchemaVersion != JSONSchemaVersion || time.Since(doc.GeneratedAt) > time.Minute {
+		t.Errorf("unexpected envelope: %s", buf.String())
+	}
+	if doc.Instance.Server.Version != "7.2.4" || doc.Instance.KeyCount != 10 || doc.Instance.Sampled != 2 || doc.Instance.Warnings == nil {
+		t.Errorf("unexpected instance metadata: %+v", doc.Instance)
+	}
+	if doc.Instance.Skipped != 1 || doc.Instance.DurationSeconds != 3 {
+		t.Errorf("unexpected run metadata: %+v", doc.Instance)
+	}
+	assertFloat(t, 0.2, doc.Instance.Coverage, epsilon)
+	if len(doc.Results) != 2 || doc.Results[0].Group != "a" || doc.Results[1].Group != "b" {
+		t.Fatalf("expected the groups in order, actual: %+v", doc.Results)
+	}
+	assertInt(t, 1, int(doc.Results[0].Results.StringSizes[5]))
+	assertInt(t, 1, int(doc.Results[1].Results.KeyCount))
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// instanceName returns a name for the redis instance described by `opts`, for
+// use in error messages and warnings: the instance's tag if it has one,
+// otherwise its address
+func instanceName(opts Options) string {
+	if opts.Tag != "" {
+		return opts.Tag
+	}
+	_, addr := address(opts)
+	return addr
+}
+
+// mergeStats merges each Results in `stats` into the Results for the same
+// aggregation group in `totals`, returning the first error encountered (if
+// any) once every group has been merged
+func mergeStats(totals, stats map[string]*Results) error {
+	var err error
+	for k, v := range stats {
+		if existing, ok := totals[k]; ok {
+			if merr := existing.Merge(v); merr != nil && err == nil {
+				err = fmt.Errorf("group %s: %w", k, merr)
+			}
+		} else {
+			totals[k] = v
+		}
+	}
+	return err
+}
+
+// merge combines the summary of a sampling run against another redis instance
+// into the method receiver.  The merged Capabilities are those shared by both
+// instances.
+func (s *Summary) merge(other Summary, instance string) {
+	first := s.KeyCount == 0 && s.Sampled == 0 && s.Capabilities == (Capabilities{})
+
+	s.Exact = other.Exact && (first || s.Exact)
+	s.KeyCount += other.KeyCount
+	s.Sampled += other.Sampled
+	s.Skipped += other.Skipped
+	s.Filtered += other.Filtered
+	s.Expired += other.Expired
+	s.TypeChanged += other.TypeChanged
+	s.PrunedGroups += other.PrunedGroups
+	s.EvictedGroups += other.EvictedGroups
+	s.Commands += other.Commands
+	s.BytesReceived += other.BytesReceived
+	s.Retries += other.Retries
+	if other.Duration > s.Duration {
+		s.Duration = other.Duration
+	}
+	s.RuntimeCapReached = s.RuntimeCapReached || other.RuntimeCapReached
+	s.Interrupted = s.Interrupted || other.Interrupted
+	for _, w := range other.Warnings {
+		s.Warnings = append(s.Warnings, instance+": "+w)
+	}
+
+	if first {
+		s.Server = other.Server
+		s.Capabilities = other.Capabilities
+		return
+	}
+	s.Server.merge(other.Server)
+	c := &s.Capabilities
+	if c.Version != other.Capabilities.Version {
+		c.Version = ""
+	}
+	c.ObjectEncoding = c.ObjectEncoding && other.Capabilities.ObjectEncoding
+	c.MemoryUsage = c.MemoryUsage && other.Capabilities.MemoryUsage
+	c.ObjectFreq = c.ObjectFreq && other.Capabilities.ObjectFreq
+	c.HRandField = c.HRandField && other.Capabilities.HRandField
+}
+
+// RunMulti performs the sampling operation described by each element of
+// `opts` concurrently (one goroutine per redis instance), and merges the
+// results from every instance into a single set of aggregated statistics and
+// a single Summary.  Since the Aggregator is shared between goroutines, it must
+// be safe for concurrent use.
+//
+// To retain the ability to attribute merged statistics to individual
+// instances, set Options.Tag for each instance.
+//
+// If sampling any instance fails, the merged results from the instances that
+// succeeded are returned, along with the first error encountered.
+func RunMulti(opts []Options, aggregator Aggregator) (map[string]*Results, Summary, error) {
+	return RunMultiContext(context.Background(), opts, aggregator)
+}
+
+// RunMultiContext is like RunMulti, but stops sampling every instance once
+// `ctx` is done (see RunContext).  It returns only once every goroutine has
+// finished and every connection has been closed.  The partial results sampled
+// from each instance before cancellation are merged and returned, along with
+// an error wrapping ctx.Err().
+func RunMultiContext(ctx context.Context, opts []Options, aggregator Aggregator) (map[string]*Results, Summary, error) {
+
+	type result struct {
+		stats   map[string]*Results
+		summary Summary
+		err     error
+	}
+
+	var wg sync.WaitGroup
+	results := make([]result, len(opts))
+	wg.Add(len(opts))
+
+	for i, o := range opts {
+		go func(i int, o Options) {
+			defer wg.Done()
+			stats, summary, err := RunContext(ctx, o, aggregator)
+			results[i] = result{stats: stats, summary: summary, err: err}
+		}(i, o)
+	}
+	wg.Wait()
+
+	totals := make(map[string]*Results)
+	var summary Summary
+	var err error
+	for i, r := range results {
+		if r.err != nil {
+			if err == nil {
+				err = fmt.Errorf("%s: %w", instanceName(opts[i]), r.err)
+			}
+			if r.err != ctx.Err() {
+				continue
+			}
+			// sampling was interrupted, so keep what was sampled
+		}
+		if merr := mergeStats(totals, r.stats); merr != nil && err == nil {
+			err = fmt.Errorf("%s: %w", instanceName(opts[i]), merr)
+		}
+		summary.merge(r.summary, instanceName(opts[i]))
+	}
+	return totals, summary, err
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// groupLine is the JSON representation of a single aggregation group written
+// by RenderNDJSON
+type groupLine struct {
+	Group   string   `json:"group"`
+	Results *Results `json:"results"`
+}
+
+// RenderNDJSON writes the Results for every aggregation group in `stats` to
+// the supplied io.Writer as newline-delimited JSON: one object per line, of
+// the form {"group": ..., "results": {...}}, ordered by group name.  Each
+// group is encoded and written (and flushed, if the io.Writer has a Flush
+// method, such as a bufio.Writer or an http.Flusher) before the next is
+// encoded, so that memory use doesn't grow with the number of groups.  This
+// suits ingestion into log pipelines and other tools that expect NDJSON.
+// Frequency tables are encoded as objects keyed by size, and any invalid UTF-8
+// in key names and values is replaced with U+FFFD.
+func RenderNDJSON(stats map[string]*Results, w io.Writer) error {
+	for _, gr := range Ordered(stats, ByGroup) {
+		gr.Results.mu.Lock()
+		b, err := json.Marshal(groupLine{Group: gr.Group, Results: gr.Results})
+		gr.Results.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+		if err := flushWriter(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushWriter flushes `w`, if it buffers its output
+func flushWriter(w io.Writer) error {
+	switch f := w.(type) {
+	case interface{ Flush() error }:
+		return f.Flush()
+	case interface{ Flush() }:
+		f.Flush()
+	}
+	return nil
+}
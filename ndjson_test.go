@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRenderNDJSON(t *testing.T) {
+
+	a, b := NewResults(), NewResults()
+	a.TopK = 2
+	a.observeString("a1", "value")
+	a.observeString("a2", "value")
+	b.observeList("b1", 3, "x", "yy")
+
+	var buf bytes.Buffer
+	w := bufio.NewWriterSize(&buf, 16)
+	if err := RenderNDJSON(map[string]*Results{"b": b, "a": a}, w); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected one line per group, actual: %q", buf.String())
+	}
+
+	var line struct {
+		Group   string
+		Results struct {
+			KeyCount         int64
+			StringSizes      map[int]int64
+			ListElementSizes map[int]int64
+			TopValues        map[ValueType]struct{ Top []ValueCount }
+		}
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line.Group != "a" || line.Results.KeyCount != 2 || line.Results.StringSizes[5] != 2 {
+		t.Errorf("unexpected first line: %s", lines[0])
+	}
+	if top := line.Results.TopValues[TypeString].Top; len(top) != 1 || top[0].Value != "value" || top[0].Count != 2 {
+		t.Errorf("expected the top values to be encoded, actual: %+v", top)
+	}
+
+	if err := json.Unmarshal([]byte(lines[1]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line.Group != "b" || line.Results.ListElementSizes[2] != 1 {
+		t.Errorf("unexpected second line: %s", lines[1])
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import "sort"
+
+// GroupResults pairs the Results for an aggregation group with the group's
+// name.
+type GroupResults struct {
+	Group   string
+	Results *Results
+}
+
+// OrderedResults is a deterministically ordered list of aggregated results,
+// as an alternative to the map returned by Run, whose iteration order varies
+// from one iteration to the next.
+type OrderedResults []GroupResults
+
+// ByGroup orders results by group name.
+func ByGroup(a, b GroupResults) bool {
+	return a.Group < b.Group
+}
+
+// ByKeyCount orders results by the number of sampled keys, largest first, and
+// then by group name.
+func ByKeyCount(a, b GroupResults) bool {
+	ak, bk := a.Results.keyCount(), b.Results.keyCount()
+	if ak != bk {
+		return ak > bk
+	}
+	return a.Group < b.Group
+}
+
+// ByEstimatedBytes orders results by their estimated size in bytes (see
+// Results.EstimatedBytes), largest first, and then by group name.
+func ByEstimatedBytes(a, b GroupResults) bool {
+	ab, bb := a.Results.EstimatedBytes(), b.Results.EstimatedBytes()
+	if ab != bb {
+		return ab > bb
+	}
+	return a.Group < b.Group
+}
+
+// Ordered converts a map of aggregated results, as returned by Run, into
+// OrderedResults, sorted using `less` (or ByGroup, if `less` is nil).
+func Ordered(stats map[string]*Results, less func(a, b GroupResults) bool) OrderedResults {
+	if less == nil {
+		less = ByGroup
+	}
+
+	o := make(OrderedResults, 0, len(stats))
+	for g, r := range stats {
+		o = append(o, GroupResults{Group: g, Results: r})
+	}
+	sort.SliceStable(o, func(i, j int) bool { return less(o[i], o[j]) })
+	return o
+}
+
+// Groups returns the group names, in order
+func (o OrderedResults) Groups() []string {
+	groups := make([]string, len(o))
+	for i, gr := range o {
+		groups[i] = gr.Group
+	}
+	return groups
+}
+
+// Map converts the OrderedResults back into a map, keyed by group name
+func (o OrderedResults) Map() map[string]*Results {
+	m := make(map[string]*Results, len(o))
+	for _, gr := range o {
+		m[gr.Group] = gr.Results
+	}
+	return m
+}
@@ -0,0 +1,42 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOrdered(t *testing.T) {
+
+	stats := map[string]*Results{"b": NewResults(), "a": NewResults(), "c": NewResults()}
+	stats["c"].observeString("k1", "v")
+	stats["c"].observeString("k2", "v")
+	stats["b"].observeString("k3", "v")
+
+	for i := 0; i < 10; i++ {
+		if g := Ordered(stats, nil).Groups(); !reflect.DeepEqual(g, []string{"a", "b", "c"}) {
+			t.Fatalf("unexpected order by group: %v", g)
+		}
+	}
+	if g := Ordered(stats, ByKeyCount).Groups(); !reflect.DeepEqual(g, []string{"c", "b", "a"}) {
+		t.Errorf("unexpected order by key count: %v", g)
+	}
+	if m := Ordered(stats, nil).Map(); !reflect.DeepEqual(m, stats) {
+		t.Errorf("expected the map to round-trip")
+	}
+}
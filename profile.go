@@ -0,0 +1,73 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import "time"
+
+const (
+	// ProfileMinSamples and ProfileSampleRate are the sample size used by
+	// Profile: 1% of the keyspace, but at least 10,000 keys (or every key, in
+	// smaller keyspaces)
+	ProfileMinSamples = 10000
+	ProfileSampleRate = 0.01
+
+	// ProfileMaxGroups bounds the number of key prefixes profiled by Profile
+	ProfileMaxGroups = 1000
+
+	// ProfileBigKeyThreshold and ProfileShortTTL are the thresholds above
+	// which Profile reports big keys, and below which it reports short-lived
+	// keys
+	ProfileBigKeyThreshold = 1 << 20
+	ProfileShortTTL        = time.Hour
+)
+
+// Profile samples the redis instance at host:port with sensible defaults,
+// answering "what's in my redis?" without any configuration: keys are
+// selected uniformly with SCAN (see StrategyReservoir), up to
+// ProfileMinSamples or ProfileSampleRate of the keyspace (whichever is
+// larger), and grouped by their ":"-separated prefix (see PrefixAggregator),
+// for at most ProfileMaxGroups prefixes.  Encodings, big keys and short-lived
+// keys are reported too.  Each of the returned Results is named after its
+// group, ready to be rendered (e.g. with RenderHTML).  Use Run with explicit
+// Options for anything else, e.g. to authenticate, or to sample keyspaces too
+// large to hold every key name in memory while selecting keys.
+func Profile(host string, port int) (map[string]*Results, Summary, error) {
+	stats, summary, err := Run(profileOptions(host, port), profileAggregator())
+	for group, r := range stats {
+		r.Name = group
+	}
+	return stats, summary, err
+}
+
+// profileOptions returns the Options used by Profile
+func profileOptions(host string, port int) Options {
+	return Options{
+		Host:             host,
+		Port:             port,
+		MinSamples:       ProfileMinSamples,
+		SampleRate:       ProfileSampleRate,
+		Strategy:         StrategyReservoir,
+		CollectEncodings: true,
+		BigKeyThreshold:  ProfileBigKeyThreshold,
+		ShortTTL:         ProfileShortTTL,
+	}
+}
+
+// profileAggregator returns the Aggregator used by Profile
+func profileAggregator() Aggregator {
+	return NewValidatingAggregator(PrefixAggregator(":"), 0, ProfileMaxGroups)
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPrefixAggregator(t *testing.T) {
+	a := PrefixAggregator(":")
+	for key, expected := range map[string]string{
+		"user:1234":       "user",
+		"user:1234:email": "user",
+		":empty":          NoPrefix,
+		"plain":           NoPrefix,
+	} {
+		if groups := a.Groups(key, TypeString); len(groups) != 1 || groups[0] != expected {
+			t.Errorf("%s: expected group %q, actual: %v", key, expected, groups)
+		}
+	}
+}
+
+func TestProfileOptions(t *testing.T) {
+
+	opts := profileOptions("localhost", 6379)
+	if err := validate(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newFakeRedis()
+	f.set("user:1", TypeString, "alice")
+	f.set("user:2", TypeHash, "name", "bob")
+	f.set("session:1", TypeString, "token")
+	f.set("counter", TypeString, "1")
+
+	// a small keyspace fits within the sample, so is profiled exactly
+	stats, summary, err := sample(context.Background(), f, opts, profileAggregator())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !summary.Exact || summary.Sampled != 4 {
+		t.Errorf("expected an exact profile of every key, actual: %+v", summary)
+	}
+	assertInt(t, 3, len(stats))
+	assertInt(t, 2, int(stats["user"].KeyCount))
+	assertInt(t, 1, int(stats["session"].KeyCount))
+	assertInt(t, 1, int(stats[NoPrefix].KeyCount))
+}
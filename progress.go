@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"fmt"
+	"time"
+)
+
+// Progress describes how far a sampling run has got, for reporting via
+// Options.ProgressFunc.
+type Progress struct {
+	// Source describes what is being sampled, e.g. "redis at host:6379"
+	Source string
+
+	// Attempted is the number of keys selected so far, and Target the number
+	// of keys that the run intends to sample (excluding any extra keys needed
+	// to meet Options.TypeQuotas)
+	Attempted int
+	Target    int
+
+	// Elapsed is the time since sampling started
+	Elapsed time.Duration
+
+	// KeyCount is the number of keys in the source, as reported before
+	// sampling starts.  The first report, made before any keys are selected
+	// (i.e. with Attempted zero), announces it.
+	KeyCount int64
+}
+
+// A ProgressFunc receives progress reports during a sampling run
+type ProgressFunc func(p Progress)
+
+// PrintProgress is the default ProgressFunc, which prints progress to stdout
+func PrintProgress(p Progress) {
+	if p.Attempted == 0 {
+		fmt.Printf("%s has %d keys\n", p.Source, p.KeyCount)
+		return
+	}
+	fmt.Printf("sampled %d keys from %s...\n", p.Attempted, p.Source)
+}
+
+// progressReporter decides when progress should be reported, according to the
+// cadence configured in Options
+type progressReporter struct {
+	report   ProgressFunc
+	source   string
+	target   int
+	every    int
+	interval time.Duration
+	start    time.Time
+	keyCount int64
+
+	lastKeys int
+	lastTime time.Time
+}
+
+// newProgressReporter creates a progressReporter for a run that intends to
+// sample `target` keys from `src`.  Without a configured cadence, progress is
+// reported every 1% of the target (see Options.ProgressEvery).
+func newProgressReporter(opts Options, src interface{}, target int, start time.Time) *progressReporter {
+	p := &progressReporter{
+		report:   opts.ProgressFunc,
+		source:   fmt.Sprint(src),
+		target:   target,
+		every:    opts.ProgressEvery,
+		interval: opts.ProgressInterval,
+		start:    start,
+		lastTime: start,
+	}
+	if p.report == nil {
+		p.report = PrintProgress
+	}
+	if p.every == 0 && p.interval == 0 {
+		p.every = max(target/100, 1)
+	}
+	return p
+}
+
+// begin reports that sampling is about to start, from a source of `keyCount`
+// keys
+func (p *progressReporter) begin(keyCount int64) {
+	p.keyCount = keyCount
+	p.report(Progress{Source: p.source, Target: p.target, KeyCount: keyCount})
+}
+
+// update reports progress, if due, once `attempted` keys have been selected
+func (p *progressReporter) update(attempted int) {
+	now := time.Now()
+	due := (p.every > 0 && attempted-p.lastKeys >= p.every) ||
+		(p.interval > 0 && now.Sub(p.lastTime) >= p.interval)
+	if !due {
+		return
+	}
+
+	p.lastKeys, p.lastTime = attempted, now
+	p.report(Progress{Source: p.source, Attempted: attempted, Target: p.target, Elapsed: now.Sub(p.start), KeyCount: p.keyCount})
+}
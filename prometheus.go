@@ -0,0 +1,186 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// metricFamily describes a single metric family in the OpenMetrics text
+// exposition format
+type metricFamily struct {
+	name string
+	help string
+	typ  string
+}
+
+var (
+	sampledKeysMetric = metricFamily{
+		name: "reckon_sampled_keys",
+		help: "Number of sampled keys, by aggregation group and redis data type.",
+		typ:  "gauge",
+	}
+	valueLengthMetric = metricFamily{
+		name: "reckon_value_length",
+		help: "Length of sampled values: bytes for strings, number of elements for collections.",
+		typ:  "histogram",
+	}
+	elementSizeMetric = metricFamily{
+		name: "reckon_element_size_bytes",
+		help: "Size in bytes of sampled collection elements (hash fields, set/sorted set/list members).",
+		typ:  "histogram",
+	}
+	hashValueSizeMetric = metricFamily{
+		name: "reckon_hash_value_size_bytes",
+		help: "Size in bytes of sampled hash values.",
+		typ:  "histogram",
+	}
+)
+
+// escapeLabelValue escapes a label value according to the OpenMetrics text
+// format: backslashes, double-quotes and line feeds must be escaped
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// labels formats the group/type label set shared by all reckon metrics,
+// followed by any extra, already-formatted labels
+func labels(group string, vt ValueType, extra ...string) string {
+	l := append([]string{
+		fmt.Sprintf(`group="%s"`, escapeLabelValue(group)),
+		fmt.Sprintf(`type="%s"`, vt),
+	}, extra...)
+	return "{" + strings.Join(l, ",") + "}"
+}
+
+// sum returns the total number of observations in a frequency map
+func sum(m map[int]int64) int64 {
+	var s int64
+	for _, v := range m {
+		s += v
+	}
+	return s
+}
+
+// writeHistogram writes a frequency map as an OpenMetrics histogram, using
+// the supplied bucket boundaries (or power-of-two boundaries, if none are given)
+func writeHistogram(w io.Writer, name, group string, vt ValueType, m map[int]int64, boundaries []int) {
+	pf := ComputeBucketFreq(m, boundaries)
+	bounds := make([]int, 0, len(pf))
+	for k := range pf {
+		if k != OverflowBucket {
+			bounds = append(bounds, k)
+		}
+	}
+	sort.Ints(bounds)
+
+	var cumulative, total int64
+	for _, le := range bounds {
+		cumulative += pf[le]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(group, vt, fmt.Sprintf(`le="%d"`, le)), cumulative)
+	}
+	for k, v := range m {
+		total += int64(k) * v
+	}
+	cumulative += pf[OverflowBucket]
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(group, vt, `le="+Inf"`), cumulative)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels(group, vt), cumulative)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels(group, vt), strconv.FormatInt(total, 10))
+}
+
+// RenderPrometheus writes the statistics for every aggregation group in
+// `stats` to the supplied io.Writer, using the OpenMetrics text exposition
+// format.  Each metric carries a "group" label (the aggregation group) and a
+// "type" label (the redis data type).  The output can be pushed as-is to a
+// Prometheus Pushgateway, or served from a metrics endpoint.
+func RenderPrometheus(stats map[string]*Results, w io.Writer) error {
+	ordered := Ordered(stats, ByGroup)
+	groups := ordered.Groups()
+
+	// take a snapshot of each Results, so that rendering doesn't race with any
+	// ongoing observations
+	snapshots := make([]*Results, len(ordered))
+	for i, gr := range ordered {
+		snapshots[i] = gr.Results.Clone()
+	}
+
+	type series struct {
+		vt    ValueType
+		sizes func(r *Results) map[int]int64
+	}
+	lengths := []series{
+		{TypeString, func(r *Results) map[int]int64 { return r.StringSizes }},
+		{TypeList, func(r *Results) map[int]int64 { return r.ListSizes }},
+		{TypeSet, func(r *Results) map[int]int64 { return r.SetSizes }},
+		{TypeSortedSet, func(r *Results) map[int]int64 { return r.SortedSetSizes }},
+		{TypeHash, func(r *Results) map[int]int64 { return r.HashSizes }},
+	}
+	elements := []series{
+		{TypeList, func(r *Results) map[int]int64 { return r.ListElementSizes }},
+		{TypeSet, func(r *Results) map[int]int64 { return r.SetElementSizes }},
+		{TypeSortedSet, func(r *Results) map[int]int64 { return r.SortedSetElementSizes }},
+		{TypeHash, func(r *Results) map[int]int64 { return r.HashElementSizes }},
+	}
+
+	bw := bufio.NewWriter(w)
+	header := func(f metricFamily) {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+	}
+
+	header(sampledKeysMetric)
+	for i, g := range groups {
+		for _, s := range lengths {
+			if n := sum(s.sizes(snapshots[i])); n > 0 {
+				fmt.Fprintf(bw, "%s%s %d\n", sampledKeysMetric.name, labels(g, s.vt), n)
+			}
+		}
+	}
+
+	header(valueLengthMetric)
+	for i, g := range groups {
+		for _, s := range lengths {
+			if m := s.sizes(snapshots[i]); len(m) > 0 {
+				writeHistogram(bw, valueLengthMetric.name, g, s.vt, m, snapshots[i].Buckets)
+			}
+		}
+	}
+
+	header(elementSizeMetric)
+	for i, g := range groups {
+		for _, s := range elements {
+			if m := s.sizes(snapshots[i]); len(m) > 0 {
+				writeHistogram(bw, elementSizeMetric.name, g, s.vt, m, snapshots[i].Buckets)
+			}
+		}
+	}
+
+	header(hashValueSizeMetric)
+	for i, g := range groups {
+		if m := snapshots[i].HashValueSizes; len(m) > 0 {
+			writeHistogram(bw, hashValueSizeMetric.name, g, TypeHash, m, snapshots[i].Buckets)
+		}
+	}
+
+	fmt.Fprintln(bw, "# EOF")
+	return bw.Flush()
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRenderPrometheus(t *testing.T) {
+
+	r := NewResults()
+	r.observeString("a", "abc")
+	r.observeString("b", "abcde")
+	r.observeHash("h", 2, "field", "value")
+
+	var buf bytes.Buffer
+	if err := RenderPrometheus(map[string]*Results{`we"ird`: r}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, line := range []string{
+		"# TYPE reckon_sampled_keys gauge",
+		`reckon_sampled_keys{group="we\"ird",type="string"} 2`,
+		`reckon_sampled_keys{group="we\"ird",type="hash"} 1`,
+		"# TYPE reckon_value_length histogram",
+		`reckon_value_length_bucket{group="we\"ird",type="string",le="4"} 1`,
+		`reckon_value_length_bucket{group="we\"ird",type="string",le="8"} 2`,
+		`reckon_value_length_bucket{group="we\"ird",type="string",le="+Inf"} 2`,
+		`reckon_value_length_count{group="we\"ird",type="string"} 2`,
+		`reckon_value_length_sum{group="we\"ird",type="string"} 8`,
+		`reckon_hash_value_size_bytes_sum{group="we\"ird",type="hash"} 5`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("expected output to contain: %s", line)
+		}
+	}
+	if !strings.HasSuffix(out, "# EOF\n") {
+		t.Errorf("expected output to end with an EOF marker")
+	}
+}
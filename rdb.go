@@ -0,0 +1,924 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// RDB opcodes, which may appear in place of a value type
+const (
+	rdbOpSlotInfo     = 0xF4
+	rdbOpFunction2    = 0xF5
+	rdbOpFunctionPre  = 0xF6
+	rdbOpModuleAux    = 0xF7
+	rdbOpIdle         = 0xF8
+	rdbOpFreq         = 0xF9
+	rdbOpAux          = 0xFA
+	rdbOpResizeDB     = 0xFB
+	rdbOpExpireTimeMs = 0xFC
+	rdbOpExpireTime   = 0xFD
+	rdbOpSelectDB     = 0xFE
+	rdbOpEOF          = 0xFF
+)
+
+// RDB value types
+const (
+	rdbTypeString          = 0
+	rdbTypeList            = 1
+	rdbTypeSet             = 2
+	rdbTypeZSet            = 3
+	rdbTypeHash            = 4
+	rdbTypeZSet2           = 5
+	rdbTypeHashZipmap      = 9
+	rdbTypeListZiplist     = 10
+	rdbTypeSetIntset       = 11
+	rdbTypeZSetZiplist     = 12
+	rdbTypeHashZiplist     = 13
+	rdbTypeListQuicklist   = 14
+	rdbTypeHashListpack    = 16
+	rdbTypeZSetListpack    = 17
+	rdbTypeListQuicklist2  = 18
+	rdbTypeSetListpack     = 20
+	rdbQuicklistNodePlain  = 1
+	rdbQuicklistNodePacked = 2
+)
+
+var (
+	// ErrNotRDB is returned when the data read by an RDBKeySource does not
+	// start with an RDB header
+	ErrNotRDB = errors.New("Not an RDB file")
+
+	// errTruncated indicates that an encoded value ended prematurely
+	errTruncated = errors.New("Truncated RDB value")
+)
+
+// maxRDBStringLength bounds the length of the strings read from a dump, at
+// redis' own limit (see proto-max-bulk-len), so that a corrupt length is
+// reported as such rather than exhausting memory
+const maxRDBStringLength = 512 << 20
+
+// rdbReadChunk is the largest string that is read into a buffer allocated
+// up front, at its full length
+const rdbReadChunk = 1 << 20
+
+// lzfMaxExpansion is the largest ratio of LZF-decompressed to compressed data:
+// a 3-byte back reference expands to at most 264 bytes
+const lzfMaxExpansion = 88
+
+// RDBKeySource is a KeySource that reads keys from an RDB dump file, as
+// written by redis' `SAVE` and `BGSAVE` commands, so that a keyspace can be
+// analyzed without touching a live redis instance.  Keys are read in the order
+// in which they appear in the dump, rather than at random, so Options.SampleRate
+// should usually be 1.0 (i.e. every key in the dump is read).  Keys that had
+// already expired when the RDBKeySource was created are counted in
+// Summary.Expired.  Stream and module values are not supported.
+type RDBKeySource struct {
+	rs   io.ReadSeeker
+	r    *bufio.Reader
+	name string
+
+	// now is the time against which key expiry times are compared
+	now time.Time
+
+	started  bool
+	db       int
+	count    int64
+	counted  bool
+	expireAt int64
+	idle     time.Duration
+	freq     int
+	access   AccessMetric
+
+	pending        Sample
+	pendingExpired bool
+}
+
+// NewRDBKeySource creates an RDBKeySource that reads an RDB dump from `rs`.
+// Determining the KeyCount requires a full pass over the dump, after which
+// `rs` is rewound.
+func NewRDBKeySource(rs io.ReadSeeker) *RDBKeySource {
+	return &RDBKeySource{rs: rs, r: bufio.NewReader(rs), name: "RDB dump", now: time.Now()}
+}
+
+// OpenRDB opens the RDB dump file at `path`, for use as a KeySource.  The
+// caller should Close it once done.
+func OpenRDB(path string) (*RDBKeySource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s := NewRDBKeySource(f)
+	s.name = "RDB dump at " + path
+	return s, nil
+}
+
+// Close closes the underlying file, if the RDBKeySource was created by OpenRDB
+func (s *RDBKeySource) Close() error {
+	if c, ok := s.rs.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// String describes the RDB dump, for use in progress messages
+func (s *RDBKeySource) String() string {
+	return s.name
+}
+
+// KeyCount counts the keys in the RDB dump, by reading it in its entirety
+func (s *RDBKeySource) KeyCount() (int64, error) {
+	if s.counted {
+		return s.count, nil
+	}
+	if err := s.rewind(); err != nil {
+		return 0, err
+	}
+
+	var count int64
+	for {
+		_, _, err := s.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	if err := s.rewind(); err != nil {
+		return 0, err
+	}
+
+	s.count, s.counted = count, true
+	if count == 0 {
+		return 0, ErrNoKeys
+	}
+	return count, nil
+}
+
+// rewind seeks back to the start of the RDB dump
+func (s *RDBKeySource) rewind() error {
+	if _, err := s.rs.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.r.Reset(s.rs)
+	s.started = false
+	s.db = 0
+	return nil
+}
+
+// Next reads the next key from the RDB dump, returning io.EOF once the end of
+// the dump has been reached
+func (s *RDBKeySource) Next() (string, ValueType, error) {
+	if !s.started {
+		if err := s.readHeader(); err != nil {
+			return "", TypeUnknown, err
+		}
+		s.started = true
+	}
+
+	for {
+		op, err := s.r.ReadByte()
+		if err != nil {
+			return "", TypeUnknown, err
+		}
+
+		switch op {
+		case rdbOpEOF:
+			return "", TypeUnknown, io.EOF
+		case rdbOpSelectDB:
+			var db uint64
+			db, err = s.readLength()
+			s.db = int(db)
+		case rdbOpIdle:
+			var idle uint64
+			idle, err = s.readLength()
+			s.idle = time.Duration(idle) * time.Second
+			s.access = AccessIdleTime
+		case rdbOpResizeDB:
+			if _, err = s.readLength(); err == nil {
+				_, err = s.readLength()
+			}
+		case rdbOpSlotInfo:
+			for i := 0; i < 3 && err == nil; i++ {
+				_, err = s.readLength()
+			}
+		case rdbOpAux:
+			if _, err = s.readString(); err == nil {
+				_, err = s.readString()
+			}
+		case rdbOpFunction2:
+			_, err = s.readString()
+		case rdbOpFreq:
+			var freq byte
+			freq, err = s.r.ReadByte()
+			s.freq = int(freq)
+			s.access = AccessFrequency
+		case rdbOpExpireTime:
+			var b []byte
+			if b, err = s.read(4); err == nil {
+				s.expireAt = int64(binary.LittleEndian.Uint32(b)) * 1000
+			}
+		case rdbOpExpireTimeMs:
+			var b []byte
+			if b, err = s.read(8); err == nil {
+				s.expireAt = int64(binary.LittleEndian.Uint64(b))
+			}
+		case rdbOpModuleAux, rdbOpFunctionPre:
+			return "", TypeUnknown, fmt.Errorf("Unsupported RDB opcode: %d", op)
+		default:
+			return s.readKey(op)
+		}
+		if err != nil {
+			return "", TypeUnknown, err
+		}
+	}
+}
+
+// Fetch returns the Sample of the key most recently read by Next
+func (s *RDBKeySource) Fetch(key string, vt ValueType) (Sample, error) {
+	if key != s.pending.Key {
+		return Sample{}, fmt.Errorf("Key %q was not the last key read from the RDB dump", key)
+	}
+	if s.pendingExpired {
+		return Sample{}, ErrKeyMissing
+	}
+	return s.pending, nil
+}
+
+// readHeader reads and checks the "REDISnnnn" RDB header
+func (s *RDBKeySource) readHeader() error {
+	b, err := s.read(9)
+	if err != nil || string(b[:5]) != "REDIS" {
+		return ErrNotRDB
+	}
+	if _, err := strconv.Atoi(string(b[5:])); err != nil {
+		return ErrNotRDB
+	}
+	return nil
+}
+
+// readKey reads a key and its value, of RDB value type `t`
+func (s *RDBKeySource) readKey(t byte) (string, ValueType, error) {
+	key, err := s.readString()
+	if err != nil {
+		return "", TypeUnknown, err
+	}
+
+	smp, err := s.readValue(t)
+	if err != nil {
+		return "", TypeUnknown, fmt.Errorf("Error reading RDB key %q: %s", key, err)
+	}
+	smp.Key = string(key)
+	smp.DB = s.db
+
+	if s.expireAt > 0 {
+		smp.Expires = true
+		smp.TTL = time.Duration(s.expireAt-s.now.UnixNano()/int64(time.Millisecond)) * time.Millisecond
+	}
+	smp.IdleTime = s.idle
+	smp.Frequency = s.freq
+	smp.Access = s.access
+	s.pending = smp
+	s.pendingExpired = smp.Expires && smp.TTL <= 0
+	s.expireAt = 0
+	s.idle = 0
+	s.freq = 0
+	s.access = AccessUnknown
+	return smp.Key, smp.Type, nil
+}
+
+// readValue reads a value of RDB value type `t`, returning a Sample of it
+// (without the key name)
+func (s *RDBKeySource) readValue(t byte) (Sample, error) {
+	switch t {
+	case rdbTypeString:
+		v, err := s.readString()
+		return Sample{Type: TypeString, Length: len(v), Value: string(v)}, err
+
+	case rdbTypeList, rdbTypeSet:
+		vt := TypeList
+		if t == rdbTypeSet {
+			vt = TypeSet
+		}
+		return s.readElements(vt, 1, nil)
+
+	case rdbTypeZSet:
+		return s.readElements(TypeSortedSet, 1, func() error {
+			// scores are stored as strings, prefixed by their length (or a
+			// special length for NaN and infinities)
+			n, err := s.r.ReadByte()
+			if err == nil && n < 253 {
+				_, err = s.read(int(n))
+			}
+			return err
+		})
+
+	case rdbTypeZSet2:
+		return s.readElements(TypeSortedSet, 1, func() error {
+			_, err := s.read(8)
+			return err
+		})
+
+	case rdbTypeHash:
+		return s.readElements(TypeHash, 2, nil)
+
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		return s.readQuicklist(t)
+	}
+
+	// the remaining types are stored as a single encoded string
+	b, err := s.readString()
+	if err != nil {
+		return Sample{}, err
+	}
+
+	var entries []string
+	var vt ValueType
+	per := 1
+	switch t {
+	case rdbTypeHashZipmap:
+		vt, per = TypeHash, 2
+		entries, err = zipmapEntries(b)
+	case rdbTypeListZiplist:
+		vt = TypeList
+		entries, err = ziplistEntries(b)
+	case rdbTypeSetIntset:
+		vt = TypeSet
+		entries, err = intsetEntries(b)
+	case rdbTypeZSetZiplist:
+		vt, per = TypeSortedSet, 2
+		entries, err = ziplistEntries(b)
+	case rdbTypeHashZiplist:
+		vt, per = TypeHash, 2
+		entries, err = ziplistEntries(b)
+	case rdbTypeHashListpack:
+		vt, per = TypeHash, 2
+		entries, err = listpackEntries(b)
+	case rdbTypeZSetListpack:
+		vt, per = TypeSortedSet, 2
+		entries, err = listpackEntries(b)
+	case rdbTypeSetListpack:
+		vt = TypeSet
+		entries, err = listpackEntries(b)
+	default:
+		return Sample{}, fmt.Errorf("Unsupported RDB value type: %d", t)
+	}
+	if err != nil {
+		return Sample{}, err
+	}
+	return sampleEntries(vt, entries, per), nil
+}
+
+// sampleEntries builds a Sample from the flattened entries of a collection,
+// where each element occupies `per` consecutive entries (e.g. a hash field
+// and its value, or a sorted set member and its score).  Up to
+// DefaultMaxElementsPerKey elements are retained.
+func sampleEntries(vt ValueType, entries []string, per int) Sample {
+	smp := Sample{Type: vt, Length: len(entries) / per}
+	for i := 0; i+per <= len(entries) && i/per < DefaultMaxElementsPerKey; i += per {
+		smp.Elements = append(smp.Elements, entries[i])
+		if vt == TypeHash {
+			smp.Elements = append(smp.Elements, entries[i+1])
+		}
+	}
+	return smp
+}
+
+// readElements reads a collection stored as a length followed by its
+// elements, each of which is made up of `per` strings, optionally followed by
+// some additional data that is skipped by `skip`
+func (s *RDBKeySource) readElements(vt ValueType, per int, skip func() error) (Sample, error) {
+	n, err := s.readLength()
+	if err != nil {
+		return Sample{}, err
+	}
+
+	smp := Sample{Type: vt, Length: int(n)}
+	for i := uint64(0); i < n; i++ {
+		for j := 0; j < per; j++ {
+			b, err := s.readString()
+			if err != nil {
+				return Sample{}, err
+			}
+			if i < DefaultMaxElementsPerKey {
+				smp.Elements = append(smp.Elements, string(b))
+			}
+		}
+		if skip != nil {
+			if err := skip(); err != nil {
+				return Sample{}, err
+			}
+		}
+	}
+	return smp, nil
+}
+
+// readQuicklist reads a list stored as a quicklist: a sequence of nodes, each
+// of which is a ziplist (RDB_TYPE_LIST_QUICKLIST), or either a listpack or a
+// single element (RDB_TYPE_LIST_QUICKLIST_2)
+func (s *RDBKeySource) readQuicklist(t byte) (Sample, error) {
+	nodes, err := s.readLength()
+	if err != nil {
+		return Sample{}, err
+	}
+
+	smp := Sample{Type: TypeList}
+	for i := uint64(0); i < nodes; i++ {
+		container := uint64(rdbQuicklistNodePacked)
+		if t == rdbTypeListQuicklist2 {
+			if container, err = s.readLength(); err != nil {
+				return Sample{}, err
+			}
+		}
+		b, err := s.readString()
+		if err != nil {
+			return Sample{}, err
+		}
+
+		var entries []string
+		switch {
+		case container == rdbQuicklistNodePlain:
+			entries = []string{string(b)}
+		case t == rdbTypeListQuicklist:
+			entries, err = ziplistEntries(b)
+		default:
+			entries, err = listpackEntries(b)
+		}
+		if err != nil {
+			return Sample{}, err
+		}
+
+		for _, e := range entries {
+			if len(smp.Elements) == DefaultMaxElementsPerKey {
+				break
+			}
+			smp.Elements = append(smp.Elements, e)
+		}
+		smp.Length += len(entries)
+	}
+	return smp, nil
+}
+
+// read reads exactly `n` bytes.  Reads of more than rdbReadChunk bytes grow
+// their buffer as the data arrives, so that a corrupt length fails once the
+// dump runs out instead of first allocating the whole length.
+func (s *RDBKeySource) read(n int) ([]byte, error) {
+	if n < 0 || n > maxRDBStringLength {
+		return nil, errTruncated
+	}
+	if n > rdbReadChunk {
+		var buf bytes.Buffer
+		buf.Grow(rdbReadChunk)
+		if _, err := io.CopyN(&buf, s.r, int64(n)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(s.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// readLength reads a length-encoded integer
+func (s *RDBKeySource) readLength() (uint64, error) {
+	n, encoded, err := s.readLengthOrEncoding()
+	if err == nil && encoded {
+		err = fmt.Errorf("Unexpected RDB string encoding: %d", n)
+	}
+	return n, err
+}
+
+// readLengthOrEncoding reads a length-encoded integer, which may instead
+// describe a special string encoding
+func (s *RDBKeySource) readLengthOrEncoding() (n uint64, encoded bool, err error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false, nil
+	case 1:
+		next, err := s.r.ReadByte()
+		return uint64(b&0x3F)<<8 | uint64(next), false, err
+	case 3:
+		return uint64(b & 0x3F), true, nil
+	}
+
+	switch b {
+	case 0x80:
+		p, err := s.read(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(p)), false, nil
+	case 0x81:
+		p, err := s.read(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(p), false, nil
+	}
+	return 0, false, fmt.Errorf("Invalid RDB length encoding: %d", b)
+}
+
+// readString reads a string, which may be stored as an integer or compressed
+func (s *RDBKeySource) readString() ([]byte, error) {
+	n, encoded, err := s.readLengthOrEncoding()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return s.read(int(n))
+	}
+
+	switch n {
+	case 0, 1, 2:
+		b, err := s.read(1 << n)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.FormatInt(leInt(b), 10)), nil
+	case 3:
+		clen, err := s.readLength()
+		if err != nil {
+			return nil, err
+		}
+		ulen, err := s.readLength()
+		if err != nil {
+			return nil, err
+		}
+		b, err := s.read(int(clen))
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(b, int(ulen))
+	}
+	return nil, fmt.Errorf("Unexpected RDB string encoding: %d", n)
+}
+
+// leInt decodes a little-endian, two's complement integer of up to 8 bytes
+func leInt(b []byte) int64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	shift := uint(64 - 8*len(b))
+	return int64(v<<shift) >> shift
+}
+
+// lzfDecompress decompresses LZF-compressed data, of known uncompressed length
+func lzfDecompress(in []byte, ulen int) ([]byte, error) {
+	if ulen < 0 || ulen > lzfMaxExpansion*len(in) {
+		return nil, errTruncated
+	}
+	out := make([]byte, 0, ulen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 32 {
+			// a literal run of ctrl+1 bytes
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, errTruncated
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		// a back reference
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errTruncated
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errTruncated
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errors.New("Invalid LZF back reference")
+		}
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != ulen {
+		return nil, errors.New("Unexpected LZF decompressed length")
+	}
+	return out, nil
+}
+
+// cursor reads sequentially from an encoded value, such as a ziplist
+type cursor struct {
+	b []byte
+	i int
+}
+
+func (c *cursor) take(n int) ([]byte, error) {
+	if n < 0 || c.i+n > len(c.b) {
+		return nil, errTruncated
+	}
+	p := c.b[c.i : c.i+n]
+	c.i += n
+	return p, nil
+}
+
+func (c *cursor) byte() (byte, error) {
+	p, err := c.take(1)
+	if err != nil {
+		return 0, err
+	}
+	return p[0], nil
+}
+
+// ziplistEntries decodes every entry of a ziplist
+func ziplistEntries(b []byte) ([]string, error) {
+	// skip the zlbytes, zltail and zllen header fields
+	c := &cursor{b: b, i: 10}
+	entries := []string{}
+	for {
+		prev, err := c.byte()
+		if err != nil {
+			return nil, err
+		}
+		if prev == 0xFF {
+			return entries, nil
+		}
+		if prev == 0xFE {
+			if _, err := c.take(4); err != nil {
+				return nil, err
+			}
+		}
+
+		enc, err := c.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		var n int
+		switch {
+		case enc>>6 == 0:
+			n = int(enc & 0x3F)
+		case enc>>6 == 1:
+			next, err := c.byte()
+			if err != nil {
+				return nil, err
+			}
+			n = int(enc&0x3F)<<8 | int(next)
+		case enc>>6 == 2:
+			p, err := c.take(4)
+			if err != nil {
+				return nil, err
+			}
+			n = int(binary.BigEndian.Uint32(p))
+		case enc >= 0xF1 && enc <= 0xFD:
+			entries = append(entries, strconv.Itoa(int(enc&0x0F)-1))
+			continue
+		default:
+			size := map[byte]int{0xC0: 2, 0xD0: 4, 0xE0: 8, 0xF0: 3, 0xFE: 1}[enc]
+			if size == 0 {
+				return nil, fmt.Errorf("Invalid ziplist entry encoding: %d", enc)
+			}
+			p, err := c.take(size)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, strconv.FormatInt(leInt(p), 10))
+			continue
+		}
+
+		p, err := c.take(n)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, string(p))
+	}
+}
+
+// listpackEntries decodes every entry of a listpack
+func listpackEntries(b []byte) ([]string, error) {
+	// skip the total bytes and number of elements header fields
+	c := &cursor{b: b, i: 6}
+	entries := []string{}
+	for {
+		enc, err := c.byte()
+		if err != nil {
+			return nil, err
+		}
+		if enc == 0xFF {
+			return entries, nil
+		}
+
+		// n is the length of a string entry, size that of an integer entry
+		n, size := -1, 0
+		switch {
+		case enc&0x80 == 0:
+			entries = append(entries, strconv.Itoa(int(enc)))
+		case enc&0xC0 == 0x80:
+			n = int(enc & 0x3F)
+		case enc&0xE0 == 0xC0:
+			next, err := c.byte()
+			if err != nil {
+				return nil, err
+			}
+			v := int(enc&0x1F)<<8 | int(next)
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			entries = append(entries, strconv.Itoa(v))
+		case enc&0xF0 == 0xE0:
+			next, err := c.byte()
+			if err != nil {
+				return nil, err
+			}
+			n = int(enc&0x0F)<<8 | int(next)
+		case enc == 0xF0:
+			p, err := c.take(4)
+			if err != nil {
+				return nil, err
+			}
+			n = int(binary.LittleEndian.Uint32(p))
+		default:
+			size = map[byte]int{0xF1: 2, 0xF2: 3, 0xF3: 4, 0xF4: 8}[enc]
+			if size == 0 {
+				return nil, fmt.Errorf("Invalid listpack entry encoding: %d", enc)
+			}
+		}
+
+		start := c.i
+		if n >= 0 {
+			p, err := c.take(n)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, string(p))
+		} else if size > 0 {
+			p, err := c.take(size)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, strconv.FormatInt(leInt(p), 10))
+		}
+
+		// skip the "backlen", which encodes the size of the entry (including
+		// its encoding byte(s)) in as few bytes as possible
+		if _, err := c.take(backlenSize(c.i - start + encodingSize(enc))); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// encodingSize returns the number of bytes used by a listpack entry's
+// encoding, given its first byte
+func encodingSize(enc byte) int {
+	switch {
+	case enc&0x80 == 0, enc&0xC0 == 0x80, enc >= 0xF1:
+		return 1
+	case enc&0xE0 == 0xC0, enc&0xF0 == 0xE0:
+		return 2
+	default:
+		return 5
+	}
+}
+
+// backlenSize returns the number of bytes used to encode the length of a
+// listpack entry of `n` bytes
+func backlenSize(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// intsetEntries decodes every member of an intset
+func intsetEntries(b []byte) ([]string, error) {
+	c := &cursor{b: b}
+	p, err := c.take(8)
+	if err != nil {
+		return nil, err
+	}
+	size := int(binary.LittleEndian.Uint32(p[:4]))
+	n := int(binary.LittleEndian.Uint32(p[4:]))
+	if size != 2 && size != 4 && size != 8 {
+		return nil, fmt.Errorf("Invalid intset encoding: %d", size)
+	}
+	if n > (len(b)-8)/size {
+		return nil, errTruncated
+	}
+
+	entries := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		p, err := c.take(size)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, strconv.FormatInt(leInt(p), 10))
+	}
+	return entries, nil
+}
+
+// zipmapEntries decodes every field and value of a zipmap
+func zipmapEntries(b []byte) ([]string, error) {
+	// skip the zmlen header field
+	c := &cursor{b: b, i: 1}
+	entries := []string{}
+
+	length := func() (int, bool, error) {
+		l, err := c.byte()
+		switch {
+		case err != nil:
+			return 0, false, err
+		case l == 0xFF:
+			return 0, true, nil
+		case l == 253:
+			p, err := c.take(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return int(binary.LittleEndian.Uint32(p)), false, nil
+		case l > 253:
+			return 0, false, fmt.Errorf("Invalid zipmap length: %d", l)
+		}
+		return int(l), false, nil
+	}
+
+	for {
+		n, end, err := length()
+		if err != nil || end {
+			return entries, err
+		}
+		field, err := c.take(n)
+		if err != nil {
+			return nil, err
+		}
+
+		n, end, err = length()
+		if err == nil && end {
+			err = errTruncated
+		}
+		if err != nil {
+			return nil, err
+		}
+		free, err := c.byte()
+		if err != nil {
+			return nil, err
+		}
+		val, err := c.take(n)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := c.take(int(free)); err != nil {
+			return nil, err
+		}
+		entries = append(entries, string(field), string(val))
+	}
+}
@@ -0,0 +1,217 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// rdbString encodes a short, uncompressed RDB string
+func rdbString(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+// rdbBlob encodes an RDB string holding an encoded value, e.g. a ziplist
+func rdbBlob(b ...[]byte) []byte {
+	v := bytes.Join(b, nil)
+	return append([]byte{0x40 | byte(len(v)>>8), byte(len(v))}, v...)
+}
+
+// testRDB builds a small RDB dump, with a key of every supported data type
+// and a few different encodings
+func testRDB() []byte {
+	var b bytes.Buffer
+	b.WriteString("REDIS0011")
+	b.WriteByte(rdbOpAux)
+	b.Write(rdbString("redis-ver"))
+	b.Write(rdbString("7.2.0"))
+	b.Write([]byte{rdbOpSelectDB, 0, rdbOpResizeDB, 8, 1})
+
+	// a plain string, and an integer-encoded one
+	b.WriteByte(rdbTypeString)
+	b.Write(rdbString("str"))
+	b.Write(rdbString("hello"))
+	b.WriteByte(rdbTypeString)
+	b.Write(rdbString("int"))
+	b.Write([]byte{0xC1, 0x39, 0x30})
+
+	// an LZF-compressed string: a literal "a", then a back reference
+	// repeating it 9 times
+	b.WriteByte(rdbTypeString)
+	b.Write(rdbString("lzf"))
+	b.Write([]byte{0xC3, 5, 10, 0x00, 'a', 0xE0, 0x00, 0x00})
+
+	// a list, as a quicklist of one listpack node
+	lp := []byte{0, 0, 0, 0, 2, 0}
+	lp = append(lp, 0x83, 'a', 'b', 'c', 4)
+	lp = append(lp, 0x05, 1, 0xFF)
+	b.WriteByte(rdbTypeListQuicklist2)
+	b.Write(rdbString("list"))
+	b.Write([]byte{1, rdbQuicklistNodePacked})
+	b.Write(rdbBlob(lp))
+
+	// a set, as an intset
+	is := make([]byte, 8+3*2)
+	binary.LittleEndian.PutUint32(is, 2)
+	binary.LittleEndian.PutUint32(is[4:], 3)
+	binary.LittleEndian.PutUint16(is[8:], 1)
+	binary.LittleEndian.PutUint16(is[10:], 2)
+	binary.LittleEndian.PutUint16(is[12:], 3)
+	b.WriteByte(rdbTypeSetIntset)
+	b.Write(rdbString("set"))
+	b.Write(rdbBlob(is))
+
+	// a sorted set, with binary scores
+	b.WriteByte(rdbTypeZSet2)
+	b.Write(rdbString("zset"))
+	b.WriteByte(2)
+	b.Write(rdbString("member"))
+	b.Write(make([]byte, 8))
+	b.Write(rdbString("m2"))
+	b.Write(make([]byte, 8))
+
+	// a hash, as a ziplist, in another database
+	b.Write([]byte{rdbOpSelectDB, 1})
+	zl := make([]byte, 10)
+	zl = append(zl, 0, 0x05, 'f', 'i', 'e', 'l', 'd')
+	zl = append(zl, 7, 0xC0, 0xE8, 0x03)
+	zl = append(zl, 0xFF)
+	b.WriteByte(rdbTypeHashZiplist)
+	b.Write(rdbString("hash"))
+	b.Write(rdbBlob(zl))
+
+	// a string that expired long ago
+	b.WriteByte(rdbOpExpireTimeMs)
+	b.Write([]byte{1, 0, 0, 0, 0, 0, 0, 0})
+	b.WriteByte(rdbTypeString)
+	b.Write(rdbString("expired"))
+	b.Write(rdbString("gone"))
+
+	b.WriteByte(rdbOpEOF)
+	b.Write(make([]byte, 8))
+	return b.Bytes()
+}
+
+func TestRDBKeySource(t *testing.T) {
+
+	src := NewRDBKeySource(bytes.NewReader(testRDB()))
+	count, err := src.KeyCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 8, int(count))
+
+	expected := []Sample{
+		{Key: "str", Type: TypeString, Length: 5, Value: "hello"},
+		{Key: "int", Type: TypeString, Length: 5, Value: "12345"},
+		{Key: "lzf", Type: TypeString, Length: 10, Value: "aaaaaaaaaa"},
+		{Key: "list", Type: TypeList, Length: 2, Elements: []string{"abc", "5"}},
+		{Key: "set", Type: TypeSet, Length: 3, Elements: []string{"1", "2", "3"}},
+		{Key: "zset", Type: TypeSortedSet, Length: 2, Elements: []string{"member", "m2"}},
+		{Key: "hash", Type: TypeHash, DB: 1, Length: 1, Elements: []string{"field", "1000"}},
+	}
+	for _, e := range expected {
+		key, vt, err := src.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		smp, err := src.Fetch(key, vt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(smp, e) {
+			t.Errorf("expected %+v, actual: %+v", e, smp)
+		}
+	}
+
+	key, vt, err := src.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Fetch(key, vt); err != ErrKeyMissing {
+		t.Errorf("expected the expired key to be missing, actual error: %v", err)
+	}
+	if _, _, err := src.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, actual: %v", err)
+	}
+}
+
+func TestRunSourceRDB(t *testing.T) {
+
+	src := NewRDBKeySource(bytes.NewReader(testRDB()))
+	stats, summary, err := RunSource(src, Options{SampleRate: 1.0}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 7, summary.Sampled)
+	assertInt(t, 1, summary.Expired)
+
+	r := stats["any-key"]
+	assertInt(t, 3, int(sum(r.StringSizes)))
+	assertInt(t, 1, int(r.SetSizes[3]))
+	assertInt(t, 1, int(r.HashValueSizes[4]))
+}
+
+func TestRDBKeySourceNotRDB(t *testing.T) {
+
+	src := NewRDBKeySource(bytes.NewReader([]byte("not an rdb file")))
+	if _, err := src.KeyCount(); err != ErrNotRDB {
+		t.Errorf("expected ErrNotRDB, actual: %v", err)
+	}
+}
+
+func TestRDBCorruptLengths(t *testing.T) {
+
+	for name, encoded := range map[string][]byte{
+		// lengths that overflow an int, or are merely huge
+		"string":       {0x81, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+		"huge string":  {0x81, 0, 0, 0, 0x10, 0, 0, 0, 0},
+		"lzf":          {0xC3, 5, 0x81, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x00, 'a', 0xE0, 0x00, 0x00},
+		"lzf expanded": {0xC3, 5, 0x81, 0, 0, 0, 0x10, 0, 0, 0, 0, 0x00, 'a', 0xE0, 0x00, 0x00},
+	} {
+		src := NewRDBKeySource(bytes.NewReader(encoded))
+		if _, err := src.readString(); err != errTruncated {
+			t.Errorf("%s: expected errTruncated, actual: %v", name, err)
+		}
+	}
+
+	// a length within bounds, but far longer than the rest of the dump
+	src := NewRDBKeySource(bytes.NewReader([]byte{0x80, 0x10, 0, 0, 0, 'a'}))
+	if _, err := src.readString(); err != io.ErrUnexpectedEOF {
+		t.Errorf("long string: expected io.ErrUnexpectedEOF, actual: %v", err)
+	}
+
+	// an intset claiming far more members than it holds
+	is := make([]byte, 8+2)
+	binary.LittleEndian.PutUint32(is, 2)
+	binary.LittleEndian.PutUint32(is[4:], 0x3FFFFFFF)
+	var b bytes.Buffer
+	b.WriteString("REDIS0011")
+	b.WriteByte(rdbTypeSetIntset)
+	b.Write(rdbString("set"))
+	b.Write(rdbBlob(is))
+	b.WriteByte(rdbOpEOF)
+	src = NewRDBKeySource(bytes.NewReader(b.Bytes()))
+	if _, err := src.KeyCount(); err == nil || !strings.Contains(err.Error(), errTruncated.Error()) {
+		t.Errorf("intset: expected errTruncated, actual: %v", err)
+	}
+}
@@ -19,12 +19,17 @@
 package reckon
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 )
@@ -33,10 +38,89 @@ import (
 // the redis instance listening on a particular host/port with a specified
 // number/percentage of random keys.
 type Options struct {
-	Host     string
-	Port     int
+	Host string
+	Port int
+
+	// Password, if non-empty, is sent with `AUTH` once connected (e.g. for
+	// instances configured with requirepass).  If it is rejected, Run returns
+	// an error wrapping ErrAuthentication.
 	Password string
 
+	// Socket is the path of a Unix domain socket to connect to the redis
+	// instance over (see the unixsocket directive), e.g. from a co-located
+	// sidecar.  When set, Host and Port are ignored, even if they are set too.
+	Socket string
+
+	// DB is the index of the logical database to be sampled, which is selected
+	// with `SELECT` once connected (and authenticated), unless it is 0, the
+	// default database.  The key count (and so the sample size) is that of
+	// the selected database.
+	DB int
+
+	// Dial optionally supplies the function used to establish the network
+	// connection to the redis instance, in place of the default TCP dialer.
+	// It is an escape hatch for constrained network topologies, e.g. instances
+	// that can only be reached via a SOCKS5 proxy (see the Dial method of the
+	// dialers in golang.org/x/net/proxy).
+	Dial func(network, addr string) (net.Conn, error)
+
+	// TLS causes the connection to the redis instance to be encrypted with
+	// TLS, e.g. for instances behind stunnel, or with tls-port configured.
+	// TLSConfig optionally configures it (e.g. with client certificates or a
+	// private CA); otherwise the server's certificate is verified against the
+	// host's root CAs, unless TLSSkipVerify is set (e.g. for self-signed
+	// certificates in development).  Connections are unencrypted by default.
+	TLS           bool
+	TLSConfig     *tls.Config
+	TLSSkipVerify bool
+
+	// ConnectTimeout bounds the time taken to connect to the redis instance,
+	// and ReadTimeout and WriteTimeout the time taken to read each reply and
+	// write each command, so that a hung instance fails the run rather than
+	// blocking it forever.  When zero, DefaultConnectTimeout,
+	// DefaultReadTimeout and DefaultWriteTimeout are used.  A timeout while
+	// sampling returns the partial results (see Summary.Partial) along with an
+	// error wrapping ErrTimeout.  ConnectTimeout does not apply to a custom
+	// Dial, and none apply to RunConn, whose connection is established by the
+	// caller.
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+
+	// Tag optionally names the redis instance (e.g. "shard-3").  When set, the
+	// number of keys sampled from this instance is recorded in the Instances
+	// breakdown of each Results, so that merged results (see RunMulti) can still
+	// be attributed to individual instances.
+	Tag string
+
+	// TagGroups causes the Tag to be prefixed to every aggregation group name,
+	// as "<tag>/<group>", so that results from different instances are kept
+	// separate even when merged.  It has no effect unless Tag is set.
+	TagGroups bool
+
+	// Provenance optionally labels the results of this sampling run (e.g.
+	// "by-prefix/v2") with the aggregator and configuration that produced
+	// them, and is recorded in each Results.  Results with different
+	// provenance cannot be merged, which catches accidental merges of results
+	// aggregated in different ways, whose group names may collide while
+	// meaning different things.  See also Label.
+	Provenance string
+
+	// KeyNormalizer optionally canonicalizes each sampled key name before it is
+	// handed to the Aggregator, e.g. to map legacy "u:123" keys onto the newer
+	// "user:123" naming scheme, so that both are aggregated into the same
+	// groups without complicating the Aggregator itself.  Only the grouping is
+	// affected: example keys, big keys and key name sizes still describe the
+	// actual key names.  When nil, key names are passed through unchanged.
+	KeyNormalizer func(key string) string
+
+	// OnKey, if set, is called once for each key after it has been sampled
+	// and aggregated, with its type and estimated size in bytes (see
+	// Sample.Size), e.g. to forward the names of big keys to an external
+	// queue for offline analysis.  It has no effect on the Results, and is
+	// called synchronously, so slow callbacks slow down sampling.
+	OnKey func(key string, vt ValueType, size int)
+
 	// MinSamples indicates the minimum number of random keys to sample from the redis
 	// instance.  Note that this does not mean **unique** keys, just an absolute
 	// number of random keys.  Therefore, this number should be small relative to
@@ -47,8 +131,533 @@ type Options struct {
 	// Accordingly, values should be between 0.0 and 1.0.  If a non-zero value is
 	// given for both `SampleRate` and `MinSamples`, the actual number of keys
 	// sampled will be the greater of the two values, once the key count has been
-	// calculated using the `SampleRate`.
+	// calculated using the `SampleRate`.  If both are zero, the whole keyspace
+	// is observed, as if Census were set.
 	SampleRate float32
+
+	// Census causes every key in the redis instance to be observed exactly
+	// once, by iterating over the entire keyspace with SCAN, rather than
+	// sampling random keys.  The resulting statistics are exact (see
+	// Results.Exact), and MinSamples and SampleRate are ignored.  This is only
+	// practical for small-to-medium instances.
+	Census bool
+
+	// Strategy determines how random keys are selected (see Strategy).  It is
+	// ignored in Census mode.
+	Strategy Strategy
+
+	// MatchPattern optionally restricts the keys iterated over by SCAN (in
+	// Census mode, or when RANDOMKEY is not permitted) to those matching a
+	// glob-style pattern, as for SCAN's MATCH option
+	MatchPattern string
+
+	// KeyFilter, when non-nil, is called with each selected key (and its data
+	// type) before the key is read; keys for which it returns false are
+	// skipped entirely, e.g. to ignore an internal-prefix blocklist without
+	// building it into every Aggregator.  Filtered keys are tallied in
+	// Summary.Filtered, and still count towards the sample size.  Unlike
+	// MatchPattern, which is applied by the server, KeyFilter is applied
+	// client-side, after MatchPattern, and so can express arbitrary logic.
+	KeyFilter func(key string, vt ValueType) bool
+
+	// Slots optionally restricts sampling to the keys that hash to the given
+	// redis cluster slot ranges (see KeySlot), e.g. to profile a single
+	// shard's slots from a node that owns them.  Keys are then selected with
+	// SCAN, filtering out keys in other slots.  Note that SampleRate remains
+	// relative to the node's whole keyspace.
+	Slots []SlotRange
+
+	// ScanCount is the COUNT hint given to SCAN (in Census mode, or when
+	// RANDOMKEY is not permitted), i.e. the amount of work the server does per
+	// call.  Larger counts mean fewer round trips, and so a faster scan, at
+	// the cost of longer-running SCAN calls (and larger pages of pipelined
+	// TYPE commands) that can cause latency spikes on a busy instance: a large
+	// count suits a quiet replica, and a small one a busy master.  Note that
+	// reckon does not rate limit its commands, so the scan's impact can only
+	// be bounded per call, via ScanCount, and not over time.  When zero,
+	// DefaultScanCount is used.
+	ScanCount int
+
+	// ScanStride thins out the keys selected with StrategyScan: only every
+	// ScanStride'th key returned by SCAN is sampled, which spreads the sample
+	// over more of the keyspace.  When zero or one, every key is sampled.
+	ScanStride int
+
+	// CollectEncodings causes the internal encoding of each sampled key (as
+	// reported by redis' `OBJECT ENCODING` command) to be recorded, along with
+	// an estimate of the key's size.  This costs one additional (pipelined)
+	// command per sampled key.
+	CollectEncodings bool
+
+	// EncodingThresholds optionally gives the compact encoding thresholds
+	// configured on the redis instance (e.g. its hash-max-listpack-entries, or
+	// hash-max-ziplist-entries before redis 7), which are recorded in each
+	// Results so that reports recommend raising them where that would save
+	// memory (see Results.Recommendations).  Reports make no recommendations
+	// without them, since the settings' names and values depend on the
+	// server's version and configuration.
+	EncodingThresholds map[ValueType]EncodingThreshold
+
+	// CollectLatencies causes the time taken to fetch each sampled key (the
+	// round trip for its pipelined commands) to be recorded, in
+	// Results.FetchLatencies, revealing groups whose keys are expensive to
+	// touch, e.g. because of huge values.  The latencies include the network
+	// round trip, so are only comparable within a run.
+	CollectLatencies bool
+
+	// CollectScripts causes the number and memory usage of the cached Lua
+	// scripts and function libraries to be read from the "memory" section of
+	// redis' `INFO` command (see ServerInfo.Scripts) and shown in reports, so
+	// that these consumers of memory outside the keyspace aren't overlooked.
+	// It costs no extra commands.
+	CollectScripts bool
+
+	// TypeQuotas optionally specifies a minimum number of keys to sample for
+	// each redis data type (stratified sampling).  Once the usual number of
+	// keys has been sampled, Run keeps sampling until every quota is met,
+	// skipping any keys whose type has already met its quota.  Since a quota
+	// may be unattainable (e.g. there are no keys of that type), Run gives up
+	// after sampling QuotaAttemptsFactor times the sum of all quotas in
+	// additional keys.
+	TypeQuotas map[ValueType]int
+
+	// ResultsFactory optionally constructs the Results for each aggregation
+	// group, in place of NewResults, e.g. to configure every group's
+	// histogram buckets, top values or provenance from one place.  It must
+	// return a new, empty Results on every call, typically by calling
+	// NewResults and adjusting it; a nil or non-empty Results is rejected
+	// before sampling starts, and any maps left nil are allocated.  The
+	// corresponding Options (SizeBuckets, TopValues, Provenance, ShortTTL,
+	// MinSize and TemperatureThresholds) take precedence where set.
+	ResultsFactory func() *Results
+
+	// Allocation determines how the sample size is allocated among data types
+	// (see Allocation), and MinPerType is the minimum number of keys of each
+	// data type that AllocationProportional allocates.  Neither applies in
+	// Census mode.
+	Allocation Allocation
+	MinPerType int
+
+	// SizeBuckets optionally specifies ascending histogram bucket boundaries
+	// (e.g. 1024, 10240) to be used when reporting on size distributions, so that
+	// reports line up with externally-defined thresholds.  When empty,
+	// power-of-two buckets are used.
+	SizeBuckets []int
+
+	// MaxRuntime is a safety cap on the duration of the sampling loop.  Once it
+	// is exceeded, sampling stops and the partial results are returned, with
+	// Summary.RuntimeCapReached set.  When zero, DefaultMaxRuntime is used; a
+	// negative value disables the cap.
+	MaxRuntime time.Duration
+
+	// MaxElementsPerKey caps the number of elements that will be read from any
+	// single collection, so that sampling a pathological key (e.g. a set with
+	// millions of members) is never expensive.  Cardinalities are always
+	// obtained using O(1) commands (LLEN, SCARD, ZCARD and HLEN), so only the
+	// sampling of element contents is affected: since element sizes are
+	// estimated from at most this many elements, estimates for giant
+	// collections whose elements vary widely in size may be inaccurate.  When
+	// zero, DefaultMaxElementsPerKey is used.
+	MaxElementsPerKey int
+
+	// ElementsPerKey is the number of elements sampled from each collection,
+	// using a single multi-element command per key (e.g. SRANDMEMBER with a
+	// count, or HRANDFIELD), so that element size statistics reflect more than
+	// one element of each key.  It is capped by MaxElementsPerKey.  When zero,
+	// DefaultElementsPerKey is used.
+	ElementsPerKey int
+
+	// ElementStrategy determines how elements are selected from sets, sorted
+	// sets and hashes.  StrategyRandom (the default) uses SRANDMEMBER,
+	// ZRANDMEMBER or HRANDFIELD, which favour elements in sparsely populated
+	// hash table buckets, much like RANDOMKEY.  StrategyReservoir instead
+	// walks the collection with SSCAN, ZSCAN or HSCAN, reading at most
+	// MaxElementsPerKey elements, and keeps a uniform random sample of
+	// ElementsPerKey of them, so that every element read is equally likely
+	// to be sampled.  Collections larger than MaxElementsPerKey are only
+	// partially walked, so their sample is drawn from the elements that SCAN
+	// happens to visit first.  Lists are unaffected.
+	ElementStrategy Strategy
+
+	// ListEnds causes elements to be sampled from both ends of each list (the
+	// first and last ElementsPerKey elements, in a single round trip), and
+	// their sizes to be recorded separately, in ListHeadElementSizes and
+	// ListTailElementSizes.  For queue-like lists, this reveals systematic
+	// differences between the newest and oldest elements, such as a change of
+	// payload format.  ListElementSizes is unaffected.
+	ListEnds bool
+
+	// SizeOnlyTypes optionally lists data types whose contents should not be
+	// sampled: for keys of these types, only the length is fetched (using
+	// STRLEN, LLEN, SCARD, ZCARD or HLEN), keeping potentially large values off
+	// the wire.  Their key names and lengths are still aggregated, but element
+	// and value sizes, examples and top values are not, and their encoding
+	// sizes and big keys cannot be estimated (other than for strings).
+	SizeOnlyTypes map[ValueType]bool
+
+	// ValueTransform, if set, is applied to each sampled value before it is
+	// measured: string values, hash values, and the members of lists, sets and
+	// sorted sets (but not key names or hash fields).  It allows the logical
+	// size of application-encoded values to be measured, e.g. by decompressing
+	// gzipped values or decoding base64.  The transformed values are also
+	// those reported as examples and top values, and passed to a
+	// ContextAggregator.  The transform runs on the sampling goroutine, once
+	// per sampled value, so an expensive transform (such as decompression)
+	// directly reduces the sampling rate; it should return its input unchanged
+	// for values that it cannot decode.
+	ValueTransform func([]byte) []byte
+
+	// CollectCompression causes both the stored size of each sampled value
+	// (before ValueTransform) and its logical size (after) to be recorded, in
+	// Results.StoredValueSizes and Results.LogicalValueSizes, so that the
+	// effectiveness of compression can be compared between groups (see
+	// Results.CompressionRatio).  It is ignored without a ValueTransform,
+	// which does the (potentially costly) decompression.
+	CollectCompression bool
+
+	// CollectMemoryUsage causes the memory used by each sampled key, as
+	// reported by `MEMORY USAGE` (pipelined along with the key's contents), to
+	// be recorded in Results.MemoryUsages.  Unlike the estimated sizes (see
+	// Sample.Size), this includes redis' own overheads, for capacity
+	// planning.  Servers that don't support `MEMORY USAGE` (before redis 4.0,
+	// or when it isn't permitted) fall back to the estimated sizes, which is
+	// flagged by Results.MemoryEstimated.
+	CollectMemoryUsage bool
+
+	// CommandRetries is the number of times a command that fails with a
+	// transient error reply (e.g. LOADING, while an instance loads its dataset
+	// after a restart, or MASTERDOWN) is retried before the error is treated
+	// as fatal.  Retries are delayed by CommandRetryBackoff, doubling after
+	// each attempt.  Other errors (e.g. WRONGTYPE or NOPERM) are never
+	// retried.  When zero, DefaultCommandRetries and DefaultCommandRetryBackoff
+	// are used; a negative CommandRetries disables retries.
+	CommandRetries      int
+	CommandRetryBackoff time.Duration
+
+	// PipelineBatchSize, when greater than 1, causes random keys to be
+	// selected in batches of this many, pipelining their RANDOMKEY and TYPE
+	// commands to save round trips.  It also bounds the number of outstanding
+	// commands (and so the number of buffered replies): the pipeline is
+	// flushed and drained each time the batch fills.  Larger batches are
+	// faster, at the cost of up to PipelineBatchSize-1 wasted selections at
+	// the end of the run.
+	PipelineBatchSize int
+
+	// Unique causes keys selected with StrategyRandom to be sampled at most
+	// once: since RANDOMKEY selects keys with replacement, a small instance
+	// would otherwise see the same (possibly big) key many times over,
+	// overweighting it.  Duplicates are skipped, and don't count towards the
+	// sample size.  To bound memory, only the first MaxUniqueKeys keys are
+	// remembered, and sampling stops once MaxDuplicateSelections duplicates
+	// have been selected in a row (i.e. the keyspace has likely been
+	// exhausted).  The other strategies never select a key twice anyway.
+	Unique bool
+
+	// BigKeyThreshold, when positive, causes any sampled key whose estimated
+	// size (see Sample.Size) exceeds this many bytes to be recorded in
+	// Results.BigKeys, similar to `redis-cli --bigkeys`.  BigKeyThresholds
+	// optionally overrides the threshold for individual data types.
+	BigKeyThreshold  int
+	BigKeyThresholds map[ValueType]int
+
+	// ShortTTL, when positive, causes the remaining time to live of each
+	// sampled key to be fetched (with PTTL, pipelined along with the key's
+	// contents), and the estimated sizes (see Sample.Size) of keys that will
+	// expire within ShortTTL to be recorded in Results.ShortLivedSizes.  This
+	// reveals large, short-lived keys (e.g. big cache entries with short
+	// TTLs), which churn memory and cause eviction pressure, but are hidden
+	// by the overall size distribution.
+	ShortTTL time.Duration
+
+	// CollectTTLs causes the remaining time to live of each sampled key to be
+	// fetched (as for ShortTTL), and the numbers of keys with and without an
+	// expiry, and the distribution of the former's TTLs, to be recorded in
+	// Results.Persistent, Expiring and TTLs, e.g. to find the groups whose
+	// keys are never expired.
+	CollectTTLs bool
+
+	// MinSize, when positive, excludes the keys whose estimated size in bytes
+	// (see Sample.Size) is below MinSize from the statistics, e.g. so that
+	// millions of trivial flag keys don't drown out the keys that matter for
+	// memory.  Excluded keys are still tallied, in the TrivialKeys of each of
+	// their groups, so that nothing is silently dropped.  Collections whose
+	// contents weren't sampled (see SizeOnlyTypes) are never excluded, since
+	// their size is unknown.
+	MinSize int
+
+	// MaxIdleTime, when positive, restricts observation to the keys that
+	// were accessed within the last MaxIdleTime, i.e. the "working set",
+	// skipping older keys (which are tallied in Summary.Skipped, and still
+	// count towards the sample size).  The idle time of each key is fetched
+	// with `OBJECT IDLETIME`, pipelined ahead of the commands that read the
+	// key (which would otherwise reset it).  Note that the idle time reflects
+	// when a key was last read or written, not when it was created, and that
+	// redis doesn't track it under an LFU maxmemory-policy, in which case
+	// sampling fails.  RDB dumps record idle times (as of the dump) under LRU
+	// policies only; other keys are treated as not idle.
+	MaxIdleTime time.Duration
+
+	// CollectAccess causes the idle time of each sampled key to be recorded,
+	// in Results.IdleTimes, or its access frequency counter, in
+	// Results.AccessFrequencies, under an LFU maxmemory-policy (which tracks
+	// frequencies instead of idle times).  Each group is then classified as
+	// hot, warm or cold in reports (see Results.Temperature), according to
+	// TemperatureThresholds (or DefaultTemperatureThresholds, if zero), e.g.
+	// to decide which groups to move to cheaper storage.  This costs one
+	// additional (pipelined) command per sampled key, and disables the length
+	// pre-check of MinLength and MaxLength, as for MaxIdleTime.  RDB dumps
+	// record either statistic (as of the dump), depending on the policy.
+	CollectAccess         bool
+	TemperatureThresholds TemperatureThresholds
+
+	// MinLength and MaxLength, when positive, restrict observation to the
+	// keys whose length lies within [MinLength, MaxLength], e.g. to zoom into
+	// a size band identified from the histograms.  Note that the length is
+	// the number of bytes for strings, but the number of elements (not bytes)
+	// for collections.  Keys outside the range are tallied in
+	// Summary.Skipped, and still count towards the sample size.  The length
+	// of each key is checked with an O(1) command (see lengthCommands) before
+	// its contents are read, at the cost of an extra round trip per key
+	// (unless MaxIdleTime is also set, in which case it is checked after
+	// reading, so as not to reset the key's idle time first).
+	MinLength int
+	MaxLength int
+
+	// TopValues, when positive, causes the most frequently observed values of
+	// each data type to be tracked (see Results.TopValues), reporting this many
+	// values per type.  Memory use is bounded, regardless of the number of
+	// distinct values.
+	TopValues int
+
+	// ProgressFunc receives progress reports during sampling.  When nil,
+	// PrintProgress is used.
+	ProgressFunc ProgressFunc
+
+	// ProgressEvery and ProgressInterval set the cadence of progress reports:
+	// every ProgressEvery keys, and/or every ProgressInterval.  When both are
+	// zero, ProgressEvery defaults to 1% of the number of keys to be sampled
+	// (or 1, for samples of fewer than 100 keys).  Regardless of the cadence,
+	// a first report is made before sampling starts (see Progress).
+	ProgressEvery    int
+	ProgressInterval time.Duration
+
+	// MinCoverage and MinGroupSamples are the thresholds below which results
+	// are flagged as too small a sample to be meaningful.  If the fraction of
+	// the keyspace that was sampled (see Summary.Coverage) is below
+	// MinCoverage, a warning is added to the Summary and to every Results.  Any
+	// group with fewer than MinGroupSamples sampled keys is flagged as low
+	// confidence (see Results.LowConfidence).  When zero, DefaultMinCoverage
+	// and DefaultMinGroupSamples are used; a negative value disables the
+	// corresponding check.
+	MinCoverage     float64
+	MinGroupSamples int
+
+	// MinGroupCount, when positive, causes groups with fewer than this many
+	// sampled keys to be removed from the results once sampling completes, so
+	// that reports aren't cluttered by a long tail of tiny groups.  The number
+	// of groups removed is recorded in Summary.PrunedGroups.  RunMulti prunes
+	// each instance's results before merging them; to prune the merged
+	// results instead, call Prune on them.
+	MinGroupCount int
+
+	// SamplerMemoryBudget, when positive, bounds the memory (in bytes) retained
+	// by reckon itself for the statistics of all groups, e.g. so that a
+	// high-cardinality Aggregator can't exhaust a small sampling host.  The
+	// retained memory is estimated roughly, every BudgetCheckInterval sampled
+	// keys and once sampling completes; when it exceeds the budget, the groups
+	// with the fewest sampled keys are evicted until it fits.  A group that
+	// recurs after its eviction starts afresh (and is likely to be evicted
+	// again at the next check), so that reckon need not remember every evicted
+	// group.  The number of evictions is recorded in Summary.EvictedGroups,
+	// along with a warning.
+	SamplerMemoryBudget int
+
+	// SummaryWriter, when set, receives a single line of JSON summarizing the
+	// run once it completes (successfully or not), for consumption by scripts
+	// that wrap reckon.  It is distinct from the progress output.  An error
+	// writing the summary fails a run that would otherwise have succeeded.  See
+	// Summary.WriteJSON.
+	SummaryWriter io.Writer
+}
+
+// DefaultMaxRuntime is the MaxRuntime used when none is specified.  It is
+// deliberately generous: it is intended to stop runaway sampling jobs, not to
+// bound ordinary ones.
+const DefaultMaxRuntime = 6 * time.Hour
+
+// DefaultConnectTimeout is the ConnectTimeout used when none is specified.
+const DefaultConnectTimeout = 5 * time.Second
+
+// DefaultReadTimeout is the ReadTimeout used when none is specified.
+const DefaultReadTimeout = 3 * time.Second
+
+// DefaultWriteTimeout is the WriteTimeout used when none is specified.
+const DefaultWriteTimeout = 3 * time.Second
+
+// ErrTimeout is wrapped by the error returned when connecting to, or sampling,
+// the redis instance times out (see Options.ConnectTimeout)
+var ErrTimeout = errors.New("Timed out")
+
+// ErrAuthentication is wrapped by the error returned when the redis instance
+// rejects Options.Password, so that a wrong password can be told apart from a
+// connection failure
+var ErrAuthentication = errors.New("Error authenticating")
+
+// DefaultMaxElementsPerKey is the MaxElementsPerKey used when none is
+// specified.
+const DefaultMaxElementsPerKey = 100
+
+// DefaultElementsPerKey is the ElementsPerKey used when none is specified.
+const DefaultElementsPerKey = 10
+
+// DefaultScanCount is the ScanCount used when none is specified.
+const DefaultScanCount = 100
+
+// Strategy determines how random keys are selected from a redis instance.
+type Strategy int
+
+const (
+	// StrategyRandom selects keys with RANDOMKEY.  This is cheap, but not
+	// uniform: RANDOMKEY picks a random hash table bucket and then a random
+	// key within it, so keys that share a bucket are less likely to be
+	// picked than keys that don't, and while the keyspace is being rehashed,
+	// keys in the smaller of the two tables are favoured.  Logically expired
+	// keys that haven't yet been evicted are also returned (and tallied in
+	// Summary.Expired).  Keys may be selected more than once.
+	StrategyRandom Strategy = iota
+
+	// StrategyReservoir selects a uniform random sample of distinct keys by
+	// iterating over the whole keyspace with SCAN, and keeping a reservoir
+	// of the sample size (see Algorithm R).  Every key is as likely to be
+	// selected as every other, at the cost of a full SCAN pass (see
+	// ScanCount) before sampling begins, and of holding every key name in
+	// memory during that pass (as for a census).  This is the recommended
+	// strategy when the sampled statistics need to be representative, e.g.
+	// of a keyspace dominated by a few big hash table buckets.
+	StrategyReservoir
+
+	// StrategyScan selects keys in the order that SCAN returns them (or
+	// every ScanStride'th of them), stopping once the sample size is reached
+	// or the cursor wraps around.  Each key is selected at most once, and,
+	// unlike the other strategies, repeated runs against an unchanged
+	// keyspace select the same keys, so their results can be compared.  The
+	// sample is not random, though: it is biased towards the keys in the
+	// hash table buckets that SCAN visits first.
+	StrategyScan
+)
+
+// String returns the name of the strategy
+func (s Strategy) String() string {
+	switch s {
+	case StrategyRandom:
+		return "random"
+	case StrategyReservoir:
+		return "reservoir"
+	case StrategyScan:
+		return "scan"
+	}
+	return fmt.Sprintf("Strategy(%d)", int(s))
+}
+
+// DefaultCommandRetries and DefaultCommandRetryBackoff are the
+// Options.CommandRetries and Options.CommandRetryBackoff used when none are
+// specified.
+const (
+	DefaultCommandRetries      = 3
+	DefaultCommandRetryBackoff = 100 * time.Millisecond
+)
+
+// DefaultMinCoverage and DefaultMinGroupSamples are the Options.MinCoverage
+// and Options.MinGroupSamples used when none are specified: a run is flagged
+// if it sampled less than 0.01% of the keyspace, and a group if it holds fewer
+// than 30 sampled keys.
+const (
+	DefaultMinCoverage     = 0.0001
+	DefaultMinGroupSamples = 30
+)
+
+// QuotaAttemptsFactor bounds the number of additional keys that will be
+// sampled in order to satisfy Options.TypeQuotas, as a multiple of the sum of
+// all quotas.
+const QuotaAttemptsFactor = 10
+
+// MaxUniqueKeys bounds the number of sampled keys remembered in order to skip
+// duplicates (see Options.Unique), costing up to roughly 100 bytes per key
+// name.  Keys selected once this many have been remembered are always
+// sampled.
+const MaxUniqueKeys = 1000000
+
+// MaxDuplicateSelections is the number of consecutive duplicate keys after
+// which a sample of unique keys (see Options.Unique) stops short, on the
+// assumption that few keys remain unsampled.
+const MaxDuplicateSelections = 100
+
+// Allocation determines how the sample size is allocated among data types.
+type Allocation int
+
+const (
+	// AllocationUniform observes keys as they are selected, so that each data
+	// type's share of the sample reflects its share of the keyspace, subject
+	// to any Options.TypeQuotas.
+	AllocationUniform Allocation = iota
+
+	// AllocationProportional explicitly allocates the sample size among data
+	// types in proportion to their share of the keyspace, but with at least
+	// Options.MinPerType keys of each type, so that rare types are covered
+	// while the sample stays representative.  The shares are estimated from
+	// a pilot of the first quarter of the sample (at most
+	// AllocationPilotSize keys), after which keys of any type whose
+	// allocation has been met are skipped.  As with TypeQuotas, sampling
+	// gives up on unmet allocations after QuotaAttemptsFactor times the
+	// sample size in additional keys.  Types not seen in the pilot are
+	// allocated MinPerType keys, but not waited for.
+	AllocationProportional
+)
+
+// AllocationPilotSize is the maximum number of keys sampled to estimate the
+// share of each data type, with AllocationProportional.
+const AllocationPilotSize = 100
+
+// String returns the name of the allocation
+func (a Allocation) String() string {
+	switch a {
+	case AllocationUniform:
+		return "uniform"
+	case AllocationProportional:
+		return "proportional"
+	}
+	return fmt.Sprintf("Allocation(%d)", int(a))
+}
+
+// allocate divides `n` samples among data types in proportion to their
+// `counts`, but with at least `floor` samples of each type: types whose
+// proportional share falls below the floor are allocated the floor, and the
+// remainder is divided among the others
+func allocate(counts map[ValueType]int, n, floor int) map[ValueType]int {
+	alloc := make(map[ValueType]int, len(counts))
+	floored := make(map[ValueType]bool)
+	for changed := true; changed; {
+		changed = false
+		remaining := n - floor*len(floored)
+		var total int
+		for vt, c := range counts {
+			if !floored[vt] {
+				total += c
+			}
+		}
+		for vt, c := range counts {
+			if floored[vt] {
+				continue
+			}
+			alloc[vt] = int(math.Round(float64(remaining) * float64(c) / float64(total)))
+			if alloc[vt] < floor {
+				alloc[vt] = floor
+				floored[vt] = true
+				changed = true
+			}
+		}
+	}
+	return alloc
 }
 
 // A ValueType represents the various data types that redis can store. The
@@ -72,16 +681,28 @@ var (
 	// TypeList represents a redis list value
 	TypeList ValueType = "list"
 
+	// TypeNone is reported by redis' `TYPE` command for keys that don't exist,
+	// e.g. because they expired after being selected
+	TypeNone ValueType = "none"
+
 	// TypeUnknown means that the redis value type is undefined, and indicates an error
 	TypeUnknown ValueType = "unknown"
 
+	// valueTypes lists the known redis data types, in the order in which they
+	// appear in reports
+	valueTypes = []ValueType{TypeString, TypeList, TypeSet, TypeSortedSet, TypeHash}
+
 	// ErrNoKeys is the error returned when a specified redis instance contains
 	// no keys, or the key count could not be determined
 	ErrNoKeys = errors.New("No keys are present in the configured redis instance")
 
-	// keysExpr captures the key count from the matching line of output from
+	// keysExpr captures the database index and key count from the matching
+	// lines of output from redis' "INFO" command
+	keysExpr = regexp.MustCompile("^db(\\d+):keys=(\\d+),")
+
+	// keyspaceExpr matches the names of the database fields in the output of
 	// redis' "INFO" command
-	keysExpr = regexp.MustCompile("^db\\d+:keys=(\\d+),")
+	keyspaceExpr = regexp.MustCompile("^db\\d+$")
 )
 
 // AnyKey is an AggregatorFunc that puts any sampled key (regardless of key
@@ -110,9 +731,19 @@ func (f AggregatorFunc) Groups(key string, valueType ValueType) []string {
 }
 
 // flush is a convenience func for flushing a redis pipeline, receiving the
-// replies, and returning them, along with any error
+// replies, and returning them, along with any error (including the first error
+// reply to a pipelined command)
 func flush(conn redis.Conn) ([]interface{}, error) {
-	return redis.Values(conn.Do(""))
+	replies, err := redis.Values(conn.Do(""))
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range replies {
+		if e, ok := r.(redis.Error); ok {
+			return replies, e
+		}
+	}
+	return replies, nil
 }
 
 // ensureEntry is a convenience func for obtaining the Stats instance for the
@@ -142,135 +773,319 @@ func randomKey(conn redis.Conn) (key string, vt ValueType, err error) {
 	return key, ValueType(typeStr), nil
 }
 
-// keyCount obtains a the number of keys in the redis instance.
-func keyCount(conn redis.Conn) (count int64, err error) {
-	resp, err := redis.String(conn.Do("INFO"))
-	if err != nil {
-		return count, err
+// info obtains the output of redis' `INFO` command from the supplied redis
+// connection
+func info(conn redis.Conn) (string, error) {
+	return redis.String(conn.Do("INFO"))
+}
+
+// parseInfo parses the output of redis' `INFO` command into a map of fields
+func parseInfo(resp string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(resp, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.Index(line, ":"); i > 0 {
+			fields[line[:i]] = line[i+1:]
+		}
 	}
+	return fields
+}
 
+// keyCount obtains a the number of keys in the redis instance from the output
+// of redis' `INFO` command: that of database `db`, which is not listed if it
+// holds no keys.
+func keyCount(resp string, db int) (count int64, err error) {
 	for _, str := range strings.Split(resp, "\n") {
-		if matches := keysExpr.FindStringSubmatch(str); len(matches) >= 2 {
-			if count, err = strconv.ParseInt(matches[1], 10, 64); err == nil && count != 0 {
-				return count, nil
-			}
-			return count, ErrNoKeys
+		matches := keysExpr.FindStringSubmatch(str)
+		if len(matches) < 3 || matches[1] != strconv.Itoa(db) {
+			continue
+		}
+		if count, err = strconv.ParseInt(matches[2], 10, 64); err == nil && count != 0 {
+			return count, nil
 		}
+		return count, ErrNoKeys
 	}
 
 	return 0, ErrNoKeys
 }
 
-func sampleString(key string, conn redis.Conn, aggregator Aggregator, stats map[string]*Results) error {
-	val, err := redis.String(conn.Do("GET", key))
-	if err != nil {
-		return err
-	}
+// sampler holds the state of a single sampling run
+type sampler struct {
+	src        KeySource
+	opts       Options
+	aggregator Aggregator
+	stats      map[string]*Results
 
-	for _, agg := range aggregator.Groups(key, TypeString) {
-		s := ensureEntry(stats, agg, NewResults)
-		s.observeString(key, val)
-	}
-	return nil
+	// sampled counts the number of observed keys of each data type
+	sampled map[ValueType]int
+
+	// typeChanged counts the keys whose type changed between being selected
+	// and fetched
+	typeChanged int
+
+	// allocation holds the number of keys of each data type to be sampled,
+	// once the sample size has been allocated (see AllocationProportional)
+	allocation map[ValueType]int
 }
 
-func sampleList(key string, conn redis.Conn, aggregator Aggregator, stats map[string]*Results) error {
-	// TODO: Let's not always get the first element, like the orig. reckon
-	conn.Send("LLEN", key)
-	conn.Send("LRANGE", key, 0, 0)
-	replies, err := flush(conn)
-	if err != nil {
-		return err
+// quotaMet indicates whether the configured quota (if any) for data type `vt`
+// has been reached, or its allocation, once the sample size has been
+// allocated among data types (see AllocationProportional)
+func (s *sampler) quotaMet(vt ValueType) bool {
+	if s.allocation != nil {
+		quota, ok := s.allocation[vt]
+		if !ok {
+			quota = s.opts.MinPerType
+		}
+		return s.sampled[vt] >= quota
 	}
+	return s.sampled[vt] >= s.opts.TypeQuotas[vt]
+}
 
-	if len(replies) >= 2 {
-		l, err := redis.Int(replies[0], nil)
-		ms, err := redis.Strings(replies[1], err)
-		if err != nil {
-			return err
+// quotasMet indicates whether every configured quota (or allocation) has been
+// reached
+func (s *sampler) quotasMet() bool {
+	quotas := s.opts.TypeQuotas
+	if s.allocation != nil {
+		quotas = s.allocation
+	}
+	for vt := range quotas {
+		if !s.quotaMet(vt) {
+			return false
 		}
+	}
+	return true
+}
 
-		for _, g := range aggregator.Groups(key, TypeList) {
-			s := ensureEntry(stats, g, NewResults)
-			s.observeList(key, l, ms[0])
+// newResults constructs a new Results, configured according to the sampling
+// options
+func (s *sampler) newResults() *Results {
+	r := NewResults()
+	if s.opts.ResultsFactory != nil {
+		if created := s.opts.ResultsFactory(); created != nil {
+			r = created
+			r.init()
 		}
 	}
-	return nil
+	if len(s.opts.SizeBuckets) > 0 {
+		r.Buckets = s.opts.SizeBuckets
+	}
+	if s.opts.TopValues > 0 {
+		r.TopK = s.opts.TopValues
+	}
+	if s.opts.Provenance != "" {
+		r.Provenance = s.opts.Provenance
+	}
+	if s.opts.ShortTTL > 0 {
+		r.ShortTTL = s.opts.ShortTTL
+	}
+	if s.opts.MinSize > 0 {
+		r.MinSize = s.opts.MinSize
+	}
+	if s.opts.TemperatureThresholds != (TemperatureThresholds{}) {
+		r.TemperatureThresholds = s.opts.TemperatureThresholds
+	}
+	if len(s.opts.EncodingThresholds) > 0 {
+		r.EncodingThresholds = s.opts.EncodingThresholds
+	}
+	return r
 }
 
-func sampleSet(key string, conn redis.Conn, aggregator Aggregator, stats map[string]*Results) error {
-	conn.Send("SCARD", key)
-	conn.Send("SRANDMEMBER", key)
-	replies, err := flush(conn)
+// entry obtains the Results for the specified aggregation `group`, creating it
+// if necessary
+func (s *sampler) entry(group string) *Results {
+	return ensureEntry(s.stats, group, s.newResults)
+}
+
+// sampleKey fetches `key` (of type `vt`) from the KeySource, and records it in
+// each of its aggregation groups
+func (s *sampler) sampleKey(key string, vt ValueType) error {
+	if s.opts.KeyFilter != nil && !s.opts.KeyFilter(key, vt) {
+		return errFiltered
+	}
+	smp, err := s.src.Fetch(key, vt)
+	if err == ErrTypeChanged || (err == nil && smp.Type != vt) {
+		s.typeChanged++
+	}
 	if err != nil {
 		return err
 	}
+	if s.opts.MaxIdleTime > 0 && smp.IdleTime >= s.opts.MaxIdleTime {
+		return errIdle
+	}
+	if !inLengthRange(s.opts, smp.Length) {
+		return errOutOfRange
+	}
+	s.observe(smp)
+	if s.sampled != nil {
+		s.sampled[smp.Type]++
+	}
+	if s.opts.OnKey != nil {
+		s.opts.OnKey(smp.Key, smp.Type, smp.Size())
+	}
+	return nil
+}
 
-	if len(replies) >= 2 {
-		l, err := redis.Int(replies[0], nil)
-		m, err := redis.String(replies[1], err)
-		if err != nil {
-			return err
+// observe records a Sample in each of its aggregation groups
+func (s *sampler) observe(smp Sample) {
+	smp = smp.limitElements(elementsPerKey(s.opts))
+	sizeOnly := s.opts.SizeOnlyTypes[smp.Type]
+	var stored, logical []string
+	if s.opts.ValueTransform != nil && !sizeOnly {
+		if s.opts.CollectCompression {
+			stored = smp.values()
+		}
+		smp = smp.transform(s.opts.ValueTransform)
+		if s.opts.CollectCompression {
+			logical = smp.values()
 		}
+	}
+
+	ctx := SampleContext{Key: smp.Key, Type: smp.Type, DB: smp.DB, Instance: s.opts.Tag}
+	if s.opts.KeyNormalizer != nil {
+		ctx.Key = s.opts.KeyNormalizer(ctx.Key)
+	}
+	if smp.Type == TypeString {
+		ctx.Value = []byte(smp.Value)
+	}
 
-		for _, g := range aggregator.Groups(key, TypeSet) {
-			s := ensureEntry(stats, g, NewResults)
-			s.observeSet(key, l, m)
+	trivial := s.trivial(smp)
+	for _, g := range groupsFor(s.aggregator, ctx) {
+		r := s.entry(g)
+		if trivial {
+			r.observeTrivial()
+			continue
+		}
+		switch {
+		case sizeOnly:
+			r.observeSize(smp.Type, smp.Key, smp.Length)
+		case smp.Type == TypeString:
+			r.observeString(smp.Key, smp.Value)
+		case smp.Type == TypeList && len(smp.TailElements) > 0:
+			r.observeListEnds(smp.Key, smp.Length, smp.Elements, smp.TailElements)
+		case smp.Type == TypeList:
+			r.observeList(smp.Key, smp.Length, smp.Elements...)
+		case smp.Type == TypeSet:
+			r.observeSet(smp.Key, smp.Length, smp.Elements...)
+		case smp.Type == TypeSortedSet:
+			r.observeSortedSet(smp.Key, smp.Length, smp.Elements...)
+		case smp.Type == TypeHash:
+			r.observeHash(smp.Key, smp.Length, smp.Elements...)
+		}
+		if smp.Encoding != "" {
+			r.observeEncoding(smp.Type, smp.Encoding, smp.Size())
+		}
+		if threshold := s.bigKeyThreshold(smp.Type); threshold > 0 && smp.Size() > threshold {
+			r.observeBigKey(BigKey{Key: smp.Key, Type: smp.Type, Length: smp.Length, Size: int64(smp.Size())})
+		}
+		if s.opts.ShortTTL > 0 && smp.Expires && smp.TTL < s.opts.ShortTTL {
+			r.observeShortLived(smp.Size())
+		}
+		if s.opts.CollectTTLs {
+			r.observeTTL(smp.Expires, smp.TTL)
+		}
+		if s.opts.CollectLatencies && smp.Latency > 0 {
+			r.observeLatency(smp.Latency)
+		}
+		if s.opts.CollectAccess && smp.Access != AccessUnknown {
+			r.observeAccess(smp.Access, smp.IdleTime, smp.Frequency)
+		}
+		if len(stored) > 0 {
+			r.observeCompression(stored, logical)
+		}
+		if s.opts.CollectMemoryUsage {
+			if smp.MemoryUsage > 0 {
+				r.observeMemory(smp.MemoryUsage, false)
+			} else {
+				r.observeMemory(smp.Size(), true)
+			}
 		}
 	}
-	return nil
 }
 
-func sampleSortedSet(key string, conn redis.Conn, aggregator Aggregator, stats map[string]*Results) error {
-	conn.Send("ZCARD", key)
-	// TODO: Let's not always get the first element, like the orig. sampler
-	conn.Send("ZRANGE", key, 0, 0)
-	replies, err := flush(conn)
-	if err != nil {
-		return err
+// trivial indicates whether `smp` is too small to be included in the
+// statistics (see Options.MinSize)
+func (s *sampler) trivial(smp Sample) bool {
+	if s.opts.MinSize <= 0 || (smp.Type != TypeString && len(smp.Elements) == 0) {
+		return false
 	}
+	return smp.Size() < s.opts.MinSize
+}
 
-	if len(replies) >= 2 {
-		l, err := redis.Int(replies[0], nil)
-		ms, err := redis.Strings(replies[1], err)
-		if err != nil {
-			return err
-		}
+// bigKeyThreshold returns the size above which keys of type `vt` are
+// considered big, or 0 if big keys aren't being recorded
+func (s *sampler) bigKeyThreshold(vt ValueType) int {
+	if t, ok := s.opts.BigKeyThresholds[vt]; ok {
+		return t
+	}
+	return s.opts.BigKeyThreshold
+}
 
-		for _, g := range aggregator.Groups(key, TypeSortedSet) {
-			s := ensureEntry(stats, g, NewResults)
-			s.observeSortedSet(key, l, ms[0])
-		}
+// filtersLengths indicates whether keys are only observed within a range of
+// lengths, as configured by `opts`
+func filtersLengths(opts Options) bool {
+	return opts.MinLength > 0 || opts.MaxLength > 0
+}
+
+// inLengthRange indicates whether a key of length `length` lies within the
+// range of lengths to be observed, as configured by `opts`
+func inLengthRange(opts Options, length int) bool {
+	return length >= opts.MinLength && (opts.MaxLength <= 0 || length <= opts.MaxLength)
+}
+
+// collectTTLs indicates whether the remaining time to live of each sampled
+// key is needed, as configured by `opts`
+func collectTTLs(opts Options) bool {
+	return opts.ShortTTL > 0 || opts.CollectTTLs
+}
+
+// sampleSize returns the number of keys to sample from a keyspace of
+// `keyCount` keys, as configured by `opts`
+func sampleSize(opts Options, keyCount int64) int {
+	numSamples := opts.MinSamples
+	if opts.SampleRate > 0.0 {
+		v := int(float32(keyCount) * opts.SampleRate)
+		numSamples = max(max(v, numSamples), 1)
 	}
-	return nil
+	return numSamples
 }
 
-func sampleHash(key string, conn redis.Conn, aggregator Aggregator, stats map[string]*Results) error {
-	conn.Send("HLEN", key)
-	conn.Send("HKEYS", key)
-	replies, err := flush(conn)
-	if err != nil {
-		return err
+// scanCount returns the COUNT hint given to SCAN, as configured by `opts`
+func scanCount(opts Options) int {
+	if opts.ScanCount == 0 {
+		return DefaultScanCount
 	}
+	return opts.ScanCount
+}
 
-	if len(replies) >= 2 {
-		for _, g := range aggregator.Groups(key, TypeHash) {
+// withDefaults returns a copy of `opts` with implied settings made explicit:
+// when no sample size is configured (MinSamples and SampleRate are both zero),
+// the whole keyspace is observed, rather than nothing at all
+func withDefaults(opts Options) Options {
+	if opts.MinSamples == 0 && opts.SampleRate == 0.0 {
+		opts.Census = true
+	}
+	return opts
+}
 
-			// TODO: Let's not always get the first hash field, like the orig. sampler
-			l, err := redis.Int(replies[0], nil)
-			fields, err := redis.Strings(replies[1], err)
-			if err != nil {
-				return err
-			}
-			val, err := redis.String(conn.Do("HGET", key, fields[0]))
-			if err != nil {
-				return err
-			}
-			s := ensureEntry(stats, g, NewResults)
-			s.observeHash(key, l, fields[0], val)
-		}
+// elementsPerKey returns the number of elements to be sampled from each
+// collection, as configured by `opts`
+func elementsPerKey(opts Options) int {
+	n := opts.ElementsPerKey
+	if n == 0 {
+		n = DefaultElementsPerKey
 	}
-	return nil
+	limit := opts.MaxElementsPerKey
+	if limit == 0 {
+		limit = DefaultMaxElementsPerKey
+	}
+	if n > limit {
+		return limit
+	}
+	return n
 }
 
 func max(a, b int) int {
@@ -280,91 +1095,592 @@ func max(a, b int) int {
 	return b
 }
 
+// validate checks that the supplied Options are usable
+func validate(opts Options) error {
+	if opts.SampleRate < 0.0 || opts.SampleRate > 1.0 {
+		return errors.New("SampleRate must be between 0.0 and 1.0")
+	}
+
+	if opts.MinSamples < 0 {
+		return errors.New("MinSamples cannot be negative")
+	}
+
+	if opts.MinSize < 0 {
+		return errors.New("MinSize cannot be negative")
+	}
+
+	if opts.MinLength < 0 || opts.MaxLength < 0 {
+		return errors.New("MinLength and MaxLength cannot be negative")
+	}
+	if opts.MaxLength > 0 && opts.MaxLength < opts.MinLength {
+		return fmt.Errorf("MaxLength (%d) cannot be less than MinLength (%d)", opts.MaxLength, opts.MinLength)
+	}
+
+	if opts.Strategy != StrategyRandom && opts.Strategy != StrategyReservoir && opts.Strategy != StrategyScan {
+		return fmt.Errorf("Unknown Strategy: %s", opts.Strategy)
+	}
+	if opts.ElementStrategy != StrategyRandom && opts.ElementStrategy != StrategyReservoir {
+		return fmt.Errorf("Unknown ElementStrategy: %s", opts.ElementStrategy)
+	}
+
+	for vt, q := range opts.TypeQuotas {
+		if q < 0 {
+			return fmt.Errorf("TypeQuotas cannot be negative (%s: %d)", vt, q)
+		}
+	}
+
+	if opts.Allocation != AllocationUniform && opts.Allocation != AllocationProportional {
+		return fmt.Errorf("Unknown Allocation: %s", opts.Allocation)
+	}
+	if opts.MinPerType < 0 {
+		return errors.New("MinPerType cannot be negative")
+	}
+	if opts.Allocation == AllocationProportional && len(opts.TypeQuotas) > 0 {
+		return errors.New("TypeQuotas cannot be combined with AllocationProportional; use MinPerType instead")
+	}
+
+	if opts.BigKeyThreshold < 0 {
+		return errors.New("BigKeyThreshold cannot be negative")
+	}
+	for vt, b := range opts.BigKeyThresholds {
+		if b < 0 {
+			return fmt.Errorf("BigKeyThresholds cannot be negative (%s: %d)", vt, b)
+		}
+	}
+
+	if opts.TopValues < 0 {
+		return errors.New("TopValues cannot be negative")
+	}
+
+	if opts.ProgressEvery < 0 || opts.ProgressInterval < 0 {
+		return errors.New("ProgressEvery and ProgressInterval cannot be negative")
+	}
+
+	if opts.CommandRetryBackoff < 0 {
+		return errors.New("CommandRetryBackoff cannot be negative")
+	}
+
+	if opts.PipelineBatchSize < 0 {
+		return errors.New("PipelineBatchSize cannot be negative")
+	}
+
+	for _, r := range opts.Slots {
+		if r.First < 0 || r.Last >= ClusterSlots || r.First > r.Last {
+			return fmt.Errorf("Invalid slot range: %d-%d", r.First, r.Last)
+		}
+	}
+
+	if opts.ScanCount < 0 {
+		return errors.New("ScanCount cannot be negative")
+	}
+	if opts.ConnectTimeout < 0 || opts.ReadTimeout < 0 || opts.WriteTimeout < 0 {
+		return errors.New("ConnectTimeout, ReadTimeout and WriteTimeout cannot be negative")
+	}
+	if opts.ScanStride < 0 {
+		return errors.New("ScanStride cannot be negative")
+	}
+
+	if opts.MaxElementsPerKey < 0 || opts.ElementsPerKey < 0 {
+		return errors.New("MaxElementsPerKey and ElementsPerKey cannot be negative")
+	}
+
+	if opts.MinGroupCount < 0 {
+		return errors.New("MinGroupCount cannot be negative")
+	}
+	if opts.SamplerMemoryBudget < 0 {
+		return errors.New("SamplerMemoryBudget cannot be negative")
+	}
+	if opts.DB < 0 {
+		return errors.New("DB cannot be negative")
+	}
+	if (opts.TLSConfig != nil || opts.TLSSkipVerify) && !opts.TLS {
+		return errors.New("TLSConfig and TLSSkipVerify require TLS to be set")
+	}
+	if opts.TLSConfig != nil && opts.TLSSkipVerify {
+		return errors.New("TLSSkipVerify cannot be combined with TLSConfig; set its InsecureSkipVerify instead")
+	}
+
+	if opts.MinCoverage > 1.0 {
+		return errors.New("MinCoverage cannot be greater than 1.0")
+	}
+	if opts.ResultsFactory != nil {
+		if r := opts.ResultsFactory(); r == nil {
+			return errors.New("ResultsFactory must not return nil")
+		} else if r.KeyCount != 0 {
+			return errors.New("ResultsFactory must return an empty Results")
+		}
+	}
+
+	for i, b := range opts.SizeBuckets {
+		if b < 0 || (i > 0 && b <= opts.SizeBuckets[i-1]) {
+			return errors.New("SizeBuckets must be non-negative and strictly ascending")
+		}
+	}
+	return nil
+}
+
 // Run performs the configured sampling operation against the redis instance,
 // returning aggregated statistics using the provided Aggregator, as well as
-// the actual key count for the redis instance.  If any errors occur, the
-// sampling is short-circuited, and the error is returned.  In such a case, the
-// results should be considered invalid.
-func Run(opts Options, aggregator Aggregator) (map[string]*Results, int64, error) {
+// a Summary of the run (including the actual key count for the redis
+// instance).  If an error occurs before sampling starts, it is returned
+// without results.  An error while sampling (e.g. a timeout, see
+// Options.ReadTimeout) stops it, and is returned along with the results of the
+// keys sampled so far, which are flagged in Summary.Partial and the warnings.
+func Run(opts Options, aggregator Aggregator) (map[string]*Results, Summary, error) {
+	return RunContext(context.Background(), opts, aggregator)
+}
 
-	stats := make(map[string]*Results)
-	var err error
-	var keys int64
+// RunContext is like Run, but stops sampling once `ctx` is done, closing the
+// connection to the redis instance (interrupting any command in progress) and
+// returning the results sampled so far, along with ctx.Err().  Such partial
+// results are complete in every other respect, and can be rendered as usual
+// (see Summary.Interrupted), so that e.g. an interactive run cancelled with
+// Ctrl-C (see signal.NotifyContext) still produces a usable report.  The
+// context also bounds the time spent connecting, unless Options.Dial is set.
+func RunContext(ctx context.Context, opts Options, aggregator Aggregator) (stats map[string]*Results, summary Summary, err error) {
 
-	if opts.SampleRate < 0.0 || opts.SampleRate > 1.0 {
-		return stats, keys, errors.New("SampleRate must be between 0.0 and 1.0")
-	}
+	defer finish(opts, time.Now(), &stats, &summary, &err)
+
+	stats = make(map[string]*Results)
 
-	if opts.MinSamples <= 0 && opts.SampleRate == 0.0 {
-		return stats, keys, errors.New("MinSamples cannot be 0")
+	if err := validate(opts); err != nil {
+		return stats, summary, err
 	}
 
-	conn, err := redis.Dial("tcp", net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port)))
+	network, addr := address(opts)
+	conn, err := redis.Dial(network, addr, dialOptions(ctx, opts)...)
 	if err != nil {
-		return stats, keys, fmt.Errorf("Error connecting to the redis instance at: %s:%d : %s", opts.Host, opts.Port, err.Error())
+		if ctx.Err() != nil {
+			return stats, summary, ctx.Err()
+		}
+		if timedOut(err) {
+			return stats, summary, fmt.Errorf("%w connecting to the redis instance at: %s : %s", ErrTimeout, addr, err.Error())
+		}
+		return stats, summary, fmt.Errorf("Error connecting to the redis instance at: %s : %s", addr, err.Error())
 	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
 
 	if opts.Password != "" {
-		_, err := conn.Do("AUTH", opts.Password)
+		if _, err := conn.Do("AUTH", opts.Password); err != nil {
+			if timedOut(err) {
+				return stats, summary, fmt.Errorf("%w authenticating to the redis instance at: %s : %s", ErrTimeout, addr, err.Error())
+			}
+			return stats, summary, fmt.Errorf("%w to the redis instance at: %s : %s", ErrAuthentication, addr, err.Error())
+		}
+	}
+	if opts.DB != 0 {
+		if _, err := conn.Do("SELECT", opts.DB); err != nil {
+			if timedOut(err) {
+				return stats, summary, fmt.Errorf("%w selecting database %d on the redis instance at: %s : %s", ErrTimeout, opts.DB, addr, err.Error())
+			}
+			return stats, summary, fmt.Errorf("Error selecting database %d on the redis instance at: %s : %s", opts.DB, addr, err.Error())
+		}
+	}
+
+	stats, summary, err = sample(ctx, conn, opts, aggregator)
+	if ctx.Err() != nil {
+		// report the cancellation, rather than the error caused by closing the
+		// connection mid-command
+		err = ctx.Err()
+	} else if timedOut(err) {
+		err = fmt.Errorf("%w sampling the redis instance at: %s : %s", ErrTimeout, addr, err.Error())
+	}
+	return stats, summary, err
+}
+
+// RunWithConn samples `numKeys` random keys from the redis instance over
+// `conn`, e.g. a connection borrowed from the caller's pool, which is neither
+// dialed nor closed, so that an instance can be sampled repeatedly without
+// reconnecting.  It is a shorthand for RunConn with default Options, which
+// should be used for any other configuration.
+func RunWithConn(conn redis.Conn, numKeys int, aggregator Aggregator) (map[string]*Results, error) {
+	stats, _, err := RunConn(context.Background(), conn, Options{MinSamples: numKeys}, aggregator)
+	return stats, err
+}
+
+// RunConn is like RunContext, but samples the redis instance over `conn`, a
+// connection established (and, if need be, authenticated) by the caller, e.g.
+// one obtained from the caller's own redigo Pool, with its own dialing,
+// credential rotation or tracing.  None of the connection-related Options
+// (Host, Port, Password, TLS and Dial) are used, and no database is selected:
+// if the caller has selected a database other than 0, set DB to match, so that
+// its key count is used.  The connection is neither closed
+// nor interrupted once `ctx` is done: sampling stops after the command in
+// progress instead.
+func RunConn(ctx context.Context, conn redis.Conn, opts Options, aggregator Aggregator) (stats map[string]*Results, summary Summary, err error) {
+
+	defer finish(opts, time.Now(), &stats, &summary, &err)
+
+	if err := validate(opts); err != nil {
+		return make(map[string]*Results), summary, err
+	}
+
+	stats, summary, err = sample(ctx, conn, opts, aggregator)
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return stats, summary, err
+}
 
-		if err != nil {
-			return stats, keys, err
+// dialOptions returns the redigo DialOptions used to connect to the redis
+// instance described by `opts`, abandoning the connection attempt once `ctx`
+// is done
+func dialOptions(ctx context.Context, opts Options) []redis.DialOption {
+	dial := opts.Dial
+	if dial == nil {
+		// redigo's default dialer, but bound to `ctx`
+		// (redigo's DialConnectTimeout doesn't apply to a custom dialer)
+		d := net.Dialer{Timeout: timeout(opts.ConnectTimeout, DefaultConnectTimeout), KeepAlive: 5 * time.Minute}
+		dial = func(network, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, network, addr)
 		}
 	}
+	return []redis.DialOption{
+		redis.DialNetDial(dial),
+		redis.DialUseTLS(opts.TLS),
+		redis.DialTLSConfig(opts.TLSConfig),
+		redis.DialTLSSkipVerify(opts.TLSSkipVerify),
+		redis.DialReadTimeout(timeout(opts.ReadTimeout, DefaultReadTimeout)),
+		redis.DialWriteTimeout(timeout(opts.WriteTimeout, DefaultWriteTimeout)),
+	}
+}
 
-	numSamples := opts.MinSamples
+// address returns the network address of the redis instance described by
+// `opts`: its Unix socket, if it has one, otherwise its TCP host and port
+func address(opts Options) (network, addr string) {
+	if opts.Socket != "" {
+		return "unix", opts.Socket
+	}
+	return "tcp", net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))
+}
 
-	if keys, err = keyCount(conn); err != nil {
-		return stats, keys, err
+// timeout returns `d`, or `def` if it is zero
+func timeout(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
 	}
+	return d
+}
 
-	fmt.Printf("redis at %s:%d has %d keys\n", opts.Host, opts.Port, keys)
-	if opts.SampleRate > 0.0 {
-		v := int(float32(keys) * opts.SampleRate)
-		numSamples = max(max(v, numSamples), 1)
+// timedOut indicates whether `err` was caused by a network timeout
+func timedOut(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// closeOnDone closes `conn` once `ctx` is done, so that a blocked command
+// returns promptly.  The returned func stops watching `ctx`.
+func closeOnDone(ctx context.Context, conn redis.Conn) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// RunSource performs the configured sampling operation against an arbitrary
+// KeySource, returning aggregated statistics using the provided Aggregator,
+// along with a Summary of the run.  Options that only apply to live redis
+// instances (e.g. Host, Port and Password) are ignored.  Errors are handled
+// as for Run.
+func RunSource(src KeySource, opts Options, aggregator Aggregator) (stats map[string]*Results, summary Summary, err error) {
+
+	defer finish(opts, time.Now(), &stats, &summary, &err)
+
+	if err := validate(opts); err != nil {
+		return make(map[string]*Results), summary, err
+	}
+	opts = withDefaults(opts)
+
+	keyCount, err := src.KeyCount()
+	summary.KeyCount = keyCount
+	if err != nil {
+		return make(map[string]*Results), summary, err
+	}
+
+	stats, err = run(context.Background(), src, opts, aggregator, &summary)
+	return stats, summary, err
+}
+
+// RunMultiAggregator is like Run, but aggregates the statistics using each of
+// the provided Aggregators, returning one map of results per Aggregator (in
+// the same order), so that several reports (e.g. by key prefix and by data
+// type) can be produced from a single, expensive sampling pass.  Every
+// sampled key is fed to every Aggregator.  The Summary describes the run as a
+// whole, so its count of groups (see Options.SummaryWriter) is the total for
+// all Aggregators.
+func RunMultiAggregator(opts Options, aggregators ...Aggregator) ([]map[string]*Results, Summary, error) {
+	stats, summary, err := Run(opts, multiAggregator(aggregators))
+	return splitGroups(stats, len(aggregators)), summary, err
+}
+
+// finish completes the Summary of a run that started at `start`, writing it to
+// the SummaryWriter (if any).  Failing to write the Summary fails an otherwise
+// successful run.
+func finish(opts Options, start time.Time, stats *map[string]*Results, summary *Summary, err *error) {
+	summary.Duration = time.Since(start)
+	if opts.SummaryWriter == nil {
+		return
+	}
+	if werr := summary.WriteJSON(opts.SummaryWriter, len(*stats), *err); werr != nil && *err == nil {
+		*err = fmt.Errorf("Error writing the run summary: %s", werr)
+	}
+}
+
+// sample performs the sampling operation described by `opts` against an
+// established connection to a redis instance, until `ctx` is done
+func sample(ctx context.Context, rc redis.Conn, opts Options, aggregator Aggregator) (stats map[string]*Results, summary Summary, err error) {
+
+	stats = make(map[string]*Results)
+	opts = withDefaults(opts)
+
+	// account for the load that sampling imposes on the server, however the
+	// run ends
+	conn := &countingConn{Conn: rc}
+	retrying := newRetryingConn(ctx, conn, opts)
+	defer func() {
+		summary.Commands = conn.commands
+		summary.BytesReceived = conn.bytes
+		summary.Retries = retrying.retried
+	}()
+
+	src := NewRedisKeySource(retrying, opts)
+	if summary.KeyCount, err = src.KeyCount(); err != nil {
+		return stats, summary, err
 	}
 
-	interval := numSamples / 100
-	if interval == 0 {
-		interval = 1
+	// disable any optional collectors that the server can't support, rather
+	// than failing on every sampled key
+	summary.Capabilities = probeCapabilities(src.info)
+	for _, w := range summary.Capabilities.restrict(&opts) {
+		summary.Warnings = append(summary.Warnings, w)
+	}
+	src.opts = opts
+	src.caps = summary.Capabilities
+	summary.Server = parseServerInfo(src.info)
+	src.lfu = strings.Contains(summary.Server.MaxMemoryPolicy, "lfu")
+	if opts.CollectScripts {
+		scripts := parseScriptInfo(src.info)
+		summary.Server.Scripts = &scripts
+	}
+	if opts.MaxIdleTime > 0 && src.lfu {
+		return stats, summary, fmt.Errorf("MaxIdleTime cannot be used with the %s maxmemory-policy, since redis doesn't track idle times under LFU", summary.Server.MaxMemoryPolicy)
+	}
+	if summary.Server.Replica && !summary.Server.Replication.LinkUp {
+		w := "sampled a replica whose link to its master is down; the data may be stale"
+		summary.Warnings = append(summary.Warnings, w)
 	}
-	lastInterval := 0
 
-	for i := 0; i < numSamples; i++ {
-		key, vt, err := randomKey(conn)
-		if err != nil {
-			return stats, keys, err
+	stats, err = run(ctx, src, opts, aggregator, &summary)
+	if src.Scanning() {
+		w := "RANDOMKEY is not permitted for this redis user; keys were selected with SCAN instead"
+		summary.Warnings = append(summary.Warnings, w)
+	}
+	for _, r := range stats {
+		server := summary.Server
+		r.Server = &server
+	}
+	return stats, summary, err
+}
+
+// run samples keys from `src`, as described by `opts`, updating the supplied
+// Summary (whose KeyCount must already be set) as it goes.  Once `ctx` is
+// done, the keys sampled so far are returned along with ctx.Err(); likewise,
+// if sampling a key fails, along with that error.
+func run(ctx context.Context, src KeySource, opts Options, aggregator Aggregator, summary *Summary) (map[string]*Results, error) {
+
+	stats := make(map[string]*Results)
+
+	var quotaAttempts int
+	for _, q := range opts.TypeQuotas {
+		quotaAttempts += QuotaAttemptsFactor * q
+	}
+
+	numSamples := sampleSize(opts, summary.KeyCount)
+	if opts.Census {
+		// keep going until the source is exhausted; the key count is only
+		// the expected number of keys
+		numSamples = int(summary.KeyCount)
+	}
+
+	// with proportional allocation, the types of the keys selected for the
+	// pilot determine each type's share of the sample
+	proportional := opts.Allocation == AllocationProportional && !opts.Census
+	var pilot int
+	pilotTypes := make(map[ValueType]int)
+	if proportional {
+		pilot = max(numSamples/4, 1)
+		if pilot > AllocationPilotSize {
+			pilot = AllocationPilotSize
 		}
+		quotaAttempts = QuotaAttemptsFactor * numSamples
+	}
+
+	maxRuntime := opts.MaxRuntime
+	if maxRuntime == 0 {
+		maxRuntime = DefaultMaxRuntime
+	}
+	start := time.Now()
+	progress := newProgressReporter(opts, src, numSamples, start)
+	progress.begin(summary.KeyCount)
 
-		if i/interval != lastInterval {
-			fmt.Printf("sampled %d keys from redis at: %s:%d...\n", i, opts.Host, opts.Port)
-			lastInterval = i / interval
+	smp := &sampler{src: src, opts: opts, aggregator: aggregator, stats: stats, sampled: make(map[ValueType]int)}
+	var exhausted bool
+	var interrupted, failed error
+	for i := 0; opts.Census || i < numSamples || (!smp.quotasMet() && i < numSamples+quotaAttempts); i++ {
+		if interrupted = ctx.Err(); interrupted != nil {
+			break
+		}
+		if maxRuntime > 0 && time.Since(start) > maxRuntime {
+			summary.RuntimeCapReached = true
+			summary.Warnings = append(summary.Warnings, fmt.Sprintf("runtime cap of %s reached after sampling %d keys; results are partial", maxRuntime, summary.Sampled))
+			break
+		}
+		if proportional && i == pilot {
+			smp.allocation = allocate(pilotTypes, numSamples, opts.MinPerType)
+		}
+		if smp.allocation != nil && smp.quotasMet() {
+			break
 		}
 
-		switch ValueType(vt) {
-		case TypeString:
-			if err = sampleString(key, conn, aggregator, stats); err != nil {
-				return stats, keys, err
-			}
-		case TypeList:
-			if err = sampleList(key, conn, aggregator, stats); err != nil {
-				return stats, keys, err
-			}
-		case TypeSet:
-			if err = sampleSet(key, conn, aggregator, stats); err != nil {
-				return stats, keys, err
+		key, vt, err := src.Next()
+		if err == io.EOF {
+			exhausted = true
+			break
+		} else if err != nil {
+			if interrupted = ctx.Err(); interrupted == nil {
+				// not caused by the cancellation (which can close the
+				// connection mid-command)
+				failed = err
 			}
-		case TypeSortedSet:
-			if err = sampleSortedSet(key, conn, aggregator, stats); err != nil {
-				return stats, keys, err
-			}
-		case TypeHash:
-			if err = sampleHash(key, conn, aggregator, stats); err != nil {
-				return stats, keys, err
+			break
+		}
+
+		if i < pilot {
+			pilotTypes[vt]++
+		}
+
+		// past the regular sample size (or once it has been allocated among
+		// data types), only keys that count towards an unmet quota are of
+		// interest
+		if !opts.Census && (i >= numSamples || smp.allocation != nil) && smp.quotaMet(vt) {
+			summary.Skipped++
+			continue
+		}
+
+		progress.update(i)
+
+		err = smp.sampleKey(key, vt)
+		summary.TypeChanged = smp.typeChanged
+		if err == ErrKeyMissing {
+			// with lazy expiration, RANDOMKEY can return keys that have
+			// logically expired; tally them rather than failing the run
+			summary.Expired++
+			continue
+		} else if err == ErrTypeChanged {
+			// a high-churn key, already tallied; skip it rather than failing
+			continue
+		} else if err == errIdle || err == errOutOfRange {
+			summary.Skipped++
+			continue
+		} else if err == errFiltered {
+			summary.Filtered++
+			continue
+		} else if err != nil {
+			if interrupted = ctx.Err(); interrupted == nil {
+				failed = err
 			}
-		default:
-			return stats, keys, fmt.Errorf("unknown type for redis key: %s", key)
+			break
+		}
+		summary.Sampled++
+		if opts.SamplerMemoryBudget > 0 && summary.Sampled%BudgetCheckInterval == 0 {
+			summary.EvictedGroups += smp.enforceBudget()
+		}
+	}
+	summary.EvictedGroups += smp.enforceBudget()
+	if summary.EvictedGroups > 0 {
+		summary.Warnings = append(summary.Warnings, budgetWarning(summary.EvictedGroups, opts.SamplerMemoryBudget))
+	}
+	if interrupted != nil {
+		summary.Interrupted = true
+		w := fmt.Sprintf("sampling was interrupted after sampling %d keys; results are partial", summary.Sampled)
+		summary.Warnings = append(summary.Warnings, w)
+		for _, r := range stats {
+			r.Warnings = append(r.Warnings, w)
+		}
+	}
+	if failed != nil {
+		summary.Partial = true
+		w := fmt.Sprintf("sampling failed after sampling %d keys (%s); results are partial", summary.Sampled, failed)
+		summary.Warnings = append(summary.Warnings, w)
+		for _, r := range stats {
+			r.Warnings = append(r.Warnings, w)
+		}
+	}
+	// every key was observed if a census ran to completion, or the source
+	// was exhausted (e.g. every key in an RDB dump was read), unless all that
+	// was exhausted was a sample of the keys (e.g. a reservoir)
+	if c, ok := src.(interface{ complete() bool }); ok && !c.complete() {
+		exhausted = false
+	}
+	summary.Exact = exhausted
+	for _, r := range stats {
+		r.Exact = exhausted
+	}
+	flagConfidence(stats, opts, summary)
+	summary.PrunedGroups = Prune(stats, opts.MinGroupCount)
+	if failed != nil {
+		return tag(stats, opts), failed
+	}
+	return tag(stats, opts), interrupted
+}
+
+// flagConfidence warns (in the Summary and every Results) if too small a
+// fraction of the keyspace was sampled, and records the number of samples
+// below which individual groups are considered low confidence
+func flagConfidence(stats map[string]*Results, opts Options, summary *Summary) {
+	minCoverage := opts.MinCoverage
+	if minCoverage == 0 {
+		minCoverage = DefaultMinCoverage
+	}
+	minGroupSamples := opts.MinGroupSamples
+	if minGroupSamples == 0 {
+		minGroupSamples = DefaultMinGroupSamples
+	}
+
+	var w string
+	if coverage := summary.Coverage(); summary.Sampled > 0 && coverage < minCoverage {
+		w = fmt.Sprintf("only %d of %d keys (%.4f%%) were sampled, so statistics for all but the largest groups may not be meaningful", summary.Sampled, summary.KeyCount, 100*coverage)
+		summary.Warnings = append(summary.Warnings, w)
+	}
+
+	for _, r := range stats {
+		r.MinGroupSamples = max(minGroupSamples, 0)
+		if w != "" {
+			r.Warnings = append(r.Warnings, w)
+		}
+	}
+}
+
+// tag records the instance Tag (if any) on each of the aggregated `stats`,
+// prefixing group names with the tag if Options.TagGroups is set
+func tag(stats map[string]*Results, opts Options) map[string]*Results {
+	if opts.Tag == "" {
+		return stats
+	}
+
+	tagged := make(map[string]*Results, len(stats))
+	for g, r := range stats {
+		r.Instances[opts.Tag] = r.KeyCount
+		if opts.TagGroups {
+			g = opts.Tag + "/" + g
 		}
+		tagged[g] = r
 	}
-	return stats, keys, nil
+	return tagged
 }
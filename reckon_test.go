@@ -0,0 +1,2315 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// fakeKey is a single key stored in a fakeRedis.  For hashes, `value` holds
+// alternating fields and values.
+type fakeKey struct {
+	vt       ValueType
+	value    []string
+	encoding string
+
+	// ttl is the key's remaining time to live, or zero if it has no expiry
+	ttl time.Duration
+
+	// idle is the time since the key was last accessed, and freq its access
+	// frequency counter
+	idle time.Duration
+	freq int
+}
+
+// fakeRedis is an in-memory stand-in for a redis server, implementing
+// redis.Conn by answering the subset of commands that reckon issues.  RANDOMKEY
+// returns keys round-robin, in insertion order, so that tests are deterministic.
+type fakeRedis struct {
+	names []string
+	keys  map[string]*fakeKey
+	next  int
+
+	// override, when set, is consulted before the built-in command handling.
+	// If it returns true, its reply is used as-is.
+	override func(cmd string, args []interface{}) (interface{}, bool)
+
+	// commands logs every command received, as "CMD arg1 arg2..."
+	commands []string
+
+	pending []interface{}
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{keys: make(map[string]*fakeKey)}
+}
+
+func (f *fakeRedis) set(key string, vt ValueType, value ...string) *fakeKey {
+	if _, ok := f.keys[key]; !ok {
+		f.names = append(f.names, key)
+	}
+	k := &fakeKey{vt: vt, value: value, encoding: "raw"}
+	f.keys[key] = k
+	return k
+}
+
+func (f *fakeRedis) del(key string) {
+	delete(f.keys, key)
+	for i, n := range f.names {
+		if n == key {
+			f.names = append(f.names[:i], f.names[i+1:]...)
+			return
+		}
+	}
+}
+
+func bulk(s string) []byte { return []byte(s) }
+
+func bulks(ss []string) []interface{} {
+	r := make([]interface{}, len(ss))
+	for i, s := range ss {
+		r[i] = bulk(s)
+	}
+	return r
+}
+
+func argString(arg interface{}) string {
+	switch a := arg.(type) {
+	case string:
+		return a
+	case []byte:
+		return string(a)
+	default:
+		return fmt.Sprint(a)
+	}
+}
+
+// matches mimics SCAN's MATCH option, for patterns with a trailing "*" only
+func matches(pattern, key string) bool {
+	return strings.HasPrefix(key, strings.TrimSuffix(pattern, "*"))
+}
+
+func argInt(arg interface{}) int {
+	n, _ := strconv.Atoi(argString(arg))
+	return n
+}
+
+// listRange mimics redis' handling of (possibly negative) LRANGE/ZRANGE offsets
+func listRange(vals []string, start, stop int) []string {
+	if start < 0 {
+		start += len(vals)
+	}
+	if stop < 0 {
+		stop += len(vals)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= len(vals) {
+		stop = len(vals) - 1
+	}
+	if start > stop {
+		return []string{}
+	}
+	return vals[start : stop+1]
+}
+
+func (f *fakeRedis) exec(cmd string, args []interface{}) interface{} {
+	parts := []string{cmd}
+	for _, a := range args {
+		parts = append(parts, argString(a))
+	}
+	f.commands = append(f.commands, strings.Join(parts, " "))
+
+	if f.override != nil {
+		if reply, ok := f.override(cmd, args); ok {
+			return reply
+		}
+	}
+
+	var k *fakeKey
+	if len(args) > 0 {
+		k = f.keys[argString(args[0])]
+	}
+
+	switch strings.ToUpper(cmd) {
+	case "INFO":
+		return bulk(fmt.Sprintf("# Keyspace\r\ndb0:keys=%d,expires=0,avg_ttl=0\r\n", len(f.names)))
+	case "SELECT":
+		if db, err := strconv.Atoi(argString(args[0])); err != nil || db < 0 || db > 15 {
+			return redis.Error("ERR DB index is out of range")
+		}
+		return "OK"
+	case "RANDOMKEY":
+		if len(f.names) == 0 {
+			return nil
+		}
+		key := f.names[f.next%len(f.names)]
+		f.next++
+		return bulk(key)
+	case "SCAN":
+		// the cursor is simply an offset into the key names
+		start, end := argInt(args[0]), argInt(args[0])+argInt(args[2])
+		cursor := strconv.Itoa(end)
+		if end >= len(f.names) {
+			cursor, end = "0", len(f.names)
+		}
+		page := []string{}
+		for _, name := range f.names[start:end] {
+			if len(args) < 5 || matches(argString(args[4]), name) {
+				page = append(page, name)
+			}
+		}
+		return []interface{}{bulk(cursor), bulks(page)}
+	case "TYPE":
+		if k == nil {
+			return "none"
+		}
+		return string(k.vt)
+	case "OBJECT":
+		k = f.keys[argString(args[1])]
+		if k == nil {
+			return nil
+		}
+		switch strings.ToUpper(argString(args[0])) {
+		case "IDLETIME":
+			return int64(k.idle / time.Second)
+		case "FREQ":
+			return int64(k.freq)
+		}
+		return bulk(k.encoding)
+	case "PTTL":
+		switch {
+		case k == nil:
+			return int64(-2)
+		case k.ttl == 0:
+			return int64(-1)
+		}
+		return int64(k.ttl / time.Millisecond)
+	}
+
+	if k == nil {
+		switch strings.ToUpper(cmd) {
+		case "LRANGE", "ZRANGE", "SRANDMEMBER", "ZRANDMEMBER", "HRANDFIELD":
+			return []interface{}{}
+		case "HSCAN", "SSCAN", "ZSCAN":
+			return []interface{}{bulk("0"), []interface{}{}}
+		case "STRLEN", "LLEN", "SCARD", "ZCARD", "HLEN":
+			return int64(0)
+		default:
+			return nil
+		}
+	}
+
+	switch strings.ToUpper(cmd) {
+	case "GET":
+		if k.vt != TypeString {
+			return redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		return bulk(k.value[0])
+	case "STRLEN":
+		return int64(len(k.value[0]))
+	case "LLEN", "SCARD", "ZCARD":
+		return int64(len(k.value))
+	case "HLEN":
+		return int64(len(k.value) / 2)
+	case "LRANGE", "ZRANGE":
+		return bulks(listRange(k.value, argInt(args[1]), argInt(args[2])))
+	case "SRANDMEMBER", "ZRANDMEMBER":
+		// not actually random: the first `count` members are returned
+		return bulks(listRange(k.value, 0, argInt(args[1])-1))
+	case "HRANDFIELD":
+		n := len(k.value) / 2
+		if c := argInt(args[1]); c < n {
+			n = c
+		}
+		return bulks(k.value[:2*n])
+	case "HSCAN":
+		// the whole hash is returned in a single page, up to COUNT fields
+		n := len(k.value) / 2
+		if c := argInt(args[3]); c < n {
+			n = c
+		}
+		return []interface{}{bulk("0"), bulks(k.value[:2*n])}
+	case "SSCAN", "ZSCAN":
+		// as for SCAN, the cursor is simply an offset into the members
+		start, end := argInt(args[1]), argInt(args[1])+argInt(args[3])
+		cursor := strconv.Itoa(end)
+		if end >= len(k.value) {
+			cursor, end = "0", len(k.value)
+		}
+		page := []string{}
+		for _, member := range k.value[start:end] {
+			page = append(page, member)
+			if strings.ToUpper(cmd) == "ZSCAN" {
+				page = append(page, "1")
+			}
+		}
+		return []interface{}{bulk(cursor), bulks(page)}
+	}
+	return redis.Error("ERR unknown command '" + cmd + "'")
+}
+
+func (f *fakeRedis) Close() error { return nil }
+func (f *fakeRedis) Err() error   { return nil }
+
+func (f *fakeRedis) Send(cmd string, args ...interface{}) error {
+	f.pending = append(f.pending, f.exec(cmd, args))
+	return nil
+}
+
+func (f *fakeRedis) Flush() error { return nil }
+
+func (f *fakeRedis) Receive() (interface{}, error) {
+	if len(f.pending) == 0 {
+		return nil, fmt.Errorf("no pending replies")
+	}
+	reply := f.pending[0]
+	f.pending = f.pending[1:]
+	if e, ok := reply.(redis.Error); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// Do follows redigo's semantics: an empty command flushes the pipeline and
+// returns every pending reply, otherwise only the reply to `cmd` is returned.
+func (f *fakeRedis) Do(cmd string, args ...interface{}) (interface{}, error) {
+	pending := f.pending
+	f.pending = nil
+	if cmd == "" {
+		if len(pending) == 0 {
+			return nil, nil
+		}
+		return pending, nil
+	}
+
+	var err error
+	for _, r := range pending {
+		if e, ok := r.(redis.Error); ok && err == nil {
+			err = e
+		}
+	}
+	reply := f.exec(cmd, args)
+	if e, ok := reply.(redis.Error); ok && err == nil {
+		err = e
+	}
+	return reply, err
+}
+
+// serveFake answers the commands received on each connection accepted from
+// `l` with a fakeRedis, speaking the redis protocol, one connection at a time
+// until `l` is closed
+func serveFake(l net.Listener, f *fakeRedis) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		serveFakeConn(c, f)
+	}
+}
+
+func serveFakeConn(c net.Conn, f *fakeRedis) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	readInt := func(prefix byte) (int, error) {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		if len(line) < 3 || line[0] != prefix {
+			return 0, fmt.Errorf("unexpected line: %q", line)
+		}
+		return strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+	}
+
+	for {
+		n, err := readInt('*')
+		if err != nil || n == 0 {
+			return
+		}
+		args := make([]interface{}, n)
+		for i := range args {
+			size, err := readInt('$')
+			if err != nil {
+				return
+			}
+			b := make([]byte, size+2)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return
+			}
+			args[i] = b[:size]
+		}
+
+		reply, _ := f.Do(argString(args[0]), args[1:]...)
+		var buf bytes.Buffer
+		writeReply(&buf, reply)
+		if _, err := c.Write(buf.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+// writeReply encodes a fakeRedis reply in the redis protocol
+func writeReply(buf *bytes.Buffer, reply interface{}) {
+	switch r := reply.(type) {
+	case nil:
+		buf.WriteString("$-1\r\n")
+	case redis.Error:
+		fmt.Fprintf(buf, "-%s\r\n", r)
+	case string:
+		fmt.Fprintf(buf, "+%s\r\n", r)
+	case int64:
+		fmt.Fprintf(buf, ":%d\r\n", r)
+	case []byte:
+		fmt.Fprintf(buf, "$%d\r\n%s\r\n", len(r), r)
+	case []interface{}:
+		fmt.Fprintf(buf, "*%d\r\n", len(r))
+		for _, e := range r {
+			writeReply(buf, e)
+		}
+	default:
+		fmt.Fprintf(buf, "-ERR unsupported reply %T\r\n", r)
+	}
+}
+
+// benchmarkSample measures the rate at which keys are sampled from a
+// fakeRedis holding a mix of data types, reporting it in keys/sec
+func benchmarkSample(b *testing.B, opts Options) {
+	f := newFakeRedis()
+	for i := 0; i < 1000; i++ {
+		f.set(fmt.Sprintf("s%d", i), TypeString, strings.Repeat("v", i%100))
+		f.set(fmt.Sprintf("h%d", i), TypeHash, "field", "value", "other", "value")
+		f.set(fmt.Sprintf("l%d", i), TypeList, "a", "b", "c")
+	}
+
+	opts.MinSamples = b.N
+	b.ResetTimer()
+	start := time.Now()
+	_, summary, err := sample(context.Background(), f, opts, AggregatorFunc(AnyKey))
+	if err != nil {
+		b.Fatal(err)
+	}
+	summary.Duration = time.Since(start)
+	b.ReportMetric(summary.Throughput(), "keys/sec")
+}
+
+func BenchmarkSample(b *testing.B) {
+	benchmarkSample(b, Options{})
+}
+
+func BenchmarkSampleSizeOnly(b *testing.B) {
+	benchmarkSample(b, Options{SizeOnlyTypes: map[ValueType]bool{TypeString: true, TypeHash: true, TypeList: true}})
+}
+
+func BenchmarkSampleEncodings(b *testing.B) {
+	benchmarkSample(b, Options{CollectEncodings: true})
+}
+
+func BenchmarkSampleBatched(b *testing.B) {
+	benchmarkSample(b, Options{PipelineBatchSize: 10})
+}
+
+// newTestSampler returns a sampler over `conn` that aggregates every key into
+// the "any-key" group
+func newTestSampler(conn redis.Conn, opts Options) *sampler {
+	return &sampler{
+		src:        NewRedisKeySource(conn, opts),
+		opts:       opts,
+		aggregator: AggregatorFunc(AnyKey),
+		stats:      make(map[string]*Results),
+	}
+}
+
+// roundTripConn counts the round trips made over a redis.Conn: every call to
+// Do flushes the pipeline and waits for the replies
+type roundTripConn struct {
+	redis.Conn
+	roundTrips int
+}
+
+func (c *roundTripConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	c.roundTrips++
+	return c.Conn.Do(cmd, args...)
+}
+
+func TestFetchRoundTrips(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("s", TypeString, "abcd")
+	f.set("l", TypeList, "a", "b")
+	f.set("set", TypeSet, "a", "b")
+	f.set("z", TypeSortedSet, "a", "b")
+	f.set("h", TypeHash, "f", "value")
+
+	for _, caps := range []Capabilities{{}, {HRandField: true}} {
+		conn := &roundTripConn{Conn: f}
+		src := NewRedisKeySource(conn, Options{CollectEncodings: true})
+		src.caps = caps
+		for key, vt := range map[string]ValueType{"s": TypeString, "l": TypeList, "set": TypeSet, "z": TypeSortedSet, "h": TypeHash} {
+			conn.roundTrips = 0
+			smp, err := src.Fetch(key, vt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if smp.Encoding != "raw" {
+				t.Errorf("expected the encoding of %s to be sampled, actual: %q", key, smp.Encoding)
+			}
+			// the contents, length and encoding are all pipelined together
+			assertInt(t, 1, conn.roundTrips)
+		}
+	}
+}
+
+func TestSampleEncodings(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("s", TypeString, "abcd").encoding = "embstr"
+	f.set("h1", TypeHash, "f", "value").encoding = "listpack"
+	f.set("h2", TypeHash, "f", "value", "g", "other").encoding = "listpack"
+	f.set("h3", TypeHash, "field", "value").encoding = "hashtable"
+
+	s := newTestSampler(f, Options{CollectEncodings: true})
+	for _, err := range []error{
+		s.sampleKey("s", TypeString),
+		s.sampleKey("h1", TypeHash),
+		s.sampleKey("h2", TypeHash),
+		s.sampleKey("h3", TypeHash),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	encs := s.stats["any-key"].Encodings
+	assertInt(t, 1, int(encs[TypeString]["embstr"].Keys))
+	assertInt(t, 4, int(encs[TypeString]["embstr"].Bytes))
+	assertInt(t, 2, int(encs[TypeHash]["listpack"].Keys))
+	assertInt(t, 18, int(encs[TypeHash]["listpack"].Bytes))
+	assertInt(t, 1, int(encs[TypeHash]["hashtable"].Keys))
+	assertInt(t, 10, int(encs[TypeHash]["hashtable"].Bytes))
+
+	// without the option, no OBJECT ENCODING commands are issued
+	f.commands = nil
+	s = newTestSampler(f, Options{})
+	if err := s.sampleKey("s", TypeString); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range f.commands {
+		if strings.HasPrefix(c, "OBJECT") {
+			t.Errorf("unexpected command: %s", c)
+		}
+	}
+	if len(s.stats["any-key"].Encodings) != 0 {
+		t.Errorf("expected no encodings to be recorded")
+	}
+}
+
+func TestSampleTypeQuotas(t *testing.T) {
+
+	f := newFakeRedis()
+	for i := 0; i < 9; i++ {
+		f.set(fmt.Sprintf("s%d", i), TypeString, "value")
+	}
+	f.set("h", TypeHash, "field", "value")
+
+	// 5 keys in, only strings have been seen; sampling continues until three
+	// hashes have been observed, skipping further strings
+	stats, _, err := sample(context.Background(), f, Options{MinSamples: 5, TypeQuotas: map[ValueType]int{TypeHash: 3}}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := stats["any-key"]
+	assertInt(t, 5, int(sum(r.StringSizes)))
+	assertInt(t, 3, int(sum(r.HashSizes)))
+
+	// an unattainable quota gives up after QuotaAttemptsFactor * quota extra keys
+	f.next = 0
+	f.commands = nil
+	stats, summary, err := sample(context.Background(), f, Options{MinSamples: 5, TypeQuotas: map[ValueType]int{TypeSet: 2}}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 5, int(stats["any-key"].KeyCount))
+	assertInt(t, 2*QuotaAttemptsFactor, summary.Skipped)
+	randomKeys := 0
+	for _, c := range f.commands {
+		if c == "RANDOMKEY" {
+			randomKeys++
+		}
+	}
+	assertInt(t, 5+2*QuotaAttemptsFactor, randomKeys)
+}
+
+func TestAllocate(t *testing.T) {
+
+	alloc := allocate(map[ValueType]int{TypeString: 90, TypeHash: 8, TypeSet: 2}, 100, 5)
+	assertInt(t, 5, alloc[TypeSet])
+	assertInt(t, 8, alloc[TypeHash])
+	assertInt(t, 87, alloc[TypeString])
+
+	// flooring one type can push another below the floor
+	alloc = allocate(map[ValueType]int{TypeString: 89, TypeHash: 10, TypeSet: 1}, 100, 10)
+	assertInt(t, 10, alloc[TypeSet])
+	assertInt(t, 10, alloc[TypeHash])
+	assertInt(t, 80, alloc[TypeString])
+}
+
+func TestSampleAllocationProportional(t *testing.T) {
+
+	f := newFakeRedis()
+	for i := 0; i < 40; i++ {
+		if i%10 == 9 {
+			f.set(fmt.Sprintf("h%d", i), TypeHash, "field", "value")
+		} else {
+			f.set(fmt.Sprintf("s%d", i), TypeString, "value")
+		}
+	}
+
+	// the pilot of 10 keys finds 10% hashes, which would be 4 keys of 40, so
+	// hashes get the floor of 8 keys, and strings the remaining 32
+	opts := Options{MinSamples: 40, Allocation: AllocationProportional, MinPerType: 8}
+	stats, summary, err := sample(context.Background(), f, opts, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := stats["any-key"]
+	assertInt(t, 32, int(sum(r.StringSizes)))
+	assertInt(t, 8, int(sum(r.HashSizes)))
+	assertInt(t, 40, summary.Sampled)
+	assertInt(t, 40, summary.Skipped)
+
+	if err := validate(Options{MinSamples: 1, Allocation: AllocationProportional, TypeQuotas: map[ValueType]int{TypeHash: 1}}); err == nil {
+		t.Error("expected TypeQuotas and AllocationProportional to be rejected")
+	}
+}
+
+func TestProbeCapabilities(t *testing.T) {
+
+	c := probeCapabilities(parseInfo("# Server\r\nredis_version:6.0.9\r\nredis_mode:standalone\r\n"))
+	if c.Version != "6.0.9" || !c.ObjectEncoding || !c.MemoryUsage || !c.ObjectFreq || c.HRandField {
+		t.Errorf("unexpected capabilities for 6.0.9: %+v", c)
+	}
+
+	c = probeCapabilities(parseInfo("redis_version:2.2.0\r\n"))
+	if c.ObjectEncoding || c.MemoryUsage {
+		t.Errorf("unexpected capabilities for 2.2.0: %+v", c)
+	}
+	opts := Options{CollectEncodings: true}
+	if w := c.restrict(&opts); len(w) != 1 || opts.CollectEncodings {
+		t.Errorf("expected encodings to be disabled with a single warning, got: %v", w)
+	}
+
+	// an unknown version assumes every feature is available
+	c = probeCapabilities(parseInfo("# Keyspace\r\n"))
+	if c.Version != "" || !c.HRandField {
+		t.Errorf("unexpected capabilities for an unknown version: %+v", c)
+	}
+}
+
+func TestTagAndMergeSummaries(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("a", TypeString, "value")
+
+	totals := make(map[string]*Results)
+	var summary Summary
+	for _, tag := range []string{"shard-1", "shard-2", "shard-2"} {
+		f.next = 0
+		stats, s, err := sample(context.Background(), f, Options{MinSamples: 2, Tag: tag}, AggregatorFunc(AnyKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := mergeStats(totals, stats); err != nil {
+			t.Fatal(err)
+		}
+		summary.merge(s, tag)
+	}
+
+	r := totals["any-key"]
+	assertInt(t, 6, int(r.KeyCount))
+	assertInt(t, 2, int(r.Instances["shard-1"]))
+	assertInt(t, 4, int(r.Instances["shard-2"]))
+	assertInt(t, 3, int(summary.KeyCount))
+	assertInt(t, 6, summary.Sampled)
+
+	f.next = 0
+	stats, _, err := sample(context.Background(), f, Options{MinSamples: 1, Tag: "shard-3", TagGroups: true}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats["shard-3/any-key"]; !ok || len(stats) != 1 {
+		t.Errorf("expected a single, tagged group, got: %v", stats)
+	}
+}
+
+func TestSampleMaxRuntime(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("a", TypeString, "value")
+
+	stats, summary, err := sample(context.Background(), f, Options{MinSamples: 1000, MaxRuntime: time.Nanosecond}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !summary.RuntimeCapReached || len(summary.Warnings) != 1 {
+		t.Errorf("expected the runtime cap to be reached, with a warning: %+v", summary)
+	}
+	if summary.Sampled >= 1000 {
+		t.Errorf("expected sampling to stop early, but %d keys were sampled", summary.Sampled)
+	}
+	if r, ok := stats["any-key"]; ok {
+		assertInt(t, summary.Sampled, int(r.KeyCount))
+	}
+
+	// a negative MaxRuntime disables the cap
+	_, summary, err = sample(context.Background(), f, Options{MinSamples: 10, MaxRuntime: -1}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.RuntimeCapReached {
+		t.Errorf("expected the runtime cap to be disabled")
+	}
+	assertInt(t, 10, summary.Sampled)
+}
+
+func TestSampleCommandAccounting(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("s", TypeString, "0123456789")
+	f.set("l", TypeList, "abc", "def")
+
+	_, summary, err := sample(context.Background(), f, Options{MinSamples: 2}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// INFO, then RANDOMKEY + TYPE + GET for the string, and RANDOMKEY + TYPE +
+	// LLEN + LRANGE for the list
+	assertInt(t, 8, int(summary.Commands))
+	assertInt(t, len(f.commands), int(summary.Commands))
+
+	// "s" + "string" + "0123456789" + "l" + "list" + "2" + "abc" + "def", plus
+	// the INFO reply
+	info, _ := redis.String(f.exec("INFO", nil), nil)
+	assertInt(t, 1+6+10+1+4+1+3+3+len(info), int(summary.BytesReceived))
+}
+
+func TestSampleExpiredKeys(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("s", TypeString, "value")
+	f.set("h", TypeHash, "field", "value")
+
+	// RANDOMKEY hands back keys that have logically expired: two whose TYPE is
+	// still reported, but whose values are gone, and one that has been evicted
+	calls := 0
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		switch {
+		case cmd == "RANDOMKEY":
+			calls++
+			switch calls % 5 {
+			case 1:
+				return bulk("expired-string"), true
+			case 2:
+				return bulk("expired-hash"), true
+			case 3:
+				return bulk("evicted"), true
+			}
+		case cmd == "TYPE" && argString(args[0]) == "expired-string":
+			return string(TypeString), true
+		case cmd == "TYPE" && argString(args[0]) == "expired-hash":
+			return string(TypeHash), true
+		}
+		return nil, false
+	}
+
+	stats, summary, err := sample(context.Background(), f, Options{MinSamples: 5}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 3, summary.Expired)
+	assertInt(t, 2, summary.Sampled)
+	assertInt(t, 0, summary.Skipped)
+	assertInt(t, 2, int(stats["any-key"].KeyCount))
+}
+
+func TestSampleMaxElementsPerKey(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("h", TypeHash, "f1", "v1", "f2", "v2", "f3", "v3")
+
+	s := newTestSampler(f, Options{MaxElementsPerKey: 2})
+	if err := s.sampleKey("h", TypeHash); err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 1, int(s.stats["any-key"].HashSizes[3]))
+	for _, c := range f.commands {
+		if strings.HasPrefix(c, "HKEYS") || strings.HasPrefix(c, "HGETALL") {
+			t.Errorf("unexpected unbounded command: %s", c)
+		}
+	}
+	if c := f.commands[1]; c != "HSCAN h 0 COUNT 2" {
+		t.Errorf("expected a bounded HSCAN, actual: %s", c)
+	}
+
+	// a sparse hash table may yield empty pages before any fields are found
+	f.commands = nil
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		if cmd == "HSCAN" && argString(args[1]) == "0" {
+			return []interface{}{bulk("17"), []interface{}{}}, true
+		}
+		return nil, false
+	}
+	if err := s.sampleKey("h", TypeHash); err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 4, int(s.stats["any-key"].HashElementSizes[2]))
+	assertInt(t, 3, len(f.commands))
+}
+
+func TestSamplePipelineBatchSize(t *testing.T) {
+
+	f := newFakeRedis()
+	for i := 0; i < 5; i++ {
+		f.set(fmt.Sprintf("s%d", i), TypeString, "value")
+	}
+
+	stats, summary, err := sample(context.Background(), f, Options{MinSamples: 5, PipelineBatchSize: 3}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 5, summary.Sampled)
+	assertInt(t, 5, len(stats["any-key"].StringKeys))
+
+	// keys are selected three at a time, so one selection is wasted
+	expected := []string{"INFO", "RANDOMKEY", "RANDOMKEY", "RANDOMKEY", "TYPE s0", "TYPE s1", "TYPE s2", "GET s0"}
+	for i, c := range expected {
+		if f.commands[i] != c {
+			t.Errorf("expected command %d to be %q, actual: %q", i, c, f.commands[i])
+		}
+	}
+	randomKeys := 0
+	for _, c := range f.commands {
+		if c == "RANDOMKEY" {
+			randomKeys++
+		}
+	}
+	assertInt(t, 6, randomKeys)
+	assertInt(t, len(f.commands), int(summary.Commands))
+}
+
+func TestRunCustomDial(t *testing.T) {
+
+	var dialed string
+	opts := Options{
+		Host:       "redis.internal",
+		Port:       6380,
+		MinSamples: 1,
+		Dial: func(network, addr string) (net.Conn, error) {
+			dialed = network + "://" + addr
+			return nil, errors.New("proxy unavailable")
+		},
+	}
+
+	_, _, err := Run(opts, AggregatorFunc(AnyKey))
+	if err == nil || !strings.Contains(err.Error(), "proxy unavailable") {
+		t.Errorf("expected the custom dialer's error, actual: %v", err)
+	}
+	if dialed != "tcp://redis.internal:6380" {
+		t.Errorf("expected the custom dialer to be used, actual: %q", dialed)
+	}
+}
+
+func TestRunAuthenticationFailure(t *testing.T) {
+
+	opts := Options{
+		Host:       "redis.internal",
+		Port:       6379,
+		Password:   "wrong",
+		MinSamples: 1,
+		Dial: func(network, addr string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				buf := make([]byte, 1024)
+				if _, err := server.Read(buf); err == nil {
+					server.Write([]byte("-WRONGPASS invalid username-password pair or user is disabled.\r\n"))
+				}
+			}()
+			return client, nil
+		},
+	}
+
+	_, _, err := Run(opts, AggregatorFunc(AnyKey))
+	if !errors.Is(err, ErrAuthentication) || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("expected an authentication error, actual: %v", err)
+	}
+
+	// connection failures are reported differently
+	opts.Dial = func(network, addr string) (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+	if _, _, err := Run(opts, AggregatorFunc(AnyKey)); err == nil || errors.Is(err, ErrAuthentication) {
+		t.Errorf("expected a connection error, actual: %v", err)
+	}
+}
+
+func TestRunTLS(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("a", TypeString, "value")
+
+	// borrow a self-signed certificate (valid for 127.0.0.1) from httptest
+	https := httptest.NewUnstartedServer(nil)
+	https.StartTLS()
+	certs, roots := https.TLS.Certificates, x509.NewCertPool()
+	roots.AddCert(https.Certificate())
+	https.Close()
+
+	listen := func(useTLS bool) int {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		if useTLS {
+			l = tls.NewListener(l, &tls.Config{Certificates: certs})
+		}
+		go serveFake(l, f)
+		return l.Addr().(*net.TCPAddr).Port
+	}
+	plain, encrypted := listen(false), listen(true)
+
+	for _, opts := range []Options{
+		{Port: plain},
+		{Port: encrypted, TLS: true, TLSSkipVerify: true},
+		{Port: encrypted, TLS: true, TLSConfig: &tls.Config{RootCAs: roots}},
+	} {
+		opts.Host, opts.MinSamples = "127.0.0.1", 1
+		_, summary, err := Run(opts, AggregatorFunc(AnyKey))
+		if err != nil {
+			t.Fatalf("%+v: %s", opts, err)
+		}
+		assertInt(t, 1, summary.Sampled)
+	}
+
+	// the server's certificate is verified by default
+	if _, _, err := Run(Options{Host: "127.0.0.1", Port: encrypted, MinSamples: 1, TLS: true}, AggregatorFunc(AnyKey)); err == nil {
+		t.Error("expected the self-signed certificate to be rejected")
+	}
+
+	if err := validate(Options{TLSSkipVerify: true}); err == nil {
+		t.Error("expected TLSSkipVerify to require TLS")
+	}
+}
+
+func TestRunSelectDB(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("a", TypeString, "value")
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		if cmd != "INFO" {
+			return nil, false
+		}
+		return bulk("# Keyspace\r\ndb0:keys=5,expires=0,avg_ttl=0\r\ndb3:keys=1,expires=0,avg_ttl=0\r\n"), true
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveFake(l, f)
+	opts := Options{Host: "127.0.0.1", Port: l.Addr().(*net.TCPAddr).Port, MinSamples: 1}
+
+	// the default database isn't selected explicitly
+	_, summary, err := Run(opts, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 5, int(summary.KeyCount))
+	for _, c := range f.commands {
+		if strings.HasPrefix(c, "SELECT") {
+			t.Errorf("unexpected command: %s", c)
+		}
+	}
+
+	// other databases are selected before sampling, and their key count used
+	f.commands = nil
+	opts.DB = 3
+	var dbs []int
+	agg := ContextAggregatorFunc(func(ctx SampleContext) []string {
+		dbs = append(dbs, ctx.DB)
+		return []string{"any-key"}
+	})
+	if _, summary, err = Run(opts, agg); err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 1, int(summary.KeyCount))
+	if len(f.commands) == 0 || f.commands[0] != "SELECT 3" {
+		t.Errorf("expected the database to be selected first, actual: %v", f.commands)
+	}
+	if len(dbs) != 1 || dbs[0] != 3 {
+		t.Errorf("expected the samples to be attributed to the database, actual: %v", dbs)
+	}
+
+	// an out of range database is reported, rather than sampling another
+	opts.DB = 16
+	if _, _, err := Run(opts, AggregatorFunc(AnyKey)); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("expected an out of range error, actual: %v", err)
+	}
+}
+
+func TestKeyCountDB(t *testing.T) {
+
+	// an empty database isn't listed, so another's count mustn't be used
+	info := "# Keyspace\r\ndb3:keys=7,expires=0,avg_ttl=0\r\n"
+	if count, err := keyCount(info, 0); err != ErrNoKeys {
+		t.Errorf("expected ErrNoKeys for db0, actual: %d, %v", count, err)
+	}
+	count, err := keyCount(info, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 7, int(count))
+}
+
+func TestSampleBigKeys(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("small", TypeString, "tiny")
+	f.set("big", TypeString, strings.Repeat("x", 2000))
+	f.set("list", TypeList, "0123456789", "0123456789")
+	f.set("hash", TypeHash, "field", strings.Repeat("y", 500))
+
+	opts := Options{
+		MinSamples:       8,
+		BigKeyThreshold:  1000,
+		BigKeyThresholds: map[ValueType]int{TypeList: 10},
+	}
+	stats, _, err := sample(context.Background(), f, opts, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each key is sampled twice, but recorded once
+	expected := []BigKey{
+		{Key: "big", Type: TypeString, Length: 2000, Size: 2000},
+		{Key: "list", Type: TypeList, Length: 2, Size: 20},
+	}
+	if bk := stats["any-key"].BigKeys; !reflect.DeepEqual(expected, bk) {
+		t.Errorf("expected big keys: %+v, actual: %+v", expected, bk)
+	}
+}
+
+func TestSampleRandomKeyDenied(t *testing.T) {
+
+	for _, batch := range []int{0, 3} {
+		f := newFakeRedis()
+		for i := 0; i < 150; i++ {
+			f.set("key"+strconv.Itoa(i), TypeString, "value")
+		}
+		f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+			if cmd == "RANDOMKEY" {
+				return redis.Error("NOPERM this user has no permissions to run the 'randomkey' command or its subcommand"), true
+			}
+			return nil, false
+		}
+
+		stats, summary, err := sample(context.Background(), f, Options{MinSamples: 120, PipelineBatchSize: batch}, AggregatorFunc(AnyKey))
+		if err != nil {
+			t.Fatalf("batch %d: %s", batch, err)
+		}
+		assertInt(t, 120, int(stats["any-key"].KeyCount))
+		if len(summary.Warnings) != 1 || !strings.Contains(summary.Warnings[0], "SCAN") {
+			t.Errorf("batch %d: expected a warning about SCAN, actual: %v", batch, summary.Warnings)
+		}
+
+		var scans int
+		for _, c := range f.commands {
+			if strings.HasPrefix(c, "SCAN ") {
+				scans++
+			}
+		}
+		assertInt(t, 2, scans)
+	}
+}
+
+func TestSampleRandomKeyAndScanDenied(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("key", TypeString, "value")
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		if cmd == "RANDOMKEY" || cmd == "SCAN" {
+			return redis.Error("NOPERM this user has no permissions to run the '" + strings.ToLower(cmd) + "' command or its subcommand"), true
+		}
+		return nil, false
+	}
+
+	_, _, err := sample(context.Background(), f, Options{MinSamples: 1}, AggregatorFunc(AnyKey))
+	if err == nil || !strings.Contains(err.Error(), "+randomkey or +scan") {
+		t.Errorf("expected an error naming the missing permissions, actual: %v", err)
+	}
+}
+
+func TestSampleSizeOnlyTypes(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("s", TypeString, strings.Repeat("x", 2000))
+	f.set("h", TypeHash, "field", "value")
+	f.set("l", TypeList, "a", "b", "c")
+
+	s := newTestSampler(f, Options{SizeOnlyTypes: map[ValueType]bool{TypeString: true, TypeList: true}, BigKeyThreshold: 1000})
+	for _, k := range []struct {
+		key string
+		vt  ValueType
+	}{{"s", TypeString}, {"h", TypeHash}, {"l", TypeList}} {
+		if err := s.sampleKey(k.key, k.vt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range f.commands {
+		if strings.HasPrefix(c, "GET ") || strings.HasPrefix(c, "LRANGE ") {
+			t.Errorf("expected no contents to be fetched for size-only types, actual: %q", c)
+		}
+	}
+
+	r := s.stats["any-key"]
+	assertInt(t, 3, int(r.KeyCount))
+	assertInt(t, 1, int(r.StringSizes[2000]))
+	assertInt(t, 0, len(r.StringValues))
+	assertInt(t, 1, int(r.ListSizes[3]))
+	assertInt(t, 0, len(r.ListElementSizes))
+	assertInt(t, 1, len(r.ListKeys))
+	assertInt(t, 1, int(r.HashValueSizes[5]))
+	if len(r.BigKeys) != 1 || r.BigKeys[0].Key != "s" {
+		t.Errorf("expected the size-only string to be a big key, actual: %v", r.BigKeys)
+	}
+
+	if _, err := s.src.Fetch("gone", TypeList); err != ErrKeyMissing {
+		t.Errorf("expected ErrKeyMissing, actual: %v", err)
+	}
+}
+
+func TestCommands(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("s", TypeString, "value")
+	f.set("l", TypeList, "a", "b")
+	f.set("set", TypeSet, "a")
+	f.set("z", TypeSortedSet, "a")
+	f.set("h", TypeHash, "field", "value")
+
+	for _, opts := range []Options{
+		{MinSamples: 20},
+		{MinSamples: 20, CollectEncodings: true, ListEnds: true, CollectScripts: true},
+		{MinSamples: 20, ShortTTL: time.Minute, MaxIdleTime: time.Hour},
+		{MinSamples: 20, SizeOnlyTypes: map[ValueType]bool{TypeString: true, TypeHash: true}},
+		{MinSamples: 20, ElementStrategy: StrategyReservoir},
+		{MinSamples: 20, MinLength: 1},
+		{Census: true},
+	} {
+		allowed := make(map[string]bool)
+		for _, c := range Commands(opts) {
+			allowed[c] = true
+		}
+
+		f.commands = nil
+		if _, _, err := sample(context.Background(), f, opts, AggregatorFunc(AnyKey)); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range f.commands {
+			fields := strings.Fields(c)
+			name := fields[0]
+			if name == "OBJECT" {
+				name += "|" + fields[1]
+			}
+			if !allowed[name] {
+				t.Errorf("expected %s to be listed in Commands(%+v): %v", name, opts, Commands(opts))
+			}
+		}
+	}
+
+	// string lengths are checked with STRLEN before the value is read
+	if rules := ACLRules(Options{MinLength: 1}); !strings.Contains(rules, "+strlen") {
+		t.Errorf("expected STRLEN to be permitted when filtering lengths, actual: %s", rules)
+	}
+
+	if rules := ACLRules(Options{Census: true, SizeOnlyTypes: map[ValueType]bool{TypeString: true}}); !strings.HasPrefix(rules, "+hlen +hrandfield +hscan +info") ||
+		strings.Contains(rules, "+get") || strings.Contains(rules, "+randomkey") {
+		t.Errorf("unexpected ACL rules: %s", rules)
+	}
+}
+
+func TestSampleBinaryString(t *testing.T) {
+
+	// invalid UTF-8, which must be measured in bytes and kept intact
+	value := "\x00\xff\xfe\x80é"
+
+	f := newFakeRedis()
+	f.set("blob", TypeString, value)
+
+	s := newTestSampler(f, Options{})
+	smp, err := s.src.Fetch("blob", TypeString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 6, smp.Length)
+	if smp.Value != value {
+		t.Errorf("expected the value to be kept byte-for-byte, actual: %q", smp.Value)
+	}
+
+	s.observe(smp)
+	r := s.stats["any-key"]
+	assertInt(t, 1, int(r.StringSizes[6]))
+	if !r.StringValues[value] {
+		t.Errorf("expected the binary value to be recorded, actual: %v", r.StringValues)
+	}
+}
+
+func TestSampleMaxIdleTime(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("recent", TypeString, "value").idle = time.Minute
+	f.set("stale", TypeString, "value").idle = 2 * time.Hour
+	f.set("fresh", TypeList, "a", "b")
+
+	stats, summary, err := sample(context.Background(), f, Options{Census: true, MaxIdleTime: time.Hour}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := stats["any-key"]
+	if r.KeyCount != 2 || r.StringKeys["stale"] {
+		t.Errorf("expected only the keys accessed within the hour, actual: %v %v", r.StringKeys, r.ListKeys)
+	}
+	assertInt(t, 1, summary.Skipped)
+	assertInt(t, 2, summary.Sampled)
+
+	// the idle time must be read before the key is, which would reset it
+	for i, c := range f.commands {
+		if strings.HasPrefix(c, "GET") && !strings.HasPrefix(f.commands[i-1], "OBJECT IDLETIME") {
+			t.Errorf("expected OBJECT IDLETIME to precede %s, actual: %s", c, f.commands[i-1])
+		}
+	}
+}
+
+func TestSampleLengthRange(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("short", TypeString, "ab")
+	f.set("medium", TypeString, "abcde")
+	f.set("small", TypeSet, "a", "b")
+	f.set("large", TypeSet, "a", "b", "c", "d", "e", "f")
+
+	stats, summary, err := sample(context.Background(), f, Options{Census: true, MinLength: 3, MaxLength: 5}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := stats["any-key"]
+	if r.KeyCount != 1 || !r.StringKeys["medium"] {
+		t.Errorf("expected only the keys within the length range, actual: %v %v", r.StringKeys, r.SetKeys)
+	}
+	assertInt(t, 3, summary.Skipped)
+	assertInt(t, 1, summary.Sampled)
+
+	// the contents of keys outside the range aren't read
+	for _, c := range f.commands {
+		if c == "GET short" || strings.HasPrefix(c, "SRANDMEMBER") {
+			t.Errorf("unexpected command: %s", c)
+		}
+	}
+
+	if err := validate(Options{Census: true, MinLength: 3, MaxLength: 2}); err == nil {
+		t.Error("expected an error for an empty length range")
+	}
+}
+
+func TestSampleKeyFilter(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("user:1", TypeString, "a")
+	f.set("internal:lock", TypeString, "b")
+	f.set("internal:queue", TypeList, "c")
+	f.set("tags", TypeSet, "d")
+
+	filter := func(key string, vt ValueType) bool {
+		return !strings.HasPrefix(key, "internal:") && vt != TypeSet
+	}
+	stats, summary, err := sample(context.Background(), f, Options{Census: true, KeyFilter: filter}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := stats["any-key"]
+	if r.KeyCount != 1 || !r.StringKeys["user:1"] {
+		t.Errorf("expected only the unfiltered key, actual: %v", r.StringKeys)
+	}
+	assertInt(t, 3, summary.Filtered)
+	assertInt(t, 0, summary.Skipped)
+	assertInt(t, 1, summary.Sampled)
+
+	// filtered keys aren't read
+	for _, c := range f.commands {
+		if strings.HasPrefix(c, "GET internal:") || strings.HasPrefix(c, "LRANGE") || strings.HasPrefix(c, "SRANDMEMBER") {
+			t.Errorf("unexpected command: %s", c)
+		}
+	}
+}
+
+func TestSampleMinSize(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("flag:1", TypeString, "1")
+	f.set("flag:2", TypeString, "0")
+	f.set("doc", TypeString, strings.Repeat("x", 100))
+	f.set("tags", TypeSet, "a", "b")
+	f.set("big", TypeHash, "field", strings.Repeat("v", 100))
+
+	stats, summary, err := sample(context.Background(), f, Options{Census: true, MinSize: 10}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 5, summary.Sampled)
+	r := stats["any-key"]
+	assertInt(t, 2, int(r.KeyCount))
+	assertInt(t, 3, int(r.TrivialKeys))
+	if len(r.StringSizes) != 1 || r.StringSizes[100] != 1 || len(r.SetSizes) != 0 {
+		t.Errorf("expected the trivial keys to be excluded, actual: %v %v", r.StringSizes, r.SetSizes)
+	}
+
+	var buf bytes.Buffer
+	if err := RenderText(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if s := "# of trivial keys excluded (smaller than 10 bytes): 3"; !strings.Contains(buf.String(), s) {
+		t.Errorf("expected text output to contain: %s", s)
+	}
+
+	// a group holding only trivial keys isn't pruned away
+	stats, _, err = sample(context.Background(), f, Options{Census: true, MinSize: 10}, AggregatorFunc(func(key string, vt ValueType) []string {
+		return []string{strings.SplitN(key, ":", 2)[0]}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := stats["flag"]; r == nil || r.TrivialKeys != 2 {
+		t.Errorf("expected the trivial flag keys to be counted, actual: %+v", r)
+	}
+}
+
+func TestSampleLatencies(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("a", TypeString, "value")
+	f.set("b", TypeHash, "field", "value")
+
+	stats, _, err := sample(context.Background(), f, Options{Census: true, CollectLatencies: true}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := stats["any-key"]
+	var n int64
+	for _, c := range r.FetchLatencies {
+		n += c
+	}
+	assertInt(t, 2, int(n))
+
+	var buf bytes.Buffer
+	if err := RenderText(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "--- Fetch Latencies (µs) ---") {
+		t.Errorf("expected the latencies to be rendered:\n%s", buf.String())
+	}
+	buf.Reset()
+	if err := RenderHTML(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Fetch Latencies") {
+		t.Error("expected the latencies to be rendered in HTML")
+	}
+}
+
+func TestSampleOnKey(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("a", TypeString, "value")
+	f.set("b", TypeSet, "x", "yy")
+
+	seen := make(map[string]int)
+	opts := Options{
+		Census: true,
+		OnKey: func(key string, vt ValueType, size int) {
+			seen[key+":"+string(vt)] = size
+		},
+	}
+	stats, _, err := sample(context.Background(), f, opts, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 2, len(seen))
+	assertInt(t, 5, seen["a:string"])
+	assertInt(t, 3, seen["b:set"])
+	assertInt(t, 2, int(stats["any-key"].KeyCount))
+}
+
+func TestSampleResultsFactory(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("a", TypeString, "value")
+	f.set("b", TypeString, "other")
+
+	var created int
+	opts := Options{
+		Census:    true,
+		TopValues: 3,
+		ResultsFactory: func() *Results {
+			created++
+			r := NewResults()
+			r.Buckets = []int{10, 100}
+			r.TopK = 1
+			r.Provenance = "factory"
+			return r
+		},
+	}
+	stats, _, err := sample(context.Background(), f, opts, AggregatorFunc(func(key string, vt ValueType) []string {
+		return []string{key}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 2, created)
+
+	r := stats["a"]
+	if len(r.Buckets) != 2 || r.Provenance != "factory" {
+		t.Errorf("expected the factory's configuration, actual: %v %q", r.Buckets, r.Provenance)
+	}
+	// options take precedence where set
+	assertInt(t, 3, r.TopK)
+
+	// a zero-value Results has its maps allocated
+	opts = Options{Census: true, Tag: "primary", ResultsFactory: func() *Results { return &Results{} }}
+	stats, _, err = sample(context.Background(), f, opts, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 2, int(stats["any-key"].Instances["primary"]))
+	assertInt(t, 2, int(stats["any-key"].KeyCount))
+
+	// nil and non-empty Results are rejected before sampling
+	if err := validate(Options{ResultsFactory: func() *Results { return nil }}); err == nil {
+		t.Error("expected a factory returning nil to be rejected")
+	}
+	used := NewResults()
+	used.observeString("key", "value")
+	if err := validate(Options{ResultsFactory: func() *Results { return used }}); err == nil {
+		t.Error("expected a factory returning a non-empty Results to be rejected")
+	}
+}
+
+func TestSampleShortTTL(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("big-short", TypeString, strings.Repeat("x", 1000)).ttl = 10 * time.Second
+	f.set("small-short", TypeString, "x").ttl = 30 * time.Second
+	f.set("big-long", TypeString, strings.Repeat("x", 1000)).ttl = time.Hour
+	f.set("big-forever", TypeString, strings.Repeat("x", 1000))
+
+	stats, _, err := sample(context.Background(), f, Options{Census: true, ShortTTL: time.Minute}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := stats["any-key"]
+	if len(r.ShortLivedSizes) != 2 || r.ShortLivedSizes[1000] != 1 || r.ShortLivedSizes[1] != 1 {
+		t.Errorf("expected only the keys expiring within a minute, actual: %v", r.ShortLivedSizes)
+	}
+	if r.ShortTTL != time.Minute {
+		t.Errorf("expected the threshold to be recorded, actual: %s", r.ShortTTL)
+	}
+
+	var buf bytes.Buffer
+	if err := RenderText(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Short-Lived Keys (expiring within 1m0s)") {
+		t.Errorf("expected the short-lived keys to be rendered:\n%s", buf.String())
+	}
+
+	// thresholds must match for results to be merged
+	other := NewResults()
+	other.ShortTTL = time.Hour
+	other.observeString("key", "value")
+	if err := r.Merge(other); !errors.Is(err, ErrIncompatibleResults) {
+		t.Errorf("expected ErrIncompatibleResults, actual: %v", err)
+	}
+}
+
+func TestSampleListEnds(t *testing.T) {
+
+	// a queue whose newer (head) elements have a new, longer format
+	f := newFakeRedis()
+	var elems []string
+	for i := 0; i < 20; i++ {
+		if i < 10 {
+			elems = append(elems, "v2:payload")
+		} else {
+			elems = append(elems, "v1")
+		}
+	}
+	f.set("queue", TypeList, elems...)
+
+	s := newTestSampler(f, Options{ListEnds: true, ElementsPerKey: 3})
+	if err := s.sampleKey("queue", TypeList); err != nil {
+		t.Fatal(err)
+	}
+	if c := f.commands[len(f.commands)-1]; c != "LRANGE queue -3 -1" {
+		t.Errorf("expected the tail of the list to be sampled, actual: %q", c)
+	}
+
+	r := s.stats["any-key"]
+	assertInt(t, 1, int(r.KeyCount))
+	assertInt(t, 3, int(r.ListElementSizes[10]))
+	assertInt(t, 3, int(r.ListHeadElementSizes[10]))
+	assertInt(t, 3, int(r.ListTailElementSizes[2]))
+
+	var out bytes.Buffer
+	if err := RenderText(r, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Tail Element Sizes (min: 2 max: 2") {
+		t.Errorf("expected the report to include the tail element sizes, actual: %s", out.String())
+	}
+
+	// by default, lists are only sampled from the head
+	s = newTestSampler(f, Options{ElementsPerKey: 3})
+	if err := s.sampleKey("queue", TypeList); err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 0, len(s.stats["any-key"].ListTailElementSizes))
+}
+
+func TestSampleKeyNormalizer(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("u:1", TypeString, "a")
+	f.set("user:2", TypeString, "b")
+
+	normalize := func(key string) string {
+		if strings.HasPrefix(key, "u:") {
+			return "user:" + key[len("u:"):]
+		}
+		return key
+	}
+	byPrefix := AggregatorFunc(func(key string, vt ValueType) []string {
+		return []string{key[:strings.Index(key, ":")]}
+	})
+
+	s := newTestSampler(f, Options{KeyNormalizer: normalize})
+	s.aggregator = byPrefix
+	for _, key := range []string{"u:1", "user:2"} {
+		if err := s.sampleKey(key, TypeString); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(s.stats) != 1 || s.stats["user"] == nil {
+		t.Fatalf("expected a single normalized group, actual: %v", s.stats)
+	}
+	// the actual key names are still reported
+	r := s.stats["user"]
+	assertInt(t, 2, int(r.KeyCount))
+	if !r.StringKeys["u:1"] || !r.StringKeys["user:2"] {
+		t.Errorf("expected the actual key names as examples, actual: %v", r.StringKeys)
+	}
+}
+
+func TestSampleValueTransform(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("s", TypeString, base64.StdEncoding.EncodeToString([]byte("decoded")))
+	f.set("h", TypeHash, "field", base64.StdEncoding.EncodeToString([]byte("value")))
+	f.set("l", TypeList, "not base64!")
+
+	decode := func(b []byte) []byte {
+		if d, err := base64.StdEncoding.DecodeString(string(b)); err == nil {
+			return d
+		}
+		return b
+	}
+	s := newTestSampler(f, Options{ValueTransform: decode})
+	for _, k := range []struct {
+		key string
+		vt  ValueType
+	}{{"s", TypeString}, {"h", TypeHash}, {"l", TypeList}} {
+		if err := s.sampleKey(k.key, k.vt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := s.stats["any-key"]
+	assertInt(t, 1, int(r.StringSizes[7]))
+	if !r.StringValues["decoded"] {
+		t.Errorf("expected the decoded string value to be recorded, actual: %v", r.StringValues)
+	}
+	// hash fields are left alone
+	assertInt(t, 1, int(r.HashElementSizes[5]))
+	assertInt(t, 1, int(r.HashValueSizes[5]))
+	assertInt(t, 1, int(r.ListElementSizes[11]))
+}
+
+func TestSampleCompression(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("packed:s", TypeString, "z:abcdefgh")
+	f.set("packed:h", TypeHash, "field", "z:ijklmnop")
+	f.set("plain:s", TypeString, "abcdefghij")
+
+	// a toy compression scheme, which stores the value repeated 4 times
+	unpack := func(b []byte) []byte {
+		if s := string(b); strings.HasPrefix(s, "z:") {
+			return []byte(strings.Repeat(s[2:], 4))
+		}
+		return b
+	}
+	opts := Options{Census: true, ValueTransform: unpack, CollectCompression: true}
+	stats, _, err := sample(context.Background(), f, opts, PrefixAggregator(":"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packed := stats["packed"]
+	assertInt(t, 2, int(packed.StoredValueSizes[10]))
+	assertInt(t, 2, int(packed.LogicalValueSizes[32]))
+	assertFloat(t, 3.2, packed.CompressionRatio(), epsilon)
+	assertFloat(t, 1, stats["plain"].CompressionRatio(), epsilon)
+
+	var buf bytes.Buffer
+	if err := RenderText(packed, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Compression (3.20x logical / stored value size)") {
+		t.Errorf("expected the compression ratio to be reported, actual: %s", buf.String())
+	}
+
+	// compression isn't measured unless requested
+	opts.CollectCompression = false
+	stats, _, err = sample(context.Background(), f, opts, PrefixAggregator(":"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := stats["packed"]; len(r.StoredValueSizes) > 0 || r.CompressionRatio() != 0 {
+		t.Errorf("unexpected compression statistics: %v", r.StoredValueSizes)
+	}
+}
+
+func TestSampleLargeHash(t *testing.T) {
+
+	f := newFakeRedis()
+	fields := make([]string, 0, 2*100000)
+	for i := 0; i < 100000; i++ {
+		fields = append(fields, "field"+strconv.Itoa(i), "value")
+	}
+	f.set("huge", TypeHash, fields...)
+
+	for _, opts := range []Options{
+		{ElementsPerKey: 5},
+		{ElementsPerKey: 5, ElementStrategy: StrategyReservoir},
+	} {
+		for _, hrandfield := range []bool{false, true} {
+			f.commands = nil
+			s := newTestSampler(f, opts)
+			s.src.(*RedisKeySource).caps.HRandField = hrandfield
+			smp, err := s.src.Fetch("huge", TypeHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertInt(t, 100000, smp.Length)
+			if len(smp.Elements) != 10 {
+				t.Errorf("expected 5 fields and their values, actual: %d elements", len(smp.Elements))
+			}
+
+			// the full field set is never requested, only a bounded number of
+			// fields, in a single round trip
+			assertInt(t, 2, len(f.commands))
+			for _, c := range f.commands {
+				fields := strings.Fields(c)
+				switch fields[0] {
+				case "HKEYS", "HVALS", "HGETALL":
+					t.Errorf("unexpected unbounded command: %s", c)
+				case "HSCAN":
+					if n, _ := strconv.Atoi(fields[len(fields)-1]); n > DefaultMaxElementsPerKey {
+						t.Errorf("expected a bounded HSCAN, actual: %s", c)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSampleMultipleElements(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("set", TypeSet, "a", "bb", "cc", "dddd", "eeeee")
+	f.set("zset", TypeSortedSet, "a", "bb", "ccc")
+	f.set("hash", TypeHash, "f1", "v", "f2", "vv", "field3", "vvv")
+
+	for _, hrandfield := range []bool{false, true} {
+		f.commands = nil
+		s := newTestSampler(f, Options{ElementsPerKey: 4})
+		s.src.(*RedisKeySource).caps.HRandField = hrandfield
+		for _, k := range []struct {
+			key string
+			vt  ValueType
+		}{{"set", TypeSet}, {"zset", TypeSortedSet}, {"hash", TypeHash}} {
+			if err := s.sampleKey(k.key, k.vt); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// a single command fetches the elements of each key
+		assertInt(t, 6, len(f.commands))
+
+		r := s.stats["any-key"]
+		assertInt(t, 3, int(r.KeyCount))
+		for size, count := range map[int]int64{1: 1, 2: 2, 4: 1} {
+			assertInt(t, int(count), int(r.SetElementSizes[size]))
+		}
+		assertInt(t, 4, len(r.SetElements))
+		for size, count := range map[int]int64{1: 1, 2: 1, 3: 1} {
+			assertInt(t, int(count), int(r.SortedSetElementSizes[size]))
+		}
+		for size, count := range map[int]int64{1: 1, 2: 1, 3: 1} {
+			assertInt(t, int(count), int(r.HashValueSizes[size]))
+		}
+		assertInt(t, 2, int(r.HashElementSizes[2]))
+		assertInt(t, 1, int(r.HashElementSizes[6]))
+	}
+}
+
+// cancelingSource is a KeySource that cancels a context once `n` keys have
+// been selected
+type cancelingSource struct {
+	sliceSource
+	n      int
+	cancel context.CancelFunc
+}
+
+func (s *cancelingSource) Next() (string, ValueType, error) {
+	if s.next == s.n {
+		s.cancel()
+	}
+	return s.sliceSource.Next()
+}
+
+func TestRunCanceled(t *testing.T) {
+
+	samples := make([]Sample, 10)
+	for i := range samples {
+		samples[i] = Sample{Key: strconv.Itoa(i), Type: TypeString, Length: 1, Value: "v"}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	src := &cancelingSource{sliceSource: sliceSource{samples: samples}, n: 4, cancel: cancel}
+
+	summary := Summary{KeyCount: 10}
+	stats, err := run(ctx, src, Options{MinSamples: 10}, AggregatorFunc(AnyKey), &summary)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, actual: %v", err)
+	}
+	r := stats["any-key"]
+	assertInt(t, 5, int(r.KeyCount))
+
+	// the partial results are flagged as such, but otherwise complete
+	if !summary.Interrupted || summary.Exact {
+		t.Errorf("expected an interrupted, inexact run: %+v", summary)
+	}
+	if len(r.Warnings) == 0 || !strings.Contains(r.Warnings[0], "interrupted after sampling 5 keys") {
+		t.Errorf("expected the results to be flagged as partial, actual: %v", r.Warnings)
+	}
+	if r.MinGroupSamples != DefaultMinGroupSamples {
+		t.Errorf("expected the results to be finalized, actual: %+v", r)
+	}
+}
+
+func TestRunContextCanceled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := newFakeRedis()
+	for i := 0; i < 100; i++ {
+		f.set("s"+strconv.Itoa(i), TypeString, "value")
+	}
+	var gets int
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		if cmd == "GET" {
+			if gets++; gets == 5 {
+				cancel()
+			}
+		}
+		return nil, false
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		if c, err := l.Accept(); err == nil {
+			serveFakeConn(c, f)
+		}
+	}()
+
+	opts := Options{Host: "127.0.0.1", Port: l.Addr().(*net.TCPAddr).Port, MinSamples: 100}
+	stats, summary, err := RunContext(ctx, opts, AggregatorFunc(AnyKey))
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, actual: %v", err)
+	}
+	if r := stats["any-key"]; r == nil || r.KeyCount == 0 || r.KeyCount >= 100 || !summary.Interrupted {
+		t.Errorf("expected partial results, actual: %+v", summary)
+	}
+
+	// the connection is closed, rather than leaked
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Error("expected the connection to be closed")
+	}
+}
+
+func TestRunReadTimeout(t *testing.T) {
+
+	f := newFakeRedis()
+	for i := 0; i < 100; i++ {
+		f.set("s"+strconv.Itoa(i), TypeString, "value")
+	}
+	var gets int
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		if cmd == "GET" {
+			if gets++; gets == 5 {
+				// a hung instance
+				time.Sleep(time.Second)
+			}
+		}
+		return nil, false
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveFake(l, f)
+
+	opts := Options{Host: "127.0.0.1", Port: l.Addr().(*net.TCPAddr).Port, MinSamples: 100, ReadTimeout: 50 * time.Millisecond, Tag: "shard-1", TagGroups: true}
+	stats, summary, err := Run(opts, AggregatorFunc(AnyKey))
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected an error wrapping ErrTimeout, actual: %v", err)
+	}
+	r := stats["shard-1/any-key"]
+	if r == nil || r.KeyCount == 0 || r.KeyCount >= 100 {
+		t.Fatalf("expected partial, tagged results, actual: %v", stats)
+	}
+	assertInt(t, int(r.KeyCount), int(r.Instances["shard-1"]))
+	if !summary.Partial || !strings.Contains(strings.Join(summary.Warnings, "\n"), "results are partial") {
+		t.Errorf("expected the results to be flagged as partial, actual: %v", summary.Warnings)
+	}
+	if !strings.Contains(strings.Join(r.Warnings, "\n"), "results are partial") {
+		t.Errorf("expected the group to be warned of partial results, actual: %v", r.Warnings)
+	}
+
+	if _, _, err := Run(Options{Host: "127.0.0.1", MinSamples: 1, ReadTimeout: -time.Second}, AggregatorFunc(AnyKey)); err == nil {
+		t.Error("expected a negative ReadTimeout to be rejected")
+	}
+}
+
+func TestRunUnixSocket(t *testing.T) {
+
+	f := newFakeRedis()
+	for i := 0; i < 10; i++ {
+		f.set("s"+strconv.Itoa(i), TypeString, "value")
+	}
+
+	socket := filepath.Join(t.TempDir(), "redis.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets are not supported: %s", err)
+	}
+	defer l.Close()
+	go serveFake(l, f)
+
+	// the socket is preferred over the (unreachable) host and port
+	opts := Options{Socket: socket, Host: "192.0.2.1", Port: 1, MinSamples: 10, ConnectTimeout: time.Second}
+	stats, summary, err := Run(opts, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 10, summary.Sampled)
+	assertInt(t, 10, int(stats["any-key"].KeyCount))
+}
+
+// closeTrackingConn records whether the wrapped redis.Conn was closed
+type closeTrackingConn struct {
+	redis.Conn
+	closed bool
+}
+
+func (c *closeTrackingConn) Close() error {
+	c.closed = true
+	return c.Conn.Close()
+}
+
+func TestRunConn(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("a", TypeString, "value")
+	f.set("b", TypeList, "x", "y")
+	conn := &closeTrackingConn{Conn: f}
+
+	// the connection is used as is, without dialing or authenticating
+	var summaryOut bytes.Buffer
+	opts := Options{Census: true, Host: "unreachable.invalid", Password: "secret", SummaryWriter: &summaryOut}
+	stats, summary, err := RunConn(context.Background(), conn, opts, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 2, summary.Sampled)
+	assertInt(t, 2, int(stats["any-key"].KeyCount))
+	for _, c := range f.commands {
+		if strings.HasPrefix(c, "AUTH") {
+			t.Errorf("unexpected command: %s", c)
+		}
+	}
+	if conn.closed {
+		t.Error("expected the caller's connection to be left open")
+	}
+	if !strings.Contains(summaryOut.String(), `"sampled":2`) {
+		t.Errorf("expected the summary to be written, actual: %s", summaryOut.String())
+	}
+
+	if _, _, err := RunConn(context.Background(), conn, Options{SampleRate: 2}, AggregatorFunc(AnyKey)); err == nil {
+		t.Error("expected invalid options to be rejected")
+	}
+}
+
+func TestRunWithConn(t *testing.T) {
+
+	f := newFakeRedis()
+	for i := 0; i < 10; i++ {
+		f.set("s"+strconv.Itoa(i), TypeString, "value")
+	}
+	conn := &closeTrackingConn{Conn: f}
+
+	// the same connection can be reused for repeated runs
+	for run := 0; run < 2; run++ {
+		stats, err := RunWithConn(conn, 5, AggregatorFunc(AnyKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertInt(t, 5, int(stats["any-key"].KeyCount))
+		if conn.closed {
+			t.Fatal("expected the caller's connection to be left open")
+		}
+	}
+
+	if _, err := RunWithConn(conn, -1, AggregatorFunc(AnyKey)); err == nil {
+		t.Error("expected a negative number of keys to be rejected")
+	}
+}
+
+func TestRunMultiContext(t *testing.T) {
+
+	// a server that accepts connections, but never replies to any command
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	closed := make(chan struct{}, 2)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				// the copy ends once the client closes its end
+				io.Copy(io.Discard, c)
+				c.Close()
+				closed <- struct{}{}
+			}()
+		}
+	}()
+
+	port := l.Addr().(*net.TCPAddr).Port
+	opts := []Options{
+		{Host: "127.0.0.1", Port: port, MinSamples: 1, Tag: "a"},
+		{Host: "127.0.0.1", Port: port, MinSamples: 1, Tag: "b"},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, _, err = RunMultiContext(ctx, opts, AggregatorFunc(AnyKey))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, actual: %v", err)
+	}
+	for i := range opts {
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatalf("connection %d was not closed", i)
+		}
+	}
+}
+
+func TestRunMultiIncompatible(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("s", TypeString, "value")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveFake(l, f)
+
+	// results aggregated differently cannot be merged
+	port := l.Addr().(*net.TCPAddr).Port
+	opts := []Options{
+		{Host: "127.0.0.1", Port: port, Census: true, Tag: "a", Provenance: "by-prefix"},
+		{Host: "127.0.0.1", Port: port, Census: true, Tag: "b", Provenance: "by-type"},
+	}
+	if _, _, err := RunMulti(opts, AggregatorFunc(AnyKey)); !errors.Is(err, ErrIncompatibleResults) {
+		t.Errorf("expected an error wrapping ErrIncompatibleResults, actual: %v", err)
+	}
+}
+
+func TestSampleCensus(t *testing.T) {
+
+	f := newFakeRedis()
+	for i := 0; i < 150; i++ {
+		f.set(fmt.Sprintf("a%d", i), TypeString, "value")
+		f.set(fmt.Sprintf("b%d", i), TypeList, "x")
+	}
+	// SCAN may return a key more than once
+	f.names = append(f.names, "a0")
+
+	stats, summary, err := sample(context.Background(), f, Options{Census: true, MatchPattern: "a*"}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := stats["any-key"]
+	assertInt(t, 150, int(r.KeyCount))
+	assertInt(t, 150, int(r.StringSizes[5]))
+	if !summary.Exact || !r.Exact {
+		t.Error("expected the census results to be exact")
+	}
+	for _, c := range f.commands {
+		if strings.HasPrefix(c, "RANDOMKEY") {
+			t.Errorf("expected no random keys to be selected, actual: %s", c)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := RenderText(r, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "# of keys (exact census): 150") {
+		t.Errorf("expected the report to be labelled as exact, actual: %s", out.String())
+	}
+
+	// the SCAN COUNT hint is configurable
+	f.commands = nil
+	if _, _, err := sample(context.Background(), f, Options{Census: true, ScanCount: 1000}, AggregatorFunc(AnyKey)); err != nil {
+		t.Fatal(err)
+	}
+	if c := f.commands[1]; c != "SCAN 0 COUNT 1000" {
+		t.Errorf("expected a SCAN with a COUNT of 1000, actual: %q", c)
+	}
+
+	// without a sample size, the whole keyspace is observed too
+	f.commands = nil
+	if _, summary, err := sample(context.Background(), f, Options{}, AggregatorFunc(AnyKey)); err != nil {
+		t.Fatal(err)
+	} else if !summary.Exact || summary.Sampled != 300 {
+		t.Errorf("expected a census of 300 keys, actual: %+v", summary)
+	}
+
+	// sampled results are not exact, and neither is a merge of the two
+	stats, _, err = sample(context.Background(), f, Options{MinSamples: 10}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats["any-key"].Exact {
+		t.Error("expected sampled results not to be exact")
+	}
+	if err := r.Merge(stats["any-key"]); err != nil {
+		t.Fatal(err)
+	}
+	if r.Exact {
+		t.Error("expected merged results not to be exact")
+	}
+}
+
+func TestSampleCommandRetries(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("s", TypeString, "value")
+	f.set("l", TypeList, "a", "b")
+
+	var gets, types int
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		switch {
+		case cmd == "GET":
+			if gets++; gets <= 2 {
+				return redis.Error("LOADING Redis is loading the dataset in memory"), true
+			}
+		case cmd == "TYPE" && argString(args[0]) == "l":
+			// fails in the middle of a pipeline
+			if types++; types == 1 {
+				return redis.Error("MASTERDOWN Link with MASTER is down and replica-serve-stale-data is set to 'no'"), true
+			}
+		}
+		return nil, false
+	}
+
+	opts := Options{MinSamples: 2, PipelineBatchSize: 2, CommandRetryBackoff: time.Millisecond}
+	stats, summary, err := sample(context.Background(), f, opts, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 2, int(stats["any-key"].KeyCount))
+	assertInt(t, 3, int(summary.Retries))
+
+	// errors that aren't transient are not retried
+	f = newFakeRedis()
+	f.set("s", TypeString, "value")
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		if cmd == "GET" {
+			return redis.Error("NOPERM this user has no permissions to run the 'get' command"), true
+		}
+		return nil, false
+	}
+	_, summary, err = sample(context.Background(), f, Options{MinSamples: 1, CommandRetryBackoff: time.Millisecond}, AggregatorFunc(AnyKey))
+	if err == nil {
+		t.Error("expected the NOPERM error to be returned")
+	}
+	assertInt(t, 0, int(summary.Retries))
+
+	// transient errors are eventually returned, too
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		if cmd == "GET" {
+			return redis.Error("LOADING Redis is loading the dataset in memory"), true
+		}
+		return nil, false
+	}
+	_, summary, err = sample(context.Background(), f, Options{MinSamples: 1, CommandRetries: 2, CommandRetryBackoff: time.Millisecond}, AggregatorFunc(AnyKey))
+	if err == nil || !strings.HasPrefix(err.Error(), "LOADING") {
+		t.Errorf("expected the LOADING error to be returned, actual: %v", err)
+	}
+	assertInt(t, 2, int(summary.Retries))
+
+	// the backoff is cut short once the run is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err = sample(ctx, f, Options{MinSamples: 1, CommandRetryBackoff: time.Hour}, AggregatorFunc(AnyKey))
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, actual: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the backoff to be cut short, actual: %s", elapsed)
+	}
+}
+
+func TestSampleTypeChanged(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("k", TypeHash, "field", "value")
+
+	// the key was a string when selected, but has since been recreated as a
+	// hash
+	var types int
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		if cmd == "TYPE" {
+			if types++; types == 1 {
+				return "string", true
+			}
+		}
+		return nil, false
+	}
+	stats, summary, err := sample(context.Background(), f, Options{MinSamples: 1}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 1, int(stats["any-key"].HashSizes[1]))
+	assertInt(t, 0, int(sum(stats["any-key"].StringSizes)))
+	assertInt(t, 1, summary.Sampled)
+	assertInt(t, 1, summary.TypeChanged)
+
+	// a key that keeps changing type is skipped
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		if cmd == "TYPE" {
+			return "string", true
+		}
+		return nil, false
+	}
+	stats, summary, err = sample(context.Background(), f, Options{MinSamples: 1}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 0, len(stats))
+	assertInt(t, 0, summary.Sampled)
+	assertInt(t, 1, summary.TypeChanged)
+}
+
+func TestSampleUnique(t *testing.T) {
+	f := newFakeRedis()
+	f.set("big", TypeString, strings.Repeat("v", 1000))
+	f.set("small1", TypeString, "v")
+	f.set("small2", TypeString, "v")
+
+	// RANDOMKEY returns the big key twice before each small key
+	replies := []string{"big", "big", "small1", "big", "small2"}
+	var calls int
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		if cmd != "RANDOMKEY" {
+			return nil, false
+		}
+		calls++
+		return bulk(replies[(calls-1)%len(replies)]), true
+	}
+
+	stats, summary, err := sample(context.Background(), f, Options{MinSamples: 3, Unique: true}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 3, summary.Sampled)
+	assertInt(t, 1, int(stats["any-key"].StringSizes[1000]))
+	assertInt(t, 2, int(stats["any-key"].StringSizes[1]))
+	assertInt(t, 5, calls)
+
+	// once every key has been sampled, sampling stops short
+	calls = 0
+	stats, summary, err = sample(context.Background(), f, Options{MinSamples: 10, Unique: true}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 3, summary.Sampled)
+	assertInt(t, 1, int(stats["any-key"].StringSizes[1000]))
+	assertInt(t, 5+MaxDuplicateSelections, calls)
+	if summary.Exact {
+		t.Error("expected a sample of unique random keys not to be exact")
+	}
+}
+
+func TestSampleMemoryUsage(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("a", TypeString, strings.Repeat("x", 10))
+	f.set("b", TypeString, strings.Repeat("x", 30))
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		if cmd != "MEMORY" {
+			return nil, false
+		}
+		// redis' overheads come on top of the value
+		return int64(50 + len(f.keys[argString(args[1])].value[0])), true
+	}
+
+	opts := Options{Census: true, CollectMemoryUsage: true}
+	stats, _, err := sample(context.Background(), f, opts, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := stats["any-key"]
+	assertInt(t, 1, int(r.MemoryUsages[60]))
+	assertInt(t, 1, int(r.MemoryUsages[80]))
+	assertInt(t, 140, int(r.MemoryUsage()))
+	if r.MemoryEstimated {
+		t.Error("expected the memory usage not to be estimated")
+	}
+
+	var buf bytes.Buffer
+	if err := RenderHTML(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<h1>Memory Usage <small>140B sampled</small></h1>") {
+		t.Error("expected the HTML report to include the memory usage")
+	}
+	buf.Reset()
+	if err := RenderIndex(stats, &buf, func(group string) string { return group + ".html" }); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<td>140B</td>") || !strings.Contains(buf.String(), "<td>70.00B</td>") {
+		t.Errorf("expected the index to include memory columns, actual: %s", buf.String())
+	}
+
+	// servers without MEMORY USAGE fall back to the estimated sizes
+	f.override = func(cmd string, args []interface{}) (interface{}, bool) {
+		if cmd != "MEMORY" {
+			return nil, false
+		}
+		return redis.Error("ERR unknown command 'MEMORY'"), true
+	}
+	f.commands = nil
+	stats, _, err = sample(context.Background(), f, opts, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = stats["any-key"]
+	assertInt(t, 40, int(r.MemoryUsage()))
+	if !r.MemoryEstimated {
+		t.Error("expected the memory usage to be flagged as estimated")
+	}
+	var memories int
+	for _, c := range f.commands {
+		if strings.HasPrefix(c, "MEMORY") {
+			memories++
+		}
+	}
+	assertInt(t, 1, memories)
+
+	buf.Reset()
+	if err := RenderText(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "--- Memory Usage (~40B sampled, partly estimated without redis' overheads) ---") {
+		t.Errorf("expected the estimate to be noted, actual: %s", buf.String())
+	}
+}
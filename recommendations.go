@@ -0,0 +1,162 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"fmt"
+	"sort"
+)
+
+// An EncodingThreshold is the configured limit below which redis stores a
+// collection in its compact encoding (a listpack), rather than as a hash
+// table or skiplist: collections with at most Entries elements, none longer
+// than Value bytes, are stored compactly.
+type EncodingThreshold struct {
+	// Setting is the name of the redis configuration setting for Entries,
+	// e.g. "hash-max-listpack-entries"
+	Setting string
+	Entries int
+	Value   int
+}
+
+// DefaultEncodingThresholds holds redis' default compact encoding thresholds
+// for hashes, sets and sorted sets (as of redis 7.2).
+var DefaultEncodingThresholds = map[ValueType]EncodingThreshold{
+	TypeHash:      {Setting: "hash-max-listpack-entries", Entries: 128, Value: 64},
+	TypeSet:       {Setting: "set-max-listpack-entries", Entries: 128, Value: 64},
+	TypeSortedSet: {Setting: "zset-max-listpack-entries", Entries: 128, Value: 64},
+}
+
+// elementOverhead is a rough estimate of the number of bytes per element
+// saved by storing a collection as a listpack, rather than as a hash table
+// (or, for sorted sets, a hash table and a skiplist): the hash table entry,
+// the object and string headers of each member, and the skiplist node
+var elementOverhead = map[ValueType]int64{
+	TypeHash:      56,
+	TypeSet:       40,
+	TypeSortedSet: 72,
+}
+
+// A Recommendation suggests raising a compact encoding threshold, and
+// estimates the memory that doing so would save.
+type Recommendation struct {
+	Type    ValueType
+	Setting string
+
+	// Current is the current threshold, and Suggested the recommended one
+	Current   int
+	Suggested int
+
+	// Keys is the number of sampled keys that are too big for the current
+	// threshold, but not the suggested one, and Savings the estimated number
+	// of bytes that storing them compactly would save
+	Keys    int64
+	Savings int64
+
+	// Oversized is the fraction of the sampled elements that are too long
+	// to be stored compactly (see EncodingThreshold.Value), regardless of
+	// the threshold.  Keys holding any such elements won't benefit.
+	Oversized float64
+}
+
+func (rec Recommendation) String() string {
+	s := fmt.Sprintf("raising %s from %d to %d would store %d more sampled %s keys compactly, saving ~%s",
+		rec.Setting, rec.Current, rec.Suggested, rec.Keys, rec.Type, humanBytes(rec.Savings))
+	if rec.Oversized > 0 {
+		s += fmt.Sprintf(" (less for keys holding any of the %.1f%% of elements that are too long to be stored compactly)", 100*rec.Oversized)
+	}
+	return s
+}
+
+// Recommendations estimates the memory that could be saved by doubling the
+// compact encoding thresholds for hashes, sets and sorted sets, given the
+// sampled collection lengths and element sizes: every sampled key whose
+// length lies between the current and doubled thresholds would then be
+// stored as a listpack, saving a fixed (rough) overhead per element.  This is
+// a heuristic, and listpacks trade memory for CPU, since their operations are
+// linear in the number of elements, so thresholds should be raised
+// gradually.  `thresholds` gives the current thresholds (as configured on
+// the redis instance), defaulting to DefaultEncodingThresholds for any type
+// that is absent.  Only thresholds that would save memory are recommended,
+// largest savings first.
+func (r *Results) Recommendations(thresholds map[ValueType]EncodingThreshold) []Recommendation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.recommendations(thresholds)
+}
+
+// reportRecommendations returns the Recommendations for the thresholds
+// recorded in the Results (see Options.EncodingThresholds), if any, without
+// any locking, for use by the report templates.  DefaultEncodingThresholds
+// are not assumed, since they may not match the server.
+func reportRecommendations(r *Results) []Recommendation {
+	if len(r.EncodingThresholds) == 0 {
+		return nil
+	}
+	var recs []Recommendation
+	for _, rec := range r.recommendations(r.EncodingThresholds) {
+		if _, ok := r.EncodingThresholds[rec.Type]; ok {
+			recs = append(recs, rec)
+		}
+	}
+	return recs
+}
+
+// recommendations is Recommendations, without any locking
+func (r *Results) recommendations(thresholds map[ValueType]EncodingThreshold) []Recommendation {
+	var recs []Recommendation
+	for _, vt := range valueTypes {
+		t, ok := thresholds[vt]
+		if !ok {
+			if t, ok = DefaultEncodingThresholds[vt]; !ok {
+				continue
+			}
+		}
+
+		rec := Recommendation{Type: vt, Setting: t.Setting, Current: t.Entries, Suggested: 2 * t.Entries}
+		for length, n := range r.lengths(vt) {
+			if length > rec.Current && length <= rec.Suggested {
+				rec.Keys += n
+				rec.Savings += n * int64(length) * elementOverhead[vt]
+			}
+		}
+		if rec.Keys == 0 {
+			continue
+		}
+
+		sizes := []map[int]int64{r.valueSizes(vt)}
+		if vt == TypeHash {
+			sizes = append(sizes, r.HashElementSizes)
+		}
+		var elements, oversized int64
+		for _, m := range sizes {
+			for size, n := range m {
+				elements += n
+				if size > t.Value {
+					oversized += n
+				}
+			}
+		}
+		if elements > 0 {
+			rec.Oversized = float64(oversized) / float64(elements)
+		}
+		recs = append(recs, rec)
+	}
+
+	sort.SliceStable(recs, func(i, j int) bool { return recs[i].Savings > recs[j].Savings })
+	return recs
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRecommendations(t *testing.T) {
+
+	r := NewResults()
+	// too big for the default threshold of 128, but not for 256
+	r.observeHash("h1", 200, "field", "value")
+	r.observeHash("h2", 150, "field", strings.Repeat("x", 100))
+	// too big either way
+	r.observeHash("h3", 1000, "field", "value")
+	// small enough already
+	r.observeSet("s1", 100, "member")
+	r.observeSortedSet("z1", 100, "member")
+
+	recs := r.Recommendations(map[ValueType]EncodingThreshold{
+		TypeSortedSet: {Setting: "zset-max-listpack-entries", Entries: 64, Value: 64},
+	})
+	if len(recs) != 2 {
+		t.Fatalf("expected recommendations for hashes and sorted sets, actual: %v", recs)
+	}
+
+	hash := recs[0]
+	if hash.Type != TypeHash || hash.Current != 128 || hash.Suggested != 256 || hash.Keys != 2 {
+		t.Errorf("unexpected hash recommendation: %+v", hash)
+	}
+	assertInt(t, 350*56, int(hash.Savings))
+	// one of the 6 sampled fields and values is too long
+	assertFloat(t, 1.0/6, hash.Oversized, epsilon)
+
+	zset := recs[1]
+	if zset.Type != TypeSortedSet || zset.Current != 64 || zset.Keys != 1 || zset.Oversized != 0 {
+		t.Errorf("unexpected sorted set recommendation: %+v", zset)
+	}
+
+	// reports only make recommendations for known thresholds
+	var buf bytes.Buffer
+	if err := RenderText(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "Recommendations") {
+		t.Errorf("unexpected recommendations without known thresholds:\n%s", buf.String())
+	}
+
+	r.EncodingThresholds = map[ValueType]EncodingThreshold{
+		TypeHash: {Setting: "hash-max-ziplist-entries", Entries: 128, Value: 64},
+	}
+	buf.Reset()
+	if err := RenderText(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "raising hash-max-ziplist-entries from 128 to 256") {
+		t.Errorf("expected the recommendations to be rendered:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "zset-max-listpack-entries") {
+		t.Errorf("unexpected recommendation for an unknown threshold:\n%s", buf.String())
+	}
+}
+
+func TestSampleEncodingThresholds(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("h", TypeHash, "field", "value")
+
+	thresholds := map[ValueType]EncodingThreshold{
+		TypeHash: {Setting: "hash-max-listpack-entries", Entries: 512, Value: 64},
+	}
+	stats, _, err := sample(context.Background(), f, Options{Census: true, EncodingThresholds: thresholds}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := stats["any-key"]; r.EncodingThresholds[TypeHash] != thresholds[TypeHash] {
+		t.Errorf("expected the thresholds to be recorded, actual: %v", r.EncodingThresholds)
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import "math/rand"
+
+// reservoir keeps a uniform random sample of up to `size` distinct keys from
+// a stream of keys of unknown length, using Algorithm R: the first `size`
+// keys fill the reservoir, and the nth key thereafter replaces a random key
+// in it with probability size/n.  Keys that have already been offered are
+// ignored, since SCAN may return a key more than once, and a key offered twice
+// would otherwise be twice as likely to be kept.
+type reservoir struct {
+	keys []string
+	seen map[string]bool
+	size int
+	rng  *rand.Rand
+}
+
+// newReservoir returns an empty reservoir of `size` keys, drawing its random
+// numbers from `rng`
+func newReservoir(size int, rng *rand.Rand) *reservoir {
+	return &reservoir{seen: make(map[string]bool), size: size, rng: rng}
+}
+
+// add offers `key` to the reservoir, returning the index at which it was
+// kept, or -1 if it wasn't
+func (r *reservoir) add(key string) int {
+	if r.seen[key] {
+		return -1
+	}
+	r.seen[key] = true
+	if len(r.keys) < r.size {
+		r.keys = append(r.keys, key)
+		return len(r.keys) - 1
+	}
+	if j := r.rng.Intn(len(r.seen)); j < r.size {
+		r.keys[j] = key
+		return j
+	}
+	return -1
+}
+
+// take removes and returns up to `n` keys from the reservoir
+func (r *reservoir) take(n int) []string {
+	if n > len(r.keys) {
+		n = len(r.keys)
+	}
+	keys := r.keys[len(r.keys)-n:]
+	r.keys = r.keys[:len(r.keys)-n]
+	return keys
+}
+
+// complete indicates whether every key offered to the reservoir was kept
+func (r *reservoir) complete() bool {
+	return len(r.seen) <= r.size
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReservoirUniform(t *testing.T) {
+	const keys, size, trials = 20, 5, 4000
+	rng := rand.New(rand.NewSource(1))
+
+	counts := make(map[string]int)
+	for i := 0; i < trials; i++ {
+		r := newReservoir(size, rng)
+		for k := 0; k < keys; k++ {
+			r.add(fmt.Sprintf("key%d", k))
+			// SCAN may return keys more than once
+			if k%3 == 0 {
+				r.add(fmt.Sprintf("key%d", k))
+			}
+		}
+		if r.complete() {
+			t.Fatal("expected an incomplete reservoir")
+		}
+		taken := r.take(size + 1)
+		if len(taken) != size {
+			t.Fatalf("expected %d keys, got: %v", size, taken)
+		}
+		for _, key := range taken {
+			counts[key]++
+		}
+	}
+
+	expected := float64(trials * size / keys)
+	for k := 0; k < keys; k++ {
+		key := fmt.Sprintf("key%d", k)
+		if c := float64(counts[key]); math.Abs(c-expected) > 0.15*expected {
+			t.Errorf("expected %s to be selected ~%.0f times, got: %.0f", key, expected, c)
+		}
+	}
+}
+
+func TestSampleReservoir(t *testing.T) {
+	f := newFakeRedis()
+	for i := 0; i < 30; i++ {
+		f.set(fmt.Sprintf("key%d", i), TypeString, "v")
+	}
+
+	opts := Options{MinSamples: 10, Strategy: StrategyReservoir}
+	stats, summary, err := sample(context.Background(), f, opts, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Sampled != 10 || stats["any-key"].KeyCount != 10 {
+		t.Errorf("expected 10 sampled keys, got: %d", summary.Sampled)
+	}
+	if summary.Exact {
+		t.Error("expected a sample of the keyspace not to be exact")
+	}
+	for _, c := range f.commands {
+		if c == "RANDOMKEY" {
+			t.Fatal("expected reservoir sampling not to use RANDOMKEY")
+		}
+	}
+}
+
+func TestFetchElementReservoir(t *testing.T) {
+
+	f := newFakeRedis()
+	var members, fields []string
+	for i := 0; i < 20; i++ {
+		members = append(members, fmt.Sprintf("m%d", i))
+		fields = append(fields, fmt.Sprintf("f%d", i), fmt.Sprintf("v%d", i))
+	}
+	f.set("set", TypeSet, members...)
+	f.set("z", TypeSortedSet, members...)
+	f.set("h", TypeHash, fields...)
+
+	const size, trials = 5, 2000
+	s := newTestSampler(f, Options{ElementsPerKey: size, MaxElementsPerKey: 20, ElementStrategy: StrategyReservoir})
+	for _, vt := range []ValueType{TypeSet, TypeSortedSet, TypeHash} {
+		key := map[ValueType]string{TypeSet: "set", TypeSortedSet: "z", TypeHash: "h"}[vt]
+
+		counts := make(map[string]int)
+		for i := 0; i < trials; i++ {
+			smp, err := s.src.Fetch(key, vt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertInt(t, 20, smp.Length)
+			if vt == TypeHash {
+				assertInt(t, 2*size, len(smp.Elements))
+				for j := 0; j < len(smp.Elements); j += 2 {
+					if "v"+smp.Elements[j][1:] != smp.Elements[j+1] {
+						t.Fatalf("field %s sampled with the wrong value: %s", smp.Elements[j], smp.Elements[j+1])
+					}
+					counts[smp.Elements[j]]++
+				}
+				continue
+			}
+			assertInt(t, size, len(smp.Elements))
+			for _, m := range smp.Elements {
+				counts[m]++
+			}
+		}
+
+		// every element is kept with probability size/20
+		assertInt(t, 20, len(counts))
+		expected := float64(trials) * size / 20
+		for elem, n := range counts {
+			if math.Abs(float64(n)-expected) > 0.2*expected {
+				t.Errorf("%s: element %s sampled %d times, expected about %.0f", vt, elem, n, expected)
+			}
+		}
+	}
+
+	// the walk is bounded by MaxElementsPerKey
+	s = newTestSampler(f, Options{ElementsPerKey: size, MaxElementsPerKey: 10, ElementStrategy: StrategyReservoir})
+	f.commands = nil
+	smp, err := s.src.Fetch("set", TypeSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range smp.Elements {
+		if n, _ := strconv.Atoi(m[1:]); n >= 10 {
+			t.Errorf("expected only the first 10 members to be walked, sampled %s", m)
+		}
+	}
+	for _, c := range f.commands {
+		if strings.HasPrefix(c, "SRANDMEMBER") || c == "SSCAN set 10 COUNT 10" {
+			t.Errorf("unexpected command: %s", c)
+		}
+	}
+}
+
+func TestSampleScan(t *testing.T) {
+	f := newFakeRedis()
+	for i := 0; i < 30; i++ {
+		f.set(fmt.Sprintf("key%d", i), TypeString, "v")
+	}
+
+	run := func(opts Options) ([]string, Summary) {
+		var keys []string
+		opts.Strategy = StrategyScan
+		opts.ScanCount = 4
+		opts.KeyFilter = func(key string, vt ValueType) bool {
+			keys = append(keys, key)
+			return true
+		}
+		_, summary, err := sample(context.Background(), f, opts, AggregatorFunc(AnyKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys, summary
+	}
+
+	first, summary := run(Options{MinSamples: 10})
+	if len(first) != 10 || first[0] != "key0" || first[9] != "key9" {
+		t.Errorf("expected the first 10 keys in SCAN order, actual: %v", first)
+	}
+	if summary.Exact {
+		t.Error("expected a partial scan not to be exact")
+	}
+	second, _ := run(Options{MinSamples: 10})
+	if strings.Join(first, ",") != strings.Join(second, ",") {
+		t.Errorf("expected repeated scans to select the same keys, actual: %v and %v", first, second)
+	}
+	for _, c := range f.commands {
+		if c == "RANDOMKEY" {
+			t.Fatal("expected StrategyScan not to use RANDOMKEY")
+		}
+	}
+
+	// the scan stops once the cursor wraps around, short of the sample size
+	all, summary := run(Options{MinSamples: 100})
+	assertInt(t, 30, len(all))
+	if !summary.Exact {
+		t.Error("expected a full scan to be exact")
+	}
+
+	strided, summary := run(Options{MinSamples: 100, ScanStride: 3})
+	if len(strided) != 10 || strided[1] != "key3" || strided[9] != "key27" {
+		t.Errorf("expected every 3rd key, actual: %v", strided)
+	}
+	if summary.Exact {
+		t.Error("expected a strided scan not to be exact")
+	}
+}
@@ -0,0 +1,228 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerInfo describes the redis instance at the time it was sampled, as
+// reported by redis' `INFO` command, so that sampling results can be framed
+// against the instance's configuration and memory pressure.
+type ServerInfo struct {
+	// Version is the redis server version
+	Version string
+
+	// UsedMemory is the number of bytes allocated by redis, and MaxMemory its
+	// configured limit (0 means that there is no limit)
+	UsedMemory int64
+	MaxMemory  int64
+
+	// MaxMemoryPolicy is the eviction policy applied when MaxMemory is reached
+	MaxMemoryPolicy string
+
+	// KeyCount is the total number of keys, across all databases
+	KeyCount int64
+
+	// Replica indicates that the instance is a replica, in which case
+	// Replication describes its link to the master: the sampled data may lag
+	// the master's.  When instances are merged, Replica is set if any of them
+	// is a replica, and Replication describes the stalest replica.
+	Replica     bool
+	Replication ReplicationInfo
+
+	// Scripts describes the memory used outside the keyspace by scripts and
+	// functions, or is nil unless sampling with Options.CollectScripts
+	Scripts *ScriptInfo
+}
+
+// ScriptInfo describes the cached Lua scripts (see `SCRIPT LOAD` and `EVAL`)
+// and function libraries (see `FUNCTION LOAD`, redis >= 7.0) held by a redis
+// instance, as reported by the "memory" section of redis' `INFO` command.
+// Their memory isn't attributed to any key, so sampling can't account for it.
+type ScriptInfo struct {
+	// CachedScripts is the number of cached scripts, and ScriptMemory the
+	// bytes used by them
+	CachedScripts int64
+	ScriptMemory  int64
+
+	// Functions is the number of functions, in Libraries libraries, and
+	// FunctionMemory the bytes used by them (all 0 before redis 7.0)
+	Functions      int64
+	Libraries      int64
+	FunctionMemory int64
+
+	// EngineMemory is the number of bytes used by the Lua engines that run the
+	// scripts and functions
+	EngineMemory int64
+}
+
+// ReplicationInfo describes a replica's link to its master, as reported by
+// the "replication" section of redis' `INFO` command, so that readers can tell
+// how fresh the sampled data is.
+type ReplicationInfo struct {
+	// Master is the address of the master, as "host:port"
+	Master string
+
+	// LinkUp indicates that the replica was connected to its master.  While
+	// connected, LastIO is the time since the replica last heard from the
+	// master; otherwise, LinkDown is how long the link had been down.
+	LinkUp   bool
+	LastIO   time.Duration
+	LinkDown time.Duration
+
+	// SyncInProgress indicates that the replica was (re)synchronizing with its
+	// master, so that its dataset may be incomplete
+	SyncInProgress bool
+
+	// Offset is the replication offset that the replica had processed; compare
+	// it with the master's master_repl_offset to measure the lag in bytes
+	Offset int64
+}
+
+// parseServerInfo extracts the ServerInfo from the parsed output of redis'
+// `INFO` command
+func parseServerInfo(info map[string]string) ServerInfo {
+	s := ServerInfo{
+		Version:         info["redis_version"],
+		MaxMemoryPolicy: info["maxmemory_policy"],
+	}
+	s.UsedMemory, _ = strconv.ParseInt(info["used_memory"], 10, 64)
+	s.MaxMemory, _ = strconv.ParseInt(info["maxmemory"], 10, 64)
+
+	if info["role"] == "slave" {
+		s.Replica = true
+		s.Replication = parseReplicationInfo(info)
+	}
+
+	// each database is listed as e.g. "db0:keys=1,expires=0,avg_ttl=0"
+	for field, value := range info {
+		if !keyspaceExpr.MatchString(field) {
+			continue
+		}
+		for _, kv := range strings.Split(value, ",") {
+			if strings.HasPrefix(kv, "keys=") {
+				n, _ := strconv.ParseInt(kv[len("keys="):], 10, 64)
+				s.KeyCount += n
+			}
+		}
+	}
+	return s
+}
+
+// parseScriptInfo extracts the ScriptInfo from the parsed output of redis'
+// `INFO` command.  Redis 7.0 split the memory used by scripts (and by their
+// engine) into separate fields for scripts and functions; for older versions
+// the combined fields are used.
+func parseScriptInfo(info map[string]string) ScriptInfo {
+	field := func(names ...string) int64 {
+		for _, name := range names {
+			if n, err := strconv.ParseInt(info[name], 10, 64); err == nil {
+				return n
+			}
+		}
+		return 0
+	}
+	return ScriptInfo{
+		CachedScripts:  field("number_of_cached_scripts"),
+		ScriptMemory:   field("used_memory_scripts_eval", "used_memory_scripts"),
+		Functions:      field("number_of_functions"),
+		Libraries:      field("number_of_libraries"),
+		FunctionMemory: field("used_memory_functions"),
+		EngineMemory:   field("used_memory_vm_total", "used_memory_lua"),
+	}
+}
+
+// parseReplicationInfo extracts the ReplicationInfo of a replica from the
+// parsed output of redis' `INFO` command
+func parseReplicationInfo(info map[string]string) ReplicationInfo {
+	r := ReplicationInfo{
+		Master:         info["master_host"] + ":" + info["master_port"],
+		LinkUp:         info["master_link_status"] == "up",
+		SyncInProgress: info["master_sync_in_progress"] == "1",
+	}
+	if n, err := strconv.ParseInt(info["master_last_io_seconds_ago"], 10, 64); err == nil && n >= 0 {
+		r.LastIO = time.Duration(n) * time.Second
+	}
+	if n, err := strconv.ParseInt(info["master_link_down_since_seconds"], 10, 64); err == nil && n >= 0 {
+		r.LinkDown = time.Duration(n) * time.Second
+	}
+	r.Offset, _ = strconv.ParseInt(info["slave_repl_offset"], 10, 64)
+	return r
+}
+
+// stalest combines the ReplicationInfo of two replicas, keeping the least
+// favorable of each: the link is only up if both links are, and the longest
+// delays are kept.  Masters and offsets are only kept if both replicas agree.
+func (r ReplicationInfo) stalest(other ReplicationInfo) ReplicationInfo {
+	if r.Master != other.Master {
+		r.Master = ""
+	}
+	if r.Offset != other.Offset {
+		r.Offset = 0
+	}
+	r.LinkUp = r.LinkUp && other.LinkUp
+	r.SyncInProgress = r.SyncInProgress || other.SyncInProgress
+	if other.LastIO > r.LastIO {
+		r.LastIO = other.LastIO
+	}
+	if other.LinkDown > r.LinkDown {
+		r.LinkDown = other.LinkDown
+	}
+	return r
+}
+
+// merge combines the ServerInfo of another redis instance into the method
+// receiver, e.g. to describe a cluster as a whole: memory and keys are summed,
+// while the version and policy are only kept if both instances agree.
+func (s *ServerInfo) merge(other ServerInfo) {
+	if s.Version != other.Version {
+		s.Version = ""
+	}
+	if s.MaxMemoryPolicy != other.MaxMemoryPolicy {
+		s.MaxMemoryPolicy = ""
+	}
+	s.UsedMemory += other.UsedMemory
+	s.MaxMemory += other.MaxMemory
+	s.KeyCount += other.KeyCount
+
+	// the ScriptInfo may be shared with copies of the receiver, so is replaced
+	// rather than updated in place
+	if other.Scripts != nil {
+		scripts := *other.Scripts
+		if s.Scripts != nil {
+			scripts.CachedScripts += s.Scripts.CachedScripts
+			scripts.ScriptMemory += s.Scripts.ScriptMemory
+			scripts.Functions += s.Scripts.Functions
+			scripts.Libraries += s.Scripts.Libraries
+			scripts.FunctionMemory += s.Scripts.FunctionMemory
+			scripts.EngineMemory += s.Scripts.EngineMemory
+		}
+		s.Scripts = &scripts
+	}
+
+	if other.Replica {
+		if s.Replica {
+			s.Replication = s.Replication.stalest(other.Replication)
+		} else {
+			s.Replication = other.Replication
+		}
+		s.Replica = true
+	}
+}
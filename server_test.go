@@ -0,0 +1,142 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testInfo = "# Server\r\nredis_version:7.2.4\r\n" +
+	"# Memory\r\nused_memory:1048576\r\nmaxmemory:4194304\r\nmaxmemory_policy:allkeys-lru\r\n" +
+	"# Keyspace\r\ndb0:keys=10,expires=2,avg_ttl=0\r\ndb3:keys=5,expires=0,avg_ttl=0\r\n"
+
+func TestParseServerInfo(t *testing.T) {
+
+	s := parseServerInfo(parseInfo(testInfo))
+	expected := ServerInfo{
+		Version:         "7.2.4",
+		UsedMemory:      1048576,
+		MaxMemory:       4194304,
+		MaxMemoryPolicy: "allkeys-lru",
+		KeyCount:        15,
+	}
+	if s != expected {
+		t.Errorf("expected %+v, actual: %+v", expected, s)
+	}
+
+	// merging instances sums their memory and keys
+	s.merge(ServerInfo{Version: "6.2.0", UsedMemory: 1, MaxMemoryPolicy: "allkeys-lru", KeyCount: 5})
+	if s.Version != "" || s.MaxMemoryPolicy != "allkeys-lru" || s.UsedMemory != 1048577 || s.KeyCount != 20 {
+		t.Errorf("unexpected merged server info: %+v", s)
+	}
+}
+
+func TestParseReplicationInfo(t *testing.T) {
+
+	replica := parseServerInfo(parseInfo(testInfo + "# Replication\r\nrole:slave\r\nmaster_host:10.0.0.1\r\n" +
+		"master_port:6379\r\nmaster_link_status:up\r\nmaster_last_io_seconds_ago:2\r\n" +
+		"master_sync_in_progress:0\r\nslave_repl_offset:12345\r\n"))
+	expected := ReplicationInfo{Master: "10.0.0.1:6379", LinkUp: true, LastIO: 2 * time.Second, Offset: 12345}
+	if !replica.Replica || replica.Replication != expected {
+		t.Errorf("expected replication %+v, actual: %+v", expected, replica.Replication)
+	}
+
+	master := parseServerInfo(parseInfo(testInfo + "# Replication\r\nrole:master\r\nconnected_slaves:1\r\n"))
+	if master.Replica {
+		t.Errorf("expected a master not to be a replica: %+v", master)
+	}
+
+	// merged instances describe the stalest replica
+	master.merge(replica)
+	master.merge(ServerInfo{Replica: true, Replication: ReplicationInfo{Master: "10.0.0.2:6379", LinkDown: time.Minute}})
+	if !master.Replica || master.Replication.LinkUp || master.Replication.LinkDown != time.Minute ||
+		master.Replication.LastIO != 2*time.Second || master.Replication.Master != "" {
+		t.Errorf("unexpected merged replication info: %+v", master.Replication)
+	}
+
+	r := NewResults()
+	r.observeString("s", "value")
+	r.Server = &replica
+
+	var buf bytes.Buffer
+	if err := RenderHTML(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if s := "sampled from a replica of 10.0.0.1:6379"; !strings.Contains(buf.String(), s) {
+		t.Errorf("expected HTML output to contain: %s", s)
+	}
+}
+
+func TestRenderHTMLServerInfo(t *testing.T) {
+
+	r := NewResults()
+	r.observeString("s", "value")
+	server := parseServerInfo(parseInfo(testInfo))
+	r.Server = &server
+
+	var buf bytes.Buffer
+	if err := RenderHTML(r.Clone(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, s := range []string{"redis 7.2.4", "15 keys in the keyspace", "1.0MB used of 4.0MB maxmemory", "(allkeys-lru)"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected HTML output to contain: %s", s)
+		}
+	}
+}
+
+func TestParseScriptInfo(t *testing.T) {
+
+	info := parseInfo(testInfo + "number_of_cached_scripts:3\r\nused_memory_scripts_eval:2048\r\n" +
+		"number_of_functions:4\r\nnumber_of_libraries:2\r\nused_memory_functions:65536\r\n" +
+		"used_memory_vm_total:131072\r\nused_memory_scripts:67584\r\n")
+	s := parseScriptInfo(info)
+	expected := ScriptInfo{CachedScripts: 3, ScriptMemory: 2048, Functions: 4, Libraries: 2, FunctionMemory: 65536, EngineMemory: 131072}
+	if s != expected {
+		t.Errorf("expected %+v, actual: %+v", expected, s)
+	}
+
+	// before redis 7.0, the combined fields are used
+	old := parseScriptInfo(parseInfo("# Memory\r\nused_memory_lua:37888\r\nused_memory_scripts:1024\r\nnumber_of_cached_scripts:1\r\n"))
+	if old != (ScriptInfo{CachedScripts: 1, ScriptMemory: 1024, EngineMemory: 37888}) {
+		t.Errorf("unexpected script info: %+v", old)
+	}
+
+	// merging sums the instances' scripts, without modifying shared copies
+	server := ServerInfo{Scripts: &s}
+	copied := server
+	server.merge(ServerInfo{Scripts: &old})
+	if server.Scripts.CachedScripts != 4 || server.Scripts.EngineMemory != 131072+37888 || s != expected {
+		t.Errorf("unexpected merged script info: %+v (original: %+v)", *server.Scripts, *copied.Scripts)
+	}
+
+	r := NewResults()
+	r.observeString("s", "value")
+	r.Server = &copied
+	var buf bytes.Buffer
+	if err := RenderHTML(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if out := "3 cached scripts (2.0KB)"; !strings.Contains(buf.String(), out) {
+		t.Errorf("expected HTML output to contain: %s", out)
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import "strings"
+
+// ClusterSlots is the number of hash slots that a redis cluster's keyspace is
+// divided into.
+const ClusterSlots = 16384
+
+// A SlotRange is an inclusive range of redis cluster hash slots, e.g. those
+// owned by a single shard (as listed by CLUSTER SHARDS or CLUSTER SLOTS).
+type SlotRange struct {
+	First, Last int
+}
+
+// contains indicates whether `slot` lies within the range
+func (r SlotRange) contains(slot int) bool {
+	return slot >= r.First && slot <= r.Last
+}
+
+// KeySlot returns the redis cluster hash slot of `key`, as CLUSTER KEYSLOT
+// would: the CRC16 of the key (or of its hash tag, the part between the first
+// "{" and the following "}", if non-empty), modulo ClusterSlots.  It is
+// computed locally, without a round trip.
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % ClusterSlots
+}
+
+// inSlots indicates whether `key` hashes to a slot within any of `ranges`
+func inSlots(ranges []SlotRange, key string) bool {
+	slot := KeySlot(key)
+	for _, r := range ranges {
+		if r.contains(slot) {
+			return true
+		}
+	}
+	return false
+}
+
+// crc16 computes the CRC16 (XMODEM) checksum used by redis cluster
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
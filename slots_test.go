@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestKeySlot(t *testing.T) {
+
+	for key, slot := range map[string]int{
+		"123456789": 12739,
+		"foo":       12182,
+		"bar":       5061,
+		// hash tags
+		"{foo}.bar":     12182,
+		"{foo}{bar}":    12182,
+		"foo{{bar}}zap": int(crc16("{bar")) % ClusterSlots,
+		// empty hash tags are ignored
+		"foo{}{bar}": int(crc16("foo{}{bar}")) % ClusterSlots,
+	} {
+		if actual := KeySlot(key); actual != slot {
+			t.Errorf("%s: expected slot %d, actual: %d", key, slot, actual)
+		}
+	}
+}
+
+func TestSampleSlots(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("foo", TypeString, "in range")
+	f.set("{foo}.bar", TypeString, "in range")
+	f.set("bar", TypeString, "out of range")
+
+	opts := Options{Census: true, Slots: []SlotRange{{First: 10000, Last: 16383}}}
+	stats, _, err := sample(context.Background(), f, opts, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := stats["any-key"]
+	if r.KeyCount != 2 || r.StringKeys["bar"] {
+		t.Errorf("expected only the keys in slots 10000-16383, actual: %v", r.StringKeys)
+	}
+
+	// random sampling falls back to SCAN, to filter by slot
+	f.commands = nil
+	opts = Options{MinSamples: 4, Slots: []SlotRange{{First: 0, Last: 9999}}}
+	if stats, _, err = sample(context.Background(), f, opts, AggregatorFunc(AnyKey)); err != nil {
+		t.Fatal(err)
+	}
+	if r := stats["any-key"]; len(r.StringKeys) != 1 || !r.StringKeys["bar"] {
+		t.Errorf("expected only the key in slots 0-9999, actual: %v", r.StringKeys)
+	}
+	for _, c := range f.commands {
+		if strings.HasPrefix(c, "RANDOMKEY") {
+			t.Fatalf("unexpected command: %s", c)
+		}
+	}
+
+	if err := validate(Options{MinSamples: 1, Slots: []SlotRange{{First: 0, Last: ClusterSlots}}}); err == nil {
+		t.Error("expected an out of range slot to be rejected")
+	}
+}
@@ -0,0 +1,1144 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ErrKeyMissing is returned by KeySource.Fetch when a selected key no longer
+// exists by the time its value is fetched (e.g. because it has expired).  Such
+// keys are tallied in Summary.Expired, rather than failing the run.
+var ErrKeyMissing = errors.New("Key expired or was deleted before it could be sampled")
+
+// ErrTypeChanged is returned by KeySource.Fetch when a selected key was
+// replaced by a key of another data type before its value could be fetched,
+// and could not be resampled as its new type.  Such keys are tallied in
+// Summary.TypeChanged, rather than failing the run.
+var ErrTypeChanged = errors.New("Key changed type repeatedly while being sampled")
+
+// errIdle is returned when a sampled key has been idle for too long to be
+// observed (see Options.MaxIdleTime)
+var errIdle = errors.New("Key has been idle for too long to be observed")
+
+// errOutOfRange is returned when a sampled key's length lies outside the range
+// to be observed (see Options.MinLength and Options.MaxLength)
+var errOutOfRange = errors.New("Key's length is outside the range to be observed")
+
+// errFiltered is returned when a selected key is rejected by Options.KeyFilter
+var errFiltered = errors.New("Key was rejected by the key filter")
+
+// A Sample describes a single key obtained from a KeySource: enough of its
+// value to be aggregated, without necessarily holding the entire value.
+type Sample struct {
+	Key  string
+	Type ValueType
+
+	// DB is the index of the logical database holding the key
+	DB int
+
+	// Length is the length of the value: the number of bytes for strings, and
+	// the number of elements for all other data types
+	Length int
+
+	// Elements holds representative elements of a collection: list, set or
+	// sorted set members, or alternating hash fields and values.  At most
+	// Options.ElementsPerKey elements are observed.  It is empty for strings.
+	Elements []string
+
+	// TailElements holds the elements nearest the tail of a list, when
+	// sampling with Options.ListEnds, in which case Elements holds those
+	// nearest the head.  It is empty for all other data types, and for sources
+	// that only sample lists from the head.
+	TailElements []string
+
+	// Value is the value of a string, byte-for-byte (it need not be valid
+	// UTF-8).  It is empty for all other data types.
+	Value string
+
+	// Encoding is the internal encoding of the key (as reported by redis'
+	// `OBJECT ENCODING` command), if known
+	Encoding string
+
+	// Expires indicates that the key is known to have an expiry, in which
+	// case TTL is its remaining time to live.  TTLs are only fetched from
+	// redis when needed (see Options.ShortTTL and CollectTTLs).
+	Expires bool
+	TTL     time.Duration
+
+	// IdleTime is the time since the key was last accessed, and Frequency
+	// its logarithmic access frequency counter.  Which of the two is known
+	// (if either) is indicated by Access: they are only fetched from redis
+	// when needed (see Options.MaxIdleTime and Options.CollectAccess), and
+	// are zero otherwise.
+	IdleTime  time.Duration
+	Frequency int
+	Access    AccessMetric
+
+	// MemoryUsage is the number of bytes used by the key, as reported by
+	// redis' `MEMORY USAGE` command, or zero if unknown.  It is only fetched
+	// when needed (see Options.CollectMemoryUsage).
+	MemoryUsage int
+
+	// Latency is the time taken to fetch the key from redis, or zero for
+	// sources that don't fetch keys individually
+	Latency time.Duration
+}
+
+// Size estimates the number of bytes held by the sampled value, by assuming
+// that every element is the mean size of the representative ones.
+func (s Sample) Size() int {
+	if s.Type == TypeString {
+		return s.Length
+	}
+
+	per := entriesPerElement(s.Type)
+	n := len(s.Elements) / per
+	if n == 0 {
+		return 0
+	}
+	var total int
+	for _, e := range s.Elements[:n*per] {
+		total += len(e)
+	}
+	return s.Length * total / n
+}
+
+// entriesPerElement returns the number of entries of Sample.Elements that
+// make up each element of a collection of type `vt`: a field and a value for
+// hashes, and a single member otherwise
+func entriesPerElement(vt ValueType) int {
+	if vt == TypeHash {
+		return 2
+	}
+	return 1
+}
+
+// limitElements trims the Elements of a Sample to at most `n` elements
+func (s Sample) limitElements(n int) Sample {
+	if max := n * entriesPerElement(s.Type); len(s.Elements) > max {
+		s.Elements = s.Elements[:max]
+	}
+	if len(s.TailElements) > n {
+		s.TailElements = s.TailElements[len(s.TailElements)-n:]
+	}
+	return s
+}
+
+// values returns the values of a Sample, as transformed by Options.ValueTransform:
+// the value of a string, or the elements of a collection (only the values of a
+// hash, not its fields)
+func (s Sample) values() []string {
+	if s.Type == TypeString {
+		return []string{s.Value}
+	}
+
+	step := entriesPerElement(s.Type)
+	values := make([]string, 0, len(s.Elements)/step+len(s.TailElements))
+	for i := step - 1; i < len(s.Elements); i += step {
+		values = append(values, s.Elements[i])
+	}
+	return append(values, s.TailElements...)
+}
+
+// transform applies `f` to the values of a Sample: the value of a string
+// (updating its Length to match), or the elements of a collection (only the
+// values of a hash, not its fields)
+func (s Sample) transform(f func([]byte) []byte) Sample {
+	if s.Type == TypeString {
+		s.Value = string(f([]byte(s.Value)))
+		s.Length = len(s.Value)
+		return s
+	}
+
+	step := entriesPerElement(s.Type)
+	elems := make([]string, len(s.Elements))
+	copy(elems, s.Elements)
+	for i := step - 1; i < len(elems); i += step {
+		elems[i] = string(f([]byte(elems[i])))
+	}
+	s.Elements = elems
+
+	if len(s.TailElements) > 0 {
+		tail := make([]string, len(s.TailElements))
+		for i, e := range s.TailElements {
+			tail[i] = string(f([]byte(e)))
+		}
+		s.TailElements = tail
+	}
+	return s
+}
+
+// A KeySource supplies the keys to be aggregated by RunSource.  Selecting a
+// key (Next) is separate from fetching it (Fetch), so that keys which are not
+// of interest (see Options.TypeQuotas) can be skipped cheaply.  RedisKeySource
+// samples a live redis instance; other implementations could read keys from
+// an offline copy of the data, such as an RDB dump.
+type KeySource interface {
+	// KeyCount returns the total number of keys available from the source
+	KeyCount() (int64, error)
+
+	// Next selects a key, returning its name and data type.  Sources that can
+	// be exhausted return io.EOF once there are no more keys.
+	Next() (key string, vt ValueType, err error)
+
+	// Fetch obtains a Sample of the previously selected `key`, which holds a
+	// value of type `vt`.  ErrKeyMissing is returned if the key no longer
+	// exists, and ErrTypeChanged if it no longer holds a value of type `vt`
+	// (and cannot be resampled as its new type).  Only the Length of the
+	// Sample is required for types listed in Options.SizeOnlyTypes.
+	Fetch(key string, vt ValueType) (Sample, error)
+}
+
+// RedisKeySource is a KeySource that selects random keys from a live redis
+// instance.
+type RedisKeySource struct {
+	conn redis.Conn
+	opts Options
+
+	// info holds the fields of the most recent INFO reply
+	info map[string]string
+
+	// caps describes the features supported by the server
+	caps Capabilities
+
+	// lfu is set if the server's maxmemory-policy is LFU, under which redis
+	// tracks access frequencies rather than idle times
+	lfu bool
+
+	// noMemoryUsage is set once `MEMORY USAGE` has failed, after which memory
+	// usage is estimated instead (see Options.CollectMemoryUsage)
+	noMemoryUsage bool
+
+	// selected holds keys that have been selected (in a pipelined batch, or a
+	// page of SCAN results), but not yet returned by Next
+	selected []selectedKey
+
+	// scanning is set once RANDOMKEY has been denied, and keys are instead
+	// selected by iterating over the keyspace with SCAN from `cursor`.
+	// `passKeys` counts the keys seen since the cursor last wrapped around.
+	scanning bool
+	cursor   string
+	passKeys int
+
+	// censusDone is set once a census has iterated over the entire keyspace,
+	// and `seen` holds the keys observed so far (since SCAN may return a key
+	// more than once)
+	censusDone bool
+	seen       map[string]bool
+
+	// scanDone is set once StrategyScan's pass over the keyspace has
+	// finished, and `visited` counts the keys returned by SCAN so far (to
+	// select every ScanStride'th of them)
+	scanDone bool
+	visited  int
+
+	// duplicates counts the consecutive duplicate keys skipped because of
+	// Options.Unique (whose sampled keys are also held in `seen`), and
+	// `saturated` is set once there were too many of them
+	duplicates int
+	saturated  bool
+
+	// keyCount is the number of keys reported by INFO, and `reservoir` holds
+	// the keys yet to be selected when sampling with StrategyReservoir
+	keyCount  int64
+	reservoir *reservoir
+
+	// rng is the source of randomness for reservoir sampling, created when
+	// first needed (see random)
+	rng *rand.Rand
+}
+
+// selectedKey is a key selected by RANDOMKEY, along with its type
+type selectedKey struct {
+	key string
+	vt  ValueType
+}
+
+// NewRedisKeySource creates a RedisKeySource that samples keys over the
+// supplied connection.  Of the Options, only those that affect what is fetched
+// for each key (e.g. CollectEncodings) are used.
+func NewRedisKeySource(conn redis.Conn, opts Options) *RedisKeySource {
+	return &RedisKeySource{conn: conn, opts: opts}
+}
+
+// String describes the redis instance, for use in progress messages
+func (s *RedisKeySource) String() string {
+	_, addr := address(s.opts)
+	return "redis at " + addr
+}
+
+// KeyCount obtains the number of keys in the redis instance, from the output
+// of redis' `INFO` command
+func (s *RedisKeySource) KeyCount() (int64, error) {
+	resp, err := info(s.conn)
+	if err != nil {
+		return 0, err
+	}
+	s.info = parseInfo(resp)
+	s.keyCount, err = keyCount(resp, s.opts.DB)
+	return s.keyCount, err
+}
+
+// Next selects a random key from the redis instance.  If the connection's ACL
+// user is not permitted to run RANDOMKEY, keys are selected by iterating over
+// the keyspace with SCAN instead (see Scanning).  In Census mode, each key is
+// selected exactly once, and io.EOF is returned once every key has been.  With
+// StrategyReservoir, io.EOF is returned once every key in the reservoir has
+// been selected.  With Options.Unique, keys that were already selected are
+// skipped, and io.EOF is returned after MaxDuplicateSelections of them in a
+// row.
+func (s *RedisKeySource) Next() (string, ValueType, error) {
+	for {
+		if len(s.selected) == 0 {
+			if err := s.selectKeys(); err != nil {
+				return "", TypeUnknown, err
+			}
+		}
+		k := s.selected[0]
+		s.selected = s.selected[1:]
+		if !s.opts.Unique || s.opts.Census || s.opts.Strategy != StrategyRandom || s.remember(k.key) {
+			s.duplicates = 0
+			return k.key, k.vt, nil
+		}
+
+		s.duplicates++
+		if s.duplicates >= MaxDuplicateSelections {
+			s.saturated = true
+			return "", TypeUnknown, io.EOF
+		}
+	}
+}
+
+// remember records that `key` was selected, and indicates whether it hadn't
+// been before.  Once MaxUniqueKeys keys have been recorded, no more are, and
+// so the rest are always treated as new.
+func (s *RedisKeySource) remember(key string) bool {
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	if s.seen[key] {
+		return false
+	}
+	if len(s.seen) < MaxUniqueKeys {
+		s.seen[key] = true
+	}
+	return true
+}
+
+// Scanning indicates whether keys are being selected with SCAN, because
+// RANDOMKEY was denied
+func (s *RedisKeySource) Scanning() bool {
+	return s.scanning
+}
+
+// selectKeys refills the buffer of selected keys, switching from RANDOMKEY to
+// SCAN if the former is denied
+func (s *RedisKeySource) selectKeys() error {
+	if s.opts.Census {
+		if s.censusDone {
+			return io.EOF
+		}
+		err := s.scanKeys()
+		if isNoPerm(err) {
+			return fmt.Errorf("A census requires the redis user to be permitted SCAN (grant it +scan): %s", err)
+		}
+		return err
+	}
+
+	if s.opts.Strategy == StrategyReservoir {
+		err := s.selectReservoir()
+		if isNoPerm(err) {
+			return fmt.Errorf("Reservoir sampling requires the redis user to be permitted SCAN (grant it +scan): %s", err)
+		}
+		return err
+	}
+
+	if s.opts.Strategy == StrategyScan {
+		err := s.selectScan()
+		if isNoPerm(err) {
+			return fmt.Errorf("StrategyScan requires the redis user to be permitted SCAN (grant it +scan): %s", err)
+		}
+		return err
+	}
+
+	if !s.scanning && len(s.opts.Slots) == 0 {
+		err := s.selectRandom()
+		if !isNoPerm(err) {
+			return err
+		}
+		s.scanning = true
+	}
+
+	err := s.scanKeys()
+	if isNoPerm(err) {
+		return fmt.Errorf("Keys cannot be sampled, since the redis user is permitted neither RANDOMKEY nor SCAN (grant it +randomkey or +scan): %s", err)
+	}
+	return err
+}
+
+// selectRandom selects one random key, or a pipelined batch of them if
+// Options.PipelineBatchSize is set
+func (s *RedisKeySource) selectRandom() error {
+	if s.opts.PipelineBatchSize > 1 {
+		return s.selectBatch(s.opts.PipelineBatchSize)
+	}
+	key, vt, err := randomKey(s.conn)
+	if err != nil {
+		return err
+	}
+	s.selected = append(s.selected, selectedKey{key: key, vt: vt})
+	return nil
+}
+
+// selectBatch selects `n` random keys, pipelining first their RANDOMKEY and
+// then their TYPE commands, so that no more than `n` commands are ever
+// outstanding
+func (s *RedisKeySource) selectBatch(n int) error {
+	for i := 0; i < n; i++ {
+		s.conn.Send("RANDOMKEY")
+	}
+	keys, err := redis.Strings(flush(s.conn))
+	if err != nil {
+		return err
+	}
+
+	return s.selectTypes(keys)
+}
+
+// selectTypes selects `keys`, pipelining their TYPE commands
+func (s *RedisKeySource) selectTypes(keys []string) error {
+	for _, key := range keys {
+		s.conn.Send("TYPE", key)
+	}
+	types, err := redis.Strings(flush(s.conn))
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		s.selected = append(s.selected, selectedKey{key: key, vt: ValueType(types[i])})
+	}
+	return nil
+}
+
+// selectReservoir selects the next batch of keys from the reservoir, filling
+// it with a full pass over the keyspace first, if need be
+func (s *RedisKeySource) selectReservoir() error {
+	if s.reservoir == nil {
+		r := newReservoir(sampleSize(s.opts, s.keyCount), s.random())
+		for {
+			keys, wrapped, err := s.scanPage()
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				r.add(key)
+			}
+			if wrapped {
+				break
+			}
+		}
+		s.reservoir = r
+	}
+
+	keys := s.reservoir.take(scanCount(s.opts))
+	if len(keys) == 0 {
+		return io.EOF
+	}
+	return s.selectTypes(keys)
+}
+
+// selectScan selects the next distinct keys returned by SCAN, keeping every
+// ScanStride'th of them, and returns io.EOF once the cursor has wrapped around
+func (s *RedisKeySource) selectScan() error {
+	for len(s.selected) == 0 {
+		if s.scanDone {
+			return io.EOF
+		}
+		keys, wrapped, err := s.scanPage()
+		if err != nil {
+			return err
+		}
+		s.scanDone = wrapped
+		keys = s.unseen(s.stride(keys))
+		if len(keys) == 0 {
+			continue
+		}
+		if err := s.selectTypes(keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stride filters out all but every ScanStride'th key visited by SCAN
+func (s *RedisKeySource) stride(keys []string) []string {
+	if s.opts.ScanStride <= 1 {
+		return keys
+	}
+	var kept []string
+	for _, key := range keys {
+		if s.visited%s.opts.ScanStride == 0 {
+			kept = append(kept, key)
+		}
+		s.visited++
+	}
+	return kept
+}
+
+// random returns the source's random number generator
+func (s *RedisKeySource) random() *rand.Rand {
+	if s.rng == nil {
+		s.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return s.rng
+}
+
+// complete indicates whether exhausting the source (see Next) means that
+// every key was selected: a reservoir is exhausted once its sample has been
+// selected, which only covers every key if the keyspace fit in the reservoir,
+// a strided scan skips keys by design, and a sample of unique random keys is
+// only likely to have covered them all
+func (s *RedisKeySource) complete() bool {
+	if s.saturated {
+		return false
+	}
+	if !s.opts.Census && s.opts.Strategy == StrategyScan && s.opts.ScanStride > 1 {
+		return false
+	}
+	return s.reservoir == nil || s.reservoir.complete()
+}
+
+// scanPage returns the next page of keys returned by SCAN (which may be
+// empty), and whether the cursor then wrapped around to the start of the
+// keyspace
+func (s *RedisKeySource) scanPage() (keys []string, wrapped bool, err error) {
+	if s.cursor == "" {
+		s.cursor = "0"
+	}
+	args := []interface{}{s.cursor, "COUNT", scanCount(s.opts)}
+	if s.opts.MatchPattern != "" {
+		args = append(args, "MATCH", s.opts.MatchPattern)
+	}
+	reply, err := s.conn.Do("SCAN", args...)
+	if err != nil {
+		return nil, false, err
+	}
+	cursor, keys, err := scanReply(reply)
+	if err != nil {
+		return nil, false, err
+	}
+	s.cursor = cursor
+	if len(s.opts.Slots) > 0 {
+		keys = s.inSlots(keys)
+	}
+	return keys, cursor == "0", nil
+}
+
+// inSlots filters out any keys that don't hash to one of Options.Slots
+func (s *RedisKeySource) inSlots(keys []string) []string {
+	var filtered []string
+	for _, key := range keys {
+		if inSlots(s.opts.Slots, key) {
+			filtered = append(filtered, key)
+		}
+	}
+	return filtered
+}
+
+// scanKeys selects the next non-empty page of keys returned by SCAN, along
+// with their (pipelined) types.  SCAN visits keys in the order of redis' hash
+// table, which is unrelated to their names, so the keys of a page are a fair
+// stand-in for randomly selected ones.  Once the cursor wraps around, keys are
+// visited again.
+func (s *RedisKeySource) scanKeys() error {
+	for len(s.selected) == 0 {
+		keys, wrapped, err := s.scanPage()
+		if err != nil {
+			return err
+		}
+		s.passKeys += len(keys)
+		if wrapped {
+			if s.opts.Census {
+				s.censusDone = true
+			} else if s.passKeys == 0 {
+				return ErrNoKeys
+			}
+			s.passKeys = 0
+		}
+		if s.opts.Census {
+			keys = s.unseen(keys)
+		}
+		if len(keys) == 0 {
+			if s.censusDone {
+				return io.EOF
+			}
+			continue
+		}
+
+		if err := s.selectTypes(keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unseen filters out any keys that have already been selected by a census,
+// recording the remainder as seen
+func (s *RedisKeySource) unseen(keys []string) []string {
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	var fresh []string
+	for _, key := range keys {
+		if !s.seen[key] {
+			s.seen[key] = true
+			fresh = append(fresh, key)
+		}
+	}
+	return fresh
+}
+
+// isWrongType indicates whether `err` is redis' reply to a command against a
+// key holding the wrong kind of value
+func isWrongType(err error) bool {
+	e, ok := err.(redis.Error)
+	return ok && strings.HasPrefix(string(e), "WRONGTYPE")
+}
+
+// isNoPerm indicates whether `err` is redis' reply to a command that the
+// connection's ACL user is not permitted to run
+func isNoPerm(err error) bool {
+	e, ok := err.(redis.Error)
+	return ok && strings.HasPrefix(string(e), "NOPERM")
+}
+
+// Fetch obtains a Sample of `key` from the redis instance.  Since a key's type
+// is obtained separately from its value, the key may have been deleted and
+// recreated with a different type in the meantime (yielding a WRONGTYPE
+// error), in which case it is resampled as its current type, once.
+func (s *RedisKeySource) Fetch(key string, vt ValueType) (Sample, error) {
+	smp, err := s.fetch(key, vt)
+	if !isWrongType(err) {
+		return smp, err
+	}
+
+	current, err := redis.String(s.conn.Do("TYPE", key))
+	if err != nil {
+		return Sample{}, err
+	}
+	smp, err = s.fetch(key, ValueType(current))
+	if isWrongType(err) {
+		return Sample{}, ErrTypeChanged
+	}
+	return smp, err
+}
+
+// fetch obtains a Sample of `key`, assuming that it holds a value of type `vt`
+func (s *RedisKeySource) fetch(key string, vt ValueType) (Sample, error) {
+	if vt == TypeNone {
+		return Sample{}, ErrKeyMissing
+	}
+
+	access := s.accessMetric()
+	if filtersLengths(s.opts) && access == AccessUnknown && !s.opts.SizeOnlyTypes[vt] {
+		if err := s.checkLength(key, vt); err != nil {
+			return Sample{}, err
+		}
+	}
+
+	var plan fetchPlan
+	var err error
+	if s.opts.SizeOnlyTypes[vt] {
+		plan, err = s.planLength(key, vt)
+	} else {
+		plan, err = s.planContents(key, vt)
+	}
+	if err != nil {
+		return Sample{}, err
+	}
+
+	// the idle time (or access frequency), encoding and TTL are pipelined
+	// along with the plan's commands, rather than costing round trips of
+	// their own.  The idle time comes first, since reading the key resets it.
+	switch access {
+	case AccessIdleTime:
+		s.conn.Send("OBJECT", "IDLETIME", key)
+	case AccessFrequency:
+		s.conn.Send("OBJECT", "FREQ", key)
+	}
+	for _, c := range plan.commands {
+		s.conn.Send(c.name, c.args...)
+	}
+	if s.opts.CollectEncodings {
+		s.conn.Send("OBJECT", "ENCODING", key)
+	}
+	if collectTTLs(s.opts) {
+		s.conn.Send("PTTL", key)
+	}
+	memory := s.collectMemoryUsage()
+	if memory {
+		s.conn.Send("MEMORY", "USAGE", key)
+	}
+	start := time.Now()
+	replies, err := flush(s.conn)
+	latency := time.Since(start)
+	if e, ok := err.(redis.Error); ok && memory && replies[len(replies)-1] == e {
+		// only MEMORY USAGE failed (e.g. it is unknown to the server, or not
+		// permitted), so fall back to estimates from now on
+		s.noMemoryUsage, memory, err = true, false, nil
+	}
+	if err != nil {
+		return Sample{}, err
+	}
+
+	var counter int64
+	if access != AccessUnknown {
+		if counter, err = redis.Int64(replies[0], nil); err == redis.ErrNil {
+			return Sample{}, ErrKeyMissing
+		} else if err != nil {
+			return Sample{}, err
+		}
+		replies = replies[1:]
+	}
+
+	smp, err := plan.parse(replies[:len(plan.commands)])
+	if err != nil {
+		return Sample{}, err
+	}
+	smp.DB = s.opts.DB
+	switch smp.Access = access; access {
+	case AccessIdleTime:
+		smp.IdleTime = time.Duration(counter) * time.Second
+	case AccessFrequency:
+		smp.Frequency = int(counter)
+	}
+	smp.Latency = latency
+	replies = replies[len(plan.commands):]
+
+	if s.opts.CollectEncodings {
+		smp.Encoding, err = redis.String(replies[0], nil)
+		if err == redis.ErrNil {
+			// the key was deleted just after its contents were read
+			return Sample{}, ErrKeyMissing
+		} else if err != nil {
+			return Sample{}, err
+		}
+		replies = replies[1:]
+	}
+
+	if collectTTLs(s.opts) {
+		ms, err := redis.Int64(replies[0], nil)
+		if err != nil {
+			return Sample{}, err
+		}
+		switch {
+		case ms == -2:
+			return Sample{}, ErrKeyMissing
+		case ms >= 0:
+			smp.Expires = true
+			smp.TTL = time.Duration(ms) * time.Millisecond
+		}
+		replies = replies[1:]
+	}
+
+	if memory {
+		bytes, err := redis.Int(replies[0], nil)
+		if err == redis.ErrNil {
+			return Sample{}, ErrKeyMissing
+		} else if err != nil {
+			return Sample{}, err
+		}
+		smp.MemoryUsage = bytes
+	}
+	return smp, nil
+}
+
+// collectMemoryUsage indicates whether `MEMORY USAGE` is to be fetched for
+// each key, i.e. it was requested, and the server is expected to support it
+func (s *RedisKeySource) collectMemoryUsage() bool {
+	return s.opts.CollectMemoryUsage && s.caps.MemoryUsage && !s.noMemoryUsage
+}
+
+// accessMetric returns the access statistic to be fetched for each key, if
+// any: the idle time, or the access frequency counter under an LFU
+// maxmemory-policy (see Options.CollectAccess), if the server supports
+// `OBJECT FREQ`
+func (s *RedisKeySource) accessMetric() AccessMetric {
+	switch {
+	case s.opts.MaxIdleTime > 0:
+		return AccessIdleTime
+	case s.opts.CollectAccess && s.lfu && s.caps.ObjectFreq:
+		return AccessFrequency
+	case s.opts.CollectAccess && s.lfu:
+		// idle times aren't tracked under LFU either
+		return AccessUnknown
+	case s.opts.CollectAccess:
+		return AccessIdleTime
+	}
+	return AccessUnknown
+}
+
+// checkLength reads the length of `key` with an O(1) command, returning
+// errOutOfRange if it lies outside the range to be observed, so that the
+// key's contents needn't be read
+func (s *RedisKeySource) checkLength(key string, vt ValueType) error {
+	plan, err := s.planLength(key, vt)
+	if err != nil {
+		return err
+	}
+	c := plan.commands[0]
+	reply, err := s.conn.Do(c.name, c.args...)
+	if err != nil {
+		return err
+	}
+	smp, err := plan.parse([]interface{}{reply})
+	if err != nil {
+		return err
+	}
+	if !inLengthRange(s.opts, smp.Length) {
+		return errOutOfRange
+	}
+	return nil
+}
+
+// command is a redis command (and its arguments) to be pipelined
+type command struct {
+	name string
+	args []interface{}
+}
+
+// A fetchPlan describes how a key is sampled: its commands are pipelined, so
+// that the key is sampled in a single round trip, and parse builds the Sample
+// from their replies.  Reading a collection's length and elements together
+// also narrows the window in which the key can change between the two, so
+// that the length and the sampled elements are consistent, without the cost
+// of wrapping them in MULTI/EXEC.
+type fetchPlan struct {
+	commands []command
+	parse    func(replies []interface{}) (Sample, error)
+}
+
+// planContents plans to sample `key` including a number of its elements (or
+// its value), using the following commands for each data type:
+//
+//   - strings: GET, which also yields the length
+//   - lists: LLEN and LRANGE, since lists can only be read by index (from
+//     each end, when Options.ListEnds is set)
+//   - sets: SCARD and SRANDMEMBER with a positive count, which returns
+//     distinct members
+//   - sorted sets: ZCARD and ZRANDMEMBER with a positive count (redis >=
+//     6.2), otherwise ZRANGE, which only reads the lowest-ranked members
+//   - hashes: HLEN and HRANDFIELD with WITHVALUES (redis >= 6.2), otherwise
+//     HSCAN, which reads a page of fields rather than the whole hash (unlike
+//     HGETALL), but may need further round trips over a sparse hash table
+//
+// The length commands are all O(1), and the element commands read at most
+// (roughly) Options.ElementsPerKey elements.  With an Options.ElementStrategy
+// of StrategyReservoir, sets, sorted sets and hashes are instead walked with
+// their SCAN command (see planElementScan).
+func (s *RedisKeySource) planContents(key string, vt ValueType) (fetchPlan, error) {
+	count := elementsPerKey(s.opts)
+	if cmd, ok := scanCommands[vt]; ok && s.opts.ElementStrategy == StrategyReservoir {
+		return s.planElementScan(key, vt, cmd, count), nil
+	}
+	switch vt {
+	case TypeString:
+		return s.planString(key), nil
+	case TypeList:
+		if s.opts.ListEnds {
+			return s.planListEnds(key, count), nil
+		}
+		// TODO: Let's not always get the first elements, like the orig. reckon
+		return s.planElements(key, vt, "LLEN", "LRANGE", 0, count-1), nil
+	case TypeSet:
+		return s.planElements(key, vt, "SCARD", "SRANDMEMBER", count), nil
+	case TypeSortedSet:
+		if s.caps.HRandField {
+			return s.planElements(key, vt, "ZCARD", "ZRANDMEMBER", count), nil
+		}
+		return s.planElements(key, vt, "ZCARD", "ZRANGE", 0, count-1), nil
+	case TypeHash:
+		if s.caps.HRandField {
+			return s.planElements(key, vt, "HLEN", "HRANDFIELD", count, "WITHVALUES"), nil
+		}
+		return s.planHashScan(key, count), nil
+	}
+	return fetchPlan{}, fmt.Errorf("unknown type for redis key: %s", key)
+}
+
+// lengthCommands holds the O(1) command used to obtain the length of a key of
+// each data type
+var lengthCommands = map[ValueType]string{
+	TypeString:    "STRLEN",
+	TypeList:      "LLEN",
+	TypeSet:       "SCARD",
+	TypeSortedSet: "ZCARD",
+	TypeHash:      "HLEN",
+}
+
+// planLength plans to sample only the length of `key`, without any of its
+// contents
+func (s *RedisKeySource) planLength(key string, vt ValueType) (fetchPlan, error) {
+	cmd, ok := lengthCommands[vt]
+	if !ok {
+		return fetchPlan{}, fmt.Errorf("unknown type for redis key: %s", key)
+	}
+	return fetchPlan{
+		commands: []command{{cmd, []interface{}{key}}},
+		parse: func(replies []interface{}) (Sample, error) {
+			l, err := redis.Int(replies[0], nil)
+			if err != nil {
+				return Sample{}, err
+			}
+			if l == 0 && vt != TypeString {
+				// redis never stores empty collections, so the key is gone.  (An
+				// empty string can't be told apart from a missing one, so is
+				// counted.)
+				return Sample{}, ErrKeyMissing
+			}
+			return Sample{Key: key, Type: vt, Length: l}, nil
+		},
+	}, nil
+}
+
+// planString plans to sample the value of a string.  The value is read as raw
+// bytes, so that binary values (e.g. serialized protobufs) are measured and
+// kept byte-for-byte; it is only interpreted as text when rendered (see
+// printable).  Strings whose contents aren't needed are measured with STRLEN
+// instead (see planLength).
+func (s *RedisKeySource) planString(key string) fetchPlan {
+	return fetchPlan{
+		commands: []command{{"GET", []interface{}{key}}},
+		parse: func(replies []interface{}) (Sample, error) {
+			val, err := redis.Bytes(replies[0], nil)
+			if err == redis.ErrNil {
+				return Sample{}, ErrKeyMissing
+			} else if err != nil {
+				return Sample{}, err
+			}
+			return Sample{Key: key, Type: TypeString, Length: len(val), Value: string(val)}, nil
+		},
+	}
+}
+
+// planElements plans to sample the length of a collection, along with a
+// number of its elements, using the supplied length and element commands
+func (s *RedisKeySource) planElements(key string, vt ValueType, lenCmd string, elemCmd string, elemArgs ...interface{}) fetchPlan {
+	return fetchPlan{
+		commands: []command{
+			{lenCmd, []interface{}{key}},
+			{elemCmd, append([]interface{}{key}, elemArgs...)},
+		},
+		parse: func(replies []interface{}) (Sample, error) {
+			l, err := redis.Int(replies[0], nil)
+			elems, err := redis.Strings(replies[1], err)
+			if err != nil {
+				return Sample{}, err
+			}
+			if l == 0 || len(elems) == 0 {
+				// redis never stores empty collections, so the key is gone
+				return Sample{}, ErrKeyMissing
+			}
+			return Sample{Key: key, Type: vt, Length: l, Elements: elems}, nil
+		},
+	}
+}
+
+// planListEnds plans to sample the length of a list, along with `count`
+// elements from each of its ends
+func (s *RedisKeySource) planListEnds(key string, count int) fetchPlan {
+	return fetchPlan{
+		commands: []command{
+			{"LLEN", []interface{}{key}},
+			{"LRANGE", []interface{}{key, 0, count - 1}},
+			{"LRANGE", []interface{}{key, -count, -1}},
+		},
+		parse: func(replies []interface{}) (Sample, error) {
+			l, err := redis.Int(replies[0], nil)
+			head, err := redis.Strings(replies[1], err)
+			tail, err := redis.Strings(replies[2], err)
+			if err != nil {
+				return Sample{}, err
+			}
+			if l == 0 || len(head) == 0 || len(tail) == 0 {
+				// redis never stores empty lists, so the key is gone
+				return Sample{}, ErrKeyMissing
+			}
+			return Sample{Key: key, Type: TypeList, Length: l, Elements: head, TailElements: tail}, nil
+		},
+	}
+}
+
+// maxElementScans bounds the number of HSCAN calls made while looking for a
+// hash field to sample, since a sparse hash table can yield empty pages
+const maxElementScans = 10
+
+// planHashScan plans to sample a hash with HSCAN, for servers that lack
+// HRANDFIELD.  The first page is pipelined along with HLEN; further pages are
+// only read if the first is empty.
+func (s *RedisKeySource) planHashScan(key string, count int) fetchPlan {
+	return fetchPlan{
+		commands: []command{
+			{"HLEN", []interface{}{key}},
+			{"HSCAN", []interface{}{key, "0", "COUNT", count}},
+		},
+		parse: func(replies []interface{}) (Sample, error) {
+			l, err := redis.Int(replies[0], nil)
+			if err != nil {
+				return Sample{}, err
+			}
+			if l == 0 {
+				return Sample{}, ErrKeyMissing
+			}
+
+			reply := replies[1]
+			for i := 0; ; i++ {
+				cursor, pairs, err := scanReply(reply)
+				if err != nil {
+					return Sample{}, err
+				}
+				if len(pairs) >= 2 {
+					return Sample{Key: key, Type: TypeHash, Length: l, Elements: pairs}, nil
+				}
+				if cursor == "0" {
+					return Sample{}, ErrKeyMissing
+				}
+				if i == maxElementScans {
+					// give up on sampling a field, rather than scan the whole hash
+					return Sample{Key: key, Type: TypeHash, Length: l}, nil
+				}
+				reply, err = s.conn.Do("HSCAN", key, cursor, "COUNT", count)
+				if err != nil {
+					return Sample{}, err
+				}
+			}
+		},
+	}
+}
+
+// scanCommands holds the command used to walk a collection of each data type
+// when sampling elements with StrategyReservoir
+var scanCommands = map[ValueType]string{
+	TypeSet:       "SSCAN",
+	TypeSortedSet: "ZSCAN",
+	TypeHash:      "HSCAN",
+}
+
+// planElementScan plans to sample `count` elements of a collection uniformly
+// at random, by walking it with `scanCmd` (SSCAN, ZSCAN or HSCAN) and keeping
+// a reservoir of them (see reservoir).  The walk stops after (roughly)
+// Options.MaxElementsPerKey distinct elements, or maxElementScans further
+// round trips, whichever comes first.  The first page is pipelined along with
+// the collection's length.  Hash fields are sampled along with their values,
+// and sorted set members without their scores.
+func (s *RedisKeySource) planElementScan(key string, vt ValueType, scanCmd string, count int) fetchPlan {
+	limit := s.opts.MaxElementsPerKey
+	if limit == 0 {
+		limit = DefaultMaxElementsPerKey
+	}
+	return fetchPlan{
+		commands: []command{
+			{lengthCommands[vt], []interface{}{key}},
+			{scanCmd, []interface{}{key, "0", "COUNT", limit}},
+		},
+		parse: func(replies []interface{}) (Sample, error) {
+			l, err := redis.Int(replies[0], nil)
+			if err != nil {
+				return Sample{}, err
+			}
+			if l == 0 {
+				return Sample{}, ErrKeyMissing
+			}
+
+			r := newReservoir(count, s.random())
+			var values []string
+			var cursor string
+			reply := replies[1]
+			for i := 0; ; i++ {
+				var page []string
+				cursor, page, err = scanReply(reply)
+				if err != nil {
+					return Sample{}, err
+				}
+				if vt == TypeSet {
+					for _, member := range page {
+						r.add(member)
+					}
+				} else {
+					for j := 0; j+1 < len(page); j += 2 {
+						switch k := r.add(page[j]); {
+						case k == len(values):
+							values = append(values, page[j+1])
+						case k >= 0:
+							values[k] = page[j+1]
+						}
+					}
+				}
+				if cursor == "0" || len(r.seen) >= limit || i == maxElementScans {
+					break
+				}
+				reply, err = s.conn.Do(scanCmd, key, cursor, "COUNT", limit)
+				if err != nil {
+					return Sample{}, err
+				}
+			}
+
+			if len(r.keys) == 0 {
+				if cursor == "0" {
+					return Sample{}, ErrKeyMissing
+				}
+				// give up on sampling an element, rather than walk the whole
+				// collection
+				return Sample{Key: key, Type: vt, Length: l}, nil
+			}
+			smp := Sample{Key: key, Type: vt, Length: l, Elements: r.keys}
+			if vt == TypeHash {
+				smp.Elements = make([]string, 0, 2*len(r.keys))
+				for j, field := range r.keys {
+					smp.Elements = append(smp.Elements, field, values[j])
+				}
+			}
+			return smp, nil
+		},
+	}
+}
+
+// scanReply parses the reply to one of redis' SCAN family of commands,
+// returning the next cursor and the page of results
+func scanReply(reply interface{}) (cursor string, page []string, err error) {
+	values, err := redis.Values(reply, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(values) != 2 {
+		return "", nil, fmt.Errorf("Unexpected SCAN reply of length %d", len(values))
+	}
+	if cursor, err = redis.String(values[0], nil); err != nil {
+		return "", nil, err
+	}
+	page, err = redis.Strings(values[1], nil)
+	return cursor, page, err
+}
@@ -0,0 +1,220 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// sliceSource is a KeySource over a fixed list of Samples, returned in order
+type sliceSource struct {
+	samples []Sample
+	next    int
+}
+
+func (s *sliceSource) KeyCount() (int64, error) {
+	return int64(len(s.samples)), nil
+}
+
+func (s *sliceSource) Next() (string, ValueType, error) {
+	if s.next >= len(s.samples) {
+		return "", TypeUnknown, io.EOF
+	}
+	smp := s.samples[s.next]
+	s.next++
+	return smp.Key, smp.Type, nil
+}
+
+func (s *sliceSource) Fetch(key string, vt ValueType) (Sample, error) {
+	for _, smp := range s.samples {
+		if smp.Key == key {
+			return smp, nil
+		}
+	}
+	return Sample{}, ErrKeyMissing
+}
+
+func TestRunSource(t *testing.T) {
+
+	src := &sliceSource{samples: []Sample{
+		{Key: "s", Type: TypeString, Length: 5, Value: "value", Encoding: "embstr"},
+		{Key: "l", Type: TypeList, Length: 3, Elements: []string{"abcd"}},
+		{Key: "h", Type: TypeHash, Length: 2, Elements: []string{"field", "value"}},
+	}}
+
+	// more samples are requested than the source holds, so sampling stops once
+	// it is exhausted
+	stats, summary, err := RunSource(src, Options{MinSamples: 10}, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 3, int(summary.KeyCount))
+	assertInt(t, 3, summary.Sampled)
+
+	r := stats["any-key"]
+	assertInt(t, 3, int(r.KeyCount))
+	assertInt(t, 1, int(r.StringSizes[5]))
+	assertInt(t, 1, int(r.ListSizes[3]))
+	assertInt(t, 1, int(r.ListElementSizes[4]))
+	assertInt(t, 1, int(r.HashElementSizes[5]))
+	assertInt(t, 5, int(r.Encodings[TypeString]["embstr"].Bytes))
+}
+
+func TestSampleSize(t *testing.T) {
+
+	assertInt(t, 5, Sample{Type: TypeString, Length: 5, Value: "value"}.Size())
+	assertInt(t, 12, Sample{Type: TypeSet, Length: 3, Elements: []string{"abcd"}}.Size())
+	assertInt(t, 20, Sample{Type: TypeHash, Length: 2, Elements: []string{"field", "value"}}.Size())
+	assertInt(t, 30, Sample{Type: TypeList, Length: 10, Elements: []string{"ab", "abcd"}}.Size())
+	assertInt(t, 0, Sample{Type: TypeHash, Length: 2, Elements: []string{"field"}}.Size())
+}
+
+func TestRunSourceProgress(t *testing.T) {
+
+	samples := make([]Sample, 5)
+	for i := range samples {
+		samples[i] = Sample{Key: string(rune('a' + i)), Type: TypeString, Length: 1, Value: "v"}
+	}
+
+	var reports []Progress
+	opts := Options{
+		MinSamples:    5,
+		ProgressEvery: 2,
+		ProgressFunc:  func(p Progress) { reports = append(reports, p) },
+	}
+	if _, _, err := RunSource(&sliceSource{samples: samples}, opts, AggregatorFunc(AnyKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the key count is announced before sampling starts
+	assertInt(t, 3, len(reports))
+	assertInt(t, 0, reports[0].Attempted)
+	assertInt(t, 5, int(reports[0].KeyCount))
+	assertInt(t, 2, reports[1].Attempted)
+	assertInt(t, 4, reports[2].Attempted)
+	assertInt(t, 5, reports[2].Target)
+
+	// headless runs print nothing to stdout
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	_, _, err = RunSource(&sliceSource{samples: samples}, opts, AggregatorFunc(AnyKey))
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := io.ReadAll(r); len(out) > 0 {
+		t.Errorf("expected no output, actual: %q", out)
+	}
+}
+
+func TestRunSourceConfidence(t *testing.T) {
+
+	samples := make([]Sample, 10)
+	for i := range samples {
+		samples[i] = Sample{Key: string(rune('a' + i)), Type: TypeString, Length: 1, Value: "v"}
+	}
+
+	opts := Options{MinSamples: 2, MinCoverage: 0.5, MinGroupSamples: 5}
+	stats, summary, err := RunSource(&sliceSource{samples: samples}, opts, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Coverage() != 0.2 || len(summary.Warnings) != 1 {
+		t.Errorf("expected 20%% coverage with a warning, actual: %f, %v", summary.Coverage(), summary.Warnings)
+	}
+	r := stats["any-key"]
+	if len(r.Warnings) != 1 || !r.LowConfidence() {
+		t.Errorf("expected the group to be flagged, actual: %v, %t", r.Warnings, r.LowConfidence())
+	}
+
+	var out bytes.Buffer
+	if err := RenderText(r, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "WARNING: fewer than 5 keys") {
+		t.Errorf("expected a low confidence warning, actual: %s", out.String())
+	}
+
+	// negative thresholds disable the checks
+	opts = Options{MinSamples: 2, MinCoverage: -1, MinGroupSamples: -1}
+	stats, summary, err = RunSource(&sliceSource{samples: samples}, opts, AggregatorFunc(AnyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Warnings) != 0 || stats["any-key"].LowConfidence() {
+		t.Errorf("expected no warnings, actual: %v", summary.Warnings)
+	}
+}
+
+func TestRunSourceMinGroupCount(t *testing.T) {
+
+	var samples []Sample
+	for i, key := range []string{"a:1", "a:2", "a:3", "b:1", "c:1"} {
+		samples = append(samples, Sample{Key: key, Type: TypeString, Length: i, Value: strings.Repeat("v", i)})
+	}
+	prefix := AggregatorFunc(func(key string, vt ValueType) []string {
+		return []string{key[:1]}
+	})
+
+	stats, summary, err := RunSource(&sliceSource{samples: samples}, Options{MinSamples: 5, MinGroupCount: 2}, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 1, len(stats))
+	assertInt(t, 3, int(stats["a"].KeyCount))
+	assertInt(t, 2, summary.PrunedGroups)
+
+	stats["empty"] = NewResults()
+	if !stats["empty"].IsEmpty() || stats["a"].IsEmpty() {
+		t.Error("expected only the new group to be empty")
+	}
+	assertInt(t, 1, Prune(stats, 0))
+	assertInt(t, 0, Prune(stats, 3))
+	assertInt(t, 1, Prune(stats, 4))
+	assertInt(t, 0, len(stats))
+}
+
+func TestRunSourceContext(t *testing.T) {
+
+	src := &sliceSource{samples: []Sample{
+		{Key: "a", Type: TypeString, Length: 1, Value: "v"},
+		{Key: "b", Type: TypeString, Length: 1, Value: "v", DB: 3},
+	}}
+	source := ContextAggregatorFunc(func(ctx SampleContext) []string {
+		return []string{fmt.Sprintf("%s/db%d", ctx.Instance, ctx.DB)}
+	})
+
+	stats, _, err := RunSource(src, Options{MinSamples: 2, Tag: "shard-1"}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 1, int(stats["shard-1/db0"].KeyCount))
+	assertInt(t, 1, int(stats["shard-1/db3"].KeyCount))
+
+	// without a context, the function sees only the key and type
+	assertGroups(t, []string{"/db0"}, source.Groups("k", TypeString))
+}
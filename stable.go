@@ -0,0 +1,104 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// StableDigits is the number of significant digits to which RenderStable
+// rounds means and estimated sizes
+const StableDigits = 2
+
+// RenderStable writes the statistics for every aggregation group in `stats` to
+// the supplied io.Writer in a canonical plain-text format, intended to be
+// committed to version control and diffed, e.g. to review whether a release
+// changed the shape of the keyspace.  The output is deterministic: groups are
+// ordered by name, data types and fields always appear in the same order, and
+// only statistics that describe the shape of the data are included (not, e.g.,
+// example keys, or the server's memory usage at the time).  Since sampled
+// statistics jitter from run to run, means and estimated sizes are rounded to
+// StableDigits significant digits, and shares of the keys to whole
+// percentages, so that only significant changes show up in a diff.  Key
+// counts are exact.
+func RenderStable(stats map[string]*Results, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for i, gr := range Ordered(stats, ByGroup) {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		writeStable(bw, gr.Group, gr.Results.Clone())
+	}
+	return bw.Flush()
+}
+
+// writeStable writes a single group's statistics for RenderStable
+func writeStable(w io.Writer, group string, r *Results) {
+	fmt.Fprintf(w, "group %s\n", strconv.Quote(group))
+	fmt.Fprintf(w, "  keys %d\n", r.KeyCount)
+	fmt.Fprintf(w, "  bytes %s\n", stableFloat(float64(r.estimatedBytes())))
+
+	for _, ts := range r.types() {
+		share := math.Round(100 * float64(ts.Keys) / float64(r.KeyCount))
+		fmt.Fprintf(w, "  %s keys %d (%d%%) length %s", ts.Type, ts.Keys, int(share), stableFloat(ts.Lengths.Mean))
+		if ts.Values > 0 {
+			fmt.Fprintf(w, " value-size %s", stableFloat(ts.ValueSizes.Mean))
+		}
+		fmt.Fprintln(w)
+	}
+
+	for _, vt := range valueTypes {
+		encodings := make([]string, 0, len(r.Encodings[vt]))
+		for enc := range r.Encodings[vt] {
+			encodings = append(encodings, enc)
+		}
+		sort.Strings(encodings)
+		for _, enc := range encodings {
+			fmt.Fprintf(w, "  %s encoding %s keys %d\n", vt, enc, r.Encodings[vt][enc].Keys)
+		}
+	}
+
+	if len(r.BigKeys) > 0 {
+		fmt.Fprintf(w, "  big-keys %d\n", len(r.BigKeys))
+	}
+}
+
+// stableFloat formats `f` rounded to StableDigits significant digits, without
+// an exponent, or as "-" if it is NaN
+func stableFloat(f float64) string {
+	if math.IsNaN(f) {
+		return "-"
+	}
+	if f == 0 {
+		return "0"
+	}
+	// scale by an exact power of ten, so that rounding adds no noise digits
+	e := StableDigits - int(math.Ceil(math.Log10(math.Abs(f))))
+	if e >= 0 {
+		p := math.Pow10(e)
+		f = math.Round(f*p) / p
+	} else {
+		p := math.Pow10(-e)
+		f = math.Round(f/p) * p
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestStableFloat(t *testing.T) {
+	for f, expected := range map[float64]string{
+		0:          "0",
+		4:          "4",
+		12.34:      "12",
+		0.4567:     "0.46",
+		99.6:       "100",
+		123456.7:   "120000",
+		-1.234:     "-1.2",
+		math.NaN(): "-",
+	} {
+		if actual := stableFloat(f); actual != expected {
+			t.Errorf("%v: expected %q, actual: %q", f, expected, actual)
+		}
+	}
+}
+
+func TestRenderStable(t *testing.T) {
+
+	users, sessions := NewResults(), NewResults()
+	users.observeString("u1", "alice")
+	users.observeString("u2", "bob")
+	users.observeHash("u3", 3, "name", "carol")
+	users.observeEncoding(TypeString, "embstr", 5)
+	users.observeEncoding(TypeHash, "listpack", 27)
+	sessions.observeString("s1", "token1")
+	sessions.observeBigKey(BigKey{Key: "s1", Type: TypeString, Length: 6, Size: 6})
+	stats := map[string]*Results{"users": users, "sessions": sessions}
+
+	var buf bytes.Buffer
+	if err := RenderStable(stats, &buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `group "sessions"
+  keys 1
+  bytes 6
+  string keys 1 (100%) length 6 value-size 6
+  big-keys 1
+
+group "users"
+  keys 3
+  bytes 35
+  string keys 2 (67%) length 4 value-size 4
+  hash keys 1 (33%) length 3 value-size 5
+  string encoding embstr keys 1
+  hash encoding listpack keys 1
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\nactual:\n%s", expected, buf.String())
+	}
+
+	// the output is deterministic
+	var again bytes.Buffer
+	if err := RenderStable(stats, &again); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != buf.String() {
+		t.Error("expected the same output for the same results")
+	}
+}
@@ -16,7 +16,14 @@
 
 package reckon
 
-import "math"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
 
 const (
 	// MaxExampleKeys sets an upper bound on the number of example keys that will
@@ -28,6 +35,9 @@ const (
 	// MaxExampleValues sets an upper bound on the number of example values that
 	// will be captured during sampling
 	MaxExampleValues = 10
+	// MaxBigKeys sets an upper bound on the number of big keys that will be
+	// recorded during sampling; only the largest are kept
+	MaxBigKeys = 100
 )
 
 // Statistics are basic descriptive statistics that summarize data in a frequency table
@@ -36,6 +46,10 @@ type Statistics struct {
 	Min    int
 	Max    int
 	StdDev float64
+
+	// Gini is the Gini coefficient of the data: 0 when every value is the
+	// same, approaching 1 as the total is concentrated in a handful of values
+	Gini float64
 }
 
 // NewStatistics creates a new zero-valued Statistics instance
@@ -43,6 +57,7 @@ func NewStatistics() *Statistics {
 	return &Statistics{
 		Mean:   math.NaN(),
 		StdDev: math.NaN(),
+		Gini:   math.NaN(),
 	}
 }
 
@@ -73,6 +88,31 @@ func ComputePowerOfTwoFreq(m map[int]int64) map[int]int64 {
 	return pf
 }
 
+// OverflowBucket is the map key used by ComputeBucketFreq for values that are
+// greater than the largest bucket boundary.
+const OverflowBucket = math.MaxInt32
+
+// ComputeBucketFreq converts a frequency map into a new frequency map, where
+// each map key is the smallest of the (ascending) bucket `boundaries` that is
+// greater than or equal to the original map key.  Map keys that are greater
+// than every boundary are counted under OverflowBucket.  If no boundaries are
+// supplied, this is equivalent to ComputePowerOfTwoFreq.
+func ComputeBucketFreq(m map[int]int64, boundaries []int) map[int]int64 {
+	if len(boundaries) == 0 {
+		return ComputePowerOfTwoFreq(m)
+	}
+
+	bf := make(map[int]int64)
+	for k, v := range m {
+		b := OverflowBucket
+		if i := sort.SearchInts(boundaries, k); i < len(boundaries) {
+			b = boundaries[i]
+		}
+		bf[b] += v
+	}
+	return bf
+}
+
 // ComputeStatistics computes basic descriptive statistics about a frequency map
 func ComputeStatistics(m map[int]int64) Statistics {
 	stats := NewStatistics()
@@ -107,7 +147,31 @@ func ComputeStatistics(m map[int]int64) Statistics {
 		Min:    min,
 		Max:    max,
 		StdDev: math.Sqrt(sd / float64(count-1)),
+		Gini:   gini(m),
+	}
+}
+
+// gini computes the Gini coefficient of a (non-empty) frequency map, from the
+// rank-weighted sum of its values in ascending order
+func gini(m map[int]int64) float64 {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
+	sort.Ints(keys)
+
+	var n, total, weighted float64
+	for _, k := range keys {
+		x, c := float64(k), float64(m[k])
+		// the values ranked n+1 through n+c all equal x
+		weighted += x * (c*n + c*(c+1)/2)
+		total += x * c
+		n += c
+	}
+	if total == 0 {
+		return 0
+	}
+	return 2*weighted/(n*total) - (n+1)/n
 }
 
 // add adds `elem` to the "set" (a map[<type>]bool is an idiomatic golang "set") if the
@@ -123,10 +187,39 @@ func add(set map[string]bool, elem string, maxsize int) {
 // lengths/sizes, while map values represent the frequency with which those
 // lengths/sizes occurred in the sampled data. Example keys are stored in
 // golang "sets", which are maps with bool values.
+//
+// A Results is safe for concurrent use by multiple goroutines: observations
+// and calls to Merge are serialized by an internal mutex.  The exported fields
+// should only be read directly once all observations and merges are complete.
 type Results struct {
+	mu sync.Mutex
+
 	Name     string
 	KeyCount int64
 
+	// Buckets holds the (ascending) histogram bucket boundaries used when
+	// reporting on these results, as configured via Options.SizeBuckets.  The
+	// frequency tables below always record exact sizes, so that they can be
+	// re-bucketed at will; when Buckets is empty, reports use power-of-two
+	// buckets.
+	Buckets []int
+
+	// Instances holds the number of sampled keys contributed by each tagged
+	// redis instance (see Options.Tag)
+	Instances map[string]int64
+
+	// KeyNameSizes holds the distribution of the lengths of the sampled keys'
+	// names, regardless of data type
+	KeyNameSizes map[int]int64
+
+	// For each data type, the *Sizes fields hold the distribution of the
+	// lengths of the sampled keys: the size in bytes of string values, but the
+	// cardinality (the number of elements, as reported by LLEN, SCARD, ZCARD
+	// and HLEN) of collections, distinguishing e.g. many single-field hashes
+	// from a few with a million fields.  The *ElementSizes and *ValueSizes
+	// fields hold the distribution of the sizes in bytes of the sampled
+	// elements and hash values, respectively.
+
 	// Strings
 	StringSizes  map[int]int64
 	StringKeys   map[string]bool
@@ -157,36 +250,173 @@ type Results struct {
 	ListElementSizes map[int]int64
 	ListKeys         map[string]bool
 	ListElements     map[string]bool
+
+	// ListHeadElementSizes and ListTailElementSizes hold the distributions of
+	// the sizes of the elements nearest each end of the sampled lists, only
+	// populated when sampling with Options.ListEnds
+	ListHeadElementSizes map[int]int64
+	ListTailElementSizes map[int]int64
+
+	// Encodings is a cross-tabulation of redis data type and internal encoding
+	// (e.g. "listpack" or "hashtable"), only populated when sampling with
+	// Options.CollectEncodings.
+	Encodings map[ValueType]map[string]*EncodingStats
+
+	// EncodingThresholds holds the compact encoding thresholds configured on
+	// the redis instance, if known (see Options.EncodingThresholds), for which
+	// reports make Recommendations
+	EncodingThresholds map[ValueType]EncodingThreshold
+
+	// Server describes the redis instance that the results were sampled from,
+	// if known.  When results from different instances are merged, it describes
+	// them as a whole (see ServerInfo).
+	Server *ServerInfo
+
+	// TopK is the number of most frequent values to be tracked for each data
+	// type, in TopValues: string values, hash values, and the members of
+	// lists, sets and sorted sets.  When zero, no values are tracked.
+	TopK int
+
+	// TopValues summarizes the most frequently observed values of each data
+	// type, when TopK is set
+	TopValues map[ValueType]*TopValues
+
+	// BigKeys lists the largest sampled keys that exceeded
+	// Options.BigKeyThreshold, largest first (at most MaxBigKeys of them)
+	BigKeys []BigKey
+
+	// Exact indicates that every key in the keyspace was observed (see
+	// Options.Census), so that the results are exact rather than estimated
+	// from a sample
+	Exact bool
+
+	// MinGroupSamples is the number of sampled keys below which the results
+	// are considered too small a sample to be meaningful (see LowConfidence)
+	MinGroupSamples int
+
+	// Warnings holds caveats that apply to the interpretation of the results,
+	// e.g. that only a tiny fraction of the keyspace was sampled
+	Warnings []string
+
+	// ShortLivedSizes holds the distribution of the estimated sizes (in
+	// bytes) of the sampled keys that were due to expire within ShortTTL,
+	// only populated when sampling with Options.ShortTTL
+	ShortTTL        time.Duration
+	ShortLivedSizes map[int]int64
+
+	// TrivialKeys is the number of sampled keys that were excluded from the
+	// statistics (and from KeyCount) for being smaller than MinSize bytes,
+	// only counted when sampling with Options.MinSize
+	MinSize     int
+	TrivialKeys int64
+
+	// Persistent and Expiring are the numbers of sampled keys without and
+	// with an expiry, and TTLs holds the distribution of the remaining times
+	// to live (in seconds) of the latter (see TTLDistribution), only
+	// populated when sampling with Options.CollectTTLs
+	Persistent int64
+	Expiring   int64
+	TTLs       map[int]int64
+
+	// FetchLatencies holds the distribution of the times taken to fetch the
+	// sampled keys, in microseconds, only populated when sampling with
+	// Options.CollectLatencies
+	FetchLatencies map[int]int64
+
+	// IdleTimes and AccessFrequencies hold the distributions of the idle times
+	// (in seconds) or the access frequency counters of the sampled keys,
+	// depending on the maxmemory-policy (see AccessMetric), only populated
+	// when sampling with Options.CollectAccess.  TemperatureThresholds
+	// determine how they are classified (see Results.Temperature).
+	IdleTimes             map[int]int64
+	AccessFrequencies     map[int]int64
+	TemperatureThresholds TemperatureThresholds
+
+	// StoredValueSizes and LogicalValueSizes hold the distributions of the
+	// sizes (in bytes) of the sampled values as stored, and as transformed by
+	// Options.ValueTransform (e.g. decompressed), only populated when sampling
+	// with Options.CollectCompression
+	StoredValueSizes  map[int]int64
+	LogicalValueSizes map[int]int64
+
+	// MemoryUsages holds the distribution of the memory used by the sampled
+	// keys (in bytes), as reported by `MEMORY USAGE`, only populated when
+	// sampling with Options.CollectMemoryUsage.  MemoryEstimated is set if
+	// any of them were instead estimated from the keys' contents (see
+	// Sample.Size), e.g. because the server doesn't support the command, in
+	// which case redis' overheads are unaccounted for.
+	MemoryUsages    map[int]int64
+	MemoryEstimated bool
+
+	// Provenance labels the aggregator and configuration that produced the
+	// results (see Options.Provenance and Label), if known
+	Provenance string
 }
 
-// NewResults constructs a new, zero-valued Results struct
-func NewResults() *Results {
-	return &Results{
-		StringSizes:  make(map[int]int64),
-		StringKeys:   make(map[string]bool),
-		StringValues: make(map[string]bool),
+// BigKey describes a sampled key whose estimated size exceeded the configured
+// threshold.
+type BigKey struct {
+	Key  string
+	Type ValueType
 
-		SetSizes:        make(map[int]int64),
-		SetElementSizes: make(map[int]int64),
-		SetKeys:         make(map[string]bool),
-		SetElements:     make(map[string]bool),
+	// Length is the length of the value (bytes for strings, elements for
+	// collections), and Size the estimated size in bytes
+	Length int
+	Size   int64
+}
 
-		SortedSetSizes:        make(map[int]int64),
-		SortedSetElementSizes: make(map[int]int64),
-		SortedSetKeys:         make(map[string]bool),
-		SortedSetElements:     make(map[string]bool),
+// EncodingStats summarizes the sampled keys of a single redis data type that
+// share the same internal encoding.
+type EncodingStats struct {
+	// Keys is the number of sampled keys with this encoding
+	Keys int64
 
-		HashSizes:        make(map[int]int64),
-		HashElementSizes: make(map[int]int64),
-		HashValueSizes:   make(map[int]int64),
-		HashKeys:         make(map[string]bool),
-		HashElements:     make(map[string]bool),
-		HashValues:       make(map[string]bool),
+	// Bytes is the estimated total size of the sampled keys with this encoding.
+	// For collections, the size of a key is estimated by multiplying its length
+	// by the size of its sampled element(s).
+	Bytes int64
+}
 
-		ListSizes:        make(map[int]int64),
-		ListElementSizes: make(map[int]int64),
-		ListKeys:         make(map[string]bool),
-		ListElements:     make(map[string]bool),
+// NewResults constructs a new, zero-valued Results struct
+func NewResults() *Results {
+	r := &Results{}
+	r.init()
+	return r
+}
+
+// init allocates any of the Results' maps that are nil, so that a Results
+// that wasn't created by NewResults (see Options.ResultsFactory) can be
+// observed into
+func (r *Results) init() {
+	if r.Instances == nil {
+		r.Instances = make(map[string]int64)
+	}
+	for _, freq := range []*map[int]int64{
+		&r.KeyNameSizes, &r.StringSizes, &r.SetSizes, &r.SetElementSizes,
+		&r.SortedSetSizes, &r.SortedSetElementSizes, &r.HashSizes,
+		&r.HashElementSizes, &r.HashValueSizes, &r.ListSizes, &r.ListElementSizes,
+		&r.ListHeadElementSizes, &r.ListTailElementSizes, &r.ShortLivedSizes,
+		&r.FetchLatencies, &r.IdleTimes, &r.TTLs, &r.AccessFrequencies,
+		&r.StoredValueSizes, &r.LogicalValueSizes, &r.MemoryUsages,
+	} {
+		if *freq == nil {
+			*freq = make(map[int]int64)
+		}
+	}
+	for _, set := range []*map[string]bool{
+		&r.StringKeys, &r.StringValues, &r.SetKeys, &r.SetElements, &r.SortedSetKeys,
+		&r.SortedSetElements, &r.HashKeys, &r.HashElements, &r.HashValues,
+		&r.ListKeys, &r.ListElements,
+	} {
+		if *set == nil {
+			*set = make(map[string]bool)
+		}
+	}
+	if r.Encodings == nil {
+		r.Encodings = make(map[ValueType]map[string]*EncodingStats)
+	}
+	if r.TopValues == nil {
+		r.TopValues = make(map[ValueType]*TopValues)
 	}
 }
 
@@ -237,11 +467,194 @@ func trimAndSum(m map[int]int64, threshold float64) int64 {
 	return s
 }
 
+// ErrIncompatibleResults is returned by Merge when the two Results were
+// sampled with different configurations, so that merging them would produce
+// misleading statistics.
+var ErrIncompatibleResults = errors.New("Results are incompatible")
+
 // Merge adds the results from `other` into the method receiver.  This method
 // can be used to combine sampling results from multiple redis instances into a
-// single result set.
-func (r *Results) Merge(other *Results) {
+// single result set.  Since the size histograms hold exact counts (rather than
+// summaries such as percentiles), merging them is lossless: any percentile of
+// the merged histograms is that of the combined observations.  It is safe to
+// call Merge concurrently with observations on either Results, or with other
+// merges.  If the two Results are not compatible (see ErrIncompatibleResults),
+// neither is modified, and an error is returned.
+func (r *Results) Merge(other *Results) error {
+	// take a private snapshot of `other` first, so that the two mutexes are never
+	// held at the same time (which would allow a.Merge(b) and b.Merge(a) to
+	// deadlock, and would make r.Merge(r) impossible)
+	o := other.Clone()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.compatible(o); err != nil {
+		return err
+	}
+	r.merge(o)
+	return nil
+}
+
+// compatible checks that the results in `other` were collected with the same
+// configuration as the method receiver: the same provenance (where known),
+// the same histogram bucket boundaries, and the same optional collectors.
+// Empty Results are compatible with anything.
+func (r *Results) compatible(other *Results) error {
+	if r.KeyCount == 0 || other.KeyCount == 0 {
+		return nil
+	}
+
+	if r.Provenance != "" && other.Provenance != "" && r.Provenance != other.Provenance {
+		return fmt.Errorf("%w: different provenance (%q and %q)", ErrIncompatibleResults, r.Provenance, other.Provenance)
+	}
+	if r.ShortTTL != other.ShortTTL {
+		return fmt.Errorf("%w: different short TTL thresholds (%s and %s)", ErrIncompatibleResults, r.ShortTTL, other.ShortTTL)
+	}
+	if r.TemperatureThresholds != other.TemperatureThresholds {
+		return fmt.Errorf("%w: different temperature thresholds", ErrIncompatibleResults)
+	}
+	if !equalThresholds(r.EncodingThresholds, other.EncodingThresholds) {
+		return fmt.Errorf("%w: different encoding thresholds", ErrIncompatibleResults)
+	}
+	if r.MinSize != other.MinSize {
+		return fmt.Errorf("%w: different minimum sizes (%d and %d)", ErrIncompatibleResults, r.MinSize, other.MinSize)
+	}
+	if !equalInts(r.Buckets, other.Buckets) {
+		return fmt.Errorf("%w: different bucket boundaries (%v and %v)", ErrIncompatibleResults, r.Buckets, other.Buckets)
+	}
+	if r.accessMetric() != AccessUnknown && other.accessMetric() != AccessUnknown && r.accessMetric() != other.accessMetric() {
+		return fmt.Errorf("%w: different access metrics (idle times under one maxmemory-policy, frequencies under LFU)", ErrIncompatibleResults)
+	}
+	// each optional collector must have been enabled for both or neither, so
+	// that its distribution covers every key of the merged Results
+	for _, c := range []struct {
+		what string
+		a, b int
+	}{
+		{"encodings were", len(r.Encodings), len(other.Encodings)},
+		{"memory usage was", len(r.MemoryUsages), len(other.MemoryUsages)},
+		{"TTLs were", int(r.Expiring + r.Persistent), int(other.Expiring + other.Persistent)},
+		{"fetch latencies were", len(r.FetchLatencies), len(other.FetchLatencies)},
+		{"idle times were", len(r.IdleTimes), len(other.IdleTimes)},
+		{"access frequencies were", len(r.AccessFrequencies), len(other.AccessFrequencies)},
+		{"stored value sizes were", len(r.StoredValueSizes), len(other.StoredValueSizes)},
+		{"logical value sizes were", len(r.LogicalValueSizes), len(other.LogicalValueSizes)},
+	} {
+		if (c.a == 0) != (c.b == 0) {
+			return fmt.Errorf("%w: %s only collected for one of them", ErrIncompatibleResults, c.what)
+		}
+	}
+	// list ends are only recorded for lists, so can only be compared if both
+	// hold some
+	if len(r.ListSizes) > 0 && len(other.ListSizes) > 0 &&
+		(len(r.ListHeadElementSizes)+len(r.ListTailElementSizes) == 0) != (len(other.ListHeadElementSizes)+len(other.ListTailElementSizes) == 0) {
+		return fmt.Errorf("%w: list ends were only sampled for one of them", ErrIncompatibleResults)
+	}
+	if r.TopK != other.TopK {
+		return fmt.Errorf("%w: different numbers of top values (%d and %d)", ErrIncompatibleResults, r.TopK, other.TopK)
+	}
+	return nil
+}
+
+// equalInts indicates whether two int slices hold the same values
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// equalThresholds indicates whether two sets of encoding thresholds are the
+// same
+func equalThresholds(a, b map[ValueType]EncodingThreshold) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for vt, t := range a {
+		if u, ok := b[vt]; !ok || t != u {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns a deep copy of the method receiver, which shares no state
+// with the original.  It can be used to take a consistent snapshot of results
+// (e.g. for rendering) while observations or merges are ongoing.
+func (r *Results) Clone() *Results {
+	c := NewResults()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c.Name = r.Name
+	c.Buckets = append([]int(nil), r.Buckets...)
+	c.merge(r)
+	return c
+}
+
+// CloneAll returns a deep copy of a map of aggregated results, as returned by
+// Run.  See Results.Clone.
+func CloneAll(stats map[string]*Results) map[string]*Results {
+	c := make(map[string]*Results, len(stats))
+	for g, r := range stats {
+		c[g] = r.Clone()
+	}
+	return c
+}
+
+// Label records `provenance` in every Results of a map of aggregated results,
+// as returned by Run, so that they cannot accidentally be merged with results
+// of a different provenance (see Options.Provenance).
+func Label(stats map[string]*Results, provenance string) {
+	for _, r := range stats {
+		r.mu.Lock()
+		r.Provenance = provenance
+		r.mu.Unlock()
+	}
+}
+
+// Prune removes every group with fewer than `minKeys` sampled keys from a map
+// of aggregated results, as returned by Run, returning the number of groups
+// removed.  Groups that are empty (see Results.IsEmpty) are always removed,
+// unless they hold trivial keys (see Options.MinSize).
+func Prune(stats map[string]*Results, minKeys int) int {
+	var pruned int
+	for g, r := range stats {
+		if n := r.keyCount(); (n == 0 && r.trivialKeys() == 0) || n < int64(minKeys) {
+			delete(stats, g)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// merge adds the results from `other` into the method receiver, without any
+// locking.  Callers must hold the appropriate mutexes.
+func (r *Results) merge(other *Results) {
+	switch {
+	case r.KeyCount == 0:
+		r.Exact = other.Exact
+	case other.KeyCount > 0:
+		r.Exact = r.Exact && other.Exact
+	}
 	r.KeyCount += other.KeyCount
+	if len(r.Buckets) == 0 {
+		r.Buckets = append([]int(nil), other.Buckets...)
+	}
+	if other.Server != nil {
+		if r.Server == nil {
+			server := *other.Server
+			r.Server = &server
+		} else if *r.Server != *other.Server {
+			r.Server.merge(*other.Server)
+		}
+	}
 
 	// union all sets
 	union(r.StringKeys, other.StringKeys)
@@ -256,7 +669,12 @@ func (r *Results) Merge(other *Results) {
 	union(r.ListKeys, other.ListKeys)
 	union(r.ListElements, other.ListElements)
 
+	for tag, n := range other.Instances {
+		r.Instances[tag] += n
+	}
+
 	// merge all frequency tables
+	merge(r.KeyNameSizes, other.KeyNameSizes)
 	merge(r.StringSizes, other.StringSizes)
 	merge(r.SetSizes, other.SetSizes)
 	merge(r.SetElementSizes, other.SetElementSizes)
@@ -267,45 +685,628 @@ func (r *Results) Merge(other *Results) {
 	merge(r.HashValueSizes, other.HashValueSizes)
 	merge(r.ListSizes, other.ListSizes)
 	merge(r.ListElementSizes, other.ListElementSizes)
+	merge(r.ListHeadElementSizes, other.ListHeadElementSizes)
+	merge(r.ListTailElementSizes, other.ListTailElementSizes)
+	merge(r.ShortLivedSizes, other.ShortLivedSizes)
+	merge(r.FetchLatencies, other.FetchLatencies)
+	merge(r.TTLs, other.TTLs)
+	r.Persistent += other.Persistent
+	r.Expiring += other.Expiring
+	merge(r.IdleTimes, other.IdleTimes)
+	merge(r.AccessFrequencies, other.AccessFrequencies)
+	if r.TemperatureThresholds == (TemperatureThresholds{}) {
+		r.TemperatureThresholds = other.TemperatureThresholds
+	}
+	if len(r.EncodingThresholds) == 0 && len(other.EncodingThresholds) > 0 {
+		r.EncodingThresholds = make(map[ValueType]EncodingThreshold, len(other.EncodingThresholds))
+		for vt, t := range other.EncodingThresholds {
+			r.EncodingThresholds[vt] = t
+		}
+	}
+	merge(r.StoredValueSizes, other.StoredValueSizes)
+	merge(r.LogicalValueSizes, other.LogicalValueSizes)
+	merge(r.MemoryUsages, other.MemoryUsages)
+	r.MemoryEstimated = r.MemoryEstimated || other.MemoryEstimated
+	if r.ShortTTL == 0 {
+		r.ShortTTL = other.ShortTTL
+	}
+	r.TrivialKeys += other.TrivialKeys
+	if r.MinSize == 0 {
+		r.MinSize = other.MinSize
+	}
+
+	for vt, encs := range other.Encodings {
+		for enc, es := range encs {
+			r.encodingEntry(vt, enc).add(es.Keys, es.Bytes)
+		}
+	}
+
+	for _, bk := range other.BigKeys {
+		r.observeBigKeyLocked(bk)
+	}
+
+	if r.TopK == 0 {
+		r.TopK = other.TopK
+	}
+	if r.Provenance == "" {
+		r.Provenance = other.Provenance
+	}
+	r.MinGroupSamples = max(r.MinGroupSamples, other.MinGroupSamples)
+	for _, w := range other.Warnings {
+		if !containsString(r.Warnings, w) {
+			r.Warnings = append(r.Warnings, w)
+		}
+	}
+	for vt, tv := range other.TopValues {
+		t, ok := r.TopValues[vt]
+		if !ok {
+			t = NewTopValues(tv.K)
+			r.TopValues[vt] = t
+		}
+		t.merge(tv)
+	}
+}
+
+// containsString indicates whether `ss` contains `s`
+func containsString(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// observeValue records a value of data type `vt` in TopValues, if enabled
+func (r *Results) observeValue(vt ValueType, value string) {
+	if r.TopK <= 0 {
+		return
+	}
+	t, ok := r.TopValues[vt]
+	if !ok {
+		t = NewTopValues(r.TopK)
+		r.TopValues[vt] = t
+	}
+	t.add(value)
+}
+
+func (e *EncodingStats) add(keys, bytes int64) {
+	e.Keys += keys
+	e.Bytes += bytes
+}
+
+// encodingEntry obtains the EncodingStats for the specified type/encoding pair,
+// creating it if necessary
+func (r *Results) encodingEntry(vt ValueType, encoding string) *EncodingStats {
+	encs, ok := r.Encodings[vt]
+	if !ok {
+		encs = make(map[string]*EncodingStats)
+		r.Encodings[vt] = encs
+	}
+	es, ok := encs[encoding]
+	if !ok {
+		es = &EncodingStats{}
+		encs[encoding] = es
+	}
+	return es
 }
 
-func (r *Results) observeSet(key string, length int, member string) {
+// observeSet records a set of `length` members, of which `members` were
+// sampled.  The observe methods for other collections are similar.
+func (r *Results) observeSet(key string, length int, members ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.KeyCount++
+	r.KeyNameSizes[len(key)]++
 	r.SetSizes[length]++
-	r.SetElementSizes[len(member)]++
 	add(r.SetKeys, key, MaxExampleKeys)
-	add(r.SetElements, member, MaxExampleElements)
+	for _, m := range members {
+		r.SetElementSizes[len(m)]++
+		add(r.SetElements, m, MaxExampleElements)
+		r.observeValue(TypeSet, m)
+	}
 }
 
-func (r *Results) observeSortedSet(key string, length int, member string) {
+func (r *Results) observeSortedSet(key string, length int, members ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.KeyCount++
+	r.KeyNameSizes[len(key)]++
 	r.SortedSetSizes[length]++
-	r.SortedSetElementSizes[len(member)]++
 	add(r.SortedSetKeys, key, MaxExampleKeys)
-	add(r.SortedSetElements, member, MaxExampleElements)
+	for _, m := range members {
+		r.SortedSetElementSizes[len(m)]++
+		add(r.SortedSetElements, m, MaxExampleElements)
+		r.observeValue(TypeSortedSet, m)
+	}
 }
 
-func (r *Results) observeHash(key string, length int, field string, value string) {
+// observeHash records a hash of `length` fields, given alternating sampled
+// fields and values
+func (r *Results) observeHash(key string, length int, pairs ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.KeyCount++
+	r.KeyNameSizes[len(key)]++
 	r.HashSizes[length]++
-	r.HashValueSizes[len(value)]++
-	r.HashElementSizes[len(field)]++
 	add(r.HashKeys, key, MaxExampleKeys)
-	add(r.HashElements, field, MaxExampleElements)
-	add(r.HashValues, value, MaxExampleValues)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		field, value := pairs[i], pairs[i+1]
+		r.HashValueSizes[len(value)]++
+		r.HashElementSizes[len(field)]++
+		add(r.HashElements, field, MaxExampleElements)
+		add(r.HashValues, value, MaxExampleValues)
+		r.observeValue(TypeHash, value)
+	}
 }
 
-func (r *Results) observeList(key string, length int, member string) {
+func (r *Results) observeList(key string, length int, members ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.KeyCount++
+	r.KeyNameSizes[len(key)]++
 	r.ListSizes[length]++
-	r.ListElementSizes[len(member)]++
 	add(r.ListKeys, key, MaxExampleKeys)
-	add(r.ListElements, member, MaxExampleElements)
+	for _, m := range members {
+		r.ListElementSizes[len(m)]++
+		add(r.ListElements, m, MaxExampleElements)
+		r.observeValue(TypeList, m)
+	}
+}
+
+// observeListEnds observes a list whose elements were sampled from both ends:
+// the head elements are observed as for observeList, and the sizes of each
+// end's elements are also recorded separately
+func (r *Results) observeListEnds(key string, length int, head, tail []string) {
+	r.observeList(key, length, head...)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range head {
+		r.ListHeadElementSizes[len(m)]++
+	}
+	for _, m := range tail {
+		r.ListTailElementSizes[len(m)]++
+	}
 }
 
 func (r *Results) observeString(key, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.KeyCount++
+	r.KeyNameSizes[len(key)]++
 	r.StringSizes[len(value)]++
 	add(r.StringKeys, key, MaxExampleKeys)
 	add(r.StringValues, value, MaxExampleValues)
+	r.observeValue(TypeString, value)
+}
+
+// observeSize records only the name and length of a key whose contents were
+// not sampled (see Options.SizeOnlyTypes)
+func (r *Results) observeSize(vt ValueType, key string, length int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.KeyCount++
+	r.KeyNameSizes[len(key)]++
+	r.lengths(vt)[length]++
+	add(r.exampleKeys(vt), key, MaxExampleKeys)
+}
+
+// observeShortLived records the estimated size of a key that was due to
+// expire within ShortTTL
+func (r *Results) observeShortLived(size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ShortLivedSizes[size]++
+}
+
+// observeTrivial records a key that was excluded from the statistics for
+// being smaller than MinSize
+func (r *Results) observeTrivial() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.TrivialKeys++
+}
+
+// observeLatency records the time taken to fetch a key
+func (r *Results) observeLatency(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.FetchLatencies[int(d/time.Microsecond)]++
+}
+
+// observeCompression records the sizes of the values of a key as stored, and
+// as transformed by Options.ValueTransform
+func (r *Results) observeCompression(stored, logical []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range stored {
+		r.StoredValueSizes[len(stored[i])]++
+		r.LogicalValueSizes[len(logical[i])]++
+	}
+}
+
+// observeMemory records the memory used by a key, in bytes, noting whether it
+// was estimated rather than reported by redis
+func (r *Results) observeMemory(bytes int, estimated bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.MemoryUsages[bytes]++
+	r.MemoryEstimated = r.MemoryEstimated || estimated
+}
+
+func (r *Results) observeBigKey(bk BigKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.observeBigKeyLocked(bk)
+}
+
+// observeBigKeyLocked records a big key, keeping BigKeys sorted by size and
+// free of duplicates (a key may be sampled more than once), without locking
+func (r *Results) observeBigKeyLocked(bk BigKey) {
+	for i, existing := range r.BigKeys {
+		if existing.Key == bk.Key {
+			if bk.Size <= existing.Size {
+				return
+			}
+			r.BigKeys = append(r.BigKeys[:i], r.BigKeys[i+1:]...)
+			break
+		}
+	}
+
+	i := sort.Search(len(r.BigKeys), func(i int) bool { return r.BigKeys[i].Size < bk.Size })
+	if i >= MaxBigKeys {
+		return
+	}
+	r.BigKeys = append(r.BigKeys, BigKey{})
+	copy(r.BigKeys[i+1:], r.BigKeys[i:])
+	r.BigKeys[i] = bk
+	if len(r.BigKeys) > MaxBigKeys {
+		r.BigKeys = r.BigKeys[:MaxBigKeys]
+	}
+}
+
+func (r *Results) observeEncoding(vt ValueType, encoding string, size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.encodingEntry(vt, encoding).add(1, int64(size))
+}
+
+// lengths returns the frequency table of lengths for data type `vt`: value
+// sizes in bytes for strings, and cardinalities for collections
+func (r *Results) lengths(vt ValueType) map[int]int64 {
+	switch vt {
+	case TypeString:
+		return r.StringSizes
+	case TypeList:
+		return r.ListSizes
+	case TypeSet:
+		return r.SetSizes
+	case TypeSortedSet:
+		return r.SortedSetSizes
+	case TypeHash:
+		return r.HashSizes
+	}
+	return nil
+}
+
+// valueSizes returns the frequency table of value sizes for data type `vt`:
+// string values, hash values, or the members of sets, sorted sets and lists
+func (r *Results) valueSizes(vt ValueType) map[int]int64 {
+	switch vt {
+	case TypeString:
+		return r.StringSizes
+	case TypeList:
+		return r.ListElementSizes
+	case TypeSet:
+		return r.SetElementSizes
+	case TypeSortedSet:
+		return r.SortedSetElementSizes
+	case TypeHash:
+		return r.HashValueSizes
+	}
+	return nil
+}
+
+// exampleKeys returns the set of example keys for data type `vt`
+func (r *Results) exampleKeys(vt ValueType) map[string]bool {
+	switch vt {
+	case TypeString:
+		return r.StringKeys
+	case TypeList:
+		return r.ListKeys
+	case TypeSet:
+		return r.SetKeys
+	case TypeSortedSet:
+		return r.SortedSetKeys
+	case TypeHash:
+		return r.HashKeys
+	}
+	return nil
+}
+
+// TypeCounts returns the number of sampled keys of each redis data type.  Data
+// types for which no keys were sampled are omitted.  The total number of
+// sampled keys is available via the KeyCount field.
+func (r *Results) TypeCounts() map[ValueType]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[ValueType]int64)
+	for _, vt := range valueTypes {
+		var n int64
+		for _, c := range r.lengths(vt) {
+			n += c
+		}
+		if n > 0 {
+			counts[vt] = n
+		}
+	}
+	return counts
+}
+
+// EstimatedBytes estimates the total size in bytes of the values of the
+// sampled keys (not the whole keyspace), from the sampled lengths and value
+// sizes: the total length of the strings, plus the total number of elements
+// in each type of collection times their mean sampled size (field plus value,
+// for hashes).  Collections whose contents weren't sampled (see
+// Options.SizeOnlyTypes) aren't counted.  Key names and redis' own overheads
+// are excluded.
+func (r *Results) EstimatedBytes() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.estimatedBytes()
+}
+
+// estimatedBytes is EstimatedBytes, without any locking
+func (r *Results) estimatedBytes() int64 {
+	var total float64
+	for _, vt := range valueTypes {
+		var length int64
+		for l, n := range r.lengths(vt) {
+			length += int64(l) * n
+		}
+		if vt == TypeString {
+			total += float64(length)
+			continue
+		}
+
+		mean := ComputeStatistics(r.valueSizes(vt)).Mean
+		if vt == TypeHash {
+			mean += ComputeStatistics(r.HashElementSizes).Mean
+		}
+		if !math.IsNaN(mean) {
+			total += float64(length) * mean
+		}
+	}
+	return int64(total)
+}
+
+// TotalBytes returns the total size in bytes of the values of the sampled
+// keys, as estimated by EstimatedBytes.  Like TotalElements, it is a total
+// over the sampled keys only, rather than an estimate for the whole keyspace:
+// unless the results are Exact, scale it by Summary.KeyCount/Summary.Sampled
+// to extrapolate.
+func (r *Results) TotalBytes() int64 {
+	return r.EstimatedBytes()
+}
+
+// TotalElements returns the total number of elements (the sum of the
+// lengths) of the sampled lists, sets, sorted sets and hashes, including
+// those whose contents weren't sampled (see Options.SizeOnlyTypes).  Strings
+// aren't counted.
+func (r *Results) TotalElements() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var total int64
+	for _, vt := range valueTypes {
+		if vt == TypeString {
+			continue
+		}
+		for l, n := range r.lengths(vt) {
+			total += int64(l) * n
+		}
+	}
+	return total
+}
+
+// CompressionRatio returns the ratio of the logical size of the sampled
+// values to their stored size (see Options.CollectCompression), e.g. 4.0 for
+// values that compress to a quarter of their size, or 0 if compression wasn't
+// measured.  A ratio close to 1.0 indicates that compression is wasted effort.
+func (r *Results) CompressionRatio() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.compressionRatio()
+}
+
+func (r *Results) compressionRatio() float64 {
+	if len(r.StoredValueSizes) == 0 {
+		return 0
+	}
+	stored := ComputeStatistics(r.StoredValueSizes)
+	if stored.Mean == 0 {
+		return 0
+	}
+	return ComputeStatistics(r.LogicalValueSizes).Mean / stored.Mean
+}
+
+// MemoryUsage returns the total memory used by the sampled keys, in bytes (see
+// Options.CollectMemoryUsage), or 0 if it wasn't measured.  Since only a
+// sample of the keys is measured, it must be scaled up to estimate the memory
+// used by the whole group.
+func (r *Results) MemoryUsage() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.memoryUsage()
+}
+
+func (r *Results) memoryUsage() int64 {
+	var total int64
+	for bytes, n := range r.MemoryUsages {
+		total += int64(bytes) * n
+	}
+	return total
+}
+
+// A TypeSummary summarizes the sampled keys of a single data type within an
+// aggregation group.
+type TypeSummary struct {
+	Type ValueType
+	Keys int64
+
+	// Values is the number of sampled values (see Results.ValueSizes), which
+	// is zero when the contents of the keys weren't sampled
+	Values int64
+
+	// Lengths and ValueSizes are as returned by Results.Lengths and
+	// Results.ValueSizes
+	Lengths    Statistics
+	ValueSizes Statistics
+}
+
+// Types summarizes the sampled keys of each data type separately, in a fixed
+// order of data types, omitting types of which no keys were sampled.  Each
+// data type's statistics are kept apart within a Results, so that a group
+// holding a mix of data types (e.g. strings and hashes under the same prefix)
+// can be read type by type.
+func (r *Results) Types() []TypeSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.types()
+}
+
+// types is Types, without any locking
+func (r *Results) types() []TypeSummary {
+	var summaries []TypeSummary
+	for _, vt := range valueTypes {
+		var n, values int64
+		for _, c := range r.lengths(vt) {
+			n += c
+		}
+		if n == 0 {
+			continue
+		}
+		for _, c := range r.valueSizes(vt) {
+			values += c
+		}
+		summaries = append(summaries, TypeSummary{
+			Type:       vt,
+			Keys:       n,
+			Values:     values,
+			Lengths:    ComputeStatistics(r.lengths(vt)),
+			ValueSizes: ComputeStatistics(r.valueSizes(vt)),
+		})
+	}
+	return summaries
+}
+
+// Lengths returns descriptive statistics about the lengths of the sampled
+// keys of data type `vt`: the size in bytes of string values, or the number of
+// elements in collections.
+func (r *Results) Lengths(vt ValueType) Statistics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return ComputeStatistics(r.lengths(vt))
+}
+
+// ValueSizes returns descriptive statistics about the size in bytes of the
+// sampled values of data type `vt`: string values, hash values, or the members
+// of sets, sorted sets and lists.
+func (r *Results) ValueSizes(vt ValueType) Statistics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return ComputeStatistics(r.valueSizes(vt))
+}
+
+// MeanValueSize returns the mean size in bytes of the sampled values of data
+// type `vt` (see ValueSizes), or NaN if no such values were sampled.
+func (r *Results) MeanValueSize(vt ValueType) float64 {
+	return r.ValueSizes(vt).Mean
+}
+
+// GiniCoefficient returns the Gini coefficient of the lengths of the sampled
+// keys of data type `vt` (see Lengths), or NaN if no such keys were sampled.
+// A high coefficient flags a group in which a handful of keys account for most
+// of the data.
+func (r *Results) GiniCoefficient(vt ValueType) float64 {
+	return r.Lengths(vt).Gini
+}
+
+// ExampleKeys returns the (sorted) example keys that were captured for data
+// type `vt`.
+func (r *Results) ExampleKeys(vt ValueType) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]string, 0, len(r.exampleKeys(vt)))
+	for k := range r.exampleKeys(vt) {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// IsEmpty indicates whether no keys have been observed
+func (r *Results) IsEmpty() bool {
+	return r.keyCount() == 0
+}
+
+// LowConfidence indicates whether fewer than MinGroupSamples keys were
+// sampled, such that the results are unlikely to be representative
+func (r *Results) LowConfidence() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lowConfidence()
+}
+
+// lowConfidence is LowConfidence, without any locking
+func (r *Results) lowConfidence() bool {
+	return r.KeyCount < int64(r.MinGroupSamples)
+}
+
+// bigKeyCount returns the number of big keys of type `vt` (or of any type, if
+// `vt` is empty)
+func (r *Results) bigKeyCount(vt ValueType) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int
+	for _, bk := range r.BigKeys {
+		if vt == "" || bk.Type == vt {
+			n++
+		}
+	}
+	return n
+}
+
+// keyCount returns the number of sampled keys, under lock
+func (r *Results) keyCount() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.KeyCount
+}
+
+// trivialKeys returns TrivialKeys, under lock
+func (r *Results) trivialKeys() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.TrivialKeys
 }
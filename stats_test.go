@@ -17,8 +17,15 @@
 package reckon
 
 import (
+	"bytes"
+	"errors"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func assertInt(t *testing.T, expected, actual int) {
@@ -58,6 +65,7 @@ func TestStatistics(t *testing.T) {
 	assertInt(t, -1, stats.Min)
 	assertFloat(t, 284.0, stats.Mean, epsilon)
 	assertFloat(t, 423.18554, stats.StdDev, epsilon)
+	assertFloat(t, 0.65606, stats.Gini, epsilon)
 
 	m = make(map[int]int64)
 	m[45] = 4
@@ -71,6 +79,10 @@ func TestStatistics(t *testing.T) {
 	assertInt(t, 45, stats.Min)
 	assertFloat(t, 13415.93333, stats.Mean, epsilon)
 	assertFloat(t, 35152.65287, stats.StdDev, epsilon)
+	assertFloat(t, 0.86149, stats.Gini, epsilon)
+
+	stats = ComputeStatistics(map[int]int64{7: 10})
+	assertFloat(t, 0, stats.Gini, epsilon)
 }
 
 func TestStatisticsZeroValues(t *testing.T) {
@@ -82,4 +94,328 @@ func TestStatisticsZeroValues(t *testing.T) {
 	assertInt(t, 0, stats.Min)
 	assertNaN(t, stats.Mean)
 	assertNaN(t, stats.StdDev)
+	assertNaN(t, stats.Gini)
+}
+
+func TestResultsConcurrentObserveAndMerge(t *testing.T) {
+
+	const goroutines, observations = 8, 500
+
+	r := NewResults()
+	other := NewResults()
+	other.observeString("other", "value")
+	snapshot := NewResults()
+
+	var wg sync.WaitGroup
+	wg.Add(goroutines + 1)
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < observations; j++ {
+				r.observeString("key", "value")
+				r.observeList("list", 3, "member")
+				r.observeSet("set", 2, "member")
+				r.observeSortedSet("zset", 1, "member")
+				r.observeHash("hash", 4, "field", "value")
+			}
+		}()
+	}
+	go func() {
+		defer wg.Done()
+		for j := 0; j < observations; j++ {
+			if err := r.Merge(other); err != nil {
+				t.Error(err)
+			}
+			if err := snapshot.Merge(r); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	// each goroutine observes 5 keys per iteration, and every merge of `other`
+	// into `r` adds the single key that `other` holds
+	assertInt(t, goroutines*observations*5+observations, int(r.KeyCount))
+	assertInt(t, goroutines*observations, int(r.ListSizes[3]))
+	assertInt(t, goroutines*observations+observations, int(r.StringSizes[5]))
+}
+
+func TestResultsMergeSelf(t *testing.T) {
+
+	r := NewResults()
+	r.observeString("key", "value")
+	if err := r.Merge(r); err != nil {
+		t.Fatal(err)
+	}
+
+	assertInt(t, 2, int(r.KeyCount))
+	assertInt(t, 2, int(r.StringSizes[5]))
+}
+
+// quantile returns the `q` quantile (by the nearest-rank method) of the values
+// counted in `freq`
+func quantile(freq map[int]int64, q float64) int {
+	var values []int
+	var total int64
+	for v, n := range freq {
+		values = append(values, v)
+		total += n
+	}
+	sort.Ints(values)
+
+	rank := int64(math.Ceil(q * float64(total)))
+	for _, v := range values {
+		if rank -= freq[v]; rank <= 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+func TestResultsMergeQuantiles(t *testing.T) {
+
+	// one instance holds the bulk of the keys, and the other the tail, so that
+	// combining per-instance quantiles (rather than the underlying histograms)
+	// would be badly wrong
+	var raw []int
+	a, b := NewResults(), NewResults()
+	for i := 1; i <= 990; i++ {
+		a.observeString("a"+strconv.Itoa(i), strings.Repeat("x", i))
+		raw = append(raw, i)
+	}
+	for i := 1; i <= 20; i++ {
+		b.observeString("b"+strconv.Itoa(i), strings.Repeat("x", 5000+i))
+		raw = append(raw, 5000+i)
+	}
+
+	merged := NewResults()
+	for _, r := range []*Results{a, b} {
+		if err := merged.Merge(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sort.Ints(raw)
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999, 1} {
+		expected := raw[int(math.Ceil(q*float64(len(raw))))-1]
+		assertInt(t, expected, quantile(merged.StringSizes, q))
+	}
+	assertInt(t, 5010, quantile(merged.StringSizes, 0.99))
+}
+
+func TestResultsMergeIncompatible(t *testing.T) {
+
+	newWith := func(configure func(r *Results)) *Results {
+		r := NewResults()
+		configure(r)
+		r.observeString("key", "value")
+		r.observeList("list", 1, "a")
+		return r
+	}
+
+	for name, other := range map[string]*Results{
+		"buckets":   newWith(func(r *Results) { r.Buckets = []int{10, 100} }),
+		"encodings": newWith(func(r *Results) { r.observeEncoding(TypeString, "embstr", 5) }),
+		"topK":      newWith(func(r *Results) { r.TopK = 5 }),
+		"memory":    newWith(func(r *Results) { r.observeMemory(64, false) }),
+		"ttls":      newWith(func(r *Results) { r.observeTTL(true, time.Minute) }),
+		"latencies": newWith(func(r *Results) { r.observeLatency(time.Millisecond) }),
+		"idle":      newWith(func(r *Results) { r.observeAccess(AccessIdleTime, time.Minute, 0) }),
+		"frequency": newWith(func(r *Results) { r.observeAccess(AccessFrequency, 0, 10) }),
+		"compression": newWith(func(r *Results) {
+			r.observeCompression([]string{"stored"}, []string{"logical value"})
+		}),
+		"list ends": newWith(func(r *Results) {
+			r.observeListEnds("list", 4, []string{"a"}, []string{"z"})
+		}),
+		"shortTTL":   newWith(func(r *Results) { r.ShortTTL = time.Minute }),
+		"thresholds": newWith(func(r *Results) { r.TemperatureThresholds = DefaultTemperatureThresholds }),
+	} {
+		r := newWith(func(r *Results) {})
+		if err := r.Merge(other); !errors.Is(err, ErrIncompatibleResults) {
+			t.Errorf("%s: expected ErrIncompatibleResults, actual: %v", name, err)
+		}
+		assertInt(t, 2, int(r.KeyCount))
+
+		// empty results are compatible with anything
+		if err := NewResults().Merge(other); err != nil {
+			t.Errorf("%s: unexpected error merging into empty results: %s", name, err)
+		}
+	}
+}
+
+func TestResultsMergeProvenance(t *testing.T) {
+
+	newLabelled := func(provenance string) *Results {
+		stats := map[string]*Results{"group": NewResults()}
+		stats["group"].observeString("key", "value")
+		Label(stats, provenance)
+		return stats["group"]
+	}
+
+	r := newLabelled("by-prefix")
+	if err := r.Merge(newLabelled("by-type")); !errors.Is(err, ErrIncompatibleResults) {
+		t.Errorf("expected ErrIncompatibleResults, actual: %v", err)
+	}
+	if err := r.Merge(newLabelled("by-prefix")); err != nil {
+		t.Fatal(err)
+	}
+
+	// results of unknown provenance can be merged with anything, and take on
+	// the provenance of what they are merged with
+	unknown := newLabelled("")
+	if err := unknown.Merge(r); err != nil {
+		t.Fatal(err)
+	}
+	if unknown.Provenance != "by-prefix" {
+		t.Errorf("expected the merged provenance to be by-prefix, actual: %q", unknown.Provenance)
+	}
+	assertInt(t, 3, int(unknown.KeyCount))
+}
+
+func TestResultsTypes(t *testing.T) {
+
+	r := NewResults()
+	r.observeString("user:1:name", "alice")
+	r.observeString("user:2:name", "bob")
+	r.observeHash("user:1", 3, "field", "value")
+	r.observeSize(TypeList, "user:1:log", 40)
+
+	types := r.Types()
+	if len(types) != 3 {
+		t.Fatalf("expected strings, lists and hashes to be summarized, actual: %+v", types)
+	}
+	str, list, hash := types[0], types[1], types[2]
+	if str.Type != TypeString || str.Keys != 2 || str.Values != 2 {
+		t.Errorf("unexpected string summary: %+v", str)
+	}
+	assertFloat(t, 4.0, str.ValueSizes.Mean, epsilon)
+	if list.Type != TypeList || list.Keys != 1 || list.Values != 0 || list.Lengths.Max != 40 {
+		t.Errorf("unexpected list summary: %+v", list)
+	}
+	if hash.Type != TypeHash || hash.Keys != 1 || hash.Lengths.Mean != 3 {
+		t.Errorf("unexpected hash summary: %+v", hash)
+	}
+
+	var buf bytes.Buffer
+	if err := RenderText(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"string: n=2 (50.00%), mean length=4.00, mean value size=4.00",
+		"list: n=1 (25.00%), mean length=40.00\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("expected %q to be rendered:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestResultsAccessors(t *testing.T) {
+
+	r := NewResults()
+	r.observeString("b", "abc")
+	r.observeString("a", "abcde")
+	r.observeHash("h", 10, "field", "value")
+	r.observeSet("s", 4, "member")
+
+	counts := r.TypeCounts()
+	assertInt(t, 3, len(counts))
+	assertInt(t, 2, int(counts[TypeString]))
+	assertInt(t, 1, int(counts[TypeHash]))
+	assertInt(t, 1, int(counts[TypeSet]))
+
+	assertFloat(t, 4.0, r.MeanValueSize(TypeString), epsilon)
+	assertFloat(t, 5.0, r.MeanValueSize(TypeHash), epsilon)
+	assertFloat(t, 6.0, r.MeanValueSize(TypeSet), epsilon)
+	assertNaN(t, r.MeanValueSize(TypeList))
+
+	assertInt(t, 10, r.Lengths(TypeHash).Max)
+	assertInt(t, 4, int(r.KeyNameSizes[1]))
+	assertInt(t, 4, r.Lengths(TypeSet).Min)
+
+	// 8 bytes of strings, 10 hash entries of 5+5 bytes, and 4 set members of
+	// 6 bytes
+	assertInt(t, 8+100+24, int(r.TotalBytes()))
+	assertInt(t, 14, int(r.TotalElements()))
+
+	keys := r.ExampleKeys(TypeString)
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("unexpected example keys: %v", keys)
+	}
+}
+
+func TestComputeBucketFreq(t *testing.T) {
+
+	m := map[int]int64{0: 1, 10: 2, 1024: 3, 1025: 4, 5000: 5, 20000: 6}
+
+	bf := ComputeBucketFreq(m, []int{1024, 10240})
+	assertInt(t, 3, len(bf))
+	assertInt(t, 6, int(bf[1024]))
+	assertInt(t, 9, int(bf[10240]))
+	assertInt(t, 6, int(bf[OverflowBucket]))
+
+	pf := ComputeBucketFreq(m, nil)
+	assertInt(t, int(ComputePowerOfTwoFreq(m)[2048]), int(pf[2048]))
+}
+
+func TestResultsClone(t *testing.T) {
+
+	r := NewResults()
+	r.Name = "original"
+	r.Buckets = []int{10, 100}
+	r.observeString("a", "value")
+	r.observeEncoding(TypeString, "embstr", 5)
+
+	stats := CloneAll(map[string]*Results{"g": r})
+	c := stats["g"]
+
+	r.observeString("b", "other value")
+	r.observeEncoding(TypeString, "embstr", 11)
+	r.Buckets[0] = 20
+
+	assertInt(t, 1, int(c.KeyCount))
+	assertInt(t, 1, len(c.StringKeys))
+	assertInt(t, 1, len(c.StringSizes))
+	assertInt(t, 1, int(c.Encodings[TypeString]["embstr"].Keys))
+	assertInt(t, 10, c.Buckets[0])
+	if c.Name != "original" {
+		t.Errorf("expected the name to be cloned, actual: %s", c.Name)
+	}
+}
+
+func TestResultsBigKeys(t *testing.T) {
+
+	r := NewResults()
+	for i := 0; i < MaxBigKeys+10; i++ {
+		r.observeBigKey(BigKey{Key: strconv.Itoa(i), Type: TypeString, Size: int64(i)})
+	}
+	r.observeBigKey(BigKey{Key: "50", Type: TypeString, Size: 1000})
+
+	other := NewResults()
+	other.observeBigKey(BigKey{Key: "largest", Type: TypeHash, Size: 5000})
+	r.Merge(other)
+
+	assertInt(t, MaxBigKeys, len(r.BigKeys))
+	if r.BigKeys[0].Key != "largest" || r.BigKeys[1].Key != "50" {
+		t.Errorf("unexpected largest keys: %+v", r.BigKeys[:2])
+	}
+	seen := make(map[string]bool)
+	for i, bk := range r.BigKeys {
+		if i > 0 && bk.Size > r.BigKeys[i-1].Size {
+			t.Fatalf("expected big keys to be sorted by size")
+		}
+		if seen[bk.Key] {
+			t.Errorf("expected big keys to be deduplicated, found %s twice", bk.Key)
+		}
+		seen[bk.Key] = true
+	}
+
+	var buf bytes.Buffer
+	if err := RenderText(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), " largest (hash): length 0, ~4.9KB\n") {
+		t.Errorf("expected text output to contain the big keys")
+	}
 }
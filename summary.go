@@ -0,0 +1,193 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"time"
+)
+
+// Summary describes a sampling run as a whole, as opposed to the per-group
+// statistics held in Results.
+type Summary struct {
+	// KeyCount is the total number of keys in the redis instance
+	KeyCount int64
+
+	// Sampled is the number of keys that were observed
+	Sampled int
+
+	// Exact indicates that every key was observed exactly once (see
+	// Options.Census), so that the statistics are exact rather than estimated
+	Exact bool
+
+	// Skipped is the number of randomly selected keys that were deliberately
+	// not observed, e.g. because the quota for their type had already been met
+	Skipped int
+
+	// Filtered is the number of selected keys that were rejected by
+	// Options.KeyFilter, and so not observed
+	Filtered int
+
+	// Expired is the number of randomly selected keys that no longer existed
+	// by the time they were fetched.  With lazy expiration, RANDOMKEY can return
+	// keys that have logically expired but have not yet been evicted, so a high
+	// count relative to Sampled indicates significant expiration lag.
+	Expired int
+
+	// TypeChanged is the number of selected keys that were replaced by a key
+	// of a different data type before they could be fetched.  Most such keys
+	// are resampled as their new type; those that changed type again were
+	// skipped (see ErrTypeChanged).
+	TypeChanged int
+
+	// PrunedGroups is the number of aggregation groups that were removed from
+	// the results for having too few sampled keys (see Options.MinGroupCount)
+	PrunedGroups int
+
+	// EvictedGroups is the number of times an aggregation group was evicted
+	// during sampling to keep within Options.SamplerMemoryBudget (a group that
+	// recurs after its eviction may be evicted again)
+	EvictedGroups int
+
+	// Commands is the number of redis commands issued during sampling, and
+	// BytesReceived is an estimate of the number of bytes received in replies
+	// (excluding protocol overhead).  Together, they describe the load that
+	// the run imposed on the redis instance.
+	Commands      int64
+	BytesReceived int64
+
+	// Retries is the number of times a command was retried after failing with
+	// a transient error (see Options.CommandRetries)
+	Retries int64
+
+	// Duration is the time taken by the run
+	Duration time.Duration
+
+	// RuntimeCapReached indicates that sampling was stopped early because
+	// Options.MaxRuntime was exceeded, so the results are partial
+	RuntimeCapReached bool
+
+	// Interrupted indicates that sampling was stopped early because the
+	// run's context was done (see RunContext), so the results are partial
+	Interrupted bool
+
+	// Partial indicates that sampling was stopped early by an error (e.g. a
+	// timeout, see Options.ReadTimeout), which is returned along with the
+	// results of the keys sampled until then
+	Partial bool
+
+	// Server describes the redis instance at the start of the run
+	Server ServerInfo
+
+	// Capabilities describes the optional server features that were detected
+	// at the start of the run
+	Capabilities Capabilities
+
+	// Warnings holds any non-fatal problems encountered during the run, such as
+	// collectors that were disabled because the server doesn't support them
+	Warnings []string
+}
+
+// Coverage returns the fraction of the keyspace that was sampled, between 0.0
+// and 1.0.  Since keys are sampled at random (and so may be sampled more than
+// once), it is an upper bound on the fraction of distinct keys observed.
+func (s Summary) Coverage() float64 {
+	if s.KeyCount <= 0 {
+		return 0
+	}
+	return math.Min(float64(s.Sampled)/float64(s.KeyCount), 1)
+}
+
+// Throughput returns the sampling rate achieved by the run, in keys observed
+// per second, so that configurations can be compared and the time needed to
+// sample a given number of keys estimated.  It is 0 if the run took no
+// measurable time.
+func (s Summary) Throughput() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Sampled) / s.Duration.Seconds()
+}
+
+// summaryLine is the JSON representation of a Summary written by WriteJSON
+type summaryLine struct {
+	KeyCount          int64    `json:"key_count"`
+	Sampled           int      `json:"sampled"`
+	Exact             bool     `json:"exact"`
+	Skipped           int      `json:"skipped"`
+	Filtered          int      `json:"filtered"`
+	Expired           int      `json:"expired"`
+	TypeChanged       int      `json:"type_changed"`
+	Coverage          float64  `json:"coverage"`
+	Groups            int      `json:"groups"`
+	PrunedGroups      int      `json:"pruned_groups"`
+	EvictedGroups     int      `json:"evicted_groups"`
+	Commands          int64    `json:"commands"`
+	BytesReceived     int64    `json:"bytes_received"`
+	Retries           int64    `json:"retries"`
+	DurationSeconds   float64  `json:"duration_seconds"`
+	KeysPerSecond     float64  `json:"keys_per_second"`
+	RuntimeCapReached bool     `json:"runtime_cap_reached"`
+	Interrupted       bool     `json:"interrupted"`
+	Partial           bool     `json:"partial"`
+	Warnings          []string `json:"warnings"`
+	Error             string   `json:"error,omitempty"`
+}
+
+// WriteJSON writes the Summary to `w` as a single line of JSON, along with the
+// number of aggregation groups produced and the error (if any) that ended the
+// run, so that a wrapping script can tell how the run went by parsing one
+// line.
+func (s Summary) WriteJSON(w io.Writer, groups int, err error) error {
+	line := summaryLine{
+		KeyCount:          s.KeyCount,
+		Sampled:           s.Sampled,
+		Exact:             s.Exact,
+		Skipped:           s.Skipped,
+		Filtered:          s.Filtered,
+		Expired:           s.Expired,
+		TypeChanged:       s.TypeChanged,
+		Coverage:          s.Coverage(),
+		Groups:            groups,
+		PrunedGroups:      s.PrunedGroups,
+		EvictedGroups:     s.EvictedGroups,
+		Commands:          s.Commands,
+		BytesReceived:     s.BytesReceived,
+		Retries:           s.Retries,
+		DurationSeconds:   s.Duration.Seconds(),
+		KeysPerSecond:     s.Throughput(),
+		RuntimeCapReached: s.RuntimeCapReached,
+		Interrupted:       s.Interrupted,
+		Partial:           s.Partial,
+		Warnings:          s.Warnings,
+	}
+	if line.Warnings == nil {
+		line.Warnings = []string{}
+	}
+	if err != nil {
+		line.Error = err.Error()
+	}
+
+	b, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummaryThroughput(t *testing.T) {
+
+	s := Summary{Sampled: 500, Duration: 2 * time.Second}
+	assertFloat(t, 250, s.Throughput(), epsilon)
+	assertFloat(t, 0, Summary{Sampled: 500}.Throughput(), epsilon)
+}
+
+func TestSummaryWriter(t *testing.T) {
+
+	var buf bytes.Buffer
+	src := &sliceSource{samples: []Sample{
+		{Key: "s", Type: TypeString, Length: 1, Value: "v"},
+		{Key: "l", Type: TypeList, Length: 1, Elements: []string{"e"}},
+	}}
+	if _, _, err := RunSource(src, Options{MinSamples: 2, SummaryWriter: &buf}, AggregatorFunc(AnyKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Fatalf("expected a single line of output, actual: %q", buf.String())
+	}
+	var line summaryLine
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 2, line.Sampled)
+	assertInt(t, 1, line.Groups)
+	if line.Error != "" || line.Warnings == nil {
+		t.Errorf("unexpected summary line: %s", buf.String())
+	}
+
+	// the load imposed on the server is reported for cost analysis
+	buf.Reset()
+	if err := (Summary{Commands: 3, BytesReceived: 120}).WriteJSON(&buf, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 3, int(line.Commands))
+	assertInt(t, 120, int(line.BytesReceived))
+
+	// failed runs are summarized too
+	buf.Reset()
+	if _, _, err := Run(Options{MinSamples: -1, SummaryWriter: &buf}, AggregatorFunc(AnyKey)); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line.Error != "MinSamples cannot be negative" {
+		t.Errorf("unexpected error in the summary line: %s", line.Error)
+	}
+
+	// failing to write the summary is reported, rather than logged
+	src = &sliceSource{samples: []Sample{{Key: "s", Type: TypeString, Length: 1, Value: "v"}}}
+	if _, _, err := RunSource(src, Options{MinSamples: 1, SummaryWriter: failingWriter{}}, AggregatorFunc(AnyKey)); err == nil || !strings.Contains(err.Error(), "run summary") {
+		t.Errorf("expected an error writing the summary, actual: %v", err)
+	}
+}
+
+// failingWriter is an io.Writer that always fails
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
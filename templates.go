@@ -17,9 +17,16 @@
 package reckon
 
 import (
+	"encoding/json"
 	"fmt"
+	htmltemplate "html/template"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
+	"unicode/utf8"
 )
 
 func summarize(m map[int]int64) int64 {
@@ -27,6 +34,14 @@ func summarize(m map[int]int64) int64 {
 	return trimAndSum(m, 0.01)
 }
 
+// bucketLabel formats a histogram bucket (or size) for display
+func bucketLabel(n int) string {
+	if n == OverflowBucket {
+		return "larger"
+	}
+	return strconv.Itoa(n)
+}
+
 func fmtFloat(n float64) string {
 	return fmt.Sprintf("%.2f", n)
 }
@@ -35,15 +50,67 @@ func percentage(n, total int64) string {
 	return fmt.Sprintf("%.2f", 100.0*float64(n)/float64(total))
 }
 
+// percentageValue is like percentage, but returns a number rather than a
+// string, for use in JS contexts
+func percentageValue(n, total int64) float64 {
+	return float64(int64(10000.0*float64(n)/float64(total))) / 100.0
+}
+
+// printable returns `s` unchanged if it is valid UTF-8.  Otherwise, any bytes
+// that are not part of a valid UTF-8 sequence are replaced with \xNN escapes,
+// so that binary key names (and values) can be rendered legibly.
+func printable(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size <= 1 {
+			fmt.Fprintf(&b, "\\x%02x", s[i])
+			i++
+			continue
+		}
+		b.WriteString(s[i : i+size])
+		i += size
+	}
+	return b.String()
+}
+
 // chartJS returns the static js what we need on the HTML templates in order to
 // render charts.  The js itself has been turned into Go src using go-bindata.
 // This func panics if there is any error accessing the embedded asset data.
-func chartJS() string {
+func chartJS() htmltemplate.JS {
 	data, err := Asset("Chart.min.js")
 	if err != nil {
 		panic(err)
 	}
-	return string(data)
+	return htmltemplate.JS(data)
+}
+
+// chartSeries encodes a frequency map as the JSON consumed by reckon.js: the
+// labels and (percentage) data of a bar chart, in ascending order of size
+func chartSeries(freq map[int]int64, total int64) (string, error) {
+	keys := make([]int, 0, len(freq))
+	for k := range freq {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	series := struct {
+		Labels []string  `json:"labels"`
+		Data   []float64 `json:"data"`
+	}{
+		Labels: make([]string, 0, len(keys)),
+		Data:   make([]float64, 0, len(keys)),
+	}
+	for _, k := range keys {
+		series.Labels = append(series.Labels, strconv.Itoa(k))
+		series.Data = append(series.Data, percentageValue(freq[k], total))
+	}
+	b, err := json.Marshal(series)
+	return string(b), err
 }
 
 type chartData struct {
@@ -58,9 +125,176 @@ func barChart(domElement string, freq map[int]int64) chartData {
 	}
 }
 
+// humanBytes formats a byte count using binary (1024-based) units
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+type encodingRow struct {
+	Type ValueType
+	// Cells holds one entry per encoding, nil where the type/encoding pair was
+	// not observed
+	Cells []*EncodingStats
+}
+
+type encodingTable struct {
+	Encodings []string
+	Rows      []encodingRow
+}
+
+// typeTopValues holds the top values of a single data type, for rendering
+type typeTopValues struct {
+	Type   ValueType
+	Values []ValueCount
+}
+
+// topValues lists the top values of each data type, in report order
+func topValues(m map[ValueType]*TopValues) []typeTopValues {
+	var tvs []typeTopValues
+	for _, vt := range valueTypes {
+		if t, ok := m[vt]; ok {
+			tvs = append(tvs, typeTopValues{Type: vt, Values: t.Top()})
+		}
+	}
+	return tvs
+}
+
+// encodingMatrix lays out an encoding cross-tab as a matrix with one row per
+// data type and one column per encoding
+func encodingMatrix(encs map[ValueType]map[string]*EncodingStats) encodingTable {
+	seen := make(map[string]bool)
+	for _, m := range encs {
+		for enc := range m {
+			seen[enc] = true
+		}
+	}
+
+	var t encodingTable
+	for enc := range seen {
+		t.Encodings = append(t.Encodings, enc)
+	}
+	sort.Strings(t.Encodings)
+
+	for _, vt := range valueTypes {
+		m, ok := encs[vt]
+		if !ok {
+			continue
+		}
+		row := encodingRow{Type: vt}
+		for _, enc := range t.Encodings {
+			row.Cells = append(row.Cells, m[enc])
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t
+}
+
 // RenderHTML renders an HTML report for a Results instance to the supplied
-// io.Writer
+// io.Writer.  All key names, values and group names are HTML-escaped, and any
+// invalid UTF-8 within them is rendered as \xNN escape sequences.
 func RenderHTML(s *Results, out io.Writer) error {
+	return RenderHTMLWithOptions(s, out, HTMLOptions{})
+}
+
+// RenderHTMLFragment renders the same report as RenderHTML, but as a fragment
+// of HTML (without a doctype, head or body) suitable for embedding within an
+// existing page.  The fragment carries its own (scoped) styles and charting
+// script, but relies on the embedding page for Bootstrap 3 styling.
+func RenderHTMLFragment(s *Results, out io.Writer) error {
+	return RenderHTMLWithOptions(s, out, HTMLOptions{Fragment: true})
+}
+
+// HTMLOptions controls how an HTML report references its styles and scripts.
+// By default the Chart.js library, the chart styles and the script drawing
+// each chart are all inlined into the report, which a Content Security Policy
+// forbidding inline scripts and styles will refuse to run.
+type HTMLOptions struct {
+	// Fragment renders the report as an embeddable fragment, as
+	// RenderHTMLFragment does
+	Fragment bool
+
+	// AssetURL, if non-empty, is the URL (without a trailing slash) at which
+	// the assets listed in HTMLAssets are served.  The report then references
+	// those assets rather than inlining them, and carries each chart's data in
+	// an attribute of its canvas element, so it contains no inline script or
+	// style at all.  Use WriteHTMLAsset to serve (or save) the assets.
+	AssetURL string
+
+	// Static omits every script from the report, rendering each chart as a
+	// table of size frequencies instead
+	Static bool
+
+	// Summary, if set, describes the sampling run that produced the report
+	// (as returned by Run), and is shown in its header: the sample size and
+	// coverage, the server version, and when the report was generated
+	Summary *Summary
+}
+
+// runHeader describes a sampling run in the header of a report (see
+// HTMLOptions.Summary and RenderTextWithSummary)
+type runHeader struct {
+	Summary
+	CoveragePercent float64
+	GeneratedAt     time.Time
+}
+
+// newRunHeader returns the header describing the run summarized by `summary`,
+// or nil if there is none
+func newRunHeader(summary *Summary) *runHeader {
+	if summary == nil {
+		return nil
+	}
+	return &runHeader{Summary: *summary, CoveragePercent: 100 * summary.Coverage(), GeneratedAt: time.Now().UTC()}
+}
+
+// HTMLAssets lists the names of the assets referenced by a report rendered
+// with HTMLOptions.AssetURL set
+var HTMLAssets = []string{"Chart.min.js", "reckon.js", "reckon.css"}
+
+// WriteHTMLAsset writes the named asset (one of HTMLAssets) to the supplied
+// io.Writer
+func WriteHTMLAsset(name string, out io.Writer) error {
+	var data []byte
+	switch name {
+	case "Chart.min.js":
+		var err error
+		if data, err = Asset(name); err != nil {
+			return err
+		}
+	case "reckon.js":
+		data = []byte(reckonJS)
+	case "reckon.css":
+		data = []byte(reckonCSS)
+	default:
+		return fmt.Errorf("Unknown HTML asset: %q", name)
+	}
+	_, err := out.Write(data)
+	return err
+}
+
+// RenderHTMLWithOptions renders an HTML report for a Results instance to the
+// supplied io.Writer, as configured by opts
+func RenderHTMLWithOptions(s *Results, out io.Writer, opts HTMLOptions) error {
+	name := "base"
+	if opts.Fragment {
+		name = "fragment"
+	}
+	return renderHTML(s, out, name, opts)
+}
+
+// renderHTML renders the named template from the HTML report templates
+func renderHTML(s *Results, out io.Writer, name string, opts HTMLOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	s.StringKeys = trim(s.StringKeys, MaxExampleKeys)
 	s.StringValues = trim(s.StringValues, MaxExampleValues)
@@ -74,22 +308,122 @@ func RenderHTML(s *Results, out io.Writer) error {
 	s.ListKeys = trim(s.ListKeys, MaxExampleKeys)
 	s.ListElements = trim(s.ListElements, MaxExampleElements)
 
-	fm := template.FuncMap{
+	t := htmltemplate.Must(htmltemplate.New("htmloutput").Funcs(htmlFuncs(opts)).Parse(htmlTmpl))
+	return t.ExecuteTemplate(out, name, s)
+}
+
+// htmlFuncs returns the functions available to the HTML report templates
+func htmlFuncs(opts HTMLOptions) htmltemplate.FuncMap {
+	header := newRunHeader(opts.Summary)
+	return htmltemplate.FuncMap{
 		"summarize":  summarize,
 		"percentage": percentage,
-		"power":      ComputePowerOfTwoFreq,
+		"buckets":    ComputeBucketFreq,
 		"stats":      ComputeStatistics,
 		"fmtFloat":   fmtFloat,
 		"barChart":   barChart,
 		"chartJS":    chartJS,
+		"copyJS":     func() htmltemplate.JS { return copyJS },
+		"copyCSS":    func() htmltemplate.CSS { return copyCSS },
+		"printable":  printable,
+
+		"bucketLabel": bucketLabel,
+
+		"percentageValue": percentageValue,
+		"encodingMatrix":  encodingMatrix,
+		"humanBytes":      humanBytes,
+		"topValues":       topValues,
+		"lowConfidence":   (*Results).lowConfidence,
+		"compression":     (*Results).compressionRatio,
+		"temperature":     (*Results).temperature,
+		"ttls":            (*Results).ttlDistribution,
+		"memoryUsage":     (*Results).memoryUsage,
+		"recommendations": reportRecommendations,
+		"types":           (*Results).types,
+		"chartSeries":     chartSeries,
+
+		"assetURL": func() string { return opts.AssetURL },
+		"static":   func() bool { return opts.Static },
+		"run":      func() *runHeader { return header },
 	}
-	t := template.Must(template.New("htmloutput").Funcs(fm).Parse(htmlTmpl))
-	return t.ExecuteTemplate(out, "base", s)
+}
+
+// indexEntry summarizes the report for a single aggregation group, for
+// RenderIndex
+type indexEntry struct {
+	Group         string
+	Href          string
+	KeyCount      int64
+	TypeCounts    []int64 // in the order of valueTypes
+	BigKeys       int
+	LowConfidence bool
+
+	// Memory is the memory used by the sampled keys, and MeanMemory their
+	// mean, if measured (see Options.CollectMemoryUsage)
+	Memory          int64
+	MeanMemory      float64
+	MemoryEstimated bool
+}
+
+// RenderIndex renders an HTML index page to the supplied io.Writer, linking to
+// the reports for each of the aggregation groups in `stats` (e.g. as rendered
+// by RenderHTML, one file per group), largest group first.  Each link is
+// accompanied by summary statistics for the group, so that a set of reports
+// can be browsed rather than opened one by one.  `href` supplies the URL of
+// each group's report, relative to the index page.  The index page carries no
+// scripts or inline styles.
+func RenderIndex(stats map[string]*Results, out io.Writer, href func(group string) string) error {
+	var entries []indexEntry
+	for _, gr := range Ordered(stats, ByKeyCount) {
+		counts := gr.Results.TypeCounts()
+		e := indexEntry{
+			Group:         gr.Group,
+			Href:          href(gr.Group),
+			TypeCounts:    make([]int64, len(valueTypes)),
+			LowConfidence: gr.Results.LowConfidence(),
+		}
+		for i, vt := range valueTypes {
+			e.TypeCounts[i] = counts[vt]
+		}
+
+		gr.Results.mu.Lock()
+		e.KeyCount = gr.Results.KeyCount
+		e.BigKeys = len(gr.Results.BigKeys)
+		if len(gr.Results.MemoryUsages) > 0 {
+			e.Memory = gr.Results.memoryUsage()
+			e.MeanMemory = ComputeStatistics(gr.Results.MemoryUsages).Mean
+			e.MemoryEstimated = gr.Results.MemoryEstimated
+		}
+		gr.Results.mu.Unlock()
+
+		entries = append(entries, e)
+	}
+
+	t := htmltemplate.Must(htmltemplate.New("htmloutput").Funcs(htmlFuncs(HTMLOptions{Static: true})).Parse(htmlTmpl))
+	return t.ExecuteTemplate(out, "index", entries)
 }
 
 // RenderText renders a plaintext report for a Results instance to the supplied
 // io.Writer
 func RenderText(s *Results, out io.Writer) error {
+	return renderText(s, out, nil)
+}
+
+// RenderTextWithSummary renders the same report as RenderText, headed by a
+// description of the sampling run summarized by `summary` (as returned by
+// Run): the sample size and coverage, the server version, and when the report
+// was generated
+func RenderTextWithSummary(s *Results, out io.Writer, summary Summary) error {
+	return renderText(s, out, &summary)
+}
+
+// renderText renders a plaintext report, headed by a description of the run
+// summarized by `summary`, if any
+func renderText(s *Results, out io.Writer, summary *Summary) error {
+	header := newRunHeader(summary)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	s.StringKeys = trim(s.StringKeys, MaxExampleKeys)
 	s.StringValues = trim(s.StringValues, MaxExampleValues)
@@ -106,9 +440,23 @@ func RenderText(s *Results, out io.Writer) error {
 	fm := template.FuncMap{
 		"summarize":  summarize,
 		"percentage": percentage,
-		"power":      ComputePowerOfTwoFreq,
+		"buckets":    ComputeBucketFreq,
 		"stats":      ComputeStatistics,
 		"fmtFloat":   fmtFloat,
+		"printable":  printable,
+
+		"bucketLabel": bucketLabel,
+		"topValues":   topValues,
+		"humanBytes":  humanBytes,
+
+		"lowConfidence":   (*Results).lowConfidence,
+		"compression":     (*Results).compressionRatio,
+		"temperature":     (*Results).temperature,
+		"ttls":            (*Results).ttlDistribution,
+		"memoryUsage":     (*Results).memoryUsage,
+		"recommendations": reportRecommendations,
+		"types":           (*Results).types,
+		"run":             func() *runHeader { return header },
 	}
 	t := template.Must(template.New("output").Funcs(fm).Parse(statsTempl))
 	return t.ExecuteTemplate(out, "base", s)
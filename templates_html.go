@@ -17,6 +17,71 @@
 package reckon
 
 const (
+	// reckonCSS styles the charts of a report rendered with an AssetURL
+	reckonCSS = `canvas.reckon-chart {
+  width: 75%;
+  height: auto;
+  margin-left: auto;
+  margin-right: auto;
+  display: block;
+}
+` + copyCSS
+
+	// copyCSS shows key names in full, however long, alongside their
+	// copy-to-clipboard buttons
+	copyCSS = `code.reckon-key {
+  word-break: break-all;
+}
+button.reckon-copy {
+  margin-left: 0.5em;
+}
+`
+
+	// copyJS reveals the copy-to-clipboard button next to each key name and
+	// copies the key when it is clicked.  The buttons stay hidden where
+	// scripts (or the clipboard API) are unavailable, leaving the key names
+	// to be selected by hand.
+	copyJS = `document.addEventListener("DOMContentLoaded", function() {
+  if (!navigator.clipboard) {
+    return;
+  }
+  var buttons = document.querySelectorAll("button.reckon-copy");
+  for (var i = 0; i < buttons.length; i++) {
+    buttons[i].hidden = false;
+    buttons[i].addEventListener("click", function(e) {
+      var button = e.currentTarget;
+      navigator.clipboard.writeText(button.getAttribute("data-key")).then(function() {
+        button.textContent = "copied";
+      });
+    });
+  }
+});
+`
+
+	// reckonJS draws the charts of a report rendered with an AssetURL, reading
+	// each chart's labels and data from its data-chart attribute
+	reckonJS = `document.addEventListener("DOMContentLoaded", function() {
+  var charts = document.querySelectorAll("canvas.reckon-chart");
+  for (var i = 0; i < charts.length; i++) {
+    var series = JSON.parse(charts[i].getAttribute("data-chart"));
+    var data = {
+      labels: series.labels,
+      datasets: [
+      {
+        label: "size frequencies",
+        fillColor: "rgba(151,187,205,0.5)",
+        strokeColor: "rgba(151,187,205,0.8)",
+        highlightFill: "rgba(151,187,205,0.75)",
+        highlightStroke: "rgba(151,187,205,1)",
+        data: series.data
+      }
+      ]
+    };
+    new Chart(charts[i].getContext("2d")).Bar(data, {"scaleLabel": "<%=value%>%"});
+  }
+});
+` + copyJS
+
 	htmlTmpl = `
 {{define "base"}}
 
@@ -29,6 +94,13 @@ const (
     <title>reckoning</title>
     <link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.4/css/bootstrap.min.css">
 
+    {{ if assetURL }}
+    <link rel="stylesheet" href="{{assetURL}}/reckon.css">
+    {{ if not static }}
+    <script src="{{assetURL}}/Chart.min.js"></script>
+    <script src="{{assetURL}}/reckon.js"></script>
+    {{ end }}
+    {{ else if not static }}
     <style>
       canvas {
         width: 75%;
@@ -37,16 +109,384 @@ const (
 			  margin-right: auto;
 			  display: block;
       }
+      {{copyCSS}}
     </style>
 
 		<script type="text/javascript">{{chartJS}}</script>
+		<script type="text/javascript">{{copyJS}}</script>
+    {{ end }}
+  </head>
+  <body>
+    <div class="container">
+      {{template "report" .}}
+    </div>
+
+		{{ if not static }}
+		<script src="https://ajax.googleapis.com/ajax/libs/jquery/1.11.2/jquery.min.js"></script>
+		<script src="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.4/js/bootstrap.min.js"></script>
+		{{ end }}
+	</body>
+</html>
+
+{{end}}
+
+{{define "index"}}
+
+<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <meta http-equiv="X-UA-Compatible" content="IE=edge">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>reckoning</title>
+    <link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.4/css/bootstrap.min.css">
   </head>
   <body>
     <div class="container">
       <div class="jumbotron">
-        <h1>{{.Name}} <small>{{.KeyCount}} keys</small></h1>
+        <h1>reckoning <small>{{len .}} groups</small></h1>
       </div>
 
+      {{ $memory := false }}{{ range . }}{{ if .Memory }}{{ $memory = true }}{{ end }}{{ end }}
+      <table class="table table-striped">
+        <thead>
+          <tr>
+            <th>Group</th>
+            <th>Keys</th>
+            <th>Strings</th>
+            <th>Lists</th>
+            <th>Sets</th>
+            <th>Sorted Sets</th>
+            <th>Hashes</th>
+            <th>Big Keys</th>
+            {{ if $memory }}
+            <th>Memory</th>
+            <th>Mean memory</th>
+            {{ end }}
+          </tr>
+        </thead>
+        <tbody>
+        {{range .}}
+          <tr>
+            <td><a href="{{.Href}}">{{printable .Group}}</a>{{ if .LowConfidence }} <span class="label label-warning">low confidence</span>{{ end }}</td>
+            <td>{{.KeyCount}}</td>
+            {{range .TypeCounts}}<td>{{.}}</td>{{end}}
+            <td>{{.BigKeys}}</td>
+            {{ if $memory }}
+            <td>{{ if .MemoryEstimated }}~{{ end }}{{humanBytes .Memory}}</td>
+            <td>{{ if .MemoryEstimated }}~{{ end }}{{fmtFloat .MeanMemory}}B</td>
+            {{ end }}
+          </tr>
+        {{end}}
+        </tbody>
+      </table>
+    </div>
+  </body>
+</html>
+
+{{end}}
+
+{{define "fragment"}}
+<div class="reckon-report">
+  {{ if assetURL }}
+  <link rel="stylesheet" href="{{assetURL}}/reckon.css">
+  {{ if not static }}
+  <script src="{{assetURL}}/Chart.min.js"></script>
+  <script src="{{assetURL}}/reckon.js"></script>
+  {{ end }}
+  {{ else if not static }}
+  <style>
+    .reckon-report canvas {
+      width: 75%;
+      height: auto;
+      margin-left: auto;
+      margin-right: auto;
+      display: block;
+    }
+    {{copyCSS}}
+  </style>
+  <script type="text/javascript">{{chartJS}}</script>
+  <script type="text/javascript">{{copyJS}}</script>
+  {{ end }}
+  {{template "report" .}}
+</div>
+{{end}}
+
+{{define "report"}}
+      <div class="jumbotron">
+        <h1>{{printable .Name}} <small>{{.KeyCount}} keys{{ if .Exact }} (exact census){{ end }}</small></h1>
+        {{ with .Server }}
+          <p>
+            {{ if .Version }}redis {{.Version}}, {{ end }}{{.KeyCount}} keys in the keyspace,
+            {{humanBytes .UsedMemory}} used{{ if .MaxMemory }} of {{humanBytes .MaxMemory}} maxmemory{{ end }}{{ if .MaxMemoryPolicy }} ({{.MaxMemoryPolicy}}){{ end }}
+          </p>
+          {{ with .Scripts }}
+          <p>
+            outside the keyspace: {{.CachedScripts}} cached scripts ({{humanBytes .ScriptMemory}}),
+            {{.Functions}} functions in {{.Libraries}} libraries ({{humanBytes .FunctionMemory}}),
+            and {{humanBytes .EngineMemory}} used by the script engines
+          </p>
+          {{ end }}
+          {{ if .Replica }}{{ with .Replication }}
+          <p>
+            sampled from a replica{{ if .Master }} of {{.Master}}{{ end }}:
+            {{ if .LinkUp }}link up, last heard from the master {{.LastIO}} ago{{ else }}link down{{ if .LinkDown }} for {{.LinkDown}}{{ end }}{{ end }}{{ if .SyncInProgress }}, sync in progress{{ end }}{{ if .Offset }}, replication offset {{.Offset}}{{ end }}
+          </p>
+          {{ end }}{{ end }}
+        {{ end }}
+        {{ with run }}
+          <p>
+            {{.Sampled}} of {{.KeyCount}} keys sampled ({{fmtFloat .CoveragePercent}}% coverage){{ if .Skipped }}, {{.Skipped}} skipped{{ end }}, in {{.Duration}}{{ if .Server.Version }}, from redis {{.Server.Version}}{{ end }};
+            generated {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}}
+          </p>
+        {{ end }}
+        {{ if .TrivialKeys }}
+          <p>{{.TrivialKeys}} trivial keys (smaller than {{.MinSize}} bytes) were excluded from these statistics</p>
+        {{ end }}
+        {{ range .Warnings }}
+          <div class="alert alert-warning">{{.}}</div>
+        {{ end }}
+        {{ if lowConfidence . }}
+          <div class="alert alert-warning">Only {{.KeyCount}} keys were sampled for this group (fewer than {{.MinGroupSamples}}), so these statistics may not be meaningful</div>
+        {{ end }}
+      </div>
+
+			{{ with types . }}
+			  <h1>Types</h1>
+				<div class="panel panel-default">
+					<div class="panel-body">
+						<table class="table table-striped">
+							<thead>
+								<tr>
+									<th>Type</th>
+									<th># of keys</th>
+									<th>%</th>
+									<th>Mean length</th>
+									<th>Mean value size</th>
+								</tr>
+							</thead>
+							<tbody>
+							{{range .}}
+								<tr><td>{{.Type}}</td> <td>{{.Keys}}</td> <td>{{percentage .Keys $.KeyCount}}%</td> <td>{{fmtFloat .Lengths.Mean}}</td> <td>{{ if .Values }}{{fmtFloat .ValueSizes.Mean}}{{ else }}-{{ end }}</td></tr>
+							{{end}}
+							</tbody>
+						</table>
+					</div>
+				</div>
+			{{ end }}
+
+			{{ if .BigKeys }}
+			  <h1>Big Keys <small>{{len .BigKeys}} keys</small></h1>
+				<div class="panel panel-danger">
+					<div class="panel-body">
+						<table class="table table-striped">
+							<thead>
+								<tr>
+									<th>Key</th>
+									<th>Type</th>
+									<th>Length</th>
+									<th>~Size</th>
+								</tr>
+							</thead>
+							<tbody>
+							{{range .BigKeys}}
+								<tr><td>{{template "key" .Key}}</td> <td>{{.Type}}</td> <td>{{.Length}}</td> <td>{{humanBytes .Size}}</td></tr>
+							{{end}}
+							</tbody>
+						</table>
+					</div>
+				</div>
+			{{ end }}
+
+			{{ if .ShortLivedSizes }}
+			  <h1>Short-Lived Keys <small>expiring within {{.ShortTTL}}</small></h1>
+				<div class="panel panel-warning">
+					<div class="panel-body">
+						<h3>~Sizes: {{template "stats" .ShortLivedSizes}}</h3>
+						{{template "freq" .ShortLivedSizes}}
+						<h3>{{template "bucketsTitle" $}} ~Sizes:</h3>
+						{{template "freq" buckets .ShortLivedSizes $.Buckets}}
+					</div>
+				</div>
+			{{ end }}
+
+			{{ with ttls . }}
+			  <h1>Expiration <small>{{$.Expiring}} keys with an expiry, {{$.Persistent}} without</small></h1>
+				<div class="panel panel-default">
+					<div class="panel-body">
+						{{ if $.Expiring }}
+						<h3>TTLs (s): {{template "stats" $.TTLs}}</h3>
+						<table class="table table-striped">
+							<thead>
+								<tr>
+									<th>Remaining TTL</th>
+									<th># of keys</th>
+									<th>%</th>
+								</tr>
+							</thead>
+							<tbody>
+							{{range .}}
+								<tr><td>{{.Label}}</td> <td>{{.Keys}}</td> <td>{{percentage .Keys $.Expiring}}%</td></tr>
+							{{end}}
+							</tbody>
+						</table>
+						{{ end }}
+					</div>
+				</div>
+			{{ end }}
+
+			{{ if .MemoryUsages }}
+			  <h1>Memory Usage <small>{{ if .MemoryEstimated }}~{{ end }}{{humanBytes (memoryUsage .)}} sampled</small></h1>
+				<div class="panel panel-default">
+					<div class="panel-body">
+						{{ if .MemoryEstimated }}
+						<div class="alert alert-warning">MEMORY USAGE was unavailable for some keys, so their memory usage was estimated from their sampled contents, without redis' overheads</div>
+						{{ end }}
+						{{ with stats .MemoryUsages }}
+						<table class="table table-striped">
+							<thead>
+								<tr>
+									<th>Total</th>
+									<th>Min</th>
+									<th>Max</th>
+									<th>Mean</th>
+								</tr>
+							</thead>
+							<tbody>
+								<tr><td>{{humanBytes (memoryUsage $)}}</td> <td>{{.Min}}B</td> <td>{{.Max}}B</td> <td>{{fmtFloat .Mean}}B</td></tr>
+							</tbody>
+						</table>
+						{{ end }}
+						<h3>2<sup><var>n</var></sup> Memory Usages (bytes):</h3>
+						{{template "freq" buckets .MemoryUsages nil}}
+					</div>
+				</div>
+			{{ end }}
+
+			{{ if .FetchLatencies }}
+			  <h1>Fetch Latencies <small>&micro;s</small></h1>
+				<div class="panel panel-default">
+					<div class="panel-body">
+						<h3>Latencies: {{template "stats" .FetchLatencies}}</h3>
+						<h3>2<sup><var>n</var></sup> Latencies:</h3>
+						{{template "freq" buckets .FetchLatencies nil}}
+					</div>
+				</div>
+			{{ end }}
+
+			{{ with temperature . }}
+			  <h1>Access <small>{{.}}</small></h1>
+				<div class="panel panel-default">
+					<div class="panel-body">
+						{{ if $.IdleTimes }}
+						<h3>Idle Times (s): {{template "stats" $.IdleTimes}}</h3>
+						<h3>2<sup><var>n</var></sup> Idle Times:</h3>
+						{{template "freq" buckets $.IdleTimes nil}}
+						{{ end }}
+						{{ if $.AccessFrequencies }}
+						<h3>Access Frequencies: {{template "stats" $.AccessFrequencies}}</h3>
+						<h3>2<sup><var>n</var></sup> Access Frequencies:</h3>
+						{{template "freq" buckets $.AccessFrequencies nil}}
+						{{ end }}
+					</div>
+				</div>
+			{{ end }}
+
+			{{ if .StoredValueSizes }}
+			  <h1>Compression <small>{{fmtFloat (compression .)}}&times; (logical / stored value size)</small></h1>
+				<div class="panel panel-default">
+					<div class="panel-body">
+						<h3>Stored Value Sizes: {{template "stats" .StoredValueSizes}}</h3>
+						<h3>{{template "bucketsTitle" $}} Stored Value Sizes:</h3>
+						{{template "freq" buckets .StoredValueSizes $.Buckets}}
+						<h3>Logical Value Sizes: {{template "stats" .LogicalValueSizes}}</h3>
+						<h3>{{template "bucketsTitle" $}} Logical Value Sizes:</h3>
+						{{template "freq" buckets .LogicalValueSizes $.Buckets}}
+					</div>
+				</div>
+			{{ end }}
+
+			{{ with recommendations . }}
+			  <h1>Recommendations</h1>
+				<div class="panel panel-info">
+					<div class="panel-body">
+						<ul>
+						{{range .}}
+							<li>{{.}}</li>
+						{{end}}
+						</ul>
+					</div>
+				</div>
+			{{ end }}
+
+			{{ if .Encodings }}
+			  <h1>Encodings</h1>
+				<div class="panel panel-default">
+					<div class="panel-body">
+						{{template "encodings" encodingMatrix .Encodings}}
+					</div>
+				</div>
+			{{ end }}
+
+			{{ if .Instances }}
+			  <h1>Instances</h1>
+				<div class="panel panel-default">
+					<div class="panel-body">
+						<table class="table table-striped">
+							<thead>
+								<tr>
+									<th>Instance</th>
+									<th># of keys</th>
+									<th>%</th>
+								</tr>
+							</thead>
+							<tbody>
+							{{range $tag, $n := .Instances}}
+								<tr><td>{{printable $tag}}</td> <td>{{$n}}</td> <td>{{percentage $n $.KeyCount}}%</td></tr>
+							{{end}}
+							</tbody>
+						</table>
+					</div>
+				</div>
+			{{ end }}
+
+			{{ if .KeyNameSizes }}
+			  <h1>Key Names</h1>
+				<div class="panel panel-default">
+					<div class="panel-body">
+						<h3>Key Name Sizes: {{template "stats" .KeyNameSizes}}</h3>
+						{{template "freq" .KeyNameSizes}}
+						{{template "barchart" barChart "KeyNameSizes" .KeyNameSizes}}
+						<h3>{{template "bucketsTitle" $}} Key Name Sizes:</h3>
+						{{template "freq" buckets .KeyNameSizes $.Buckets}}
+					</div>
+				</div>
+			{{ end }}
+
+			{{ if .TopValues }}
+			  <h1>Top Values</h1>
+				<div class="panel panel-default">
+					<div class="panel-body">
+						{{range topValues .TopValues}}
+							<h3>{{.Type}}</h3>
+							<table class="table table-striped">
+								<thead>
+									<tr>
+										<th>Value</th>
+										<th>~Count</th>
+									</tr>
+								</thead>
+								<tbody>
+								{{range .Values}}
+									<tr><td>{{printable .Value}}</td> <td>{{.Count}}</td></tr>
+								{{end}}
+								</tbody>
+							</table>
+						{{end}}
+					</div>
+				</div>
+			{{ end }}
+
 			{{ if .StringKeys }}
 			  <h1>Strings <small>{{summarize .StringSizes}}</small> </h1>
 				<div class="panel panel-default">
@@ -55,8 +495,8 @@ const (
 						<h3>Value Sizes: {{template "stats" .StringSizes}}</h3>
 						{{template "freq" .StringSizes}}
 						{{template "barchart" barChart "StringSizes" .StringSizes}}
-						<h3>2<sup><var>n</var></sup> Value Sizes:</h3>
-						{{template "freq" power .StringSizes}}
+						<h3>{{template "bucketsTitle" $}} Value Sizes:</h3>
+						{{template "freq" buckets .StringSizes $.Buckets}}
 					</div>
 				</div>
 			{{ end }}
@@ -66,18 +506,18 @@ const (
 				<div class="panel panel-default">
 					<div class="panel-body">
 						<h3>Example keys:</h3> {{template "examples" .SetKeys}}
-						<h3>Sizes: {{template "stats" .SetSizes}}</h3>
+						<h3>Cardinalities (# of elements): {{template "stats" .SetSizes}}</h3>
 						{{template "freq" .SetSizes}}
 						{{template "barchart" barChart "SetSizes" .SetSizes}}
-						<h3>2<sup><var>n</var></sup> Sizes:</h3>
-						{{template "freq" power .SetSizes}}
+						<h3>{{template "bucketsTitle" $}} Cardinalities:</h3>
+						{{template "freq" buckets .SetSizes $.Buckets}}
 
 						<h3>Example elements:</h3> {{template "examples" .SetElements}}
 						<h3>Element Sizes: {{template "stats" .SetElementSizes}}</h3>
 						{{template "freq" .SetElementSizes}}
 						{{template "barchart" barChart "SetElementSizes" .SetElementSizes}}
-						<h3>2<sup><var>n</var></sup> Element Sizes:</h3>
-						{{template "freq" power .SetElementSizes}}
+						<h3>{{template "bucketsTitle" $}} Element Sizes:</h3>
+						{{template "freq" buckets .SetElementSizes $.Buckets}}
 					</div>
 				</div>
 			{{ end }}
@@ -87,18 +527,18 @@ const (
 				<div class="panel panel-default">
 					<div class="panel-body">
 						<h3>Example keys:</h3> {{template "examples" .SortedSetKeys}}
-						<h3>Sizes: {{template "stats" .SortedSetSizes}}</h3>
+						<h3>Cardinalities (# of elements): {{template "stats" .SortedSetSizes}}</h3>
 						{{template "freq" .SortedSetSizes}}
 						{{template "barchart" barChart "SortedSetSizes" .SortedSetSizes}}
-						<h3>2<sup><var>n</var></sup> Sizes:</h3>
-						{{template "freq" power .SortedSetSizes}}
+						<h3>{{template "bucketsTitle" $}} Cardinalities:</h3>
+						{{template "freq" buckets .SortedSetSizes $.Buckets}}
 
 						<h3>Example elements:</h3> {{template "examples" .SortedSetElements}}
 						<h3>Element Sizes: {{template "stats" .SortedSetElementSizes}}</h3>
 						{{template "freq" .SortedSetElementSizes}}
 						{{template "barchart" barChart "SortedSetElementSizes" .SortedSetElementSizes}}
-						<h3>2<sup><var>n</var></sup> Element Sizes:</h3>
-						{{template "freq" power .SortedSetElementSizes}}
+						<h3>{{template "bucketsTitle" $}} Element Sizes:</h3>
+						{{template "freq" buckets .SortedSetElementSizes $.Buckets}}
 					</div>
 				</div>
 			{{ end }}
@@ -108,18 +548,25 @@ const (
 				<div class="panel panel-default">
 					<div class="panel-body">
 						<h3>Example keys:</h3> {{template "examples" .ListKeys}}
-						<h3>Sizes: {{template "stats" .ListSizes}}</h3>
+						<h3>Cardinalities (# of elements): {{template "stats" .ListSizes}}</h3>
 						{{template "freq" .ListSizes}}
 						{{template "barchart" barChart "ListSizes" .ListSizes}}
-						<h3>2<sup><var>n</var></sup> Sizes:</h3>
-						{{template "freq" power .ListSizes}}
+						<h3>{{template "bucketsTitle" $}} Cardinalities:</h3>
+						{{template "freq" buckets .ListSizes $.Buckets}}
 
 						<h3>Example elements:</h3> {{template "examples" .ListElements}}
 						<h3>Element Sizes: {{template "stats" .ListElementSizes}}</h3>
 						{{template "freq" .ListElementSizes}}
 						{{template "barchart" barChart "ListElementSizes" .ListElementSizes}}
-						<h3>2<sup><var>n</var></sup> Element Sizes:</h3>
-						{{template "freq" power .ListElementSizes}}
+						<h3>{{template "bucketsTitle" $}} Element Sizes:</h3>
+						{{template "freq" buckets .ListElementSizes $.Buckets}}
+
+						{{ if .ListHeadElementSizes }}
+						<h3>Head Element Sizes: {{template "stats" .ListHeadElementSizes}}</h3>
+						{{template "freq" .ListHeadElementSizes}}
+						<h3>Tail Element Sizes: {{template "stats" .ListTailElementSizes}}</h3>
+						{{template "freq" .ListTailElementSizes}}
+						{{ end }}
 					</div>
 				</div>
 			{{ end }}
@@ -129,36 +576,29 @@ const (
 				<div class="panel panel-default">
 					<div class="panel-body">
 						<h3>Example keys:</h3> {{template "examples" .HashKeys}}
-						<h3>Sizes: {{template "stats" .HashSizes}}</h3>
+						<h3>Cardinalities (# of elements): {{template "stats" .HashSizes}}</h3>
 						{{template "freq" .HashSizes}}
 						{{template "barchart" barChart "HashSizes" .HashSizes}}
-						<h3>2<sup><var>n</var></sup> Sizes:</h3>
-						{{template "freq" power .HashSizes}}
+						<h3>{{template "bucketsTitle" $}} Cardinalities:</h3>
+						{{template "freq" buckets .HashSizes $.Buckets}}
 
 						<h3>Example elements:</h3> {{template "examples" .HashElements}}
 						<h3>Element Sizes: {{template "stats" .HashElementSizes}}</h3>
 						{{template "freq" .HashElementSizes}}
 						{{template "barchart" barChart "HashElementSizes" .HashElementSizes}}
-						<h3>2<sup><var>n</var></sup> Element Sizes:</h3>
-						{{template "freq" power .HashElementSizes}}
+						<h3>{{template "bucketsTitle" $}} Element Sizes:</h3>
+						{{template "freq" buckets .HashElementSizes $.Buckets}}
 
 						<h3>Example values:</h3> {{template "examples" .HashValues}}
 						<h3>Value Sizes: {{template "stats" .HashValueSizes}}</h3>
 						{{template "freq" .HashValueSizes}}
 						{{template "barchart" barChart "HashValueSizes" .HashValueSizes}}
-						<h3>2<sup><var>n</var></sup> Value Sizes:</h3>
-						{{template "freq" power .HashValueSizes}}
+						<h3>{{template "bucketsTitle" $}} Value Sizes:</h3>
+						{{template "freq" buckets .HashValueSizes $.Buckets}}
 					</div>
 				</div>
 			{{ end }}
 
-		 </container>
-
-		<script src="https://ajax.googleapis.com/ajax/libs/jquery/1.11.2/jquery.min.js"></script>
-		<script src="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.4/js/bootstrap.min.js"></script>
-	</body>
-</html>
-
 {{end}}
 
 {{define "barchart"}}
@@ -167,10 +607,23 @@ const (
   {{ $l := len .Data }}
   {{ if ge $l 4}}
 	{{ $total := summarize .Data }}
+	{{ if static }}
+	<table class="table table-condensed">
+		<thead>
+			<tr><th>Size</th><th>Frequency</th></tr>
+		</thead>
+		<tbody>
+		{{range $k, $v := .Data}}
+			<tr><td>{{$k}}</td><td>{{percentage $v $total}}%</td></tr>
+		{{end}}
+		</tbody>
+	</table>
+	{{ else }}
 	<button class="btn btn-primary" type="button" data-toggle="collapse" data-target="#{{.DOMElement}}Collapse">toggle chart</button>
 	<div class="collapse in" id="{{.DOMElement}}Collapse">
-		<canvas id="{{.DOMElement}}"></canvas>
+		<canvas id="{{.DOMElement}}"{{ if assetURL }} class="reckon-chart" data-chart="{{chartSeries .Data $total}}"{{ end }}></canvas>
   </div>
+	{{ if not assetURL }}
 	<script type="text/javascript">
     // Chart.defaults.global.responsive = true;
 		var ctx = document.getElementById("{{.DOMElement}}").getContext("2d");
@@ -184,28 +637,50 @@ const (
 				strokeColor: "rgba(151,187,205,0.8)",
 				highlightFill: "rgba(151,187,205,0.75)",
 				highlightStroke: "rgba(151,187,205,1)",
-				data: [ {{range $k, $v := .Data}} {{percentage $v $total}}, {{end}} ]
+				data: [ {{range $k, $v := .Data}} {{percentageValue $v $total}}, {{end}} ]
 			}
 			]
 		};
 		new Chart(ctx).Bar(data, {"scaleLabel": "<%=value%>%"});
 	</script>
 	{{end}}
+	{{end}}
+	{{end}}
 {{end}}
 
+{{define "encodings"}}
+	<table class="table table-striped">
+		<thead>
+			<tr>
+				<th>Type</th>
+				{{range .Encodings}}<th>{{.}}</th>{{end}}
+			</tr>
+		</thead>
+		<tbody>
+		{{range .Rows}}
+			<tr><td>{{.Type}}</td> {{range .Cells}}<td>{{if .}}{{.Keys}} keys, ~{{humanBytes .Bytes}}{{end}}</td> {{end}}</tr>
+		{{end}}
+		</tbody>
+	</table>
+{{end}}
+
+{{define "bucketsTitle"}}{{if .Buckets}}Bucketed{{else}}2<sup><var>n</var></sup>{{end}}{{end}}
+
 {{define "stats"}}
 	{{ with stats . }}
-		<small>(min: {{.Min}} max: {{.Max}} mean: {{fmtFloat .Mean}} std dev: {{fmtFloat .StdDev}})</small>
+		<small>(min: {{.Min}} max: {{.Max}} mean: {{fmtFloat .Mean}} std dev: {{fmtFloat .StdDev}} gini: {{fmtFloat .Gini}})</small>
 	{{end}}
 {{end}}
 
 {{define "examples"}}
 	<ul class="list-inline">
 	{{range $k, $v := .}}
-		<li><code>{{$k}}</code></li>
+		<li>{{template "key" $k}}</li>
 	{{end}}
 {{end}}
 
+{{define "key"}}<code class="reckon-key">{{printable .}}</code>{{ if not static }}<button type="button" class="btn btn-default btn-xs reckon-copy" data-key="{{printable .}}" title="Copy to clipboard" hidden>copy</button>{{ end }}{{end}}
+
 {{define "freq"}}
 {{ $ss := summarize . }}
   <table class="table table-striped">
@@ -218,7 +693,7 @@ const (
 		</thead>
 		<tbody>
 		{{ range $s, $c := .}}
-			<tr><td>{{$s}}</td> <td>{{$c}}</td> <td>{{percentage $c $ss}}%</td></tr>
+			<tr><td>{{bucketLabel $s}}</td> <td>{{$c}}</td> <td>{{percentage $c $ss}}%</td></tr>
 		{{end}}
 		</tbody>
 	</table>
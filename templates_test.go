@@ -0,0 +1,311 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderHTMLEncodings(t *testing.T) {
+
+	r := NewResults()
+	r.observeHash("h1", 1000, "field", "value")
+	r.observeEncoding(TypeHash, "listpack", 30*1024*1024)
+	r.observeEncoding(TypeHash, "hashtable", 200)
+
+	var buf bytes.Buffer
+	if err := RenderHTML(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, s := range []string{"<th>hashtable</th>", "<th>listpack</th>", "1 keys, ~30.0MB", "1 keys, ~200B"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected HTML output to contain: %s", s)
+		}
+	}
+}
+
+func TestRenderHTMLFragment(t *testing.T) {
+
+	r := NewResults()
+	r.Name = "fragment"
+	r.observeString("s", "value")
+
+	var page, fragment bytes.Buffer
+	if err := RenderHTML(r, &page); err != nil {
+		t.Fatal(err)
+	}
+	if err := RenderHTMLFragment(r, &fragment); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{"<!DOCTYPE html>", "<html", "<head>", "<body>"} {
+		if !strings.Contains(page.String(), s) {
+			t.Errorf("expected the standalone report to contain: %s", s)
+		}
+		if strings.Contains(fragment.String(), s) {
+			t.Errorf("expected the fragment not to contain: %s", s)
+		}
+	}
+	for _, s := range []string{`<div class="reckon-report">`, "<h1>fragment <small>1 keys</small></h1>"} {
+		if !strings.Contains(fragment.String(), s) {
+			t.Errorf("expected the fragment to contain: %s", s)
+		}
+	}
+}
+
+func TestRenderHTMLWithOptions(t *testing.T) {
+
+	r := NewResults()
+	for _, v := range []string{"a", "bbbbbbbbbb", "cccccccccccccccccccc", "dddddddddddddddddddddddddddddddddddddddd"} {
+		r.observeString(v, v)
+	}
+
+	var external, static bytes.Buffer
+	if err := RenderHTMLWithOptions(r, &external, HTMLOptions{AssetURL: "/assets"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RenderHTMLWithOptions(r, &static, HTMLOptions{Static: true, Fragment: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, out := range []string{external.String(), static.String()} {
+		for _, s := range []string{"<style", "<script>", `<script type="text/javascript">`} {
+			if strings.Contains(out, s) {
+				t.Errorf("expected a CSP-safe report not to contain: %s", s)
+			}
+		}
+	}
+	for _, s := range []string{`<script src="/assets/Chart.min.js">`, `<script src="/assets/reckon.js">`,
+		`<link rel="stylesheet" href="/assets/reckon.css">`, `class="reckon-chart" data-chart="{&#34;labels&#34;:[`} {
+		if !strings.Contains(external.String(), s) {
+			t.Errorf("expected the report to contain: %s", s)
+		}
+	}
+	if strings.Contains(static.String(), "<script") || strings.Contains(static.String(), "<canvas") {
+		t.Error("expected the static report to contain no scripts or charts")
+	}
+	if !strings.Contains(static.String(), "<th>Frequency</th>") {
+		t.Error("expected the static report to render charts as tables")
+	}
+
+	for _, name := range HTMLAssets {
+		var b bytes.Buffer
+		if err := WriteHTMLAsset(name, &b); err != nil || b.Len() == 0 {
+			t.Errorf("expected asset %s to be written, got %d bytes (%v)", name, b.Len(), err)
+		}
+	}
+	if err := WriteHTMLAsset("missing.js", &bytes.Buffer{}); err == nil {
+		t.Error("expected an error writing an unknown asset")
+	}
+}
+
+func TestRenderIndex(t *testing.T) {
+
+	small, large := NewResults(), NewResults()
+	small.MinGroupSamples = 30
+	small.observeString("s", "value")
+	large.observeHash("h1", 1, "f", "v")
+	large.observeHash("h2", 1, "f", "v")
+	large.observeList("l", 1, "e")
+
+	var buf bytes.Buffer
+	stats := map[string]*Results{"<small>": small, "large": large}
+	if err := RenderIndex(stats, &buf, func(group string) string { return "output-" + group + ".html" }); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, s := range []string{`<a href="output-large.html">large</a>`, "<td>3</td>", "&lt;small&gt;", "low confidence"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected the index to contain: %s", s)
+		}
+	}
+	if strings.Index(out, "output-large.html") > strings.Index(out, "&lt;small&gt;") {
+		t.Error("expected the largest group to be listed first")
+	}
+	if strings.Contains(out, "<script") {
+		t.Error("expected the index not to contain any scripts")
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	for n, expected := range map[int64]string{
+		0:                 "0B",
+		1023:              "1023B",
+		1024:              "1.0KB",
+		1536:              "1.5KB",
+		200 * 1024 * 1024: "200.0MB",
+	} {
+		if actual := humanBytes(n); actual != expected {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	}
+}
+
+func TestRenderHTMLEscaping(t *testing.T) {
+
+	r := NewResults()
+	r.Name = "<script>alert('group')</script>"
+	r.observeString("<b>key</b>\xff\xfe", "value")
+
+	var buf bytes.Buffer
+	if err := RenderHTML(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, s := range []string{"<script>alert(", "<b>key</b>", "\xff"} {
+		if strings.Contains(out, s) {
+			t.Errorf("expected HTML output not to contain: %q", s)
+		}
+	}
+	for _, s := range []string{"&lt;script&gt;alert(", "&lt;b&gt;key&lt;/b&gt;\\xff\\xfe"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected HTML output to contain: %q", s)
+		}
+	}
+}
+
+func TestRenderHTMLCopyKeys(t *testing.T) {
+
+	r := NewResults()
+	long := strings.Repeat("tenant:1234:", 20) + "session"
+	r.observeString(long, "value")
+	r.observeBigKey(BigKey{Key: long, Type: TypeString, Length: 5, Size: 5})
+
+	var inline, static bytes.Buffer
+	if err := RenderHTML(r, &inline); err != nil {
+		t.Fatal(err)
+	}
+	if err := RenderHTMLWithOptions(r, &static, HTMLOptions{Static: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the buttons are hidden until revealed by the script, and the full key
+	// is shown
+	button := `<button type="button" class="btn btn-default btn-xs reckon-copy" data-key="` + long + `" title="Copy to clipboard" hidden>copy</button>`
+	if n := strings.Count(inline.String(), button); n != 2 {
+		t.Errorf("expected a copy button for the example key and the big key, found %d", n)
+	}
+	if !strings.Contains(inline.String(), `<code class="reckon-key">`+long+`</code>`) || !strings.Contains(inline.String(), "navigator.clipboard") {
+		t.Error("expected the report to contain the full key and the copy script")
+	}
+	if strings.Contains(static.String(), "reckon-copy") || !strings.Contains(static.String(), long) {
+		t.Error("expected the static report to show the key without a copy button")
+	}
+}
+
+func TestPrintable(t *testing.T) {
+	for s, expected := range map[string]string{
+		"plain":        "plain",
+		"üñïçødé":      "üñïçødé",
+		"\x00\x01ok":   "\x00\x01ok",
+		"bad\xffbytes": "bad\\xffbytes",
+		"\xc3":         "\\xc3",
+	} {
+		if actual := printable(s); actual != expected {
+			t.Errorf("expected: %q, actual: %q", expected, actual)
+		}
+	}
+}
+
+func TestRenderTextBuckets(t *testing.T) {
+
+	r := NewResults()
+	r.Buckets = []int{4, 8}
+	r.observeString("a", "abc")
+	r.observeString("b", "abcdef")
+	r.observeString("c", "abcdefghijkl")
+
+	var buf bytes.Buffer
+	if err := RenderText(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, s := range []string{"Bucketed Sizes:", " 4: 1 ", " 8: 1 ", " larger: 1 "} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected text output to contain: %q", s)
+		}
+	}
+}
+
+func TestRenderCardinalities(t *testing.T) {
+
+	r := NewResults()
+	for _, key := range []string{"h1", "h2", "h3"} {
+		r.observeHash(key, 1, "field", "value")
+	}
+	r.observeHash("huge", 1000000, "field", "value")
+	assertInt(t, 3, int(r.HashSizes[1]))
+	assertInt(t, 1, int(r.HashSizes[1000000]))
+
+	var text, html bytes.Buffer
+	if err := RenderText(r, &text); err != nil {
+		t.Fatal(err)
+	}
+	if err := RenderHTML(r, &html); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"Cardinalities (# of elements) (min: 1 max: 1000000", " 1: 3 ", " 1000000: 1 "} {
+		if !strings.Contains(text.String(), s) {
+			t.Errorf("expected text output to contain: %q", s)
+		}
+	}
+	if s := "<h3>Cardinalities (# of elements): "; !strings.Contains(html.String(), s) {
+		t.Errorf("expected HTML output to contain: %q", s)
+	}
+}
+
+func TestRenderRunHeader(t *testing.T) {
+
+	r := NewResults()
+	r.observeString("key", "value")
+	summary := Summary{KeyCount: 200, Sampled: 50, Skipped: 3, Duration: 2 * time.Second, Server: ServerInfo{Version: "7.2.4"}}
+
+	var text bytes.Buffer
+	if err := RenderTextWithSummary(r, &text, summary); err != nil {
+		t.Fatal(err)
+	}
+	var html bytes.Buffer
+	if err := RenderHTMLWithOptions(r, &html, HTMLOptions{Summary: &summary}); err != nil {
+		t.Fatal(err)
+	}
+	year := strconv.Itoa(time.Now().UTC().Year())
+	for _, out := range []string{text.String(), html.String()} {
+		for _, s := range []string{"50 of 200 keys sampled (25.00% coverage), 3 skipped, in 2s", "redis 7.2.4", "generated " + year} {
+			if !strings.Contains(out, s) {
+				t.Errorf("expected the report to contain %q, actual: %s", s, out)
+			}
+		}
+	}
+
+	// without a summary, there is no header
+	text.Reset()
+	if err := RenderText(r, &text); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(text.String(), "--- Run ---") {
+		t.Errorf("unexpected run header: %s", text.String())
+	}
+}
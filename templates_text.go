@@ -19,7 +19,82 @@ package reckon
 const (
 	statsTempl = `
 {{define "base"}}
-# of keys sampled: {{.KeyCount}}
+{{ with run }}--- Run ---
+{{.Sampled}} of {{.KeyCount}} keys sampled ({{fmtFloat .CoveragePercent}}% coverage){{ if .Skipped }}, {{.Skipped}} skipped{{ end }}, in {{.Duration}}
+{{ if .Server.Version }}redis {{.Server.Version}}
+{{ end }}generated {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}}
+
+{{ end }}{{ if .Exact }}# of keys (exact census): {{.KeyCount}}{{ else }}# of keys sampled: {{.KeyCount}}{{ end }}
+{{ if .TrivialKeys }}# of trivial keys excluded (smaller than {{.MinSize}} bytes): {{.TrivialKeys}}
+{{ end }}{{range .Warnings}}WARNING: {{.}}
+{{end}}{{ if lowConfidence . }}WARNING: fewer than {{.MinGroupSamples}} keys were sampled for this group, so these statistics may not be meaningful
+{{end}}
+{{ with types . }}
+--- Types ---
+{{range .}} {{.Type}}: n={{.Keys}} ({{percentage .Keys $.KeyCount}}%), mean length={{fmtFloat .Lengths.Mean}}{{ if .Values }}, mean value size={{fmtFloat .ValueSizes.Mean}}{{ end }}
+{{end}}{{end}}
+{{ if .BigKeys }}
+--- Big Keys ---
+{{range .BigKeys}} {{printable .Key}} ({{.Type}}): length {{.Length}}, ~{{humanBytes .Size}}
+{{end}}{{end}}
+
+{{ if .ShortLivedSizes }}
+--- Short-Lived Keys (expiring within {{.ShortTTL}}) ---
+~Sizes ({{template "stats" .ShortLivedSizes}}):
+{{template "freq" .ShortLivedSizes}}
+{{template "bucketsTitle" $}} ~Sizes:{{template "freq" buckets .ShortLivedSizes $.Buckets}}{{end}}
+
+{{ with ttls . }}
+--- Expiration ({{$.Expiring}} keys with an expiry, {{$.Persistent}} without) ---{{ if $.Expiring }}
+TTLs in s ({{template "stats" $.TTLs}}):
+{{range .}} {{.Label}}: {{.Keys}} ({{percentage .Keys $.Expiring}})
+{{end}}{{end}}{{end}}
+
+{{ if .MemoryUsages }}
+--- Memory Usage ({{ if .MemoryEstimated }}~{{ end }}{{humanBytes (memoryUsage .)}} sampled{{ if .MemoryEstimated }}, partly estimated without redis' overheads{{ end }}) ---
+Memory Usages in bytes ({{template "stats" .MemoryUsages}}):
+^2 Memory Usages:{{template "freq" buckets .MemoryUsages nil}}{{end}}
+
+{{ if .FetchLatencies }}
+--- Fetch Latencies (µs) ---
+Latencies ({{template "stats" .FetchLatencies}}):
+^2 Latencies:{{template "freq" buckets .FetchLatencies nil}}{{end}}
+
+{{ with temperature . }}
+--- Access ({{.}}) ---{{ if $.IdleTimes }}
+Idle Times in s ({{template "stats" $.IdleTimes}}):
+^2 Idle Times:{{template "freq" buckets $.IdleTimes nil}}{{end}}{{ if $.AccessFrequencies }}
+Access Frequencies ({{template "stats" $.AccessFrequencies}}):
+^2 Access Frequencies:{{template "freq" buckets $.AccessFrequencies nil}}{{end}}{{end}}
+
+{{ if .StoredValueSizes }}
+--- Compression ({{fmtFloat (compression .)}}x logical / stored value size) ---
+Stored Value Sizes ({{template "stats" .StoredValueSizes}}):
+{{template "bucketsTitle" $}} Stored Value Sizes:{{template "freq" buckets .StoredValueSizes $.Buckets}}
+Logical Value Sizes ({{template "stats" .LogicalValueSizes}}):
+{{template "bucketsTitle" $}} Logical Value Sizes:{{template "freq" buckets .LogicalValueSizes $.Buckets}}{{end}}
+
+{{ with recommendations . }}
+--- Recommendations ---
+{{range .}} {{.}}
+{{end}}{{end}}
+
+{{ if .Instances }}
+--- Instances ---
+{{range $tag, $n := .Instances}} {{printable $tag}}: {{$n}} ({{percentage $n $.KeyCount}})
+{{end}}{{end}}
+
+{{ if .KeyNameSizes }}
+--- Key Names ---
+Sizes ({{template "stats" .KeyNameSizes}}):
+{{template "freq" .KeyNameSizes}}
+{{template "bucketsTitle" $}} Sizes:{{template "freq" buckets .KeyNameSizes $.Buckets}}{{end}}
+
+{{ if .TopValues }}
+--- Top Values ---
+{{range topValues .TopValues}}{{.Type}}:
+{{range .Values}} {{printable .Value}}: ~{{.Count}}
+{{end}}{{end}}{{end}}
 
 {{ if .StringKeys }}
 --- Strings ({{summarize .StringSizes}}) ---
@@ -27,72 +102,78 @@ const (
 {{template "exampleValues" .StringValues}}
 Sizes ({{template "stats" .StringSizes}}):
 {{template "freq" .StringSizes}}
-^2 Sizes:{{template "freq" power .StringSizes}}{{end}}
+{{template "bucketsTitle" $}} Sizes:{{template "freq" buckets .StringSizes $.Buckets}}{{end}}
 
 {{ if .SetKeys }}
 --- Sets ({{summarize .SetSizes}}) ---
 {{template "exampleKeys" .SetKeys}}
-Sizes ({{template "stats" .SetSizes}}):
+Cardinalities (# of elements) ({{template "stats" .SetSizes}}):
 {{template "freq" .SetSizes}}
-^2 Sizes:{{template "freq" power .SetSizes}}
+{{template "bucketsTitle" $}} Cardinalities:{{template "freq" buckets .SetSizes $.Buckets}}
 {{template "exampleElements" .SetElements}}
 Element Sizes:{{template "freq" .SetElementSizes}}
-Element ^2 Sizes:{{template "freq" power .SetElementSizes}}{{end}}
+Element {{template "bucketsTitle" $}} Sizes:{{template "freq" buckets .SetElementSizes $.Buckets}}{{end}}
 
 {{ if .SortedSetKeys }}
 --- Sorted Sets ({{summarize .SortedSetSizes}}) ---
 {{template "exampleKeys" .SortedSetKeys}}
-Sizes ({{template "stats" .SortedSetSizes}}):
+Cardinalities (# of elements) ({{template "stats" .SortedSetSizes}}):
 {{template "freq" .SortedSetSizes}}
-^2 Sizes:{{template "freq" power .SortedSetSizes}}
+{{template "bucketsTitle" $}} Cardinalities:{{template "freq" buckets .SortedSetSizes $.Buckets}}
 {{template "exampleElements" .SortedSetElements}}
 Element Sizes ({{template "stats" .SortedSetElementSizes}}):
 {{template "freq" .SortedSetElementSizes}}
-Element ^2 Sizes:{{template "freq" power .SortedSetElementSizes}}{{end}}
+Element {{template "bucketsTitle" $}} Sizes:{{template "freq" buckets .SortedSetElementSizes $.Buckets}}{{end}}
 
 {{ if .HashKeys }}
 --- Hashes ({{summarize .HashSizes}}) ---
 {{template "exampleKeys" .HashKeys}}
-Sizes ({{template "stats" .HashSizes}}):
+Cardinalities (# of elements) ({{template "stats" .HashSizes}}):
 {{template "freq" .HashSizes}}
-^2 Sizes:{{template "freq" power .HashSizes}}
+{{template "bucketsTitle" $}} Cardinalities:{{template "freq" buckets .HashSizes $.Buckets}}
 {{template "exampleElements" .HashElements}}
 Element Sizes ({{template "stats" .HashElementSizes}}):
 {{template "freq" .HashElementSizes}}
-^2 Element Sizes:{{template "freq" power .HashElementSizes}}
+{{template "bucketsTitle" $}} Element Sizes:{{template "freq" buckets .HashElementSizes $.Buckets}}
 {{template "exampleValues" .HashValues}}
 Value Sizes ({{template "stats" .HashValueSizes}}):
 {{template "freq" .HashValueSizes}}
-^2 Value Sizes:{{template "freq" power .HashValueSizes}}{{end}}
+{{template "bucketsTitle" $}} Value Sizes:{{template "freq" buckets .HashValueSizes $.Buckets}}{{end}}
 
 {{ if .ListKeys }}
 --- Lists ({{summarize .ListSizes}}) ---
 {{template "exampleKeys" .ListKeys}}
-Sizes ({{template "stats" .ListSizes}}):
+Cardinalities (# of elements) ({{template "stats" .ListSizes}}):
 {{template "freq" .ListSizes}}
-^2 Sizes:{{template "freq" power .ListSizes}}
+{{template "bucketsTitle" $}} Cardinalities:{{template "freq" buckets .ListSizes $.Buckets}}
 {{template "exampleElements" .ListElements}}
 Element Sizes ({{template "stats" .ListElementSizes}}):
 {{template "freq" .ListElementSizes}}
-^2 Element Sizes{{template "freq" power .ListElementSizes}}
-{{end}}{{end}}
+{{template "bucketsTitle" $}} Element Sizes{{template "freq" buckets .ListElementSizes $.Buckets}}
+{{ if .ListHeadElementSizes }}Head Element Sizes ({{template "stats" .ListHeadElementSizes}}):
+{{template "freq" .ListHeadElementSizes}}
+Tail Element Sizes ({{template "stats" .ListTailElementSizes}}):
+{{template "freq" .ListTailElementSizes}}
+{{end}}{{end}}{{end}}
+
+{{define "bucketsTitle"}}{{if .Buckets}}Bucketed{{else}}^2{{end}}{{end}}
 
-{{define "stats"}}{{ with stats . }}min: {{.Min}} max: {{.Max}} mean: {{fmtFloat .Mean}} std dev: {{fmtFloat .StdDev}}{{end}}{{end}}
+{{define "stats"}}{{ with stats . }}min: {{.Min}} max: {{.Max}} mean: {{fmtFloat .Mean}} std dev: {{fmtFloat .StdDev}} gini: {{fmtFloat .Gini}}{{end}}{{end}}
 
 {{define "exampleKeys"}}Example Keys:
-{{range $k, $v := .}} {{$k}}
+{{range $k, $v := .}} {{printable $k}}
 {{end}}{{end}}
 
 {{define "exampleValues"}}Example Values:
-{{range $k, $v := .}} {{$k}}
+{{range $k, $v := .}} {{printable $k}}
 {{end}}{{end}}
 
 {{define "exampleElements"}}Example Elements:
-{{range $k, $v := .}} {{$k}}
+{{range $k, $v := .}} {{printable $k}}
 {{end}}{{end}}
 
 {{define "freq"}}
-{{ $ss := summarize . }}{{ range $s, $c := .}} {{$s}}: {{$c}} ({{percentage $c $ss }})
+{{ $ss := summarize . }}{{ range $s, $c := .}} {{bucketLabel $s}}: {{$c}} ({{percentage $c $ss }})
 {{end}}{{end}}
 `
 )
@@ -0,0 +1,146 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+const (
+	// TopValuesCapacityFactor is the number of values tracked by a TopValues
+	// summary, as a multiple of the number of top values to be reported.
+	// Tracking extra values improves the accuracy of the reported counts.
+	TopValuesCapacityFactor = 10
+
+	// MaxTopValueLength is the maximum length of a value tracked by a
+	// TopValues summary; longer values are truncated, so that memory use is
+	// bounded regardless of value sizes
+	MaxTopValueLength = 256
+)
+
+// ValueCount is an observed value, along with an approximation of the number
+// of times it was observed.  The true count lies between Count-Error and Count.
+type ValueCount struct {
+	Value string
+	Count int64
+	Error int64
+}
+
+// TopValues is a bounded summary of the most frequently observed values,
+// using the "space-saving" algorithm: once the summary is full, a new value
+// replaces the least frequent one, inheriting its count (as an overestimate).
+// Memory use is bounded regardless of the number of distinct values observed,
+// and the counts of frequent values are accurate, which makes it well suited
+// to profiling categorical values, such as status flags or country codes.
+type TopValues struct {
+	// K is the number of top values to be reported
+	K int
+
+	counts map[string]*ValueCount
+}
+
+// NewTopValues creates a TopValues summary that reports the top `k` values
+func NewTopValues(k int) *TopValues {
+	return &TopValues{K: k, counts: make(map[string]*ValueCount)}
+}
+
+// capacity returns the number of values tracked by the summary
+func (t *TopValues) capacity() int {
+	return t.K * TopValuesCapacityFactor
+}
+
+// add records an observation of `value`
+func (t *TopValues) add(value string) {
+	if len(value) > MaxTopValueLength {
+		value = value[:MaxTopValueLength]
+	}
+
+	if c, ok := t.counts[value]; ok {
+		c.Count++
+		return
+	}
+	if len(t.counts) < t.capacity() {
+		t.counts[value] = &ValueCount{Value: value, Count: 1}
+		return
+	}
+
+	// replace the least frequent value
+	var min *ValueCount
+	for _, c := range t.counts {
+		if min == nil || c.Count < min.Count || (c.Count == min.Count && c.Value > min.Value) {
+			min = c
+		}
+	}
+	delete(t.counts, min.Value)
+	t.counts[value] = &ValueCount{Value: value, Count: min.Count + 1, Error: min.Count}
+}
+
+// merge adds the observations summarized by `other` into the method receiver,
+// keeping only the most frequent values if the capacity is exceeded
+func (t *TopValues) merge(other *TopValues) {
+	if t.K == 0 {
+		t.K = other.K
+	}
+	for v, oc := range other.counts {
+		if c, ok := t.counts[v]; ok {
+			c.Count += oc.Count
+			c.Error += oc.Error
+		} else {
+			cp := *oc
+			t.counts[v] = &cp
+		}
+	}
+
+	if len(t.counts) > t.capacity() {
+		for _, c := range t.sorted()[t.capacity():] {
+			delete(t.counts, c.Value)
+		}
+	}
+}
+
+// sorted returns every tracked value, most frequent first
+func (t *TopValues) sorted() []ValueCount {
+	all := make([]ValueCount, 0, len(t.counts))
+	for _, c := range t.counts {
+		all = append(all, *c)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Count != all[j].Count {
+			return all[i].Count > all[j].Count
+		}
+		return all[i].Value < all[j].Value
+	})
+	return all
+}
+
+// Top returns the K most frequently observed values, most frequent first
+func (t *TopValues) Top() []ValueCount {
+	top := t.sorted()
+	if len(top) > t.K {
+		top = top[:t.K]
+	}
+	return top
+}
+
+// MarshalJSON encodes the summary as its K and its Top values
+func (t *TopValues) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		K   int
+		Top []ValueCount
+	}{t.K, t.Top()})
+}
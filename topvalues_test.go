@@ -0,0 +1,101 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTopValues(t *testing.T) {
+
+	tv := NewTopValues(2)
+
+	// a long tail of unique values can't displace the frequent ones
+	for i := 0; i < 1000; i++ {
+		tv.add("US")
+		if i%2 == 0 {
+			tv.add("DE")
+		}
+		tv.add(fmt.Sprintf("unique-%d", i))
+	}
+	assertInt(t, 2*TopValuesCapacityFactor, len(tv.counts))
+
+	top := tv.Top()
+	assertInt(t, 2, len(top))
+	if top[0].Value != "US" || top[1].Value != "DE" {
+		t.Fatalf("unexpected top values: %+v", top)
+	}
+	if top[0].Count-top[0].Error > 1000 || top[0].Count < 1000 {
+		t.Errorf("expected the true count of US to be within bounds: %+v", top[0])
+	}
+
+	// long values are truncated
+	tv.add(strings.Repeat("x", 2*MaxTopValueLength))
+	for v := range tv.counts {
+		if len(v) > MaxTopValueLength {
+			t.Errorf("expected values to be truncated, got one of length %d", len(v))
+		}
+	}
+}
+
+func TestResultsTopValues(t *testing.T) {
+
+	r := NewResults()
+	r.TopK = 2
+	r.observeString("a", "active")
+	r.observeString("b", "active")
+	r.observeString("c", "disabled")
+	r.observeHash("h", 1, "country", "US")
+
+	// disabled unless TopK is set
+	other := NewResults()
+	other.observeString("d", "active")
+	assertInt(t, 0, len(other.TopValues))
+
+	o := NewResults()
+	o.TopK = 2
+	o.observeString("d", "active")
+	if err := r.Merge(o); err != nil {
+		t.Fatal(err)
+	}
+
+	top := r.TopValues[TypeString].Top()
+	if top[0] != (ValueCount{Value: "active", Count: 3}) {
+		t.Errorf("unexpected top string value: %+v", top[0])
+	}
+
+	var buf bytes.Buffer
+	if err := RenderText(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"--- Top Values ---", "string:\n active: ~3\n disabled: ~1", "hash:\n US: ~1"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expected text output to contain: %q", s)
+		}
+	}
+
+	buf.Reset()
+	if err := RenderHTML(r, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<tr><td>active</td> <td>3</td></tr>") {
+		t.Errorf("expected HTML output to contain the top values")
+	}
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"strings"
+)
+
+// TreemapWidth and TreemapHeight are the dimensions of the SVG rendered by
+// RenderTreemap.
+const (
+	TreemapWidth  = 1200
+	TreemapHeight = 800
+)
+
+// svgText escapes a group name for use as SVG text: besides the escaping of
+// printable, control characters other than tab, LF and CR, which XML forbids
+// even when escaped, are written as \xNN
+func svgText(s string) string {
+	s = printable(s)
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 && c != '\t' && c != '\n' && c != '\r' {
+			fmt.Fprintf(&b, "\\x%02x", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return html.EscapeString(b.String())
+}
+
+// treemapRect is a laid out rectangle of a treemap
+type treemapRect struct {
+	x, y, w, h float64
+}
+
+// RenderTreemap renders a treemap of the aggregation groups in `stats` to
+// the supplied io.Writer, as a standalone SVG image (without any JS): each
+// group is drawn as a rectangle whose area is proportional to its estimated
+// size in bytes (see Results.EstimatedBytes), so that the groups that
+// dominate memory use stand out at a glance.  Hovering over a rectangle shows
+// its group name and estimated size.  Groups without any estimated bytes are
+// omitted.
+func RenderTreemap(stats map[string]*Results, out io.Writer) error {
+	var groups []string
+	var sizes []float64
+	for _, gr := range Ordered(stats, ByEstimatedBytes) {
+		b := gr.Results.EstimatedBytes()
+		if b <= 0 {
+			break
+		}
+		groups = append(groups, gr.Group)
+		sizes = append(sizes, float64(b))
+	}
+
+	if _, err := fmt.Fprintf(out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">`+"\n",
+		TreemapWidth, TreemapHeight, TreemapWidth, TreemapHeight); err != nil {
+		return err
+	}
+	for i, r := range squarify(sizes, treemapRect{w: TreemapWidth, h: TreemapHeight}) {
+		label := svgText(groups[i])
+		size := humanBytes(int64(sizes[i]))
+		// spread the hues of successive groups around the color wheel
+		hue := (i * 137) % 360
+		if _, err := fmt.Fprintf(out, `<g><title>%s: ~%s</title><rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="hsl(%d,60%%,70%%)" stroke="#fff"/>`,
+			label, size, r.x, r.y, r.w, r.h, hue); err != nil {
+			return err
+		}
+		// only label rectangles big enough to hold one
+		if r.w > 80 && r.h > 32 {
+			if _, err := fmt.Fprintf(out, `<text x="%.2f" y="%.2f">%s</text><text x="%.2f" y="%.2f">~%s</text>`,
+				r.x+4, r.y+14, label, r.x+4, r.y+28, size); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(out, "</g>\n"); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(out, "</svg>\n")
+	return err
+}
+
+// squarify lays out rectangles with areas proportional to `sizes` (which
+// must be positive, and sorted largest first) within `bounds`, using the
+// squarified treemap algorithm: rectangles are laid out in rows along the
+// shorter side of the remaining space, with each row grown for as long as
+// doing so makes its rectangles less elongated.
+func squarify(sizes []float64, bounds treemapRect) []treemapRect {
+	var total float64
+	for _, s := range sizes {
+		total += s
+	}
+	areas := make([]float64, len(sizes))
+	for i, s := range sizes {
+		areas[i] = s * bounds.w * bounds.h / total
+	}
+
+	rects := make([]treemapRect, 0, len(areas))
+	for i := 0; i < len(areas); {
+		short := math.Min(bounds.w, bounds.h)
+		j := i + 1
+		for j < len(areas) && worstRatio(areas[i:j+1], short) <= worstRatio(areas[i:j], short) {
+			j++
+		}
+
+		var sum float64
+		for _, a := range areas[i:j] {
+			sum += a
+		}
+		if bounds.w >= bounds.h {
+			// a column along the left edge
+			w := sum / bounds.h
+			y := bounds.y
+			for _, a := range areas[i:j] {
+				rects = append(rects, treemapRect{x: bounds.x, y: y, w: w, h: a / w})
+				y += a / w
+			}
+			bounds.x += w
+			bounds.w -= w
+		} else {
+			// a row along the top edge
+			h := sum / bounds.w
+			x := bounds.x
+			for _, a := range areas[i:j] {
+				rects = append(rects, treemapRect{x: x, y: bounds.y, w: a / h, h: h})
+				x += a / h
+			}
+			bounds.y += h
+			bounds.h -= h
+		}
+		i = j
+	}
+	return rects
+}
+
+// worstRatio returns the greatest aspect ratio of the rectangles of a row
+// with the given `areas` (sorted largest first), laid out along a side of
+// length `side`
+func worstRatio(areas []float64, side float64) float64 {
+	var sum float64
+	for _, a := range areas {
+		sum += a
+	}
+	largest, smallest := areas[0], areas[len(areas)-1]
+	return math.Max(side*side*largest/(sum*sum), sum*sum/(side*side*smallest))
+}
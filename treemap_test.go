@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEstimatedBytes(t *testing.T) {
+
+	r := NewResults()
+	r.observeString("s", "12345")
+	r.observeList("l", 10, "abc", "a")
+	r.observeHash("h", 4, "field", "value")
+	// size-only collections aren't counted
+	r.observeSize(TypeSet, "set", 1000)
+
+	// 5 bytes of strings, 10 list elements of 2 bytes, and 4 hash entries of
+	// 5+5 bytes
+	assertInt(t, 5+20+40, int(r.EstimatedBytes()))
+}
+
+func TestSquarify(t *testing.T) {
+
+	bounds := treemapRect{w: 600, h: 400}
+	sizes := []float64{6, 6, 4, 3, 2, 2, 1}
+	rects := squarify(sizes, bounds)
+	if len(rects) != len(sizes) {
+		t.Fatalf("expected a rectangle per size, actual: %v", rects)
+	}
+
+	for i, r := range rects {
+		// areas are proportional to the sizes, and fill the bounds
+		assertFloat(t, sizes[i]*600*400/24, r.w*r.h, 1e-6)
+		if r.x < -1e-9 || r.y < -1e-9 || r.x+r.w > 600+1e-9 || r.y+r.h > 400+1e-9 {
+			t.Errorf("rectangle %d lies outside the bounds: %+v", i, r)
+		}
+		for j, o := range rects[:i] {
+			overlapX := math.Min(r.x+r.w, o.x+o.w) - math.Max(r.x, o.x)
+			overlapY := math.Min(r.y+r.h, o.y+o.h) - math.Max(r.y, o.y)
+			if overlapX > 1e-9 && overlapY > 1e-9 {
+				t.Errorf("rectangles %d and %d overlap: %+v, %+v", j, i, o, r)
+			}
+		}
+	}
+}
+
+func TestRenderTreemap(t *testing.T) {
+
+	big, small, empty := NewResults(), NewResults(), NewResults()
+	big.observeString("a", strings.Repeat("x", 1000))
+	small.observeString("b", "x")
+	empty.observeSize(TypeHash, "c", 10)
+
+	var buf bytes.Buffer
+	if err := RenderTreemap(map[string]*Results{"big<>": big, "small\x01": small, "empty": empty}, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// the output must be well-formed XML, even with control characters in
+	// group names
+	d := xml.NewDecoder(strings.NewReader(buf.String()))
+	for {
+		if _, err := d.Token(); err != nil {
+			if err != io.EOF {
+				t.Fatalf("invalid SVG: %s\n%s", err, buf.String())
+			}
+			break
+		}
+	}
+
+	svg := buf.String()
+	if !strings.Contains(svg, "<title>big&lt;&gt;: ~1000B</title>") || !strings.Contains(svg, `<title>small\x01: ~1B</title>`) {
+		t.Errorf("expected a rectangle per group:\n%s", svg)
+	}
+	if strings.Contains(svg, "empty") {
+		t.Errorf("expected groups without estimated bytes to be omitted:\n%s", svg)
+	}
+}
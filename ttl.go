@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import "time"
+
+// A TTLBucket counts the sampled keys of an aggregation group whose remaining
+// time to live falls within a range (see Results.TTLDistribution)
+type TTLBucket struct {
+	// Label describes the range, e.g. "< 1h"
+	Label string
+	Keys  int64
+}
+
+// ttlBuckets are the (exclusive) upper bounds of the ranges that remaining
+// TTLs are bucketed into, with any longer TTLs counted in a final bucket
+var ttlBuckets = []struct {
+	label string
+	max   time.Duration
+}{
+	{"< 1m", time.Minute},
+	{"< 1h", time.Hour},
+	{"< 1d", 24 * time.Hour},
+}
+
+// observeTTL records whether a sampled key has an expiry, and if so, its
+// remaining time to live
+func (r *Results) observeTTL(expires bool, ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !expires {
+		r.Persistent++
+		return
+	}
+	r.Expiring++
+	r.TTLs[int(ttl/time.Second)]++
+}
+
+// TTLDistribution buckets the remaining TTLs of the group's expiring keys
+// into ranges of under a minute, an hour and a day, and a day or more (see
+// Options.CollectTTLs).  It returns nil if no TTLs were collected.
+func (r *Results) TTLDistribution() []TTLBucket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ttlDistribution()
+}
+
+func (r *Results) ttlDistribution() []TTLBucket {
+	if r.Expiring == 0 && r.Persistent == 0 {
+		return nil
+	}
+
+	dist := make([]TTLBucket, len(ttlBuckets)+1)
+	for i, b := range ttlBuckets {
+		dist[i].Label = b.label
+	}
+	dist[len(ttlBuckets)].Label = ">= 1d"
+	for secs, n := range r.TTLs {
+		i := 0
+		for i < len(ttlBuckets) && time.Duration(secs)*time.Second >= ttlBuckets[i].max {
+			i++
+		}
+		dist[i].Keys += n
+	}
+	return dist
+}
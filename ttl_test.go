@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2015 zulily, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reckon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSampleTTLs(t *testing.T) {
+
+	f := newFakeRedis()
+	f.set("session:1", TypeString, "value").ttl = 30 * time.Second
+	f.set("session:2", TypeString, "value").ttl = 10 * time.Minute
+	f.set("session:3", TypeString, "value").ttl = 5 * time.Hour
+	f.set("session:4", TypeString, "value").ttl = 72 * time.Hour
+	f.set("user:1", TypeString, "value")
+	f.set("user:2", TypeString, "value").ttl = 10 * time.Minute
+
+	stats, _, err := sample(context.Background(), f, Options{Census: true, CollectTTLs: true}, PrefixAggregator(":"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := stats["session"]
+	assertInt(t, 4, int(sessions.Expiring))
+	assertInt(t, 0, int(sessions.Persistent))
+	assertInt(t, 1, int(sessions.TTLs[600]))
+	for i, expected := range []TTLBucket{{"< 1m", 1}, {"< 1h", 1}, {"< 1d", 1}, {">= 1d", 1}} {
+		if actual := sessions.TTLDistribution()[i]; actual != expected {
+			t.Errorf("bucket %d: expected %v, actual: %v", i, expected, actual)
+		}
+	}
+
+	// merging combines the counts of both groups
+	users := stats["user"]
+	assertInt(t, 1, int(users.Persistent))
+	if err := sessions.Merge(users); err != nil {
+		t.Fatal(err)
+	}
+	assertInt(t, 5, int(sessions.Expiring))
+	assertInt(t, 1, int(sessions.Persistent))
+	assertInt(t, 2, int(sessions.TTLDistribution()[1].Keys))
+
+	var buf bytes.Buffer
+	if err := RenderText(sessions, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "--- Expiration (5 keys with an expiry, 1 without) ---") || !strings.Contains(buf.String(), "< 1h: 2 (40.00)") {
+		t.Errorf("expected the TTL distribution to be reported, actual: %s", buf.String())
+	}
+	buf.Reset()
+	if err := RenderHTML(sessions, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<h1>Expiration") {
+		t.Error("expected the HTML report to include the TTL distribution")
+	}
+
+	// nor can they be merged with results whose TTLs weren't collected
+	untracked := NewResults()
+	untracked.observeString("key", "value")
+	if err := sessions.Merge(untracked); !errors.Is(err, ErrIncompatibleResults) {
+		t.Errorf("expected ErrIncompatibleResults, actual: %v", err)
+	}
+	assertInt(t, 6, int(sessions.Expiring+sessions.Persistent))
+
+	// TTLs aren't fetched unless requested
+	f.commands = nil
+	stats, _, err = sample(context.Background(), f, Options{Census: true}, PrefixAggregator(":"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats["session"].TTLDistribution() != nil {
+		t.Errorf("unexpected TTL distribution: %v", stats["session"].TTLDistribution())
+	}
+	for _, c := range f.commands {
+		if strings.HasPrefix(c, "PTTL") {
+			t.Errorf("unexpected command: %s", c)
+		}
+	}
+}